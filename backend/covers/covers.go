@@ -0,0 +1,220 @@
+// Package covers keeps the artwork of songs on disk. Song.ImageURL is a
+// remote URL or the path of a local file (osu! backgrounds); either way the
+// image is fetched once, stored by the hash of its content and shrunk to a
+// few thumbnail sizes.
+package covers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // decoders for DecodeConfig and the thumbnails
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cryogon/rizumu-backend/store"
+	"cryogon/rizumu-backend/utils"
+)
+
+// Sizes are the thumbnails made of each cover, in pixels on the long side
+var Sizes = []int{64, 300, 640}
+
+// maxImageSize stops a bad URL from filling the disk
+const maxImageSize = 20 << 20
+
+var ErrNoCover = errors.New("song has no cover")
+
+// Cache is the cover store: dir/<hash>.<ext> for the originals and
+// dir/thumbs/<hash>_<size>.<ext> for the thumbnails
+type Cache struct {
+	db  *store.Store
+	dir string
+}
+
+func NewCache(db *store.Store, dir string) *Cache {
+	if err := os.MkdirAll(filepath.Join(dir, "thumbs"), 0o755); err != nil {
+		log.Printf("[Covers] WARN: Failed to create %s: %v", dir, err)
+	}
+	return &Cache{db: db, dir: dir}
+}
+
+// Cover returns the cached cover of song, fetching it the first time
+func (c *Cache) Cover(ctx context.Context, song *store.Song) (*store.Cover, error) {
+	if song.ImageURL == "" {
+		return nil, ErrNoCover
+	}
+
+	cover, err := c.db.GetCoverBySource(ctx, song.ImageURL)
+	if err != nil {
+		return nil, err
+	}
+	if cover != nil {
+		if _, err := os.Stat(c.originalPath(cover)); err == nil {
+			return cover, nil
+		}
+		// deleted behind our back, fetch it again
+	}
+
+	data, err := fetch(ctx, song.ImageURL)
+	if err != nil {
+		return nil, fmt.Errorf("fetch cover of song %d: %w", song.ID, err)
+	}
+	return c.save(ctx, song.ImageURL, data)
+}
+
+// Data returns the original image of a cover
+func (c *Cache) Data(cover *store.Cover) ([]byte, error) {
+	return os.ReadFile(c.originalPath(cover))
+}
+
+// File returns the path and type of cover at size, 0 for the original.
+// Sizes round up to the next thumbnail; asking for more than the biggest
+// one, or for a thumbnail of an image that small already, gives the
+// original. Images Go can't decode (webp) are always served as they are.
+func (c *Cache) File(cover *store.Cover, size int) (path, mime string, err error) {
+	size = snapSize(size)
+	if size == 0 || max(cover.Width, cover.Height) <= size {
+		return c.originalPath(cover), cover.MIME, nil
+	}
+	return c.thumbnail(cover, size)
+}
+
+func (c *Cache) save(ctx context.Context, source string, data []byte) (*store.Cover, error) {
+	sum := sha256.Sum256(data)
+	cover := &store.Cover{
+		Hash: hex.EncodeToString(sum[:]),
+		MIME: http.DetectContentType(data),
+		Size: int64(len(data)),
+	}
+	if !strings.HasPrefix(cover.MIME, "image/") {
+		return nil, fmt.Errorf("cover of %s is not an image (%s)", source, cover.MIME)
+	}
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		cover.Width, cover.Height = cfg.Width, cfg.Height
+	}
+
+	// another source may have brought the same image already
+	path := c.originalPath(cover)
+	if _, err := os.Stat(path); err != nil {
+		if err := writeFile(path, func(w io.Writer) error {
+			_, err := w.Write(data)
+			return err
+		}); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.db.SaveCover(ctx, cover, source); err != nil {
+		return nil, err
+	}
+
+	// made now so serving them is only reading a file
+	for _, size := range Sizes {
+		if _, _, err := c.File(cover, size); err != nil {
+			log.Printf("[Covers] WARN: Failed to make the %dpx thumbnail of %s: %v", size, cover.Hash, err)
+			break
+		}
+	}
+	return cover, nil
+}
+
+func (c *Cache) originalPath(cover *store.Cover) string {
+	return filepath.Join(c.dir, cover.Hash+extension(cover.MIME))
+}
+
+// snapSize rounds size up to a thumbnail size, 0 for the original
+func snapSize(size int) int {
+	if size <= 0 {
+		return 0
+	}
+	for _, s := range Sizes {
+		if size <= s {
+			return s
+		}
+	}
+	return 0
+}
+
+func extension(mime string) string {
+	switch mime {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	case "image/webp":
+		return ".webp"
+	case "image/bmp":
+		return ".bmp"
+	}
+	return ".img"
+}
+
+// fetch reads an image from a URL or from disk
+func fetch(ctx context.Context, source string) ([]byte, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		f, err := os.Open(source)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return readImage(f)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := utils.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", source, resp.Status)
+	}
+	return readImage(resp.Body)
+}
+
+func readImage(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxImageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxImageSize {
+		return nil, fmt.Errorf("image is over %d MB", maxImageSize>>20)
+	}
+	if len(data) == 0 {
+		return nil, errors.New("image is empty")
+	}
+	return data, nil
+}
+
+// writeFile writes path through a temp file, so a half written image is
+// never served
+func writeFile(path string, write func(io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".cover-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = write(tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,89 @@
+package covers
+
+import (
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"cryogon/rizumu-backend/store"
+)
+
+// thumbnail returns the thumbnail of cover at size, made if missing. PNGs
+// stay PNGs (transparency), everything else becomes a JPEG.
+func (c *Cache) thumbnail(cover *store.Cover, size int) (path, mime string, err error) {
+	mime = "image/jpeg"
+	if cover.MIME == "image/png" {
+		mime = "image/png"
+	}
+	path = filepath.Join(c.dir, "thumbs", cover.Hash+"_"+strconv.Itoa(size)+extension(mime))
+	if _, err := os.Stat(path); err == nil {
+		return path, mime, nil
+	}
+
+	f, err := os.Open(c.originalPath(cover))
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return "", "", err
+	}
+
+	small := scale(img, size)
+	err = writeFile(path, func(w io.Writer) error {
+		if mime == "image/png" {
+			return png.Encode(w, small)
+		}
+		return jpeg.Encode(w, small, &jpeg.Options{Quality: 85})
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return path, mime, nil
+}
+
+// scale shrinks img to fit in size x size. Each pixel is the average of the
+// ones it covers, plenty for artwork and no dependency.
+func scale(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := size, size
+	if w >= h {
+		dh = max(1, h*size/w)
+	} else {
+		dw = max(1, w*size/h)
+	}
+
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := range dh {
+		y0 := y * h / dh
+		y1 := max((y+1)*h/dh, y0+1)
+		for x := range dw {
+			x0 := x * w / dw
+			x1 := max((x+1)*w/dw, x0+1)
+
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride+x0*4 : sy*src.Stride+x1*4]
+				for i, v := range row {
+					sum[i%4] += int(v)
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			px := dst.Pix[y*dst.Stride+x*4:]
+			for i := range sum {
+				px[i] = uint8(sum[i] / n)
+			}
+		}
+	}
+	return dst
+}
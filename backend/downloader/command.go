@@ -0,0 +1,65 @@
+package downloader
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// command builds a context aware exec.Cmd in its own process group
+func command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	setProcessGroup(cmd)
+	// don't hang on the output pipes if a grandchild keeps them open
+	cmd.WaitDelay = 5 * time.Second
+	return cmd
+}
+
+// runCommand runs cmd, logging stdout/stderr as "[name-out]"/"[name-err]",
+// and passes every line to the job and to onLine (may be nil). Lines are
+// handed over one at a time, so onLine doesn't need locking.
+func runCommand(cmd *exec.Cmd, name string, job *Job, onLine func(line string)) error {
+	job.command(name)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	stream := func(pipe io.Reader, prefix string) {
+		defer wg.Done()
+		scanner := bufio.NewScanner(pipe)
+		for scanner.Scan() {
+			line := scanner.Text()
+			log.Printf("%s %s", prefix, line)
+
+			mu.Lock()
+			job.output(line)
+			if onLine != nil {
+				onLine(line)
+			}
+			mu.Unlock()
+		}
+	}
+
+	wg.Add(2)
+	go stream(stdout, "["+name+"-out]")
+	go stream(stderr, "["+name+"-err]")
+
+	// the pipes must be drained before Wait closes them
+	wg.Wait()
+	return cmd.Wait()
+}
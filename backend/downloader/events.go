@@ -0,0 +1,118 @@
+package downloader
+
+import (
+	"sync"
+	"time"
+)
+
+type TaskEventType string
+
+const (
+	EventQueued   TaskEventType = "queued"
+	EventStarted  TaskEventType = "started"
+	EventProgress TaskEventType = "progress"
+	EventRetrying TaskEventType = "retrying"
+	EventFinished TaskEventType = "finished" // Complete, Failed, Not Available or Cancelled
+)
+
+// TaskEvent is a snapshot of a task when something happened to it.
+// Progress, speed and ETA only live in memory, the DB gets the percent
+// every progressSaveInterval.
+type TaskEvent struct {
+	Type     TaskEventType `json:"type"`
+	TaskID   int64         `json:"task_id"`
+	SongID   int64         `json:"song_id"`
+	Source   string        `json:"source"`
+	Status   TaskStatus    `json:"status"`
+	Progress float64       `json:"progress"`
+
+	Downloaded int64   `json:"downloaded,omitempty"` // bytes
+	Total      int64   `json:"total,omitempty"`      // bytes
+	Speed      float64 `json:"speed,omitempty"`      // bytes per second
+	ETA        int64   `json:"eta,omitempty"`        // seconds
+
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error,omitempty"`
+	At       time.Time `json:"at"`
+}
+
+// Progress is what a source knows about a running download. Zero fields are
+// unknown, the service fills in what it can from the percent.
+type Progress struct {
+	Percent    float64
+	Downloaded int64 // bytes
+	Total      int64 // bytes
+	Speed      float64
+	ETA        time.Duration
+}
+
+const (
+	// progress events are published at most this often per task
+	progressEventInterval = 250 * time.Millisecond
+	// and written to the DB at most this often
+	progressSaveInterval = 5 * time.Second
+
+	subscriberBuffer = 64
+)
+
+// eventBus fans task events out to subscribers. A subscriber that doesn't
+// keep up misses events rather than blocking the workers.
+type eventBus struct {
+	mu   sync.Mutex
+	subs map[chan TaskEvent]struct{}
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subs: make(map[chan TaskEvent]struct{})}
+}
+
+func (b *eventBus) subscribe() (<-chan TaskEvent, func()) {
+	ch := make(chan TaskEvent, subscriberBuffer)
+
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+func (b *eventBus) publish(ev TaskEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// Subscribe streams task events until unsubscribe is called. The channel
+// is closed by unsubscribe.
+func (s *Service) Subscribe() (events <-chan TaskEvent, unsubscribe func()) {
+	return s.events.subscribe()
+}
+
+// ActiveTasks returns a snapshot of the queued and running tasks, so a new
+// subscriber can draw the current state before the events arrive
+func (s *Service) ActiveTasks() []TaskEvent {
+	tasks := s.queue.snapshot()
+	events := make([]TaskEvent, 0, len(tasks))
+	for _, task := range tasks {
+		events = append(events, task.event(EventProgress))
+	}
+	return events
+}
+
+func (s *Service) publish(task *Task, typ TaskEventType) {
+	s.events.publish(task.event(typ))
+}
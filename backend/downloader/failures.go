@@ -0,0 +1,161 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// FailureKind tells whether a failed download is worth retrying
+type FailureKind string
+
+const (
+	// FailureTransient : network hiccups, rate limits, timeouts. Retried with backoff.
+	FailureTransient FailureKind = "transient"
+	// FailurePermanent : the song can't be downloaded (removed, region locked...)
+	FailurePermanent FailureKind = "permanent"
+)
+
+// Failure is a classified download error. Reason is short and readable, it
+// ends up in the API and the TUI.
+type Failure struct {
+	Kind       FailureKind
+	Reason     string
+	RetryAfter time.Duration // asked by the server (HTTP 429), 0 if not
+	Err        error
+}
+
+func (f *Failure) Error() string {
+	if f.Err == nil {
+		return f.Reason
+	}
+	return fmt.Sprintf("%s: %v", f.Reason, f.Err)
+}
+
+func (f *Failure) Unwrap() error { return f.Err }
+
+// kind is what goes in download_tasks.error_kind, empty on success
+func (f *Failure) kind() string {
+	if f == nil {
+		return ""
+	}
+	return string(f.Kind)
+}
+
+// httpStatusError is returned when a mirror/API answers with a non 2xx status
+type httpStatusError struct {
+	StatusCode int
+	RetryAfter time.Duration
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("http %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+func newHTTPStatusError(resp *http.Response) *httpStatusError {
+	e := &httpStatusError{StatusCode: resp.StatusCode}
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+		e.RetryAfter = time.Duration(secs) * time.Second
+	}
+	return e
+}
+
+// outputPatterns match yt-dlp/spotdl output, first match wins
+var outputPatterns = []struct {
+	re     *regexp.Regexp
+	kind   FailureKind
+	reason string
+}{
+	{regexp.MustCompile(`(?i)HTTP Error 429|Too Many Requests|rate.?limit`), FailureTransient, "rate limited"},
+	{regexp.MustCompile(`(?i)Video unavailable|has been removed|no longer available|account .* terminated`), FailurePermanent, "video removed"},
+	{regexp.MustCompile(`(?i)Private video`), FailurePermanent, "video is private"},
+	{regexp.MustCompile(`(?i)not (made )?available in your country|blocked it in your country|geo.?restrict`), FailurePermanent, "region locked"},
+	{regexp.MustCompile(`(?i)copyright`), FailurePermanent, "blocked on copyright grounds"},
+	{regexp.MustCompile(`(?i)members.only|Join this channel|requires payment|Music Premium`), FailurePermanent, "requires a subscription"},
+	{regexp.MustCompile(`(?i)Sign in to confirm your age|age.restricted`), FailurePermanent, "age restricted"},
+	{regexp.MustCompile(`(?i)Unsupported URL|is not a valid URL`), FailurePermanent, "unsupported url"},
+	{regexp.MustCompile(`(?i)LookupError|No results found`), FailurePermanent, "no match found"},
+	{regexp.MustCompile(`(?i)HTTP Error 5\d\d`), FailureTransient, "server error"},
+	{regexp.MustCompile(`(?i)timed out|Connection (reset|refused|aborted)|Temporary failure in name resolution|Network is unreachable|IncompleteRead|Unable to download webpage|Got error: .*Errno`), FailureTransient, "network error"},
+}
+
+// classifyFailure decides what to do with a failed job from the error and
+// the output of the last downloader command: after a fallback, what spotdl
+// printed before says nothing about why the fallback failed
+func classifyFailure(err error, output string) *Failure {
+	var failure *Failure
+	if errors.As(err, &failure) {
+		return failure
+	}
+
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return classifyHTTPStatus(statusErr, err)
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &Failure{Kind: FailureTransient, Reason: "timed out", Err: err}
+	}
+
+	// Output first: yt-dlp exits 1 for nearly everything, the message says why
+	for _, p := range outputPatterns {
+		if p.re.MatchString(output) {
+			return &Failure{Kind: p.kind, Reason: p.reason, Err: err}
+		}
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		// 2 is a usage error: retrying the same arguments won't help
+		if exitErr.ExitCode() == 2 {
+			return &Failure{Kind: FailurePermanent, Reason: "downloader rejected the arguments", Err: err}
+		}
+		return &Failure{Kind: FailureTransient, Reason: fmt.Sprintf("downloader exited with %d", exitErr.ExitCode()), Err: err}
+	}
+	if errors.Is(err, exec.ErrNotFound) {
+		// not the song's fault, but retrying is pointless until it's installed
+		return &Failure{Kind: FailureTransient, Reason: "downloader not installed", Err: err}
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return &Failure{Kind: FailureTransient, Reason: "network error", Err: err}
+	}
+
+	return &Failure{Kind: FailureTransient, Reason: "download failed", Err: err}
+}
+
+func classifyHTTPStatus(statusErr *httpStatusError, err error) *Failure {
+	switch code := statusErr.StatusCode; {
+	case code == http.StatusTooManyRequests:
+		return &Failure{Kind: FailureTransient, Reason: "rate limited", RetryAfter: statusErr.RetryAfter, Err: err}
+	case code == http.StatusNotFound, code == http.StatusGone:
+		return &Failure{Kind: FailurePermanent, Reason: "not found on the mirror", Err: err}
+	case code == http.StatusForbidden, code == http.StatusUnavailableForLegalReasons:
+		return &Failure{Kind: FailurePermanent, Reason: "download not allowed", Err: err}
+	case code >= 500, code == http.StatusRequestTimeout:
+		return &Failure{Kind: FailureTransient, Reason: "server error", RetryAfter: statusErr.RetryAfter, Err: err}
+	default:
+		return &Failure{Kind: FailurePermanent, Reason: fmt.Sprintf("unexpected http status %d", code), Err: err}
+	}
+}
+
+// backoff is the wait before attempt n+1: base * 2^(n-1), capped, with some
+// jitter so a batch that failed together doesn't retry together
+func backoff(base, maxDelay time.Duration, attempt int, retryAfter time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxDelay)
+	delay += time.Duration(rand.Int64N(int64(delay)/5 + 1))
+	return max(delay, retryAfter)
+}
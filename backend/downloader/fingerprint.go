@@ -0,0 +1,97 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"cryogon/rizumu-backend/fingerprint"
+	"cryogon/rizumu-backend/store"
+	"cryogon/rizumu-backend/tags"
+)
+
+// minMatchScore is the lookup score under which a match isn't trusted to
+// name a song
+const minMatchScore = 0.8
+
+// Fingerprinting tells if fpcalc or ffmpeg was found to fingerprint with
+func (s *Service) Fingerprinting() bool {
+	return s.fingerprinting
+}
+
+// Fingerprint saves the fingerprint of song's file, computed again only
+// with recompute. A song still titled store.PendingTitle is then looked up
+// and named after the best match, which is returned (nil if none).
+func (s *Service) Fingerprint(ctx context.Context, song *store.Song, recompute bool) (*fingerprint.Match, error) {
+	var fp *fingerprint.Fingerprint
+	if !recompute {
+		saved, err := s.Store.GetFingerprint(ctx, song.ID)
+		if err != nil {
+			return nil, err
+		}
+		if saved != nil {
+			fp = &fingerprint.Fingerprint{Value: saved.Fingerprint, Duration: saved.Duration}
+		}
+	}
+	if fp == nil {
+		var err error
+		if fp, err = fingerprint.Compute(ctx, song.FilePath); err != nil {
+			return nil, err
+		}
+		err = s.Store.SaveFingerprint(ctx, &store.SongFingerprint{
+			SongID: song.ID, Fingerprint: fp.Value, Hash: fp.Hash(), Duration: fp.Duration,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if song.Title != store.PendingTitle || s.lookup == nil {
+		return nil, nil
+	}
+	return s.identify(ctx, song, fp)
+}
+
+// identify names song after the best lookup match, in the database and in
+// the tags of the file if it's a download
+func (s *Service) identify(ctx context.Context, song *store.Song, fp *fingerprint.Fingerprint) (*fingerprint.Match, error) {
+	matches, err := s.lookup.Lookup(ctx, fp)
+	if err != nil {
+		return nil, fmt.Errorf("lookup of song %d: %w", song.ID, err)
+	}
+	if len(matches) == 0 || matches[0].Score < minMatchScore {
+		return nil, nil
+	}
+
+	match := matches[0]
+	ok, err := s.Store.IdentifySong(ctx, song.ID, match.Title, match.Artist, match.Album, match.DurationMs)
+	if err != nil || !ok {
+		return nil, err
+	}
+	log.Printf("[Downloader] Identified song %d as %s - %s (score %.2f)", song.ID, match.Artist, match.Title, match.Score)
+
+	if err := s.saveCredits(ctx, song.ID, match.Artist, match.Album); err != nil {
+		log.Printf("WARN: Failed to save artists of song %d: %v", song.ID, err)
+	}
+	if s.layout.Contains(song.FilePath) {
+		if err := tags.Write(song.FilePath, &tags.Tags{Title: match.Title, Artist: match.Artist, Album: match.Album}); err != nil {
+			log.Printf("WARN: Failed to save tags: %v", err)
+		}
+	}
+	return &match, nil
+}
+
+// fingerprintDownload fingerprints a song once its download is complete
+func (s *Service) fingerprintDownload(ctx context.Context, songID int64) {
+	if !s.fingerprinting {
+		return
+	}
+	song, err := s.Store.GetSong(ctx, songID)
+	if err != nil {
+		log.Printf("[Worker] WARN: Failed to load song %d to fingerprint: %v", songID, err)
+		return
+	}
+	if _, err := s.Fingerprint(ctx, song, true); err != nil {
+		log.Printf("[Worker] WARN: Failed to fingerprint song %d: %v", songID, err)
+	}
+}
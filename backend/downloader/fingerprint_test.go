@@ -0,0 +1,85 @@
+package downloader
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"cryogon/rizumu-backend/fingerprint"
+	"cryogon/rizumu-backend/store"
+)
+
+// fakeLookup answers every lookup with match
+type fakeLookup struct {
+	match fingerprint.Match
+	calls int
+}
+
+func (f *fakeLookup) Lookup(ctx context.Context, fp *fingerprint.Fingerprint) ([]fingerprint.Match, error) {
+	f.calls++
+	return []fingerprint.Match{f.match}, nil
+}
+
+func TestFingerprintIdentifiesPendingSongs(t *testing.T) {
+	tests := []struct {
+		name    string
+		title   string
+		score   float64
+		renamed bool
+	}{
+		{"pending, good match", store.PendingTitle, 0.8, true},
+		{"pending, weak match", store.PendingTitle, 0.79, false},
+		{"already named", "My Title", 0.99, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db, err := store.NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lookup := &fakeLookup{match: fingerprint.Match{
+				Score: tt.score, Title: "Under Pressure", Artist: "Queen & David Bowie", Album: "Hot Space", DurationMs: 248000,
+			}}
+			svc := NewService(db, Config{Layout: Layout{Root: t.TempDir()}, Lookup: lookup})
+
+			id, err := db.SaveSong(ctx, &store.Song{
+				Title: tt.title, Artist: "Unknown", Provider: "youtube", ProviderID: "abc", Status: "Downloaded",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// saved already, so nothing needs fpcalc or ffmpeg
+			err = db.SaveFingerprint(ctx, &store.SongFingerprint{SongID: id, Fingerprint: "AQAAfp", Hash: "hash", Duration: 248})
+			if err != nil {
+				t.Fatal(err)
+			}
+			song, err := db.GetSong(ctx, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			match, err := svc.Fingerprint(ctx, song, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (match != nil) != tt.renamed {
+				t.Errorf("match = %+v, want one: %v", match, tt.renamed)
+			}
+			if song.Title != store.PendingTitle && lookup.calls > 0 {
+				t.Error("a song with a title was looked up")
+			}
+
+			song, err = db.GetSong(ctx, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if renamed := song.Title == "Under Pressure"; renamed != tt.renamed {
+				t.Errorf("title = %q, renamed: %v, want %v", song.Title, renamed, tt.renamed)
+			}
+			if tt.renamed && (song.Artist != "Queen & David Bowie" || song.Album != "Hot Space" || song.DurationMs != 248000) {
+				t.Errorf("song = %q / %q / %d", song.Artist, song.Album, song.DurationMs)
+			}
+		})
+	}
+}
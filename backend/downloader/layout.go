@@ -0,0 +1,297 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"cryogon/rizumu-backend/store"
+	"cryogon/rizumu-backend/tags"
+)
+
+// DefaultTemplate keeps the flat {id}.mp3 names of the first versions
+const DefaultTemplate = "{id}.{ext}"
+
+// maxNameLength keeps each path part under the 255 bytes most filesystems allow
+const maxNameLength = 200
+
+// Layout is where downloaded files live: Root/songs named by Template,
+// Root/covers for the artwork sources extract and Root/cache/covers for the
+// artwork cache
+type Layout struct {
+	Root string
+	// Template names a song file under Root/songs. Placeholders: {id},
+	// {title}, {artist}, {album}, {track}, {provider} and {ext}, '/' makes
+	// folders, e.g. "{artist}/{album}/{track} - {title}.{ext}". Parts left
+	// empty (no album, no track number) are dropped.
+	Template string
+}
+
+// fallbackRoot is the root when there's no home, a folder of its own: the
+// layout may move or delete anything under its root, and the working
+// directory also holds rizumu.db and rizumu.key
+const fallbackRoot = "rizumu-data"
+
+// DefaultRoot is $XDG_DATA_HOME/rizumu, ~/.local/share/rizumu if unset
+func DefaultRoot() string {
+	if dir := os.Getenv("XDG_DATA_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "rizumu")
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".local", "share", "rizumu")
+	}
+	// no home (a bare container)
+	return fallbackRoot
+}
+
+func DefaultLayout() Layout {
+	return Layout{Root: DefaultRoot(), Template: DefaultTemplate}
+}
+
+func (l Layout) SongsDir() string {
+	return filepath.Join(l.Root, "songs")
+}
+
+func (l Layout) CoversDir() string {
+	return filepath.Join(l.Root, "covers")
+}
+
+// CoverCacheDir holds the covers.Cache, artwork fetched from ImageURL and
+// its thumbnails
+func (l Layout) CoverCacheDir() string {
+	return filepath.Join(l.Root, "cache", "covers")
+}
+
+// WorkPath is where a source downloads the song to, without the extension
+// (it depends on the quality). It's moved to SongPath once tagged.
+func (l Layout) WorkPath(songID int64) string {
+	return filepath.Join(l.SongsDir(), strconv.FormatInt(songID, 10))
+}
+
+// CoverPath is where the artwork of a song is saved, ext with the dot
+func (l Layout) CoverPath(songID int64, ext string) string {
+	return filepath.Join(l.CoversDir(), fmt.Sprintf("%d%s", songID, ext))
+}
+
+// SongPath is where the file of song belongs according to the template.
+// track is the track number, 0 when unknown.
+func (l Layout) SongPath(song *store.Song, track int, ext string) string {
+	tmpl := l.Template
+	if tmpl == "" {
+		tmpl = DefaultTemplate
+	}
+
+	trackStr := ""
+	if track > 0 {
+		trackStr = fmt.Sprintf("%02d", track)
+	}
+	values := map[string]string{
+		"{id}":       strconv.FormatInt(song.ID, 10),
+		"{title}":    song.Title,
+		"{artist}":   song.Artist,
+		"{album}":    song.Album,
+		"{track}":    trackStr,
+		"{provider}": song.Provider,
+	}
+
+	var parts []string
+	for part := range strings.SplitSeq(filepath.ToSlash(tmpl), "/") {
+		// the extension is added after cleaning, trimming would eat the dot
+		part = strings.TrimSuffix(part, ".{ext}")
+		for key, value := range values {
+			part = strings.ReplaceAll(part, key, sanitizeName(value))
+		}
+		// "{track} - {title}" without a track number
+		part = strings.Trim(part, " -_.")
+		if part != "" {
+			parts = append(parts, truncateName(part))
+		}
+	}
+	if len(parts) == 0 {
+		parts = []string{strconv.FormatInt(song.ID, 10)}
+	}
+
+	parts[len(parts)-1] += "." + strings.TrimPrefix(ext, ".")
+	return filepath.Join(append([]string{l.SongsDir()}, parts...)...)
+}
+
+// legacyDirs are where files went before there was a library root,
+// relative to the working directory
+var legacyDirs = []string{"songs", "covers"}
+
+// FileDirs are the folders holding the song and cover files the layout
+// manages, as absolute paths. refs are the files the songs point at: a
+// folder of older versions only counts while a relative path in refs
+// still leads into it. Otherwise it's whatever "songs" folder the backend
+// was started next to.
+func (l Layout) FileDirs(refs []string) []string {
+	var dirs []string
+	add := func(dir string) {
+		if abs, err := filepath.Abs(dir); err == nil && !slices.Contains(dirs, abs) {
+			dirs = append(dirs, abs)
+		}
+	}
+	add(l.SongsDir())
+	add(l.CoversDir())
+	for _, dir := range legacyDirs {
+		for _, ref := range refs {
+			if !filepath.IsAbs(ref) && strings.HasPrefix(filepath.Clean(ref), dir+string(filepath.Separator)) {
+				add(dir)
+				break
+			}
+		}
+	}
+	return dirs
+}
+
+// Contains tells if path is one of the files the layout manages, the ones
+// it may move or delete. Files imported from elsewhere (library folders,
+// an osu! install) are not.
+func (l Layout) Contains(path string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+
+	dirs := []string{l.Root}
+	if !filepath.IsAbs(path) {
+		dirs = append(dirs, legacyDirs...)
+	}
+	for _, dir := range dirs {
+		dir, err := filepath.Abs(dir)
+		if err == nil && strings.HasPrefix(abs, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// sanitizeName makes a tag value safe as a file name on Linux, macOS and
+// Windows (a library on a shared drive)
+func sanitizeName(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r < 0x20, r == 0x7f:
+			return -1
+		case strings.ContainsRune(`/\:*?"<>|`, r):
+			return '_'
+		}
+		return r
+	}, s)
+	return strings.TrimSpace(s)
+}
+
+func truncateName(s string) string {
+	if len(s) <= maxNameLength {
+		return s
+	}
+	// don't cut a multi-byte character in half
+	cut := maxNameLength
+	for cut > 0 && !isRuneStart(s[cut]) {
+		cut--
+	}
+	return strings.TrimSpace(s[:cut])
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
+
+// Place moves the downloaded file of song to where the template wants it
+// and returns the new path. A different file already there is not
+// overwritten, a " (2)" suffix is added instead.
+func (l Layout) Place(song *store.Song, path string) (string, error) {
+	dest := l.SongPath(song, TrackNumber(path), filepath.Ext(path))
+	if dest == path {
+		return path, nil
+	}
+	dest = FreePath(dest, path)
+
+	if err := MoveFile(path, dest); err != nil {
+		return "", err
+	}
+	return dest, nil
+}
+
+// FreePath returns dest, or dest with a number if another file is already
+// there (two songs with the same title and artist) or one of reserved is
+// about to be. self is the file being moved, which may already have a
+// number.
+func FreePath(dest, self string, reserved ...string) string {
+	ext := filepath.Ext(dest)
+	base := strings.TrimSuffix(dest, ext)
+	candidate := dest
+	for i := 2; ; i++ {
+		if SamePath(candidate, self) {
+			return candidate
+		}
+		_, err := os.Lstat(candidate)
+		if errors.Is(err, os.ErrNotExist) && !slices.Contains(reserved, candidate) {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+}
+
+// SamePath tells if a and b are the same path once absolute
+func SamePath(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
+// MoveFile renames from to to, creating the folders. Across filesystems it
+// copies then removes the original.
+func MoveFile(from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(to), 0o755); err != nil {
+		return err
+	}
+	err := os.Rename(from, to)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	if err := copyFile(from, to); err != nil {
+		_ = os.Remove(to)
+		return err
+	}
+	return os.Remove(from)
+}
+
+func copyFile(from, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// TrackNumber reads the track number from the file tags, 0 if there is none
+func TrackNumber(path string) int {
+	t, err := tags.Read(path)
+	if err != nil {
+		// no readable tag, no track number
+		return 0
+	}
+	return t.Track
+}
@@ -0,0 +1,53 @@
+package downloader
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFreePath(t *testing.T) {
+	dir := t.TempDir()
+	song := filepath.Join(dir, "Song.mp3")
+	second := filepath.Join(dir, "Song (2).mp3")
+	third := filepath.Join(dir, "Song (3).mp3")
+	if err := os.WriteFile(song, []byte("taken"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, self string
+		reserved   []string
+		want       string
+	}{
+		{"taken", filepath.Join(dir, "other.mp3"), nil, second},
+		{"already there", song, nil, song},
+		{"already numbered", second, nil, second},
+		{"reserved", filepath.Join(dir, "other.mp3"), []string{second}, third},
+	}
+	for _, tt := range tests {
+		if got := FreePath(song, tt.self, tt.reserved...); got != tt.want {
+			t.Errorf("%s: FreePath = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	free := filepath.Join(dir, "Free.mp3")
+	if got := FreePath(free, song); got != free {
+		t.Errorf("FreePath of a free path = %s", got)
+	}
+}
+
+func TestDefaultRootWithoutHome(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", "")
+	t.Setenv("HOME", "")
+
+	layout := Layout{Root: DefaultRoot()}
+	for _, name := range []string{"rizumu.db", "rizumu.key"} {
+		if layout.Contains(name) {
+			t.Errorf("the layout at %q manages %s", layout.Root, name)
+		}
+	}
+	if !layout.Contains(layout.WorkPath(1) + ".mp3") {
+		t.Errorf("the layout at %q doesn't manage its own songs", layout.Root)
+	}
+}
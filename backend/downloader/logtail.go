@@ -0,0 +1,51 @@
+package downloader
+
+import (
+	"strings"
+	"sync"
+)
+
+// logTailLines is how much downloader output is kept with a task
+const logTailLines = 50
+
+// logTail keeps the last lines written by yt-dlp/spotdl, stdout and stderr
+// interleaved, so a failed task can be debugged without the server logs
+type logTail struct {
+	mu    sync.Mutex
+	lines []string
+	last  int // where the output of the last command starts in lines
+}
+
+// command marks the start of the output of another command, a fallback
+// after spotdl for instance
+func (l *logTail) command(name string) {
+	l.mu.Lock()
+	l.last = len(l.lines)
+	l.mu.Unlock()
+	l.add("--- " + name + " ---")
+}
+
+func (l *logTail) add(line string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.lines = append(l.lines, line)
+	if over := len(l.lines) - logTailLines; over > 0 {
+		l.lines = l.lines[over:]
+		l.last = max(l.last-over, 0)
+	}
+}
+
+func (l *logTail) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return strings.Join(l.lines, "\n")
+}
+
+// lastCommand is the output of the last command that ran, what its failure
+// is classified from
+func (l *logTail) lastCommand() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return strings.Join(l.lines[l.last:], "\n")
+}
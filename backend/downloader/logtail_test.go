@@ -0,0 +1,29 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestClassifyLastCommand(t *testing.T) {
+	var logs logTail
+	logs.command("spotdl")
+	logs.add("LookupError: No results found for song: Artist - Title")
+	logs.command("fallback")
+	logs.add("ERROR: Unable to download webpage: <urlopen error [Errno -3] Temporary failure in name resolution>")
+
+	// the fallback lost the network, spotdl's lookup has nothing to do with it
+	failure := classifyFailure(errors.New("exit status 1"), logs.lastCommand())
+	if failure.Kind != FailureTransient || failure.Reason != "network error" {
+		t.Errorf("classified as %s %q, want a transient network error", failure.Kind, failure.Reason)
+	}
+
+	// the last command's output survives the tail being trimmed
+	for i := range logTailLines + 10 {
+		logs.add(fmt.Sprintf("[download] line %d", i))
+	}
+	if out := logs.lastCommand(); out != logs.String() {
+		t.Errorf("lastCommand trimmed differently from the tail:\n%s", out)
+	}
+}
@@ -6,8 +6,11 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+
+	"cryogon/rizumu-backend/store"
 )
 
 type FileMetadata struct {
@@ -15,9 +18,10 @@ type FileMetadata struct {
 	Artist     string
 	Album      string
 	DurationMs int64
-	Bitrate    int
-	Format     string
+	Bitrate    int    // kbps
+	Format     string // codec of the audio stream: mp3, opus, aac, flac...
 	Size       int64
+	HasAudio   bool // false for files ffprobe reads but holding no sound (an image)
 }
 
 type ffprobeOutput struct {
@@ -33,6 +37,11 @@ type ffprobeOutput struct {
 			Album  string `json:"album"`
 		} `json:"tags"`
 	} `json:"format"`
+	Streams []struct {
+		CodecType string `json:"codec_type"`
+		CodecName string `json:"codec_name"`
+		BitRate   string `json:"bit_rate"`
+	} `json:"streams"`
 }
 
 // ProbeFile analyzes a media file using ffprobe
@@ -49,6 +58,7 @@ func ProbeFile(path string) (*FileMetadata, error) {
 		"-v", "quiet",
 		"-print_format", "json",
 		"-show_format",
+		"-show_streams",
 		path,
 	)
 	output, err := cmd.Output()
@@ -69,8 +79,6 @@ func ProbeFile(path string) (*FileMetadata, error) {
 	// Duration (seconds string -> ms int64)
 	if durSec, err := strconv.ParseFloat(data.Format.Duration, 64); err == nil {
 		meta.DurationMs = int64(durSec * 1000)
-		fmt.Printf("Duration: Sec(%f) Ms (%f)", durSec, meta.DurationMs)
-
 	}
 
 	// Bitrate (bps string -> kbps int)
@@ -78,8 +86,21 @@ func ProbeFile(path string) (*FileMetadata, error) {
 		meta.Bitrate = int(br / 1000)
 	}
 
-	// Format
+	// Format: the container name is vague ("ogg" for opus and vorbis, a
+	// list for m4a), the audio codec says what the file really is
 	meta.Format = data.Format.FormatName
+	for _, stream := range data.Streams {
+		if stream.CodecType != "audio" {
+			continue
+		}
+		meta.HasAudio = true
+		meta.Format = stream.CodecName
+		// the container rate counts the cover art too
+		if br, err := strconv.ParseInt(stream.BitRate, 10, 64); err == nil {
+			meta.Bitrate = int(br / 1000)
+		}
+		break
+	}
 
 	// Tags
 	meta.Title = data.Format.Tags.Title
@@ -105,3 +126,39 @@ func ProbeFile(path string) (*FileMetadata, error) {
 
 	return meta, nil
 }
+
+// ProbeSongFile is what the songs row keeps about the file at path
+func ProbeSongFile(path string) (store.SongFile, error) {
+	meta, err := ProbeFile(path)
+	if err != nil {
+		return store.SongFile{Path: path}, err
+	}
+	return store.SongFile{
+		Path:       path,
+		Size:       meta.Size,
+		Bitrate:    meta.Bitrate,
+		Format:     meta.Format,
+		DurationMs: meta.DurationMs,
+	}, nil
+}
+
+// featSplitRegex splits "A feat. B", "A ft. B", "A; B" (ID3 multi-value) and "A / B".
+// "&" and "," are left alone, too many real names contain them.
+var featSplitRegex = regexp.MustCompile(`(?i)\s+(?:feat\.?|ft\.?|featuring)\s+|\s*;\s*|\s+/\s+`)
+
+// SplitArtists turns an artist tag into credits: first is primary, rest featured
+func SplitArtists(artist string) []store.SongArtist {
+	var credits []store.SongArtist
+	for _, name := range featSplitRegex.Split(artist, -1) {
+		name = strings.TrimSpace(strings.Trim(strings.TrimSpace(name), "()"))
+		if name == "" {
+			continue
+		}
+		role := "featured"
+		if len(credits) == 0 {
+			role = "primary"
+		}
+		credits = append(credits, store.SongArtist{Name: name, Role: role})
+	}
+	return credits
+}
@@ -1,10 +1,27 @@
 package downloader
 
+import (
+	"context"
+	"sync"
+	"time"
+)
+
 type DownloadPayload struct {
-	URL  string `json:"url"`
-	Mode string `json:"mode"` // download | stream
+	URL      string       `json:"url"`
+	Mode     string       `json:"mode"`               // download | stream
+	Priority TaskPriority `json:"priority,omitempty"` // interactive (default) | bulk
+	Quality  Quality      `json:"quality,omitempty"`  // original | mp3-320 | mp3-v0 | flac, empty for the configured one
 }
 
+type TaskPriority string
+
+const (
+	// PriorityInteractive : the user is waiting on it (play, stream, single download)
+	PriorityInteractive TaskPriority = "interactive"
+	// PriorityBulk : playlist imports, anything queued in batches
+	PriorityBulk TaskPriority = "bulk"
+)
+
 type TaskStatus string
 
 const (
@@ -13,38 +30,172 @@ const (
 	StatusComplete     TaskStatus = "Complete"
 	StatusNotAvailable TaskStatus = "Not Available"
 	StatusFailed       TaskStatus = "StatusFailed"
+	StatusCancelled    TaskStatus = "Cancelled"
 )
 
+// Task is a queued download. ID is the download_tasks row, SongID the song
+// the file belongs to.
 type Task struct {
-	ID       int64      `json:"id"`
-	URL      string     `json:"url"`
-	Source   string     `json:"source"`   // youtube | spotify | osu!
-	Progress float64    `json:"progress"` // download progress
-	Status   TaskStatus `json:"status"`
-	Error    string     `json:"error,omitempty"`
+	ID       int64        `json:"id"`
+	SongID   int64        `json:"song_id"`
+	URL      string       `json:"url"`
+	Source   string       `json:"source"`   // youtube | spotify | osu!
+	Progress float64      `json:"progress"` // download progress
+	Status   TaskStatus   `json:"status"`
+	Priority TaskPriority `json:"priority"`
+	Quality  Quality      `json:"quality,omitempty"`
+	Attempts int          `json:"attempts"`
+	Error    string       `json:"error,omitempty"`
+
+	// Live progress, see TaskEvent
+	Downloaded int64   `json:"downloaded,omitempty"`
+	Total      int64   `json:"total,omitempty"`
+	Speed      float64 `json:"speed,omitempty"`
+	ETA        int64   `json:"eta,omitempty"`
+
+	logs logTail
+
+	// mu guards what the worker changes while the task is visible to
+	// ActiveTasks and Snapshot: status, error, attempts, priority, quality
+	// and progress
+	mu          sync.Mutex
+	startedAt   time.Time
+	lastEventAt time.Time
+	lastSavedAt time.Time
+
+	ctlMu     sync.Mutex
+	cancel    context.CancelFunc // set while a worker runs the task
+	cancelled bool
 }
 
-type DownloadSource int
+// setStatus changes the status and error, keeping the progress
+func (t *Task) setStatus(status TaskStatus, errMsg string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Status, t.Error = status, errMsg
+}
 
-const (
-	SourceUnknown DownloadSource = iota // 0 (This is our "zero value")
-	SourceYoutube                       // 1
-	SourceSpotify                       // 2
-	SourceOsu
-	SourceYTMusic
-)
+// begin marks a new attempt and resets the progress of the previous one
+func (t *Task) begin() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Status, t.Error = StatusDownloading, ""
+	t.Attempts++
+	t.Progress, t.Downloaded, t.Total, t.Speed, t.ETA = 0, 0, 0, 0, 0
+	t.startedAt = time.Now()
+	t.lastEventAt, t.lastSavedAt = time.Time{}, time.Now()
+}
+
+// updateProgress records p, filling speed and ETA from the elapsed time when
+// the source doesn't know them. It tells if the change is worth an event
+// and a DB write.
+func (t *Task) updateProgress(p Progress) (publish, save bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	elapsed := now.Sub(t.startedAt).Seconds()
+
+	t.Progress = p.Percent
+	t.Downloaded, t.Total = p.Downloaded, p.Total
+	if t.Total > 0 && t.Downloaded > 0 && t.Progress == 0 {
+		t.Progress = float64(t.Downloaded) / float64(t.Total) * 100
+	}
+
+	t.Speed = p.Speed
+	if t.Speed == 0 && t.Downloaded > 0 && elapsed > 0 {
+		t.Speed = float64(t.Downloaded) / elapsed
+	}
 
-func (s DownloadSource) String() string {
-	switch s {
-	case SourceYoutube:
-		return "youtube"
-	case SourceSpotify:
-		return "spotify"
-	case SourceYTMusic:
-		return "youtube-music"
-	case SourceOsu:
-		return "osu!"
+	switch {
+	case p.ETA > 0:
+		t.ETA = int64(p.ETA.Seconds())
+	case t.Progress > 0 && t.Progress < 100 && elapsed > 0:
+		t.ETA = int64(elapsed * (100 - t.Progress) / t.Progress)
 	default:
-		return "unknown"
+		t.ETA = 0
+	}
+
+	done := t.Progress >= 100
+	publish = done || now.Sub(t.lastEventAt) >= progressEventInterval
+	save = done || now.Sub(t.lastSavedAt) >= progressSaveInterval
+	if publish {
+		t.lastEventAt = now
+	}
+	if save {
+		t.lastSavedAt = now
+	}
+	return publish, save
+}
+
+// Snapshot copies what the worker changes under the lock, for handing the
+// task out (JSON) while it runs
+func (t *Task) Snapshot() *Task {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return &Task{
+		ID:         t.ID,
+		SongID:     t.SongID,
+		URL:        t.URL,
+		Source:     t.Source,
+		Progress:   t.Progress,
+		Status:     t.Status,
+		Priority:   t.Priority,
+		Quality:    t.Quality,
+		Attempts:   t.Attempts,
+		Error:      t.Error,
+		Downloaded: t.Downloaded,
+		Total:      t.Total,
+		Speed:      t.Speed,
+		ETA:        t.ETA,
+	}
+}
+
+func (t *Task) event(typ TaskEventType) TaskEvent {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return TaskEvent{
+		Type:       typ,
+		TaskID:     t.ID,
+		SongID:     t.SongID,
+		Source:     t.Source,
+		Status:     t.Status,
+		Progress:   t.Progress,
+		Downloaded: t.Downloaded,
+		Total:      t.Total,
+		Speed:      t.Speed,
+		ETA:        t.ETA,
+		Attempts:   t.Attempts,
+		Error:      t.Error,
+		At:         time.Now(),
 	}
 }
+
+// start derives the context the job runs under
+func (t *Task) start(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(parent, timeout)
+
+	t.ctlMu.Lock()
+	defer t.ctlMu.Unlock()
+	t.cancel = cancel
+	if t.cancelled {
+		// cancelled between being picked and starting
+		cancel()
+	}
+	return ctx, cancel
+}
+
+func (t *Task) requestCancel() {
+	t.ctlMu.Lock()
+	defer t.ctlMu.Unlock()
+	t.cancelled = true
+	if t.cancel != nil {
+		t.cancel()
+	}
+}
+
+func (t *Task) isCancelled() bool {
+	t.ctlMu.Lock()
+	defer t.ctlMu.Unlock()
+	return t.cancelled
+}
@@ -2,26 +2,51 @@ package downloader
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
 	"io"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+
+	"cryogon/rizumu-backend/store"
 )
 
 type OsuMetadata struct {
 	Title         string
+	TitleUnicode  string
 	Artist        string
+	ArtistUnicode string
+	Creator       string
+	Source        string
+	Tags          []string
+	BeatmapID     int // 0 when unknown, -1 for unsubmitted maps
+	BeatmapSetID  int
 	AudioFilename string
 	BgFilename    string
+	PreviewTime   int // ms into the audio, -1 when the mapper didn't set one
 	BPM           float64
+	MinBPM        float64
+	MaxBPM        float64
 	Version       string
+
+	// Difficulty settings, what the star rating depends on
+	Mode              int // 0 osu!, 1 taiko, 2 catch, 3 mania
+	HPDrainRate       float64
+	CircleSize        float64
+	OverallDifficulty float64
+	ApproachRate      float64
 }
 
 var bgEventRegex = regexp.MustCompile(`0,0,"(.+?)",`)
 
-func parseOsuFile(r io.Reader) OsuMetadata {
+// ParseOsuFile reads the metadata of a .osu difficulty file
+func ParseOsuFile(r io.Reader) OsuMetadata {
 	scanner := bufio.NewScanner(r)
-	meta := OsuMetadata{}
+	meta := OsuMetadata{PreviewTime: -1}
+	hasAR := false
 
 	section := ""
 
@@ -38,49 +63,209 @@ func parseOsuFile(r io.Reader) OsuMetadata {
 		}
 
 		// Parse Key:Value pairs
-		if section == "[General]" {
-			if strings.HasPrefix(line, "AudioFilename:") {
-				meta.AudioFilename = strings.TrimSpace(strings.SplitN(line, ":", 2)[1])
+		key, value, _ := strings.Cut(line, ":")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		switch section {
+		case "[General]":
+			switch key {
+			case "AudioFilename":
+				meta.AudioFilename = value
+			case "PreviewTime":
+				meta.PreviewTime = parseOsuInt(value, -1)
+			case "Mode":
+				meta.Mode = parseOsuInt(value, 0)
 			}
-		}
 
-		if section == "[Metadata]" {
-			if strings.HasPrefix(line, "Title:") {
-				meta.Title = strings.TrimSpace(strings.SplitN(line, ":", 2)[1])
+		case "[Metadata]":
+			switch key {
+			case "Title":
+				meta.Title = value
+			case "TitleUnicode":
+				meta.TitleUnicode = value
+			case "Artist":
+				meta.Artist = value
+			case "ArtistUnicode":
+				meta.ArtistUnicode = value
+			case "Creator":
+				meta.Creator = value
+			case "Source":
+				meta.Source = value
+			case "Tags":
+				meta.Tags = strings.Fields(value)
+			case "Version":
+				meta.Version = value
+			case "BeatmapID":
+				meta.BeatmapID = parseOsuInt(value, 0)
+			case "BeatmapSetID":
+				meta.BeatmapSetID = parseOsuInt(value, 0)
 			}
-			if strings.HasPrefix(line, "Artist:") {
-				meta.Artist = strings.TrimSpace(strings.SplitN(line, ":", 2)[1])
+
+		case "[Difficulty]":
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
 			}
-			if strings.HasPrefix(line, "Version:") {
-				meta.Version = strings.TrimSpace(strings.SplitN(line, ":", 2)[1])
+			switch key {
+			case "HPDrainRate":
+				meta.HPDrainRate = v
+			case "CircleSize":
+				meta.CircleSize = v
+			case "OverallDifficulty":
+				meta.OverallDifficulty = v
+			case "ApproachRate":
+				meta.ApproachRate = v
+				hasAR = true
 			}
-		}
 
-		if section == "[Events]" {
+		case "[Events]":
 			// Look for background image: 0,0,"bg.jpg"
-			if bgEventRegex.MatchString(line) {
-				matches := bgEventRegex.FindStringSubmatch(line)
-				if len(matches) > 1 {
+			if meta.BgFilename == "" {
+				if matches := bgEventRegex.FindStringSubmatch(line); len(matches) > 1 {
 					meta.BgFilename = matches[1]
 				}
 			}
-		}
 
-		if section == "[TimingPoints]" {
+		case "[TimingPoints]":
 			// Format: Offset, MillisecondsPerBeat, ...
-			// We only take the first uninherited timing point (positive value)
+			// Uninherited timing points have a positive value, inherited
+			// ones (slider velocity changes) a negative one
 			parts := strings.Split(line, ",")
-			if len(parts) >= 2 {
-				msPerBeat, err := strconv.ParseFloat(parts[1], 64)
-				if err == nil && msPerBeat > 0 {
-					// BPM = 60000 / msPerBeat
-					// Only capture if we haven't found BPM yet (usually the first one is main)
-					if meta.BPM == 0 {
-						meta.BPM = 60000 / msPerBeat
-					}
-				}
+			if len(parts) < 2 {
+				continue
+			}
+			msPerBeat, err := strconv.ParseFloat(parts[1], 64)
+			if err != nil || msPerBeat <= 0 {
+				continue
+			}
+			bpm := 60000 / msPerBeat
+			// the first one is usually the main BPM
+			if meta.BPM == 0 {
+				meta.BPM = bpm
+			}
+			if meta.MinBPM == 0 || bpm < meta.MinBPM {
+				meta.MinBPM = bpm
+			}
+			if bpm > meta.MaxBPM {
+				meta.MaxBPM = bpm
 			}
 		}
 	}
+
+	// before v8 there was no ApproachRate, it followed OverallDifficulty
+	if !hasAR {
+		meta.ApproachRate = meta.OverallDifficulty
+	}
 	return meta
 }
+
+func parseOsuInt(value string, fallback int) int {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fallback
+	}
+	return n
+}
+
+// TagNames are the source and the tags of the beatmap, lower case, as song tags
+func (m OsuMetadata) TagNames() []string {
+	var names []string
+	for _, name := range append([]string{m.Source}, m.Tags...) {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// OsuDifficulty is a parsed .osu file and the MD5 of its content, which is
+// how osu! refers to a difficulty (collection.db, scores)
+type OsuDifficulty struct {
+	OsuMetadata
+	Checksum string
+}
+
+func ParseOsuDifficulty(r io.Reader) (OsuDifficulty, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return OsuDifficulty{}, err
+	}
+	sum := md5.Sum(data)
+	return OsuDifficulty{
+		OsuMetadata: ParseOsuFile(bytes.NewReader(data)),
+		Checksum:    hex.EncodeToString(sum[:]),
+	}, nil
+}
+
+// Beatmap is the row saved for the difficulty
+func (d OsuDifficulty) Beatmap() store.OsuBeatmap {
+	return store.OsuBeatmap{
+		BeatmapID:         d.BeatmapID,
+		BeatmapSetID:      d.BeatmapSetID,
+		Checksum:          d.Checksum,
+		Version:           d.Version,
+		Creator:           d.Creator,
+		TitleUnicode:      d.TitleUnicode,
+		ArtistUnicode:     d.ArtistUnicode,
+		Source:            d.Source,
+		Mode:              d.Mode,
+		HPDrainRate:       d.HPDrainRate,
+		CircleSize:        d.CircleSize,
+		OverallDifficulty: d.OverallDifficulty,
+		ApproachRate:      d.ApproachRate,
+		BPM:               d.BPM,
+		MinBPM:            d.MinBPM,
+		MaxBPM:            d.MaxBPM,
+	}
+}
+
+// GroupOsuDifficulties splits the difficulties of a beatmapset by audio
+// file (case-insensitive, like osu!), the most used audio first. Most sets
+// have a single group.
+func GroupOsuDifficulties(diffs []OsuDifficulty) [][]OsuDifficulty {
+	var groups [][]OsuDifficulty
+	index := make(map[string]int)
+	for _, d := range diffs {
+		key := strings.ToLower(d.AudioFilename)
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], d)
+	}
+	slices.SortStableFunc(groups, func(a, b []OsuDifficulty) int { return len(b) - len(a) })
+	return groups
+}
+
+// OsuSetInfo is what a group of difficulties sharing an audio file tells
+// about the song
+type OsuSetInfo struct {
+	Main       OsuMetadata // the first difficulty, for the title and artist
+	Background string      // first background set by a difficulty
+	PreviewMs  *int64
+	Tags       []string
+	Beatmaps   []store.OsuBeatmap
+}
+
+func NewOsuSetInfo(diffs []OsuDifficulty) OsuSetInfo {
+	info := OsuSetInfo{Main: diffs[0].OsuMetadata}
+	for _, d := range diffs {
+		if info.Background == "" {
+			info.Background = d.BgFilename
+		}
+		if info.PreviewMs == nil && d.PreviewTime >= 0 {
+			ms := int64(d.PreviewTime)
+			info.PreviewMs = &ms
+		}
+		for _, tag := range d.TagNames() {
+			if !slices.Contains(info.Tags, tag) {
+				info.Tags = append(info.Tags, tag)
+			}
+		}
+		info.Beatmaps = append(info.Beatmaps, d.Beatmap())
+	}
+	return info
+}
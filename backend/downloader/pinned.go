@@ -0,0 +1,35 @@
+package downloader
+
+import (
+	"context"
+	"log"
+)
+
+// DownloadPinned queues bulk downloads for the songs of pinned playlists
+// that have no file yet, only playlistID's when it's > 0. Songs already
+// queued keep their task. Returns how many were queued.
+func (s *Service) DownloadPinned(ctx context.Context, playlistID int64) (int, error) {
+	songs, err := s.Store.GetPinnedSongsToDownload(ctx, playlistID)
+	if err != nil {
+		return 0, err
+	}
+
+	queued := 0
+	for _, song := range songs {
+		sourceURL, err := s.SourceURL(song.Provider, song.ProviderID)
+		if err != nil {
+			log.Printf("[Downloader] WARN: Can't keep song %d offline: %v", song.ID, err)
+			continue
+		}
+		payload := DownloadPayload{Mode: "download", URL: sourceURL, Priority: PriorityBulk}
+		if _, err := s.CreateDownload(payload, song.ID); err != nil {
+			log.Printf("[Downloader] WARN: Failed to queue pinned song %d: %v", song.ID, err)
+			continue
+		}
+		queued++
+	}
+	if queued > 0 {
+		log.Printf("[Downloader] Queued %d songs of pinned playlists", queued)
+	}
+	return queued, nil
+}
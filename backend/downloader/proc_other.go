@@ -0,0 +1,8 @@
+//go:build !unix
+
+package downloader
+
+import "os/exec"
+
+// setProcessGroup : no process groups here, cancelling only kills cmd itself
+func setProcessGroup(cmd *exec.Cmd) {}
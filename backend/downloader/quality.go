@@ -0,0 +1,105 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Quality is the audio profile a download is saved with
+type Quality string
+
+const (
+	// QualityOriginal keeps the audio the site serves (opus, m4a), no re-encode
+	QualityOriginal Quality = "original"
+	QualityMP3_320  Quality = "mp3-320"
+	QualityMP3V0    Quality = "mp3-v0"
+	// QualityFLAC is only lossless where the source is. YouTube isn't, so
+	// there it's the same as QualityOriginal: a FLAC of an opus stream would
+	// just be bigger.
+	QualityFLAC Quality = "flac"
+)
+
+// DefaultQuality stays mp3: the player decodes it natively, opus and m4a go
+// through ffmpeg first, and every device the files get copied to plays it
+const DefaultQuality = QualityMP3V0
+
+var ErrUnknownQuality = errors.New("unknown quality")
+
+// ParseQuality reads a profile name, "best" is another name for original
+func ParseQuality(s string) (Quality, error) {
+	switch q := Quality(strings.ToLower(strings.TrimSpace(s))); q {
+	case QualityOriginal, "best":
+		return QualityOriginal, nil
+	case QualityMP3_320, QualityMP3V0, QualityFLAC:
+		return q, nil
+	}
+	return "", fmt.Errorf("%w %q, expected original, mp3-320, mp3-v0 or flac", ErrUnknownQuality, s)
+}
+
+// ytdlpArgs are the yt-dlp options extracting the audio in this quality
+func (q Quality) ytdlpArgs() []string {
+	switch q {
+	case QualityMP3_320:
+		return []string{"--extract-audio", "--audio-format", "mp3", "--audio-quality", "320K"}
+	case QualityMP3V0:
+		return []string{"--extract-audio", "--audio-format", "mp3", "--audio-quality", "0"}
+	default:
+		// original and flac: the best audio only stream, remuxed not re-encoded
+		return []string{"--format", "bestaudio/best", "--extract-audio"}
+	}
+}
+
+// spotdlArgs are the spotdl options for this quality
+func (q Quality) spotdlArgs() []string {
+	switch q {
+	case QualityMP3_320:
+		return []string{"--format", "mp3", "--bitrate", "320k"}
+	case QualityMP3V0:
+		return []string{"--format", "mp3", "--bitrate", "0"}
+	default:
+		// spotdl downloads from YouTube Music, opus without a bitrate copies it
+		return []string{"--format", "opus", "--bitrate", "disable"}
+	}
+}
+
+// audioOutputExts are the files yt-dlp and spotdl may leave once done
+var audioOutputExts = []string{".mp3", ".opus", ".m4a", ".ogg", ".webm", ".flac", ".aac", ".wav"}
+
+// findOutput returns the audio file a downloader saved as base.<ext>. If
+// there are several (an older download with another extension), the newest.
+// None is a permanent failure: the downloader ran fine but nothing matched.
+func findOutput(base string) (string, error) {
+	entries, err := os.ReadDir(filepath.Dir(base))
+	if err != nil {
+		return "", err
+	}
+
+	prefix := filepath.Base(base) + "."
+	var found string
+	var newest os.FileInfo
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, prefix) || !slices.Contains(audioOutputExts, strings.ToLower(filepath.Ext(name))) {
+			continue
+		}
+		// "12.temp.mp3" and such are still being written
+		if strings.Contains(strings.TrimPrefix(name, prefix), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if newest == nil || info.ModTime().After(newest.ModTime()) {
+			found, newest = filepath.Join(filepath.Dir(base), name), info
+		}
+	}
+	if found == "" {
+		return "", &Failure{Kind: FailurePermanent, Reason: "no matching audio found", Err: fmt.Errorf("no file at %s.*", base)}
+	}
+	return found, nil
+}
@@ -0,0 +1,207 @@
+package downloader
+
+import (
+	"sync"
+)
+
+// interactiveBurst is how many interactive tasks run before a waiting bulk
+// task gets its turn, so a playlist import can't starve a song you just hit
+// play on, and the import still moves while you listen
+const interactiveBurst = 3
+
+// taskQueue is an unbounded queue with two lanes (interactive, bulk) and a
+// concurrency cap per source
+type taskQueue struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	interactive []*Task
+	bulk        []*Task
+	queued      map[int64]*Task // by task ID, to avoid queueing a task twice
+	active      map[int64]*Task // taken by a worker
+
+	limits  map[string]int // per source, 0 = no limit
+	running map[string]int
+
+	burst  int  // interactive tasks started since the last bulk one
+	paused bool // no new task starts while set, running ones finish
+}
+
+func newTaskQueue(limits map[string]int) *taskQueue {
+	q := &taskQueue{
+		queued:  make(map[int64]*Task),
+		active:  make(map[int64]*Task),
+		limits:  limits,
+		running: make(map[string]int),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// push adds a task. If the same task ID is already waiting or running it's
+// not queued again, but an interactive request promotes a waiting bulk task.
+// Returns the task held by the queue and whether it was newly added.
+func (q *taskQueue) push(task *Task) (*Task, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if running, ok := q.active[task.ID]; ok {
+		return running, false
+	}
+
+	if existing, ok := q.queued[task.ID]; ok {
+		if task.Priority == PriorityInteractive && existing.Priority == PriorityBulk {
+			q.bulk = removeTask(q.bulk, existing)
+			existing.mu.Lock()
+			existing.Priority = PriorityInteractive
+			existing.mu.Unlock()
+			q.interactive = append(q.interactive, existing)
+			q.cond.Broadcast()
+		}
+		return existing, false
+	}
+
+	q.queued[task.ID] = task
+	if task.Priority == PriorityBulk {
+		q.bulk = append(q.bulk, task)
+	} else {
+		q.interactive = append(q.interactive, task)
+	}
+	q.cond.Signal()
+	return task, true
+}
+
+// next blocks until a task can run (its source has a free slot) and takes it
+func (q *taskQueue) next() *Task {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		if q.paused {
+			q.cond.Wait()
+			continue
+		}
+		if task := q.pick(); task != nil {
+			delete(q.queued, task.ID)
+			q.active[task.ID] = task
+			q.running[task.Source]++
+			return task
+		}
+		q.cond.Wait()
+	}
+}
+
+// done frees the source slot of a finished task
+func (q *taskQueue) done(task *Task) {
+	q.mu.Lock()
+	delete(q.active, task.ID)
+	q.running[task.Source]--
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// setQuality changes the quality of a task that didn't start yet, in one
+// step so a worker can't take it in between. False if it's running.
+func (q *taskQueue) setQuality(id int64, quality Quality) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.active[id]; ok {
+		return false
+	}
+	if task, ok := q.queued[id]; ok {
+		task.mu.Lock()
+		task.Quality = quality
+		task.mu.Unlock()
+	}
+	return true
+}
+
+// cancel stops a task wherever it is, in one step so a worker can't take
+// it in between: a running task is told to stop (its worker finishes it as
+// Cancelled), a waiting one is taken out of the queue. A nil task means it
+// is in neither, e.g. waiting for a retry.
+func (q *taskQueue) cancel(id int64) (task *Task, running bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if task, ok := q.active[id]; ok {
+		task.requestCancel()
+		return task, true
+	}
+	task, ok := q.queued[id]
+	if !ok {
+		return nil, false
+	}
+	delete(q.queued, id)
+	q.interactive = removeTask(q.interactive, task)
+	q.bulk = removeTask(q.bulk, task)
+	return task, false
+}
+
+// snapshot lists the running tasks, then the waiting ones in lane order
+func (q *taskQueue) snapshot() []*Task {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	tasks := make([]*Task, 0, len(q.active)+len(q.queued))
+	for _, task := range q.active {
+		tasks = append(tasks, task)
+	}
+	tasks = append(tasks, q.interactive...)
+	return append(tasks, q.bulk...)
+}
+
+func (q *taskQueue) setPaused(paused bool) {
+	q.mu.Lock()
+	q.paused = paused
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+func (q *taskQueue) isPaused() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.paused
+}
+
+// pick must be called with mu held
+func (q *taskQueue) pick() *Task {
+	preferBulk := q.burst >= interactiveBurst
+
+	lanes := []*[]*Task{&q.interactive, &q.bulk}
+	if preferBulk {
+		lanes = []*[]*Task{&q.bulk, &q.interactive}
+	}
+
+	for _, lane := range lanes {
+		for i, task := range *lane {
+			if !q.hasSlot(task.Source) {
+				continue
+			}
+			*lane = append((*lane)[:i], (*lane)[i+1:]...)
+
+			if task.Priority == PriorityBulk {
+				q.burst = 0
+			} else {
+				q.burst++
+			}
+			return task
+		}
+	}
+	return nil
+}
+
+func (q *taskQueue) hasSlot(source string) bool {
+	limit := q.limits[source]
+	return limit <= 0 || q.running[source] < limit
+}
+
+func removeTask(tasks []*Task, task *Task) []*Task {
+	for i, t := range tasks {
+		if t == task {
+			return append(tasks[:i], tasks[i+1:]...)
+		}
+	}
+	return tasks
+}
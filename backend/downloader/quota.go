@@ -0,0 +1,120 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+
+	"cryogon/rizumu-backend/store"
+)
+
+// CacheUsage is how much of the cache budget the downloaded songs take.
+// Exempt songs (favorites, pinned playlists) count in Used but are never
+// evicted.
+type CacheUsage struct {
+	Budget      int64 `json:"budget"` // bytes, 0 when unlimited
+	Used        int64 `json:"used"`
+	Songs       int   `json:"songs"`
+	Exempt      int64 `json:"exempt"`
+	ExemptSongs int   `json:"exempt_songs"`
+}
+
+// cachedSongs lists the files the quota manages: downloads, inside the
+// layout. Files imported from elsewhere are not ours to delete.
+func (s *Service) cachedSongs(ctx context.Context) ([]store.CachedSong, error) {
+	songs, err := s.Store.GetCachedSongs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	kept := songs[:0]
+	for _, song := range songs {
+		if !s.layout.Contains(song.Path) {
+			continue
+		}
+		if song.Size <= 0 {
+			// downloaded before sizes were saved
+			if info, err := os.Stat(song.Path); err == nil {
+				song.Size = info.Size()
+			}
+		}
+		kept = append(kept, song)
+	}
+	return kept, nil
+}
+
+func (s *Service) CacheUsage(ctx context.Context) (*CacheUsage, error) {
+	songs, err := s.cachedSongs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := &CacheUsage{Budget: s.cacheBudget}
+	for _, song := range songs {
+		usage.Used += song.Size
+		usage.Songs++
+		if song.Exempt {
+			usage.Exempt += song.Size
+			usage.ExemptSongs++
+		}
+	}
+	return usage, nil
+}
+
+// EnforceQuota deletes the least recently played downloads until the cache
+// fits the budget. The songs go back to 'Remote' and are downloaded again
+// when played.
+func (s *Service) EnforceQuota(ctx context.Context) (int, error) {
+	return s.enforceQuota(ctx, 0)
+}
+
+// enforceQuota spares keepID, the song just downloaded: someone is about to
+// play it
+func (s *Service) enforceQuota(ctx context.Context, keepID int64) (int, error) {
+	if s.cacheBudget <= 0 {
+		return 0, nil
+	}
+	s.quotaMu.Lock()
+	defer s.quotaMu.Unlock()
+
+	songs, err := s.cachedSongs(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var used int64
+	for _, song := range songs {
+		used += song.Size
+	}
+
+	evicted := 0
+	for _, song := range songs {
+		if used <= s.cacheBudget {
+			break
+		}
+		if song.Exempt || song.SongID == keepID {
+			continue
+		}
+		if active, err := s.Store.GetActiveTaskForSong(ctx, song.SongID); err != nil || active != nil {
+			// being downloaded again, the file is about to change
+			continue
+		}
+
+		if err := os.Remove(song.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("[Downloader] WARN: Failed to evict song %d: %v", song.SongID, err)
+			continue
+		}
+		if err := s.Store.EvictSong(ctx, song.SongID); err != nil {
+			return evicted, err
+		}
+		used -= song.Size
+		evicted++
+		log.Printf("[Downloader] Evicted song %d (%d MB) to stay under the cache budget", song.SongID, song.Size>>20)
+	}
+
+	if used > s.cacheBudget {
+		log.Printf("[Downloader] WARN: Cache still uses %d MB of %d MB, the rest is favorites, pinned playlists and the newest download",
+			used>>20, s.cacheBudget>>20)
+	}
+	return evicted, nil
+}
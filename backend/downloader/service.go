@@ -2,54 +2,166 @@
 package downloader
 
 import (
-	"archive/zip"
-	"bufio"
 	"context"
-	"encoding/json"
+	"database/sql"
 	"errors"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
-	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"regexp"
-	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"cryogon/rizumu-backend/covers"
+	"cryogon/rizumu-backend/fingerprint"
 	"cryogon/rizumu-backend/store"
-	"cryogon/rizumu-backend/utils"
-
-	"github.com/bogem/id3v2"
+	"cryogon/rizumu-backend/tags"
 )
 
-// --- Regex Definitions for Log Parsing ---
+// Config sets how many downloads run at once
+type Config struct {
+	Workers      int            // total concurrent downloads
+	SourceLimits map[string]int // per source cap (key is Task.Source), 0 = only Workers applies
+	JobTimeout   time.Duration  // a job still running after this is killed
+
+	// Transient failures are retried after RetryDelay, doubling each time up
+	// to MaxRetryDelay, until a task made MaxAttempts attempts
+	MaxAttempts   int
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+
+	// Sources the service downloads from, nil means DefaultSources()
+	Sources []Source
+
+	// Layout is where the files go, an empty Root means DefaultLayout()
+	Layout Layout
+
+	// Quality of the downloads that don't ask for one, empty means DefaultQuality
+	Quality Quality
+
+	// CacheBudget caps the bytes of downloaded songs kept on disk, the least
+	// recently played go past it. 0 keeps everything.
+	CacheBudget int64
+
+	// Lookup names the downloads that came without metadata from their
+	// fingerprint, nil only fingerprints them
+	Lookup fingerprint.Lookup
+}
+
+// DefaultConfig : a few yt-dlp at once, but only one download from the osu! mirror
+func DefaultConfig() Config {
+	return Config{
+		Workers:       4,
+		JobTimeout:    30 * time.Minute,
+		MaxAttempts:   5,
+		RetryDelay:    30 * time.Second,
+		MaxRetryDelay: time.Hour,
+		Layout:        DefaultLayout(),
+		Quality:       DefaultQuality,
+		SourceLimits: map[string]int{
+			"youtube":       3,
+			"youtube-music": 3,
+			"spotify":       2,
+			"osu!":          1,
+		},
+	}
+}
+
 var (
-	ytProgressRegex     = regexp.MustCompile(`\[download\]\s+(\d+\.?\d*)%`)
-	spotdlTotalRegex    = regexp.MustCompile(`Found (\d+) songs in .*`)
-	spotdlDownloadRegex = regexp.MustCompile(`^(Downloaded|INFO:spotdl.download.downloader:Downloaded)`)
-	spotdlErrorRegex    = regexp.MustCompile(`^(LookupError:|ERROR:spotdl.download.progress_handler:LookupError)`)
+	ErrTaskNotFound     = errors.New("task not found")
+	ErrTaskFinished     = errors.New("task already finished")
+	ErrTaskNotRetryable = errors.New("only failed or cancelled tasks can be retried")
+	ErrQualityConflict  = errors.New("the song is already downloading in another quality")
 )
 
 type Service struct {
-	JobQueue chan *Task
-	Store    *store.Store
+	queue    *taskQueue
+	createMu sync.Mutex // one task row per song
+	sources  []Source
+	events   *eventBus
+	layout   Layout
+	quality  Quality
+	covers   *covers.Cache
+
+	// fingerprinting is off without fpcalc or ffmpeg
+	fingerprinting bool
+	lookup         fingerprint.Lookup
+
+	cacheBudget int64
+	quotaMu     sync.Mutex // one eviction pass at a time
+
+	jobTimeout    time.Duration
+	maxAttempts   int
+	retryDelay    time.Duration
+	maxRetryDelay time.Duration
+
+	Store *store.Store
 }
 
-func NewService(db *store.Store) *Service {
+func NewService(db *store.Store, cfg Config) *Service {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+	defaults := DefaultConfig()
+	if cfg.JobTimeout <= 0 {
+		cfg.JobTimeout = defaults.JobTimeout
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 1
+	}
+	if cfg.RetryDelay <= 0 {
+		cfg.RetryDelay = defaults.RetryDelay
+	}
+	if cfg.MaxRetryDelay < cfg.RetryDelay {
+		cfg.MaxRetryDelay = max(defaults.MaxRetryDelay, cfg.RetryDelay)
+	}
+	if len(cfg.Sources) == 0 {
+		cfg.Sources = DefaultSources()
+	}
+	if cfg.Quality == "" {
+		cfg.Quality = DefaultQuality
+	}
+	if cfg.Layout.Root == "" {
+		cfg.Layout.Root = defaults.Layout.Root
+	}
+	for _, dir := range []string{cfg.Layout.SongsDir(), cfg.Layout.CoversDir()} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			log.Printf("[Downloader] WARN: Failed to create %s: %v", dir, err)
+		}
+	}
+
 	s := &Service{
-		JobQueue: make(chan *Task, 100),
-		Store:    db,
+		queue:         newTaskQueue(cfg.SourceLimits),
+		sources:       cfg.Sources,
+		events:        newEventBus(),
+		layout:        cfg.Layout,
+		covers:        covers.NewCache(db, cfg.Layout.CoverCacheDir()),
+		quality:       cfg.Quality,
+		cacheBudget:   cfg.CacheBudget,
+		lookup:        cfg.Lookup,
+		jobTimeout:    cfg.JobTimeout,
+		maxAttempts:   cfg.MaxAttempts,
+		retryDelay:    cfg.RetryDelay,
+		maxRetryDelay: cfg.MaxRetryDelay,
+		Store:         db,
+	}
+	if s.fingerprinting = fingerprint.Available(); !s.fingerprinting {
+		log.Println("[Downloader] WARN: Neither fpcalc nor ffmpeg found, downloads won't be fingerprinted")
+	}
+	for i := range cfg.Workers {
+		go s.worker(i)
 	}
-	go s.worker()
 	return s
 }
 
-func (s *Service) CreateDownload(req DownloadPayload, taskID int64) (*Task, error) {
-	if taskID <= 0 {
-		return nil, errors.New("invalid task ID: must be > 0")
+// CreateDownload queues a download of songID. A song only has one
+// unfinished task at a time: asking again returns the existing one, in the
+// quality asked for if it didn't start yet. A task waiting to be retried
+// waits out its backoff.
+func (s *Service) CreateDownload(req DownloadPayload, songID int64) (*Task, error) {
+	if songID <= 0 {
+		return nil, errors.New("invalid song ID: must be > 0")
 	}
 
 	source, err := s.GetSource(req)
@@ -57,540 +169,488 @@ func (s *Service) CreateDownload(req DownloadPayload, taskID int64) (*Task, erro
 		return nil, err
 	}
 
-	newTask := &Task{
-		ID:     taskID,
-		URL:    req.URL,
-		Status: StatusPending,
-		Source: source.String(),
+	priority := req.Priority
+	if priority != PriorityBulk {
+		priority = PriorityInteractive
 	}
 
-	s.JobQueue <- newTask
-	log.Printf("[Downloader] Queued job for Song ID %d", taskID)
-	return newTask, nil
-}
-
-func (s *Service) worker() {
-	log.Println("[Worker] Ready for jobs.")
-
-	for task := range s.JobQueue {
-		log.Printf("[Worker] Processing %d (%s)", task.ID, task.Source)
-
-		// FIX 1: Handle error for Downloading status
-		if err := s.Store.UpdateSongStatus(context.Background(), task.ID, string(StatusDownloading)); err != nil {
-			log.Printf("[Worker] WARN: Failed to update status to Downloading: %v", err)
+	quality := s.quality
+	if req.Quality != "" {
+		if quality, err = ParseQuality(string(req.Quality)); err != nil {
+			return nil, err
 		}
+	}
 
-		finalPath, err := s.runDownloadJob(task)
-		_, existsErr := os.Stat(finalPath)
+	s.createMu.Lock()
+	defer s.createMu.Unlock()
 
-		if err != nil {
-			log.Printf("[Worker] ERROR job %d: %v", task.ID, err)
-			if dbErr := s.Store.UpdateSongStatus(context.Background(), task.ID, string(StatusFailed)); dbErr != nil {
-				log.Printf("[Worker] CRITICAL: Failed to mark job as failed: %v", dbErr)
-			}
-		} else if errors.Is(existsErr, os.ErrNotExist) {
-			if dbErr := s.Store.UpdateSongStatus(context.Background(), task.ID, "Not Available"); dbErr != nil {
-				log.Printf("[Worker] CRITICAL: Failed to save final path: %v", dbErr)
-			}
-		} else {
-			log.Printf("[Worker] FINISHED job %d. Path: %s", task.ID, finalPath)
-			// FIX 3: Handle error for UpdatePath
-			if dbErr := s.Store.UpdateSongPath(context.Background(), task.ID, finalPath, 0); dbErr != nil {
-				log.Printf("[Worker] CRITICAL: Failed to save final path: %v", dbErr)
-			}
+	ctx := context.Background()
+	row, err := s.Store.GetActiveTaskForSong(ctx, songID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up task: %w", err)
+	}
+	if row == nil {
+		row = &store.DownloadTask{
+			SongID:    songID,
+			Source:    source.Name(),
+			SourceURL: req.URL,
+			Priority:  string(priority),
+			Quality:   string(quality),
+			Status:    string(StatusPending),
+		}
+		if row.ID, err = s.Store.CreateDownloadTask(ctx, row); err != nil {
+			return nil, fmt.Errorf("failed to save task: %w", err)
+		}
+	} else if req.Quality != "" && Quality(row.Quality) != quality {
+		if row.Status == string(StatusDownloading) || !s.queue.setQuality(row.ID, quality) {
+			return nil, ErrQualityConflict
+		}
+		if err := s.Store.SetDownloadTaskQuality(ctx, row.ID, string(quality)); err != nil {
+			return nil, fmt.Errorf("failed to save task: %w", err)
 		}
+		row.Quality = string(quality)
+		log.Printf("[Downloader] Task %d of Song ID %d now downloads in %s", row.ID, songID, quality)
 	}
-}
-
-func (s *Service) runDownloadJob(task *Task) (string, error) {
-	req := DownloadPayload{URL: task.URL, Mode: "download"}
-	source, _ := s.GetSource(req)
 
-	switch source {
-	case SourceYoutube:
-		return s.downloadFromYoutube(task)
-	case SourceYTMusic:
-		return s.downloadFromYoutubeMusic(task)
-	case SourceSpotify:
-		return s.downloadFromSpotify(task)
-	case SourceOsu:
-		return s.downloadFromOsu(task)
+	if row.NextAttemptAt != nil && row.NextAttemptAt.After(time.Now()) {
+		// requeueAfter pushes it once the backoff is over
+		log.Printf("[Downloader] Song ID %d is waiting for a retry (task %d)", songID, row.ID)
+		return taskFromRow(row), nil
 	}
 
-	return "", errors.New("unsupported source in worker")
-}
+	newTask := taskFromRow(row)
+	newTask.Priority = priority
 
-func (s *Service) downloadFromYoutube(task *Task) (string, error) {
-	finalPath := fmt.Sprintf("./songs/%d.mp3", task.ID)
-
-	cmd := exec.Command(
-		"yt-dlp",
-		"--extract-audio",
-		"--audio-format", "mp3",
-		"-o", finalPath,
-		"--progress",
-		"--newline",
-		"--add-metadata",
-		"--embed-thumbnail",
-		task.URL,
-	)
-
-	stdoutPipe, err := cmd.StdoutPipe()
-	if err != nil {
-		return "", err
+	task, added := s.enqueue(newTask)
+	if !added {
+		log.Printf("[Downloader] Song ID %d is already queued (task %d)", songID, task.ID)
+		return task, nil
 	}
-	stderrPipe, err := cmd.StderrPipe()
+	log.Printf("[Downloader] Queued %s task %d for Song ID %d", priority, task.ID, songID)
+	return task, nil
+}
+
+// ResumeTasks queues again the tasks the last run didn't finish
+func (s *Service) ResumeTasks(ctx context.Context) error {
+	rows, err := s.Store.GetUnfinishedDownloadTasks(ctx)
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	if err := cmd.Start(); err != nil {
-		return "", err
+	for _, row := range rows {
+		if row.NextAttemptAt != nil && row.NextAttemptAt.After(time.Now()) {
+			s.requeueAfter(row.ID, time.Until(*row.NextAttemptAt))
+			continue
+		}
+		s.enqueue(taskFromRow(row))
 	}
-
-	go s.streamOutput(stdoutPipe, "[yt-dlp-out]", task)
-	go s.streamOutput(stderrPipe, "[yt-dlp-err]", task)
-
-	if err := cmd.Wait(); err != nil {
-		return "", err
+	if len(rows) > 0 {
+		log.Printf("[Downloader] Resumed %d unfinished tasks", len(rows))
 	}
-
-	s.applyMetadata(finalPath, task.ID)
-
-	return finalPath, nil
+	return nil
 }
 
-func (s *Service) downloadFromYoutubeMusic(task *Task) (string, error) {
-	return s.downloadFromYoutube(task)
+// enqueue pushes the task and announces it if it wasn't queued already
+func (s *Service) enqueue(task *Task) (*Task, bool) {
+	task, added := s.queue.push(task)
+	if added {
+		s.publish(task, EventQueued)
+	}
+	return task, added
 }
 
-func (s *Service) downloadFromSpotify(task *Task) (string, error) {
-	finalPath := fmt.Sprintf("./songs/%d.mp3", task.ID)
-	spotdlTemplate := fmt.Sprintf("./songs/%d.{output-ext}", task.ID)
+func taskFromRow(row *store.DownloadTask) *Task {
+	priority := TaskPriority(row.Priority)
+	if priority != PriorityBulk {
+		priority = PriorityInteractive
+	}
+	return &Task{
+		ID:       row.ID,
+		SongID:   row.SongID,
+		URL:      row.SourceURL,
+		Source:   row.Source,
+		Status:   StatusPending,
+		Priority: priority,
+		Quality:  Quality(row.Quality),
+		Attempts: row.Attempts,
+	}
+}
 
-	cmd := exec.Command(
-		"spotdl",
-		"download",
-		task.URL,
-		"--format", "mp3",
-		"--output", spotdlTemplate,
-	)
+func (s *Service) worker(n int) {
+	log.Printf("[Worker %d] Ready for jobs.", n)
 
-	stdoutPipe, err := cmd.StdoutPipe()
-	if err != nil {
-		return "", err
-	}
-	stderrPipe, err := cmd.StderrPipe()
-	if err != nil {
-		return "", err
+	for {
+		task := s.queue.next()
+		log.Printf("[Worker %d] Processing task %d, song %d (%s)", n, task.ID, task.SongID, task.Source)
+		s.processTask(task)
+		s.queue.done(task)
 	}
+}
 
-	if err := cmd.Start(); err == nil {
-		go s.streamOutput(stdoutPipe, "[spotdl-out]", task)
-		go s.streamOutput(stderrPipe, "[spotdl-err]", task)
-
-		if err := cmd.Wait(); err == nil {
-			if _, err := os.Stat(finalPath); err == nil {
-				return finalPath, nil
-			}
+func (s *Service) processTask(task *Task) {
+	ctx := context.Background()
+	task.begin()
+	s.publish(task, EventStarted)
+
+	if err := s.Store.StartDownloadTask(ctx, task.ID); err != nil {
+		log.Printf("[Worker] WARN: Failed to mark task %d as started: %v", task.ID, err)
+	}
+	// FIX 1: Handle error for Downloading status
+	if err := s.Store.UpdateSongStatus(ctx, task.SongID, string(StatusDownloading)); err != nil {
+		log.Printf("[Worker] WARN: Failed to update status to Downloading: %v", err)
+	}
+
+	jobCtx, cancel := task.start(ctx, s.jobTimeout)
+	finalPath, err := s.runDownloadJob(jobCtx, task)
+	timedOut := errors.Is(jobCtx.Err(), context.DeadlineExceeded)
+	cancel()
+	_, existsErr := os.Stat(finalPath)
+
+	var failure *Failure
+	switch {
+	case task.isCancelled():
+		log.Printf("[Worker] Task %d cancelled", task.ID)
+		task.setStatus(StatusCancelled, "cancelled")
+		s.cleanupPartialFiles(task.SongID)
+		// back to Pending so playing it queues a new download
+		if dbErr := s.Store.UpdateSongStatus(ctx, task.SongID, string(StatusPending)); dbErr != nil {
+			log.Printf("[Worker] CRITICAL: Failed to reset cancelled song: %v", dbErr)
 		}
+	case err != nil:
+		if timedOut {
+			err = &Failure{Kind: FailureTransient, Reason: fmt.Sprintf("timed out after %s", s.jobTimeout), Err: err}
+			s.cleanupPartialFiles(task.SongID)
+		}
+		failure = classifyFailure(err, task.logs.lastCommand())
+	case errors.Is(existsErr, os.ErrNotExist):
+		failure = &Failure{Kind: FailurePermanent, Reason: "no matching audio found"}
+	default:
+		log.Printf("[Worker] FINISHED task %d. Path: %s", task.ID, finalPath)
+		task.setStatus(StatusComplete, "")
+		s.saveFile(ctx, task.SongID, finalPath)
+	}
+
+	if failure != nil {
+		log.Printf("[Worker] ERROR task %d (%s, attempt %d/%d): %v", task.ID, failure.Kind, task.Attempts, s.maxAttempts, failure)
+		if failure.Kind == FailureTransient && task.Attempts < s.maxAttempts {
+			s.retryLater(task, failure)
+			return
+		}
+		s.fail(task, failure)
 	}
-	log.Printf("[Worker] spotdl failed for %d. Attempting Brute Force Fallback...", task.ID)
-	song, err := s.Store.GetSong(context.Background(), task.ID)
-	if err != nil {
-		return "", fmt.Errorf("fallback failed: could not get metadata: %w", err)
-	}
-
-	// JIT Metadata Fetching
-	if song.DurationMs == 0 {
-		log.Printf("[Worker] Metadata missing for %d. Fetching via spotdl save...", task.ID)
 
-		metaPath := fmt.Sprintf("./songs/meta_%d.spotdl", task.ID)
-
-		saveCmd := exec.Command("spotdl", "save", task.URL, "--save-file", metaPath)
-		if out, err := saveCmd.CombinedOutput(); err != nil {
-			log.Printf("[Worker] WARN: Metadata fetch failed: %s", string(out))
-			return "", err
-		} else {
-			type SpotdlMeta struct {
-				Name     string  `json:"name"`
-				Artist   string  `json:"artist"`
-				Duration float64 `json:"duration"` // Seconds
-			}
-			var metaList []SpotdlMeta
-
-			jsonBytes, err := os.ReadFile(metaPath)
-			if err == nil {
-				if jsonErr := json.Unmarshal(jsonBytes, &metaList); jsonErr == nil && len(metaList) > 0 {
-					m := metaList[0]
-					song.Title = m.Name
-					song.Artist = m.Artist
-					song.DurationMs = int64(m.Duration * 1000)
-
-					if dbErr := s.Store.UpdateSongFullMetadata(context.Background(), song); dbErr != nil {
-						log.Printf("WARN: Failed to persist fetched metadata: %v", dbErr)
-					}
-				}
-			}
-			// Cleanup temp file
-			_ = os.Remove(metaPath)
-		}
+	if dbErr := s.Store.FinishDownloadTask(ctx, task.ID, string(task.Status), failure.kind(), task.Error, task.logs.String()); dbErr != nil {
+		log.Printf("[Worker] CRITICAL: Failed to save task %d result: %v", task.ID, dbErr)
 	}
+	s.publish(task, EventFinished)
 
-	searchQuery := fmt.Sprintf("ytsearch5:%s - %s", song.Artist, song.Title)
-	songDuration := song.DurationMs / 1000
-	lowerBound := max(songDuration-30, 0)
-	matchFilter := fmt.Sprintf("duration > %d & duration < %d", lowerBound, songDuration+30) // adding extra 30 secs just in case
-	log.Printf("[Worker] Searching YouTube for: %s; filter:%s", searchQuery, matchFilter)
-
-	fallbackCmd := exec.Command(
-		"yt-dlp",
-		"--extract-audio",
-		"--audio-format", "mp3",
-		"-o", finalPath,
-		"--progress",
-		"--newline",
-		"--match-filter", matchFilter,
-		// stop after downloading exactly one file
-		"--max-downloads", "1",
-		searchQuery,
-	)
-
-	stdout, _ := fallbackCmd.StdoutPipe()
-	stderr, _ := fallbackCmd.StderrPipe()
-
-	if err := fallbackCmd.Start(); err != nil {
-		return "", fmt.Errorf("fallback start failed: %w", err)
-	}
-
-	go s.streamOutput(stdout, "[fallback-out]", task)
-	go s.streamOutput(stderr, "[fallback-err]", task)
-	if err := fallbackCmd.Wait(); err != nil {
-		// Check if it's the specific "Max Downloads Reached" error (Exit Code 101)
-		var exitErr *exec.ExitError
-		if errors.As(err, &exitErr) && exitErr.ExitCode() == 101 {
-			// This is actually a success for us if the file exists
-			if _, statErr := os.Stat(finalPath); statErr == nil {
-				log.Printf("[Worker] Fallback hit max-downloads limit (expected).")
-				s.applyMetadata(finalPath, task.ID)
-				return finalPath, nil
-			}
+	if task.Status == StatusComplete {
+		s.fingerprintDownload(ctx, task.SongID)
+		if _, err := s.enforceQuota(ctx, task.SongID); err != nil {
+			log.Printf("[Worker] WARN: Failed to enforce the cache budget: %v", err)
 		}
-		return "", fmt.Errorf("fallback download failed: %w", err)
 	}
-
-	s.applyMetadata(finalPath, task.ID)
-	return finalPath, nil
 }
 
-func (s *Service) downloadFromOsu(task *Task) (string, error) {
-	log.Printf("[Worker] Starting Osu processing for: %s", task.URL)
-
-	parsedURL, err := url.Parse(task.URL)
+// saveFile records the downloaded file with what ffprobe says it really is,
+// the quality asked for is not always what the source had
+func (s *Service) saveFile(ctx context.Context, songID int64, path string) {
+	file, err := ProbeSongFile(path)
 	if err != nil {
-		return "", err
-	}
-
-	downloadURL := task.URL
-	if strings.Contains(parsedURL.Host, "osu.ppy.sh") {
-		paths := strings.Split(strings.TrimPrefix(parsedURL.Path, "/"), "/")
-		if len(paths) >= 2 && paths[0] == "beatmapsets" {
-			beatmapsetID := paths[1]
-			downloadURL = fmt.Sprintf("https://osu.direct/api/d/%s", beatmapsetID)
-		} else {
-			return "", fmt.Errorf("unsupported osu url format: %s", task.URL)
+		// no ffprobe: still a playable file, the size at least is known
+		log.Printf("[Worker] WARN: Failed to probe %s: %v", path, err)
+		if info, statErr := os.Stat(path); statErr == nil {
+			file.Size = info.Size()
 		}
 	}
 
-	tempOszPath := fmt.Sprintf("./songs/temp_%d.osz", task.ID)
-	outFile, err := os.Create(tempOszPath)
-	if err != nil {
-		return "", fmt.Errorf("failed to create temp file: %w", err)
+	if err := s.Store.UpdateSongFile(ctx, songID, file); err != nil {
+		log.Printf("[Worker] CRITICAL: Failed to save final path: %v", err)
 	}
+}
 
-	req, err := http.NewRequest("GET", downloadURL, nil)
-	if err != nil {
-		if closeErr := outFile.Close(); closeErr != nil {
-			log.Printf("WARN: Failed to close temp file: %v", closeErr)
+// fail gives up on a task. Permanent failures make the song "Not Available"
+// with the reason, transient ones that ran out of attempts are just Failed.
+func (s *Service) fail(task *Task, failure *Failure) {
+	ctx := context.Background()
+
+	if failure.Kind == FailurePermanent {
+		task.setStatus(StatusNotAvailable, failure.Error())
+		if dbErr := s.Store.MarkSongUnavailable(ctx, task.SongID, failure.Reason); dbErr != nil {
+			log.Printf("[Worker] CRITICAL: Failed to mark song unavailable: %v", dbErr)
 		}
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return
 	}
-	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; Rizumu/1.0)")
 
-	resp, err := utils.HTTPClient.Do(req)
-	if err != nil {
-		if closeErr := outFile.Close(); closeErr != nil {
-			log.Printf("WARN: Failed to close temp file: %v", closeErr)
-		}
-		return "", fmt.Errorf("http get failed: %w", err)
+	errMsg := failure.Error()
+	if task.Attempts > 1 {
+		errMsg = fmt.Sprintf("gave up after %d attempts: %s", task.Attempts, errMsg)
 	}
-	defer func() {
-		if cErr := resp.Body.Close(); cErr != nil {
-			log.Printf("WARN: Failed to close response body: %v", cErr)
-		}
-	}()
+	task.setStatus(StatusFailed, errMsg)
+	if dbErr := s.Store.UpdateSongStatus(ctx, task.SongID, string(StatusFailed)); dbErr != nil {
+		log.Printf("[Worker] CRITICAL: Failed to mark job as failed: %v", dbErr)
+	}
+}
 
-	totalSizeStr := resp.Header.Get("Content-Length")
-	totalSize, _ := strconv.ParseInt(totalSizeStr, 10, 64)
+// retryLater puts the task back to Pending and queues it again after the
+// backoff delay
+func (s *Service) retryLater(task *Task, failure *Failure) {
+	ctx := context.Background()
+	delay := backoff(s.retryDelay, s.maxRetryDelay, task.Attempts, failure.RetryAfter)
+	next := time.Now().Add(delay)
 
-	var downloadedBytes int64 = 0
-	lastReportedProgress := -5.0
-	buf := make([]byte, 32*1024)
+	task.setStatus(StatusPending, failure.Error())
+	if dbErr := s.Store.ScheduleDownloadRetry(ctx, task.ID, string(failure.Kind), task.Error, task.logs.String(), next); dbErr != nil {
+		log.Printf("[Worker] CRITICAL: Failed to schedule retry of task %d: %v", task.ID, dbErr)
+	}
+	if dbErr := s.Store.UpdateSongStatus(ctx, task.SongID, string(StatusPending)); dbErr != nil {
+		log.Printf("[Worker] WARN: Failed to reset song %d: %v", task.SongID, dbErr)
+	}
 
-	for {
-		n, readErr := resp.Body.Read(buf)
-		if n > 0 {
-			if _, writeErr := outFile.Write(buf[:n]); writeErr != nil {
-				if closeErr := outFile.Close(); closeErr != nil {
-					log.Printf("WARN: Failed to close temp file on write error: %v", closeErr)
-				}
-				return "", writeErr
-			}
+	log.Printf("[Worker] Retrying task %d in %s", task.ID, delay.Round(time.Second))
+	s.publish(task, EventRetrying)
+	s.requeueAfter(task.ID, delay)
+}
 
-			downloadedBytes += int64(n)
-
-			if totalSize > 0 {
-				progress := (float64(downloadedBytes) / float64(totalSize)) * 100
-				if (progress - lastReportedProgress) >= 1.0 {
-					if dbErr := s.Store.UpdateSongProgress(context.Background(), task.ID, progress); dbErr != nil {
-						log.Printf("WARN: Failed to update DB progress: %v", dbErr)
-					}
-					lastReportedProgress = progress
-					log.Printf("Download Progress: %f", progress)
-				}
-			}
-		}
-		if readErr == io.EOF {
-			break
+// requeueAfter pushes the task back on the queue once delay is over, unless
+// it was cancelled, retried by hand or deleted in the meantime
+func (s *Service) requeueAfter(id int64, delay time.Duration) {
+	time.AfterFunc(delay, func() {
+		row, err := s.Store.GetDownloadTask(context.Background(), id)
+		if err != nil || row.Status != string(StatusPending) {
+			return
 		}
-		if readErr != nil {
-			if closeErr := outFile.Close(); closeErr != nil {
-				log.Printf("WARN: Failed to close temp file on read error: %v", closeErr)
-			}
-			return "", readErr
-		}
-	}
+		s.enqueue(taskFromRow(row))
+	})
+}
 
-	if err := outFile.Close(); err != nil {
-		return "", fmt.Errorf("failed to close osz file: %w", err)
+// CancelTask drops a waiting task, or kills the download of a running one
+func (s *Service) CancelTask(ctx context.Context, id int64) error {
+	row, err := s.Store.GetDownloadTask(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrTaskNotFound
 	}
-
-	r, err := zip.OpenReader(tempOszPath)
 	if err != nil {
-		if rmErr := os.Remove(tempOszPath); rmErr != nil {
-			log.Printf("WARN: Failed to remove invalid zip: %v", rmErr)
-		}
-		return "", fmt.Errorf("failed to open osz: %w", err)
+		return err
 	}
-	defer func() {
-		if cErr := r.Close(); cErr != nil {
-			log.Printf("WARN: Failed to close zip reader: %v", cErr)
-		}
-	}()
-
-	var meta OsuMetadata
-	foundOsu := false
-
-	for _, f := range r.File {
-		if strings.HasSuffix(f.Name, ".osu") {
-			rc, err := f.Open()
-			if err == nil {
-				meta = parseOsuFile(rc)
-				foundOsu = true
-				if cErr := rc.Close(); cErr != nil {
-					log.Printf("WARN: Failed to close .osu file: %v", cErr)
-				}
-			} else {
-				log.Printf("WARN: Failed to open .osu file: %v", err)
-			}
-		}
+	if row.Status != string(StatusPending) && row.Status != string(StatusDownloading) {
+		return ErrTaskFinished
 	}
 
-	if !foundOsu {
-		if rmErr := os.Remove(tempOszPath); rmErr != nil {
-			log.Printf("WARN: Failed to remove temp osz: %v", rmErr)
+	task, running := s.queue.cancel(id)
+	if running {
+		log.Printf("[Downloader] Cancelling running task %d", id)
+		return nil
+	}
+	if task == nil {
+		// waiting for a retry, unless it finished since the row was read
+		if row, err = s.Store.GetDownloadTask(ctx, id); err != nil {
+			return err
+		}
+		if row.Status != string(StatusPending) && row.Status != string(StatusDownloading) {
+			return ErrTaskFinished
 		}
-		return "", errors.New("no .osu file found in archive")
+		task = taskFromRow(row)
 	}
+	if err := s.Store.FinishDownloadTask(ctx, id, string(StatusCancelled), "", "cancelled", row.LogTail); err != nil {
+		return err
+	}
+	task.setStatus(StatusCancelled, "cancelled")
+	s.publish(task, EventFinished)
+	if err := s.Store.UpdateSongStatus(ctx, row.SongID, string(StatusPending)); err != nil {
+		log.Printf("[Downloader] WARN: Failed to reset song %d: %v", row.SongID, err)
+	}
+	log.Printf("[Downloader] Cancelled queued task %d", id)
+	return nil
+}
 
-	var finalAudioPath string
-	var finalImagePath string
+// RetryTask queues a failed or cancelled task again, as interactive
+func (s *Service) RetryTask(ctx context.Context, id int64) (*Task, error) {
+	s.createMu.Lock()
+	defer s.createMu.Unlock()
 
-	for _, f := range r.File {
-		if strings.EqualFold(f.Name, meta.AudioFilename) {
-			finalAudioPath = fmt.Sprintf("./songs/%d.mp3", task.ID)
-			if err := extractFileFromZip(f, finalAudioPath); err != nil {
-				return "", err
-			}
-		}
-		if meta.BgFilename != "" && strings.EqualFold(f.Name, meta.BgFilename) {
-			ext := filepath.Ext(f.Name)
-			finalImagePath = fmt.Sprintf("./covers/%d%s", task.ID, ext)
-			if mkErr := os.MkdirAll("./covers", 0o755); mkErr != nil {
-				log.Printf("WARN: Failed to create covers dir: %v", mkErr)
-			}
-			if extractErr := extractFileFromZip(f, finalImagePath); extractErr != nil {
-				log.Printf("WARN: Failed to extract bg image: %v", extractErr)
-			}
-		}
+	row, err := s.Store.GetDownloadTask(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTaskNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch TaskStatus(row.Status) {
+	case StatusFailed, StatusNotAvailable, StatusCancelled:
+	default:
+		return nil, ErrTaskNotRetryable
 	}
 
-	if finalAudioPath == "" {
-		if rmErr := os.Remove(tempOszPath); rmErr != nil {
-			log.Printf("WARN: Failed to remove temp osz: %v", rmErr)
-		}
-		return "", errors.New("audio file not found in osz")
+	// the song may have been queued again in the meantime
+	if active, err := s.Store.GetActiveTaskForSong(ctx, row.SongID); err != nil {
+		return nil, err
+	} else if active != nil {
+		return nil, fmt.Errorf("song %d already has task %d running", row.SongID, active.ID)
 	}
 
-	durationMs := int64(0)
-	probeMeta, err := ProbeFile(finalAudioPath)
-	if err == nil {
-		durationMs = probeMeta.DurationMs
-	} else {
-		log.Printf("WARN: Failed to probe osu audio file: %v", err)
+	if err := s.Store.RequeueDownloadTask(ctx, id); err != nil {
+		return nil, err
+	}
+	if err := s.Store.UpdateSongStatus(ctx, row.SongID, string(StatusPending)); err != nil {
+		log.Printf("[Downloader] WARN: Failed to reset song %d: %v", row.SongID, err)
 	}
 
-	tag, err := id3v2.Open(finalAudioPath, id3v2.Options{Parse: true})
-	if err == nil {
-		defer func() {
-			if cErr := tag.Close(); cErr != nil {
-				log.Printf("WARN: Failed to close ID3 tag: %v", cErr)
-			}
-		}()
-
-		tag.SetTitle(meta.Title)
-		tag.SetArtist(meta.Artist)
-		tag.SetAlbum("osu!")
-
-		if finalImagePath != "" {
-			imgBytes, err := os.ReadFile(finalImagePath)
-			if err == nil {
-				pic := id3v2.PictureFrame{
-					Encoding:    id3v2.EncodingISO,
-					MimeType:    "image/jpeg",
-					PictureType: id3v2.PTFrontCover,
-					Description: "Cover",
-					Picture:     imgBytes,
-				}
-				tag.AddAttachedPicture(pic)
-			} else {
-				log.Printf("WARN: Failed to read cover image for embedding: %v", err)
+	task := taskFromRow(row)
+	task.Priority, task.Attempts = PriorityInteractive, 0
+	task, _ = s.enqueue(task)
+	log.Printf("[Downloader] Retrying task %d (song %d)", id, row.SongID)
+	return task, nil
+}
+
+// Layout is where the service puts the files it downloads
+func (s *Service) Layout() Layout {
+	return s.layout
+}
+
+func (s *Service) Covers() *covers.Cache {
+	return s.covers
+}
+
+// PauseQueue stops workers from starting new tasks. Running ones finish.
+func (s *Service) PauseQueue() {
+	s.queue.setPaused(true)
+	log.Println("[Downloader] Queue paused")
+}
+
+func (s *Service) ResumeQueue() {
+	s.queue.setPaused(false)
+	log.Println("[Downloader] Queue resumed")
+}
+
+func (s *Service) IsPaused() bool {
+	return s.queue.isPaused()
+}
+
+// cleanupPartialFiles removes what an interrupted job leaves behind:
+// yt-dlp .part/.ytdl files, ffmpeg's temp output and the osu! archive
+func (s *Service) cleanupPartialFiles(songID int64) {
+	dir := s.layout.SongsDir()
+	patterns := []string{
+		fmt.Sprintf("%d.*.part", songID),
+		fmt.Sprintf("%d.*.ytdl", songID),
+		fmt.Sprintf("%d.temp.*", songID),
+		fmt.Sprintf("%d.part", songID),
+		fmt.Sprintf("temp_%d.osz", songID),
+	}
+	for _, pattern := range patterns {
+		matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+		for _, path := range matches {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				log.Printf("WARN: Failed to remove partial file %s: %v", path, err)
 			}
 		}
-		if saveErr := tag.Save(); saveErr != nil {
-			log.Printf("WARN: Failed to save ID3 tags: %v", saveErr)
-		}
-	} else {
-		log.Printf("WARN: Failed to open mp3 for tagging: %v", err)
 	}
+}
 
-	dbUpdate := &store.Song{
-		ID:         task.ID,
-		FilePath:   finalAudioPath,
-		ImageURL:   finalImagePath,
-		Title:      meta.Title,
-		Artist:     meta.Artist,
-		BPM:        meta.BPM,
-		DurationMs: durationMs,
+// reportProgress keeps the progress on the task, publishes it and, now and
+// then, writes the percent to the DB
+func (s *Service) reportProgress(task *Task, p Progress) {
+	publish, save := task.updateProgress(p)
+	if !publish && !save {
+		return
 	}
 
-	if err := s.Store.UpdateSongFullMetadata(context.Background(), dbUpdate); err != nil {
-		log.Printf("WARN: Failed to update DB metadata: %v", err)
+	ev := task.event(EventProgress)
+	if publish {
+		s.events.publish(ev)
 	}
-
-	if rmErr := os.Remove(tempOszPath); rmErr != nil {
-		log.Printf("WARN: Failed to cleanup temp osz: %v", rmErr)
+	if save {
+		// Ignore DB errors, progress is best effort
+		_ = s.Store.UpdateDownloadTaskProgress(context.Background(), task.ID, ev.Progress)
 	}
-
-	return finalAudioPath, nil
 }
 
-func extractFileFromZip(f *zip.File, destPath string) error {
-	rc, err := f.Open()
-	if err != nil {
-		return err
+// runDownloadJob hands the task to its source and saves what the source
+// learned about the song
+func (s *Service) runDownloadJob(ctx context.Context, task *Task) (string, error) {
+	source, ok := s.sourceByName(task.Source)
+	if !ok {
+		var err error
+		if source, err = s.GetSource(DownloadPayload{URL: task.URL}); err != nil {
+			return "", err
+		}
 	}
-	defer func() {
-		_ = rc.Close()
-	}()
 
-	outFile, err := os.Create(destPath)
+	song, err := s.Store.GetSong(ctx, task.SongID)
 	if err != nil {
-		return err
+		return "", fmt.Errorf("failed to load song %d: %w", task.SongID, err)
 	}
-	defer func() {
-		if cErr := outFile.Close(); cErr != nil {
-			log.Printf("WARN: Failed to close extracted file %s: %v", destPath, cErr)
-		}
-	}()
 
-	if _, err := io.Copy(outFile, rc); err != nil {
-		return err
+	job := &Job{
+		SongID:     task.SongID,
+		URL:        task.URL,
+		Dest:       s.layout.WorkPath(task.SongID),
+		Covers:     s.layout.CoversDir(),
+		Song:       song,
+		Quality:    s.taskQuality(task),
+		OnProgress: func(p Progress) { s.reportProgress(task, p) },
+		OnCommand:  task.logs.command,
+		OnOutput:   task.logs.add,
 	}
-	return nil
-}
 
-func (s *Service) GetSource(req DownloadPayload) (DownloadSource, error) {
-	u, err := url.Parse(req.URL)
+	res, err := source.Download(ctx, job)
 	if err != nil {
-		return SourceUnknown, err
+		return "", err
 	}
 
-	host := u.Host
-	if strings.Contains(host, "music.youtube.com") {
-		return SourceYTMusic, nil
+	if res.Metadata != nil {
+		song = mergeSong(song, res.Metadata)
+		song.FilePath = res.Path
+		if err := s.Store.UpdateSongFullMetadata(context.Background(), song); err != nil {
+			log.Printf("WARN: Failed to update DB metadata: %v", err)
+		}
 	}
-	if strings.Contains(host, "youtube.com") || strings.Contains(host, "youtu.be") {
-		return SourceYoutube, nil
+	if len(res.Credits) > 0 {
+		if err := s.Store.SetSongCredits(context.Background(), task.SongID, res.Credits, nil); err != nil {
+			log.Printf("WARN: Failed to save %s artists: %v", source.Name(), err)
+		}
 	}
-	if strings.Contains(host, "spotify.com") {
-		return SourceSpotify, nil
+	if len(res.Tags) > 0 {
+		if err := s.Store.AddSongTags(context.Background(), task.SongID, res.Tags); err != nil {
+			log.Printf("WARN: Failed to save %s tags: %v", source.Name(), err)
+		}
 	}
-	if strings.Contains(host, "osu.ppy.sh") {
-		return SourceOsu, nil
+	if len(res.Beatmaps) > 0 {
+		if err := s.Store.SetOsuBeatmaps(context.Background(), task.SongID, res.Beatmaps); err != nil {
+			log.Printf("WARN: Failed to save beatmaps: %v", err)
+		}
 	}
-
-	return SourceUnknown, fmt.Errorf("unknown source for url: %s", req.URL)
+	if !res.Tagged {
+		s.applyMetadata(res.Path, task.SongID)
+	}
+	return s.place(ctx, task.SongID, res.Path), nil
 }
 
-func (s *Service) streamOutput(pipe io.ReadCloser, prefix string, task *Task) {
-	scanner := bufio.NewScanner(pipe)
-
-	totalSongs := 1.0
-	processedSongs := 0.0
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		log.Printf("%s %s", prefix, line)
+// taskQuality is the quality the task asked for, the configured one for
+// tasks queued before there was a choice
+func (s *Service) taskQuality(task *Task) Quality {
+	if q, err := ParseQuality(string(task.Quality)); err == nil {
+		return q
+	}
+	return s.quality
+}
 
-		// Logic A: Spotify (Multi-file parsing)
-		if task.Source == "spotify" {
-			if matches := spotdlTotalRegex.FindStringSubmatch(line); len(matches) > 1 {
-				if total, err := strconv.ParseFloat(matches[1], 64); err == nil && total > 0 {
-					totalSongs = total
-				}
-			}
-			if spotdlDownloadRegex.MatchString(line) {
-				processedSongs++
-			}
-			if spotdlErrorRegex.MatchString(line) {
-				processedSongs++
-			}
+// place moves the tagged file to its place in the library. If it can't, the
+// file stays where the source put it, still playable.
+func (s *Service) place(ctx context.Context, songID int64, path string) string {
+	song, err := s.Store.GetSong(ctx, songID)
+	if err != nil {
+		log.Printf("[Downloader] WARN: Not moving %s: %v", path, err)
+		return path
+	}
 
-			progress := (processedSongs / totalSongs) * 100
-			// Ignore DB error in logging loop
-			_ = s.Store.UpdateSongProgress(context.Background(), task.ID, progress)
-
-		} else {
-			// Logic B: YouTube/Standard (Percentage Parsing)
-			matches := ytProgressRegex.FindStringSubmatch(line)
-			if len(matches) > 1 {
-				if p, err := strconv.ParseFloat(matches[1], 64); err == nil {
-					_ = s.Store.UpdateSongProgress(context.Background(), task.ID, p)
-				}
-			}
-		}
+	placed, err := s.layout.Place(song, path)
+	if err != nil {
+		log.Printf("[Downloader] WARN: Failed to move %s into the library: %v", path, err)
+		return path
 	}
+	return placed
 }
 
 func (s *Service) applyMetadata(path string, songID int64) {
@@ -599,21 +659,20 @@ func (s *Service) applyMetadata(path string, songID int64) {
 		return
 	}
 
-	tag, err := id3v2.Open(path, id3v2.Options{Parse: true})
+	fileTags, err := tags.Read(path)
 	if err != nil {
+		log.Printf("WARN: Failed to read tags of %s: %v", path, err)
 		return
 	}
-	defer func() { _ = tag.Close() }()
 
 	if strings.HasPrefix(song.Title, "Unknown") {
-		newTitle := tag.Title()
-		newArtist := tag.Artist()
+		newTitle := fileTags.Title
+		newArtist := fileTags.Artist
 
 		if strings.HasSuffix(newArtist, " - Topic") {
 			newArtist = strings.TrimSuffix(newArtist, " - Topic")
 			// Write the cleaned artist back to the file
-			tag.SetArtist(newArtist)
-			if saveErr := tag.Save(); saveErr != nil {
+			if saveErr := tags.Write(path, &tags.Tags{Artist: newArtist}); saveErr != nil {
 				log.Printf("WARN: Failed to save cleaned metadata to file: %v", saveErr)
 			}
 		}
@@ -621,45 +680,47 @@ func (s *Service) applyMetadata(path string, songID int64) {
 		if newTitle != "" {
 			song.Title = newTitle
 			song.Artist = newArtist
+			song.FilePath = path
 			_ = s.Store.UpdateSongFullMetadata(context.Background(), song)
+
+			if err := s.saveCredits(context.Background(), songID, newArtist, fileTags.Album); err != nil {
+				log.Printf("WARN: Failed to save artists from tags: %v", err)
+			}
 		}
 		return
 	}
 
-	tag.SetTitle(song.Title)
-	tag.SetArtist(song.Artist)
-	tag.SetAlbum(song.Album)
-
-	// Embed Cover Art
-	if song.ImageURL != "" {
-		var imgBytes []byte
+	newTags := &tags.Tags{
+		Title:  song.Title,
+		Artist: song.Artist,
+		Album:  song.Album,
+	}
 
-		// Fetch from URL or Read from Disk
-		if strings.HasPrefix(song.ImageURL, "http") {
-			resp, err := utils.HTTPClient.Get(song.ImageURL)
-			if err == nil {
-				defer resp.Body.Close()
-				imgBytes, _ = io.ReadAll(resp.Body)
-			}
-		} else {
-			// Local file (e.g. from Osu extraction)
-			imgBytes, _ = os.ReadFile(song.ImageURL)
+	// Embed Cover Art, from the cache so the type is the real one
+	if cover, err := s.covers.Cover(context.Background(), song); err == nil {
+		if data, err := s.covers.Data(cover); err == nil {
+			newTags.Cover, newTags.CoverMIME = data, cover.MIME
 		}
+	} else if !errors.Is(err, covers.ErrNoCover) {
+		log.Printf("WARN: Failed to get the cover of song %d: %v", songID, err)
+	}
 
-		if len(imgBytes) > 0 {
-			tag.AddAttachedPicture(id3v2.PictureFrame{
-				Encoding:    id3v2.EncodingISO,
-				MimeType:    "image/jpeg",
-				PictureType: id3v2.PTFrontCover,
-				Description: "Cover",
-				Picture:     imgBytes,
-			})
-		}
+	if err := tags.Write(path, newTags); err != nil {
+		log.Printf("WARN: Failed to save tags: %v", err)
 	}
+}
 
-	if err := tag.Save(); err != nil {
-		log.Printf("WARN: Failed to save ID3 tags: %v", err)
+// saveCredits links a song to the artists of an artist tag and its album
+func (s *Service) saveCredits(ctx context.Context, songID int64, artist, albumTitle string) error {
+	credits := SplitArtists(artist)
+	var album *store.Album
+	if albumTitle != "" {
+		album = &store.Album{Title: albumTitle, ArtistName: artist}
+		if len(credits) > 0 {
+			album.ArtistName = credits[0].Name
+		}
 	}
+	return s.Store.SetSongCredits(ctx, songID, credits, album)
 }
 
 func (s *Service) DownloadSong(song store.Song) error {
@@ -672,7 +733,7 @@ func (s *Service) DownloadSong(song store.Song) error {
 		URL:  "",
 	}
 
-	sourceURL, err := utils.GetSourceURL(song.Provider, song.ProviderID)
+	sourceURL, err := s.SourceURL(song.Provider, song.ProviderID)
 	if err != nil {
 		return err
 	}
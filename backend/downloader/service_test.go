@@ -0,0 +1,371 @@
+//go:build unix
+
+package downloader
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"cryogon/rizumu-backend/store"
+)
+
+// stubYtdlp acts on the video id of the URL:
+//
+//	sleep : leaves a .part file and hangs, with a child in its process group
+//	fail  : rate limited while $STUB_DIR/fail exists, then like ok
+//	ok    : "downloads" an opus file
+//
+// Every call is appended to $STUB_DIR/calls.
+const stubYtdlp = `#!/bin/sh
+out=""
+url=""
+while [ $# -gt 0 ]; do
+	case "$1" in
+	-o) out="$2"; shift ;;
+	*) url="$1" ;;
+	esac
+	shift
+done
+echo "$url" >> "$STUB_DIR/calls"
+dest="${out%.%(ext)s}"
+
+case "$url" in
+*v=sleep*)
+	echo partial > "$dest.webm.part"
+	sleep 60 &
+	echo $! > "$STUB_DIR/child.pid"
+	echo "[download]  12.5% of ~  3.45MiB at    1.20MiB/s ETA 00:02"
+	wait
+	;;
+*v=fail*)
+	if [ -e "$STUB_DIR/fail" ]; then
+		echo "ERROR: unable to download video data: HTTP Error 429: Too Many Requests" >&2
+		exit 1
+	fi
+	;;
+esac
+echo "[download] 100% of ~  3.45MiB at    1.20MiB/s ETA 00:00"
+echo fakeaudio > "$dest.opus"
+`
+
+type testEnv struct {
+	svc  *Service
+	db   *store.Store
+	stub string // $STUB_DIR
+}
+
+func newTestEnv(t *testing.T, cfg Config) *testEnv {
+	t.Helper()
+
+	stub := t.TempDir()
+	if err := os.WriteFile(filepath.Join(stub, "yt-dlp"), []byte(stubYtdlp), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// only the stub: no ffmpeg/ffprobe/fpcalc either, whatever is installed
+	t.Setenv("PATH", stub+":/bin:/usr/bin")
+	t.Setenv("STUB_DIR", stub)
+
+	db, err := store.NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.Layout = Layout{Root: t.TempDir()}
+	if cfg.Workers == 0 {
+		cfg.Workers = 1
+	}
+	return &testEnv{svc: NewService(db, cfg), db: db, stub: stub}
+}
+
+// download queues the download of a new song from a YouTube URL with id
+func (e *testEnv) download(t *testing.T, id string) *Task {
+	t.Helper()
+	ctx := context.Background()
+	songID, err := e.db.SaveSong(ctx, &store.Song{
+		Title: id, Artist: "Stub", Provider: "youtube", ProviderID: id, Status: string(StatusPending),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, err := e.svc.CreateDownload(DownloadPayload{URL: "https://www.youtube.com/watch?v=" + id}, songID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return task
+}
+
+// waitStatus waits for the row of task id to have status
+func (e *testEnv) waitStatus(t *testing.T, id int64, status TaskStatus) *store.DownloadTask {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		row, err := e.db.GetDownloadTask(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if TaskStatus(row.Status) == status {
+			return row
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("task %d is %s, want %s (error %q)", id, row.Status, status, row.LastError)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// waitFile waits for the file name in the stub dir and returns its content
+func (e *testEnv) waitFile(t *testing.T, name string) string {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		data, err := os.ReadFile(filepath.Join(e.stub, name))
+		if err == nil && len(data) > 0 {
+			return strings.TrimSpace(string(data))
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("stub never wrote %s", name)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// calls is how many times the stub ran
+func (e *testEnv) calls() int {
+	data, _ := os.ReadFile(filepath.Join(e.stub, "calls"))
+	return strings.Count(string(data), "\n")
+}
+
+func (e *testEnv) partialFiles(t *testing.T, songID int64) []string {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(e.svc.Layout().SongsDir(), strconv.FormatInt(songID, 10)+".*.part"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return matches
+}
+
+// processGone waits for pid to be killed and reaped
+func processGone(t *testing.T, pid int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for syscall.Kill(pid, 0) == nil {
+		// a killed child of the stub is a zombie of init until reaped,
+		// /proc tells them apart
+		if stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat"); err == nil && strings.Contains(string(stat), ") Z ") {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("process %d still running", pid)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestCancelKillsProcessGroup(t *testing.T) {
+	env := newTestEnv(t, Config{})
+	task := env.download(t, "sleep1")
+
+	env.waitStatus(t, task.ID, StatusDownloading)
+	child, err := strconv.Atoi(env.waitFile(t, "child.pid"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(env.partialFiles(t, task.SongID)) == 0 {
+		t.Fatal("stub left no partial file")
+	}
+
+	if err := env.svc.CancelTask(context.Background(), task.ID); err != nil {
+		t.Fatal(err)
+	}
+	env.waitStatus(t, task.ID, StatusCancelled)
+
+	processGone(t, child)
+	if files := env.partialFiles(t, task.SongID); len(files) > 0 {
+		t.Errorf("partial files left: %v", files)
+	}
+	song, err := env.db.GetSong(context.Background(), task.SongID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if song.Status != string(StatusPending) {
+		t.Errorf("song status = %s, want %s", song.Status, StatusPending)
+	}
+
+	if err := env.svc.CancelTask(context.Background(), task.ID); err != ErrTaskFinished {
+		t.Errorf("cancelling again: %v, want %v", err, ErrTaskFinished)
+	}
+}
+
+func TestJobTimeout(t *testing.T) {
+	env := newTestEnv(t, Config{JobTimeout: 500 * time.Millisecond, MaxAttempts: 1})
+	task := env.download(t, "sleep2")
+
+	row := env.waitStatus(t, task.ID, StatusFailed)
+	if row.ErrorKind != string(FailureTransient) {
+		t.Errorf("error kind = %q, want %q", row.ErrorKind, FailureTransient)
+	}
+	if !strings.Contains(row.LastError, "timed out") {
+		t.Errorf("error = %q, want a timeout", row.LastError)
+	}
+
+	child, err := strconv.Atoi(env.waitFile(t, "child.pid"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	processGone(t, child)
+	if files := env.partialFiles(t, task.SongID); len(files) > 0 {
+		t.Errorf("partial files left: %v", files)
+	}
+}
+
+func TestRetryTask(t *testing.T) {
+	env := newTestEnv(t, Config{MaxAttempts: 1})
+	if err := os.WriteFile(filepath.Join(env.stub, "fail"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	task := env.download(t, "fail1")
+
+	row := env.waitStatus(t, task.ID, StatusFailed)
+	if row.ErrorKind != string(FailureTransient) {
+		t.Errorf("error kind = %q, want %q", row.ErrorKind, FailureTransient)
+	}
+	if _, err := env.svc.RetryTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	env.waitStatus(t, task.ID, StatusFailed)
+
+	// the source is back
+	if err := os.Remove(filepath.Join(env.stub, "fail")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.svc.RetryTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	row = env.waitStatus(t, task.ID, StatusComplete)
+	if row.LastError != "" {
+		t.Errorf("error = %q after success", row.LastError)
+	}
+	// a retry starts counting again
+	if row.Attempts != 1 {
+		t.Errorf("attempts = %d after a retry, want 1", row.Attempts)
+	}
+	if env.calls() != 3 {
+		t.Errorf("stub ran %d times, want 3", env.calls())
+	}
+
+	song, err := env.db.GetSong(context.Background(), task.SongID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if song.Status != "Downloaded" || !env.svc.Layout().Contains(song.FilePath) {
+		t.Errorf("song is %s at %q, want Downloaded in the layout", song.Status, song.FilePath)
+	}
+
+	if _, err := env.svc.RetryTask(context.Background(), task.ID); err != ErrTaskNotRetryable {
+		t.Errorf("retrying a complete task: %v, want %v", err, ErrTaskNotRetryable)
+	}
+}
+
+func TestPauseResume(t *testing.T) {
+	env := newTestEnv(t, Config{})
+	env.svc.PauseQueue()
+	if !env.svc.IsPaused() {
+		t.Fatal("queue not paused")
+	}
+
+	first := env.download(t, "ok1")
+	second := env.download(t, "ok2")
+	// a task cancelled while paused never runs
+	if err := env.svc.CancelTask(context.Background(), second.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	if n := env.calls(); n != 0 {
+		t.Fatalf("stub ran %d times while paused", n)
+	}
+	env.waitStatus(t, first.ID, StatusPending)
+	env.waitStatus(t, second.ID, StatusCancelled)
+
+	env.svc.ResumeQueue()
+	env.waitStatus(t, first.ID, StatusComplete)
+	if n := env.calls(); n != 1 {
+		t.Errorf("stub ran %d times, want 1", n)
+	}
+}
+
+func TestDownloadAgainInAnotherQuality(t *testing.T) {
+	env := newTestEnv(t, Config{})
+	ctx := context.Background()
+	again := func(task *Task, id string, quality Quality) (*Task, error) {
+		return env.svc.CreateDownload(DownloadPayload{URL: "https://www.youtube.com/watch?v=" + id, Quality: quality}, task.SongID)
+	}
+
+	// still queued: the existing task takes the new quality
+	env.svc.PauseQueue()
+	queued := env.download(t, "ok3")
+	task, err := again(queued, "ok3", QualityMP3_320)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.ID != queued.ID || task.Snapshot().Quality != QualityMP3_320 {
+		t.Errorf("got task %d in %s, want task %d in %s", task.ID, task.Snapshot().Quality, queued.ID, QualityMP3_320)
+	}
+	if row, err := env.db.GetDownloadTask(ctx, queued.ID); err != nil || Quality(row.Quality) != QualityMP3_320 {
+		t.Errorf("saved quality = %q, %v", row.Quality, err)
+	}
+	env.svc.ResumeQueue()
+	env.waitStatus(t, queued.ID, StatusComplete)
+
+	// already downloading: too late
+	running := env.download(t, "sleep3")
+	env.waitStatus(t, running.ID, StatusDownloading)
+	if _, err := again(running, "sleep3", QualityFLAC); err != ErrQualityConflict {
+		t.Errorf("asking for another quality while downloading: %v, want %v", err, ErrQualityConflict)
+	}
+	// the same quality is just the same download
+	if task, err := again(running, "sleep3", ""); err != nil || task.ID != running.ID {
+		t.Errorf("asking again = %v, %v", task, err)
+	}
+	if err := env.svc.CancelTask(ctx, running.ID); err != nil {
+		t.Fatal(err)
+	}
+	env.waitStatus(t, running.ID, StatusCancelled)
+}
+
+func TestDownloadAgainWaitsForRetry(t *testing.T) {
+	env := newTestEnv(t, Config{MaxAttempts: 2, RetryDelay: time.Hour, MaxRetryDelay: time.Hour})
+	if err := os.WriteFile(filepath.Join(env.stub, "fail"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	task := env.download(t, "fail2")
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		row, err := env.db.GetDownloadTask(context.Background(), task.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if row.NextAttemptAt != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("task %d never scheduled a retry (status %s)", task.ID, row.Status)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if _, err := env.svc.CreateDownload(DownloadPayload{URL: "https://www.youtube.com/watch?v=fail2"}, task.SongID); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if n := env.calls(); n != 1 {
+		t.Errorf("stub ran %d times, the retry should wait an hour", n)
+	}
+}
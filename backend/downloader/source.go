@@ -0,0 +1,204 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+
+	"cryogon/rizumu-backend/store"
+)
+
+// Source is a place songs can be downloaded from (YouTube, Spotify, osu!...).
+// Adding a provider means implementing it and listing it in DefaultSources.
+type Source interface {
+	// Name is stored as songs.provider and download_tasks.source
+	Name() string
+	// Match tells if the URL belongs to this source
+	Match(u *url.URL) bool
+	// CanonicalID extracts the provider ID (video ID, track ID, beatmapset ID)
+	CanonicalID(u *url.URL) (string, error)
+	// SourceURL builds the URL to download a song back from its provider ID
+	SourceURL(providerID string) string
+	// FetchMetadata looks a song up without downloading it
+	FetchMetadata(ctx context.Context, rawURL string) (*store.Song, error)
+	// Download saves the audio of job.URL at job.Dest plus an extension
+	Download(ctx context.Context, job *Job) (*Result, error)
+}
+
+// DefaultSources are the sources the service uses when Config.Sources is empty
+func DefaultSources() []Source {
+	return []Source{
+		&youtubeSource{},
+		&youtubeSource{music: true},
+		&spotifySource{},
+		&osuSource{},
+	}
+}
+
+// Job is one download handed to a Source
+type Job struct {
+	SongID int64
+	URL    string
+	Dest   string      // where the audio goes without the extension, e.g. <root>/songs/12
+	Covers string      // folder for the artwork a source extracts
+	Song   *store.Song // current DB row, for search fallbacks
+	// Quality the audio is saved in, sources that can't choose ignore it
+	Quality Quality
+
+	OnProgress func(p Progress)
+	OnCommand  func(name string) // a downloader command starts
+	OnOutput   func(line string) // downloader output, kept in the task log tail
+}
+
+func (j *Job) progress(p Progress) {
+	if j.OnProgress != nil {
+		j.OnProgress(p)
+	}
+}
+
+func (j *Job) command(name string) {
+	if j.OnCommand != nil {
+		j.OnCommand(name)
+	}
+}
+
+func (j *Job) output(line string) {
+	if j.OnOutput != nil {
+		j.OnOutput(line)
+	}
+}
+
+// Result is the downloaded file and what the source learned on the way
+type Result struct {
+	Path string
+
+	// Metadata overrides the non-empty fields of the song (osu! beatmap info,
+	// spotdl lookups). Nil keeps the DB row and reads the file tags instead.
+	Metadata *store.Song
+	Credits  []store.SongArtist
+	Tags     []string
+	Beatmaps []store.OsuBeatmap // the difficulties, for osu! beatmapsets
+	// Tagged means the source already wrote the file tags
+	Tagged bool
+}
+
+// GetSource finds the source a URL belongs to
+func (s *Service) GetSource(req DownloadPayload) (Source, error) {
+	u, err := url.Parse(req.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, src := range s.sources {
+		if src.Match(u) {
+			return src, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown source for url: %s", req.URL)
+}
+
+// Identify returns the source of a URL and the song ID on it
+func (s *Service) Identify(rawURL string) (Source, string, error) {
+	src, err := s.GetSource(DownloadPayload{URL: rawURL})
+	if err != nil {
+		return nil, "", err
+	}
+
+	u, _ := url.Parse(rawURL)
+	id, err := src.CanonicalID(u)
+	if err != nil {
+		return nil, "", err
+	}
+	return src, id, nil
+}
+
+func (s *Service) sourceByName(name string) (Source, bool) {
+	for _, src := range s.sources {
+		if src.Name() == name {
+			return src, true
+		}
+	}
+	return nil, false
+}
+
+// SourceURL is where a song of provider can be downloaded from
+func (s *Service) SourceURL(provider, providerID string) (string, error) {
+	src, ok := s.sourceByName(provider)
+	if !ok {
+		return "", fmt.Errorf("source not supported: %s", provider)
+	}
+	return src.SourceURL(providerID), nil
+}
+
+// CanonicalizeProviderIDs rewrites the provider ID of the songs added by URL
+// before provider IDs were canonical, they kept the whole URL. A song added
+// again since then is merged into the new row. Returns the number of songs
+// rewritten.
+func (s *Service) CanonicalizeProviderIDs(ctx context.Context) (int, error) {
+	songs, err := s.Store.GetURLProviderSongs(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	fixed := 0
+	for _, song := range songs {
+		src, id, err := s.Identify(song.ProviderID)
+		if err != nil {
+			log.Printf("[Downloader] WARN: Song %d: %v", song.ID, err)
+			continue
+		}
+		if src.Name() != song.Provider {
+			log.Printf("[Downloader] WARN: Song %d: %s is a %s URL, not %s", song.ID, song.ProviderID, src.Name(), song.Provider)
+			continue
+		}
+		keepID, err := s.Store.SetSongProviderID(ctx, song.ID, id)
+		if err != nil {
+			return fixed, fmt.Errorf("song %d: %w", song.ID, err)
+		}
+		if keepID != song.ID {
+			log.Printf("[Downloader] Merged song %d into %d, the same %s song", song.ID, keepID, song.Provider)
+		}
+		fixed++
+	}
+	if fixed > 0 {
+		log.Printf("[Downloader] Rewrote %d URL provider IDs", fixed)
+	}
+	return fixed, nil
+}
+
+// FetchMetadata looks up a song by URL without downloading it
+func (s *Service) FetchMetadata(ctx context.Context, rawURL string) (*store.Song, error) {
+	src, err := s.GetSource(DownloadPayload{URL: rawURL})
+	if err != nil {
+		return nil, err
+	}
+	return src.FetchMetadata(ctx, rawURL)
+}
+
+// mergeSong copies the non-empty metadata fields of src into a copy of dst
+func mergeSong(dst, src *store.Song) *store.Song {
+	merged := *dst
+	if src.Title != "" {
+		merged.Title = src.Title
+	}
+	if src.Artist != "" {
+		merged.Artist = src.Artist
+	}
+	if src.Album != "" {
+		merged.Album = src.Album
+	}
+	if src.ImageURL != "" {
+		merged.ImageURL = src.ImageURL
+	}
+	if src.DurationMs > 0 {
+		merged.DurationMs = src.DurationMs
+	}
+	if src.BPM > 0 {
+		merged.BPM = src.BPM
+	}
+	if src.PreviewMs != nil {
+		merged.PreviewMs = src.PreviewMs
+	}
+	return &merged
+}
@@ -0,0 +1,315 @@
+package downloader
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"cryogon/rizumu-backend/store"
+	"cryogon/rizumu-backend/tags"
+	"cryogon/rizumu-backend/utils"
+)
+
+const osuMirror = "https://osu.direct/api"
+
+// osuSource downloads beatmapsets (.osz) from the osu.direct mirror and
+// extracts the audio and background out of them
+type osuSource struct{}
+
+func (o *osuSource) Name() string { return "osu!" }
+
+func (o *osuSource) Match(u *url.URL) bool {
+	return strings.Contains(u.Host, "osu.ppy.sh")
+}
+
+func (o *osuSource) CanonicalID(u *url.URL) (string, error) {
+	paths := strings.Split(strings.TrimPrefix(u.Path, "/"), "/")
+	if len(paths) >= 2 && paths[0] == "beatmapsets" && paths[1] != "" {
+		return paths[1], nil
+	}
+	return "", fmt.Errorf("unsupported osu url format: %s", u)
+}
+
+func (o *osuSource) SourceURL(providerID string) string {
+	return "https://osu.ppy.sh/beatmapsets/" + providerID
+}
+
+func (o *osuSource) FetchMetadata(ctx context.Context, rawURL string) (*store.Song, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	setID, err := o.CanonicalID(u)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", osuMirror+"/v2/s/"+setID, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; Rizumu/1.0)")
+
+	resp, err := utils.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, newHTTPStatusError(resp)
+	}
+
+	var set struct {
+		Title    string  `json:"title"`
+		Artist   string  `json:"artist"`
+		BPM      float64 `json:"bpm"`
+		Beatmaps []struct {
+			TotalLength int64 `json:"total_length"` // seconds
+		} `json:"beatmaps"`
+		Covers struct {
+			Cover string `json:"cover"`
+		} `json:"covers"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("bad beatmapset json: %w", err)
+	}
+
+	song := &store.Song{
+		Title:      set.Title,
+		Artist:     set.Artist,
+		BPM:        set.BPM,
+		ImageURL:   set.Covers.Cover,
+		Provider:   o.Name(),
+		ProviderID: setID,
+	}
+	if len(set.Beatmaps) > 0 {
+		song.DurationMs = set.Beatmaps[0].TotalLength * 1000
+	}
+	return song, nil
+}
+
+func (o *osuSource) Download(ctx context.Context, job *Job) (*Result, error) {
+	log.Printf("[Worker] Starting Osu processing for: %s", job.URL)
+
+	parsedURL, err := url.Parse(job.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	downloadURL := job.URL
+	if o.Match(parsedURL) {
+		beatmapsetID, err := o.CanonicalID(parsedURL)
+		if err != nil {
+			return nil, err
+		}
+		downloadURL = fmt.Sprintf("%s/d/%s", osuMirror, beatmapsetID)
+	}
+
+	tempOszPath := filepath.Join(filepath.Dir(job.Dest), fmt.Sprintf("temp_%d.osz", job.SongID))
+	defer func() {
+		if rmErr := os.Remove(tempOszPath); rmErr != nil && !errors.Is(rmErr, os.ErrNotExist) {
+			log.Printf("WARN: Failed to cleanup temp osz: %v", rmErr)
+		}
+	}()
+	if err := downloadOsz(ctx, downloadURL, tempOszPath, job); err != nil {
+		return nil, err
+	}
+
+	r, err := zip.OpenReader(tempOszPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open osz: %w", err)
+	}
+	defer func() {
+		if cErr := r.Close(); cErr != nil {
+			log.Printf("WARN: Failed to close zip reader: %v", cErr)
+		}
+	}()
+
+	var diffs []OsuDifficulty
+	for _, f := range r.File {
+		if !strings.HasSuffix(f.Name, ".osu") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			log.Printf("WARN: Failed to open .osu file: %v", err)
+			continue
+		}
+		diff, err := ParseOsuDifficulty(rc)
+		if cErr := rc.Close(); cErr != nil {
+			log.Printf("WARN: Failed to close .osu file: %v", cErr)
+		}
+		if err != nil {
+			log.Printf("WARN: Failed to read .osu file: %v", err)
+			continue
+		}
+		diffs = append(diffs, diff)
+	}
+
+	if len(diffs) == 0 {
+		return nil, errors.New("no .osu file found in archive")
+	}
+
+	// difficulties using another audio (a cut version) are not this song
+	set := NewOsuSetInfo(GroupOsuDifficulties(diffs)[0])
+	meta := set.Main
+	meta.BgFilename = set.Background
+
+	var finalAudioPath string
+	var finalImagePath string
+
+	for _, f := range r.File {
+		if strings.EqualFold(f.Name, meta.AudioFilename) {
+			// beatmaps come as mp3 or ogg, kept as they are
+			finalAudioPath = job.Dest + strings.ToLower(filepath.Ext(f.Name))
+			if err := extractFileFromZip(f, finalAudioPath); err != nil {
+				return nil, err
+			}
+		}
+		if meta.BgFilename != "" && strings.EqualFold(f.Name, meta.BgFilename) {
+			ext := filepath.Ext(f.Name)
+			finalImagePath = filepath.Join(job.Covers, fmt.Sprintf("%d%s", job.SongID, ext))
+			if mkErr := os.MkdirAll(job.Covers, 0o755); mkErr != nil {
+				log.Printf("WARN: Failed to create covers dir: %v", mkErr)
+			}
+			if extractErr := extractFileFromZip(f, finalImagePath); extractErr != nil {
+				log.Printf("WARN: Failed to extract bg image: %v", extractErr)
+			}
+		}
+	}
+
+	if finalAudioPath == "" {
+		return nil, errors.New("audio file not found in osz")
+	}
+
+	writeOsuTags(finalAudioPath, finalImagePath, meta)
+
+	return &Result{
+		Path: finalAudioPath,
+		Metadata: &store.Song{
+			Title:     meta.Title,
+			Artist:    meta.Artist,
+			BPM:       meta.BPM,
+			ImageURL:  finalImagePath,
+			PreviewMs: set.PreviewMs,
+		},
+		Credits:  SplitArtists(meta.Artist),
+		Tags:     set.Tags,
+		Beatmaps: set.Beatmaps,
+		Tagged:   true,
+	}, nil
+}
+
+// downloadOsz saves the beatmapset archive at path, reporting progress as it goes
+func downloadOsz(ctx context.Context, downloadURL, path string, job *Job) error {
+	outFile, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer func() {
+		if closeErr := outFile.Close(); closeErr != nil && !errors.Is(closeErr, os.ErrClosed) {
+			log.Printf("WARN: Failed to close temp file: %v", closeErr)
+		}
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", downloadURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; Rizumu/1.0)")
+
+	resp, err := utils.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("http get failed: %w", err)
+	}
+	defer func() {
+		if cErr := resp.Body.Close(); cErr != nil {
+			log.Printf("WARN: Failed to close response body: %v", cErr)
+		}
+	}()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return newHTTPStatusError(resp)
+	}
+
+	totalSizeStr := resp.Header.Get("Content-Length")
+	totalSize, _ := strconv.ParseInt(totalSizeStr, 10, 64)
+
+	var downloadedBytes int64 = 0
+	buf := make([]byte, 32*1024)
+
+	for {
+		n, readErr := resp.Body.Read(buf)
+		if n > 0 {
+			if _, writeErr := outFile.Write(buf[:n]); writeErr != nil {
+				return writeErr
+			}
+
+			downloadedBytes += int64(n)
+			// the percent comes from the sizes, when the mirror sent one
+			job.progress(Progress{Downloaded: downloadedBytes, Total: totalSize})
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+
+	if err := outFile.Close(); err != nil {
+		return fmt.Errorf("failed to close osz file: %w", err)
+	}
+	return nil
+}
+
+// writeOsuTags tags the extracted audio with the beatmap's title, artist and background
+func writeOsuTags(audioPath, imagePath string, meta OsuMetadata) {
+	t := &tags.Tags{Title: meta.Title, Artist: meta.Artist, Album: "osu!"}
+	if imagePath != "" {
+		imgBytes, err := os.ReadFile(imagePath)
+		if err == nil {
+			t.Cover = imgBytes
+		} else {
+			log.Printf("WARN: Failed to read cover image for embedding: %v", err)
+		}
+	}
+	if err := tags.Write(audioPath, t); err != nil {
+		log.Printf("WARN: Failed to tag %s: %v", audioPath, err)
+	}
+}
+
+func extractFileFromZip(f *zip.File, destPath string) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+
+	outFile, err := os.Create(destPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cErr := outFile.Close(); cErr != nil {
+			log.Printf("WARN: Failed to close extracted file %s: %v", destPath, cErr)
+		}
+	}()
+
+	if _, err := io.Copy(outFile, rc); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"cryogon/rizumu-backend/store"
+)
+
+var (
+	spotdlTotalRegex    = regexp.MustCompile(`Found (\d+) songs in .*`)
+	spotdlDownloadRegex = regexp.MustCompile(`^(Downloaded|INFO:spotdl.download.downloader:Downloaded)`)
+	spotdlErrorRegex    = regexp.MustCompile(`^(LookupError:|ERROR:spotdl.download.progress_handler:LookupError)`)
+)
+
+// spotifySource downloads with spotdl, falling back to a YouTube search
+// with yt-dlp when spotdl can't find a match
+type spotifySource struct{}
+
+func (sp *spotifySource) Name() string { return "spotify" }
+
+func (sp *spotifySource) Match(u *url.URL) bool {
+	return strings.Contains(u.Host, "spotify.com")
+}
+
+func (sp *spotifySource) CanonicalID(u *url.URL) (string, error) {
+	// /track/<id>, also /intl-fr/track/<id>
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	for i, part := range parts {
+		if part == "track" && i+1 < len(parts) {
+			return parts[i+1], nil
+		}
+	}
+	return "", fmt.Errorf("not a spotify track url: %s", u)
+}
+
+func (sp *spotifySource) SourceURL(providerID string) string {
+	return "https://open.spotify.com/track/" + providerID
+}
+
+func (sp *spotifySource) FetchMetadata(ctx context.Context, rawURL string) (*store.Song, error) {
+	metaFile, err := os.CreateTemp("", "meta_*.spotdl")
+	if err != nil {
+		return nil, err
+	}
+	metaPath := metaFile.Name()
+	_ = metaFile.Close()
+	// Cleanup temp file
+	defer os.Remove(metaPath)
+
+	saveCmd := command(ctx, "spotdl", "save", rawURL, "--save-file", metaPath)
+	if out, err := saveCmd.CombinedOutput(); err != nil {
+		log.Printf("[Worker] WARN: Metadata fetch failed: %s", string(out))
+		return nil, err
+	}
+
+	type SpotdlMeta struct {
+		Name     string  `json:"name"`
+		Artist   string  `json:"artist"`
+		Album    string  `json:"album_name"`
+		Duration float64 `json:"duration"` // Seconds
+		CoverURL string  `json:"cover_url"`
+		SongID   string  `json:"song_id"`
+	}
+	var metaList []SpotdlMeta
+
+	jsonBytes, err := os.ReadFile(metaPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(jsonBytes, &metaList); err != nil {
+		return nil, fmt.Errorf("bad spotdl save file: %w", err)
+	}
+	if len(metaList) == 0 {
+		return nil, errors.New("spotdl found no track")
+	}
+
+	m := metaList[0]
+	return &store.Song{
+		Title:      m.Name,
+		Artist:     m.Artist,
+		Album:      m.Album,
+		ImageURL:   m.CoverURL,
+		DurationMs: int64(m.Duration * 1000),
+		Provider:   sp.Name(),
+		ProviderID: m.SongID,
+	}, nil
+}
+
+func (sp *spotifySource) Download(ctx context.Context, job *Job) (*Result, error) {
+	args := []string{"download", job.URL}
+	args = append(args, job.Quality.spotdlArgs()...)
+	args = append(args, "--output", job.Dest+".{output-ext}")
+	cmd := command(ctx, "spotdl", args...)
+
+	if err := runCommand(cmd, "spotdl", job, spotdlProgress(job)); err == nil {
+		if path, err := findOutput(job.Dest); err == nil {
+			// spotdl tags the file itself
+			return &Result{Path: path, Tagged: true}, nil
+		}
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	log.Printf("[Worker] spotdl failed for %d. Attempting Brute Force Fallback...", job.SongID)
+	return sp.searchYoutube(ctx, job)
+}
+
+// searchYoutube downloads the first YouTube result of "artist - title"
+// whose duration is close to the track's
+func (sp *spotifySource) searchYoutube(ctx context.Context, job *Job) (*Result, error) {
+	if job.Song == nil {
+		return nil, errors.New("fallback failed: could not get metadata")
+	}
+	song := *job.Song
+	res := &Result{}
+
+	// JIT Metadata Fetching
+	if song.DurationMs == 0 {
+		log.Printf("[Worker] Metadata missing for %d. Fetching via spotdl save...", job.SongID)
+		meta, err := sp.FetchMetadata(ctx, job.URL)
+		if err != nil {
+			return nil, err
+		}
+		song = *mergeSong(&song, meta)
+		res.Metadata = meta
+	}
+
+	searchQuery := fmt.Sprintf("ytsearch5:%s - %s", song.Artist, song.Title)
+	songDuration := song.DurationMs / 1000
+	lowerBound := max(songDuration-30, 0)
+	matchFilter := fmt.Sprintf("duration > %d & duration < %d", lowerBound, songDuration+30) // adding extra 30 secs just in case
+	log.Printf("[Worker] Searching YouTube for: %s; filter:%s", searchQuery, matchFilter)
+
+	args := append(job.Quality.ytdlpArgs(),
+		"-o", job.Dest+".%(ext)s",
+		"--progress",
+		"--newline",
+		"--match-filter", matchFilter,
+		// stop after downloading exactly one file
+		"--max-downloads", "1",
+		searchQuery,
+	)
+	fallbackCmd := command(ctx, "yt-dlp", args...)
+
+	if err := runCommand(fallbackCmd, "fallback", job, ytProgress(job)); err != nil {
+		// Check if it's the specific "Max Downloads Reached" error (Exit Code 101)
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 101 {
+			// This is actually a success for us if the file exists
+			if path, findErr := findOutput(job.Dest); findErr == nil {
+				log.Printf("[Worker] Fallback hit max-downloads limit (expected).")
+				res.Path = path
+				return res, nil
+			}
+		}
+		return nil, fmt.Errorf("fallback download failed: %w", err)
+	}
+	path, err := findOutput(job.Dest)
+	if err != nil {
+		return nil, err
+	}
+	res.Path = path
+	return res, nil
+}
+
+// spotdlProgress counts the downloaded songs of a (possibly multi song) run
+func spotdlProgress(job *Job) func(string) {
+	totalSongs := 1.0
+	processedSongs := 0.0
+
+	return func(line string) {
+		if matches := spotdlTotalRegex.FindStringSubmatch(line); len(matches) > 1 {
+			if total, err := strconv.ParseFloat(matches[1], 64); err == nil && total > 0 {
+				totalSongs = total
+			}
+		}
+		if spotdlDownloadRegex.MatchString(line) {
+			processedSongs++
+		}
+		if spotdlErrorRegex.MatchString(line) {
+			processedSongs++
+		}
+		job.progress(Progress{Percent: (processedSongs / totalSongs) * 100})
+	}
+}
@@ -0,0 +1,210 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"cryogon/rizumu-backend/store"
+)
+
+// fakeSource serves songs at https://fake.test/song/<id> and downloads them
+// as a few bytes of "audio"
+type fakeSource struct{}
+
+func (fakeSource) Name() string { return "fake" }
+
+func (fakeSource) Match(u *url.URL) bool { return u.Host == "fake.test" }
+
+func (fakeSource) CanonicalID(u *url.URL) (string, error) {
+	id, ok := strings.CutPrefix(u.Path, "/song/")
+	if !ok || id == "" {
+		return "", fmt.Errorf("not a song: %s", u)
+	}
+	return id, nil
+}
+
+func (fakeSource) SourceURL(providerID string) string { return "https://fake.test/song/" + providerID }
+
+func (fakeSource) FetchMetadata(ctx context.Context, rawURL string) (*store.Song, error) {
+	return &store.Song{Title: "Fake Song", Artist: "Fake Artist"}, nil
+}
+
+func (fakeSource) Download(ctx context.Context, job *Job) (*Result, error) {
+	path := job.Dest + ".mp3"
+	if err := os.WriteFile(path, []byte("audio of "+job.URL), 0o644); err != nil {
+		return nil, err
+	}
+	return &Result{
+		Path:     path,
+		Metadata: &store.Song{Title: "Fake Song", Artist: "Fake Artist"},
+		Tagged:   true,
+	}, nil
+}
+
+func newFakeService(t *testing.T) (*Service, *store.Store) {
+	t.Helper()
+	// no fpcalc or ffmpeg, whatever is installed
+	t.Setenv("PATH", t.TempDir())
+	db, err := store.NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := NewService(db, Config{Layout: Layout{Root: t.TempDir()}, Sources: []Source{fakeSource{}}})
+	return svc, db
+}
+
+func TestIdentify(t *testing.T) {
+	svc, _ := newFakeService(t)
+
+	src, id, err := svc.Identify("https://fake.test/song/42?si=share")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.Name() != "fake" || id != "42" {
+		t.Errorf("Identify = %s, %q", src.Name(), id)
+	}
+	if u, err := svc.SourceURL("fake", id); err != nil || u != "https://fake.test/song/42" {
+		t.Errorf("SourceURL = %q, %v", u, err)
+	}
+
+	for _, rawURL := range []string{"https://other.test/song/42", "https://fake.test/album/1"} {
+		if _, _, err := svc.Identify(rawURL); err == nil {
+			t.Errorf("Identify(%s) succeeded", rawURL)
+		}
+	}
+	if _, err := svc.SourceURL("youtube", "abc"); err == nil {
+		t.Error("SourceURL of a source not configured succeeded")
+	}
+}
+
+func TestDownloadFromSource(t *testing.T) {
+	svc, db := newFakeService(t)
+	ctx := context.Background()
+
+	_, id, err := svc.Identify("https://fake.test/song/7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	songID, err := db.SaveSong(ctx, &store.Song{Title: store.PendingTitle, Artist: "Unknown", Provider: "fake", ProviderID: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, err := svc.CreateDownload(DownloadPayload{URL: "https://fake.test/song/7"}, songID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		row, err := db.GetDownloadTask(ctx, task.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if TaskStatus(row.Status) == StatusComplete {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("task is %s (error %q)", row.Status, row.LastError)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	song, err := db.GetSong(ctx, songID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if song.Status != "Downloaded" || song.Title != "Fake Song" || song.Artist != "Fake Artist" {
+		t.Errorf("song = %s %q by %q", song.Status, song.Title, song.Artist)
+	}
+	data, err := os.ReadFile(song.FilePath)
+	if err != nil || string(data) != "audio of https://fake.test/song/7" {
+		t.Errorf("file %s = %q, %v", song.FilePath, data, err)
+	}
+}
+
+func TestCanonicalizeProviderIDs(t *testing.T) {
+	svc, db := newFakeService(t)
+	ctx := context.Background()
+	save := func(song *store.Song) int64 {
+		t.Helper()
+		id, err := db.SaveSong(ctx, song)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	// added by URL before canonical IDs
+	oldID := save(&store.Song{Title: "Old", Artist: "A", Provider: "fake", ProviderID: "https://fake.test/song/1"})
+	// downloaded by URL, then added again since
+	oldCopyID := save(&store.Song{Title: "Copy", Artist: "A", Provider: "fake", ProviderID: "https://fake.test/song/2?si=x"})
+	if err := db.UpdateSongFile(ctx, oldCopyID, store.SongFile{Path: "/music/2.mp3", Size: 4}); err != nil {
+		t.Fatal(err)
+	}
+	newID := save(&store.Song{Title: "Copy", Artist: "A", Provider: "fake", ProviderID: "2"})
+	playlistID, err := db.SavePlaylist(ctx, &store.Playlist{UserID: 1, Name: "Mix", SourceType: "local", ExternalID: "mix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddSongToPlaylist(ctx, playlistID, oldCopyID); err != nil {
+		t.Fatal(err)
+	}
+	// not a song URL of any source
+	brokenID := save(&store.Song{Title: "Broken", Artist: "A", Provider: "fake", ProviderID: "https://fake.test/album/3"})
+
+	fixed, err := svc.CanonicalizeProviderIDs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fixed != 2 {
+		t.Errorf("rewrote %d songs, want 2", fixed)
+	}
+
+	want := map[int64]string{oldID: "1", newID: "2", brokenID: "https://fake.test/album/3"}
+	for id, providerID := range want {
+		song, err := db.GetSong(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if song.ProviderID != providerID {
+			t.Errorf("song %d provider_id = %q, want %q", id, song.ProviderID, providerID)
+		}
+	}
+	if _, err := db.GetSong(ctx, oldCopyID); err == nil {
+		t.Errorf("song %d is still there, it's the same as %d", oldCopyID, newID)
+	}
+
+	song, err := db.GetSong(ctx, newID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if song.Status != "Downloaded" || song.FilePath != "/music/2.mp3" {
+		t.Errorf("the kept song didn't take the file: %s %q", song.Status, song.FilePath)
+	}
+	inPlaylist, err := db.GetSongsByPlaylist(ctx, playlistID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inPlaylist) != 1 || inPlaylist[0].ID != newID {
+		t.Errorf("playlist holds %v, want song %d", inPlaylist, newID)
+	}
+
+	// re-adding a URL finds the rewritten song
+	_, id, err := svc.Identify("https://fake.test/song/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again := save(&store.Song{Title: store.PendingTitle, Artist: "Unknown", Provider: "fake", ProviderID: id}); again != oldID {
+		t.Errorf("the same URL saved as song %d, want %d", again, oldID)
+	}
+
+	if fixed, err := svc.CanonicalizeProviderIDs(ctx); err != nil || fixed != 0 {
+		t.Errorf("second run rewrote %d songs (%v)", fixed, err)
+	}
+}
@@ -0,0 +1,191 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"cryogon/rizumu-backend/store"
+)
+
+// [download]  42.3% of ~  3.45MiB at    1.20MiB/s ETA 00:02
+var (
+	ytProgressRegex = regexp.MustCompile(`\[download\]\s+(\d+\.?\d*)%`)
+	ytSizeRegex     = regexp.MustCompile(`of\s+~?\s*(\d+\.?\d*)(B|[KMGT]iB)`)
+	ytSpeedRegex    = regexp.MustCompile(`at\s+(\d+\.?\d*)(B|[KMGT]iB)/s`)
+	ytETARegex      = regexp.MustCompile(`ETA\s+(\d+(?::\d+)+)`)
+)
+
+// youtubeSource downloads with yt-dlp. music switches it to YouTube Music
+// URLs, the download itself is the same.
+type youtubeSource struct {
+	music bool
+}
+
+func (y *youtubeSource) Name() string {
+	if y.music {
+		return "youtube-music"
+	}
+	return "youtube"
+}
+
+func (y *youtubeSource) Match(u *url.URL) bool {
+	if strings.Contains(u.Host, "music.youtube.com") {
+		return y.music
+	}
+	return !y.music && (strings.Contains(u.Host, "youtube.com") || strings.Contains(u.Host, "youtu.be"))
+}
+
+func (y *youtubeSource) CanonicalID(u *url.URL) (string, error) {
+	if v := u.Query().Get("v"); v != "" {
+		return v, nil
+	}
+
+	// youtu.be/<id>, /shorts/<id>, /embed/<id>, /live/<id>
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if strings.Contains(u.Host, "youtu.be") && parts[0] != "" {
+		return parts[0], nil
+	}
+	if len(parts) == 2 && (parts[0] == "shorts" || parts[0] == "embed" || parts[0] == "live") {
+		return parts[1], nil
+	}
+	return "", fmt.Errorf("no video id in url: %s", u)
+}
+
+func (y *youtubeSource) SourceURL(providerID string) string {
+	if y.music {
+		return "https://music.youtube.com/watch?v=" + providerID
+	}
+	return "https://www.youtube.com/watch?v=" + providerID
+}
+
+func (y *youtubeSource) FetchMetadata(ctx context.Context, rawURL string) (*store.Song, error) {
+	out, err := command(ctx, "yt-dlp", "--dump-json", "--skip-download", "--no-playlist", rawURL).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, fmt.Errorf("yt-dlp: %s: %w", strings.TrimSpace(string(exitErr.Stderr)), err)
+		}
+		return nil, err
+	}
+
+	var info struct {
+		ID        string  `json:"id"`
+		Title     string  `json:"title"`
+		Track     string  `json:"track"`
+		Artist    string  `json:"artist"`
+		Uploader  string  `json:"uploader"`
+		Album     string  `json:"album"`
+		Duration  float64 `json:"duration"` // seconds
+		Thumbnail string  `json:"thumbnail"`
+	}
+	if err := json.Unmarshal(out, &info); err != nil {
+		return nil, fmt.Errorf("bad yt-dlp json: %w", err)
+	}
+
+	song := &store.Song{
+		Title:      info.Title,
+		Artist:     strings.TrimSuffix(info.Uploader, " - Topic"),
+		Album:      info.Album,
+		ImageURL:   info.Thumbnail,
+		DurationMs: int64(info.Duration * 1000),
+		Provider:   y.Name(),
+		ProviderID: info.ID,
+	}
+	// Music videos have proper track info, the title often has junk in it
+	if info.Track != "" {
+		song.Title = info.Track
+	}
+	if info.Artist != "" {
+		song.Artist = info.Artist
+	}
+	return song, nil
+}
+
+func (y *youtubeSource) Download(ctx context.Context, job *Job) (*Result, error) {
+	args := append(job.Quality.ytdlpArgs(),
+		"-o", job.Dest+".%(ext)s",
+		"--progress",
+		"--newline",
+		"--add-metadata",
+		"--embed-thumbnail",
+		job.URL,
+	)
+	cmd := command(ctx, "yt-dlp", args...)
+
+	if err := runCommand(cmd, "yt-dlp", job, ytProgress(job)); err != nil {
+		return nil, err
+	}
+	path, err := findOutput(job.Dest)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{Path: path}, nil
+}
+
+// ytProgress reads the percentage, size, speed and ETA of yt-dlp's
+// "[download]" lines
+func ytProgress(job *Job) func(string) {
+	return func(line string) {
+		matches := ytProgressRegex.FindStringSubmatch(line)
+		if len(matches) < 2 {
+			return
+		}
+		percent, err := strconv.ParseFloat(matches[1], 64)
+		if err != nil {
+			return
+		}
+
+		p := Progress{Percent: percent}
+		if m := ytSizeRegex.FindStringSubmatch(line); m != nil {
+			p.Total = parseSize(m[1], m[2])
+			p.Downloaded = int64(float64(p.Total) * percent / 100)
+		}
+		if m := ytSpeedRegex.FindStringSubmatch(line); m != nil {
+			p.Speed = float64(parseSize(m[1], m[2]))
+		}
+		if m := ytETARegex.FindStringSubmatch(line); m != nil {
+			p.ETA = parseClock(m[1])
+		}
+		job.progress(p)
+	}
+}
+
+// parseSize turns yt-dlp's "3.45" "MiB" into bytes
+func parseSize(value, unit string) int64 {
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0
+	}
+	switch unit {
+	case "KiB":
+		n *= 1 << 10
+	case "MiB":
+		n *= 1 << 20
+	case "GiB":
+		n *= 1 << 30
+	case "TiB":
+		n *= 1 << 40
+	}
+	return int64(n)
+}
+
+// parseClock turns "01:02:03" or "02:03" into a duration
+func parseClock(clock string) time.Duration {
+	var secs int
+	for part := range strings.SplitSeq(clock, ":") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return 0
+		}
+		secs = secs*60 + n
+	}
+	return time.Duration(secs) * time.Second
+}
@@ -0,0 +1,145 @@
+package fingerprint
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"cryogon/rizumu-backend/utils"
+)
+
+// Lookup names a recording from its fingerprint. AcoustID is the one we
+// use; tests and other services plug in here.
+type Lookup interface {
+	Lookup(ctx context.Context, fp *Fingerprint) ([]Match, error)
+}
+
+// Match is a recording a fingerprint lookup found, best first
+type Match struct {
+	Score       float64 `json:"score"` // 0-1, how close the fingerprints are
+	RecordingID string  `json:"recording_id"`
+	Title       string  `json:"title"`
+	Artist      string  `json:"artist"`
+	Album       string  `json:"album,omitempty"`
+	DurationMs  int64   `json:"duration_ms,omitempty"`
+}
+
+// DefaultAcoustIDURL is the AcoustID web service
+const DefaultAcoustIDURL = "https://api.acoustid.org/v2/lookup"
+
+// AcoustID looks fingerprints up on acoustid.org. Key is an application
+// API key (https://acoustid.org/new-application).
+type AcoustID struct {
+	Key     string
+	BaseURL string // DefaultAcoustIDURL, or a stand-in
+	Client  *http.Client
+}
+
+func NewAcoustID(key string) *AcoustID {
+	return &AcoustID{Key: key, BaseURL: DefaultAcoustIDURL, Client: utils.HTTPClient}
+}
+
+type releaseGroup struct {
+	Title string `json:"title"`
+	Type  string `json:"type"` // 'Album', 'Single', ...
+}
+
+type acoustIDResponse struct {
+	Status string `json:"status"`
+	Error  struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+	Results []struct {
+		ID         string  `json:"id"`
+		Score      float64 `json:"score"`
+		Recordings []struct {
+			ID       string  `json:"id"`
+			Title    string  `json:"title"`
+			Duration float64 `json:"duration"`
+			Artists  []struct {
+				Name       string `json:"name"`
+				JoinPhrase string `json:"joinphrase"`
+			} `json:"artists"`
+			ReleaseGroups []releaseGroup `json:"releasegroups"`
+		} `json:"recordings"`
+	} `json:"results"`
+}
+
+// Lookup POSTs the fingerprint (they're too long for a query string) and
+// returns one Match per recording with a title
+func (a *AcoustID) Lookup(ctx context.Context, fp *Fingerprint) ([]Match, error) {
+	form := url.Values{
+		"client":      {a.Key},
+		"format":      {"json"},
+		"meta":        {"recordings releasegroups"},
+		"duration":    {strconv.Itoa(fp.Duration)},
+		"fingerprint": {fp.Value},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.BaseURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	client := a.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var body acoustIDResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("acoustid: %s: %w", resp.Status, err)
+	}
+	if body.Status != "ok" {
+		return nil, fmt.Errorf("acoustid: %s (code %d)", body.Error.Message, body.Error.Code)
+	}
+
+	var matches []Match
+	for _, result := range body.Results {
+		for _, rec := range result.Recordings {
+			if rec.Title == "" {
+				continue
+			}
+			m := Match{
+				Score:       result.Score,
+				RecordingID: rec.ID,
+				Title:       rec.Title,
+				DurationMs:  int64(rec.Duration * 1000),
+			}
+			var artist strings.Builder
+			for _, credit := range rec.Artists {
+				artist.WriteString(credit.Name + credit.JoinPhrase)
+			}
+			m.Artist = artist.String()
+			m.Album = album(rec.ReleaseGroups)
+			matches = append(matches, m)
+		}
+	}
+	slices.SortStableFunc(matches, func(a, b Match) int { return cmp.Compare(b.Score, a.Score) })
+	return matches, nil
+}
+
+// album prefers an album to the singles and compilations a song is also on
+func album(groups []releaseGroup) string {
+	for _, g := range groups {
+		if g.Type == "Album" {
+			return g.Title
+		}
+	}
+	if len(groups) > 0 {
+		return groups[0].Title
+	}
+	return ""
+}
@@ -0,0 +1,135 @@
+package fingerprint
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// acoustIDStandIn answers every lookup with body and status, and checks
+// the request looks like what acoustid.org expects
+func acoustIDStandIn(t *testing.T, status int, body string) *AcoustID {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("method %s, want POST", r.Method)
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("bad form: %v", err)
+		}
+		want := map[string]string{
+			"client":      "test-key",
+			"format":      "json",
+			"meta":        "recordings releasegroups",
+			"duration":    "215",
+			"fingerprint": "AQAAtestfingerprint",
+		}
+		for key, value := range want {
+			if got := r.PostForm.Get(key); got != value {
+				t.Errorf("%s = %q, want %q", key, got, value)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+
+	a := NewAcoustID("test-key")
+	a.BaseURL = srv.URL
+	a.Client = srv.Client()
+	return a
+}
+
+var testFingerprint = &Fingerprint{Value: "AQAAtestfingerprint", Duration: 215}
+
+func TestAcoustIDLookup(t *testing.T) {
+	a := acoustIDStandIn(t, http.StatusOK, `{
+		"status": "ok",
+		"results": [
+			{"id": "r2", "score": 0.52, "recordings": [
+				{"id": "rec-other", "title": "Something Else", "duration": 180,
+				 "artists": [{"name": "Nobody"}]}
+			]},
+			{"id": "r1", "score": 0.97, "recordings": [
+				{"id": "rec-1", "title": "Under Pressure", "duration": 248.5,
+				 "artists": [{"name": "Queen", "joinphrase": " & "}, {"name": "David Bowie"}],
+				 "releasegroups": [
+					{"title": "Under Pressure", "type": "Single"},
+					{"title": "Hot Space", "type": "Album"}
+				 ]}
+			]}
+		]
+	}`)
+
+	matches, err := a.Lookup(context.Background(), testFingerprint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 2 {
+		t.Fatalf("%d matches, want 2", len(matches))
+	}
+	want := Match{
+		Score:       0.97,
+		RecordingID: "rec-1",
+		Title:       "Under Pressure",
+		Artist:      "Queen & David Bowie",
+		Album:       "Hot Space",
+		DurationMs:  248500,
+	}
+	if matches[0] != want {
+		t.Errorf("best match = %+v, want %+v", matches[0], want)
+	}
+	if matches[1].RecordingID != "rec-other" || matches[1].Album != "" {
+		t.Errorf("second match = %+v", matches[1])
+	}
+}
+
+func TestAcoustIDLookupError(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   string
+	}{
+		{"error status", http.StatusBadRequest, `{"status": "error", "error": {"code": 4, "message": "invalid API key"}}`, "invalid API key"},
+		{"not json", http.StatusBadGateway, `<html>502 Bad Gateway</html>`, "502"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := acoustIDStandIn(t, tt.status, tt.body)
+			matches, err := a.Lookup(context.Background(), testFingerprint)
+			if err == nil {
+				t.Fatalf("no error, got %+v", matches)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q doesn't say %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAcoustIDLookupNoRecordings(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"no results", `{"status": "ok", "results": []}`},
+		{"results without recordings", `{"status": "ok", "results": [{"id": "r1", "score": 0.9}]}`},
+		{"untitled recordings", `{"status": "ok", "results": [{"id": "r1", "score": 0.9, "recordings": [{"id": "rec-1"}, {"id": "rec-2", "title": ""}]}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := acoustIDStandIn(t, http.StatusOK, tt.body)
+			matches, err := a.Lookup(context.Background(), testFingerprint)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(matches) != 0 {
+				t.Errorf("got %+v, want no match", matches)
+			}
+		})
+	}
+}
@@ -0,0 +1,291 @@
+package fingerprint
+
+import (
+	"encoding/base64"
+	"math"
+	"math/cmplx"
+)
+
+// Chromaprint's default algorithm (TEST2), what fpcalc and AcoustID use:
+// 11025 Hz mono, 4096 sample frames every 1365 samples, their energy folded
+// into 12 pitch classes, smoothed, then 16 filters over the chroma "image"
+// give 2 bits each of a 32 bit sub-fingerprint per frame.
+const (
+	SampleRate = 11025
+	algorithm  = 1 // CHROMAPRINT_ALGORITHM_TEST2, the first byte of the fingerprint
+
+	frameSize   = 4096
+	frameStep   = frameSize / 3 // the frames overlap by frameSize - frameStep
+	minFreq     = 28
+	maxFreq     = 3520
+	numBands    = 12
+	normMinimum = 0.01 // quieter chroma vectors are silence, zeroed
+)
+
+var chromaFilter = []float64{0.25, 0.75, 1.0, 0.75, 0.25}
+
+// filter is a Haar-like filter over the chroma image: y and height in bands,
+// width in frames
+type filter struct {
+	kind, y, height, width int
+}
+
+// classifier quantizes a filter's response into 0-3
+type classifier struct {
+	filter
+	t0, t1, t2 float64
+}
+
+var classifiers = []classifier{
+	{filter{0, 4, 3, 15}, 1.98215, 2.35817, 2.63523},
+	{filter{4, 4, 6, 15}, -1.03809, -0.651211, -0.282167},
+	{filter{1, 0, 4, 16}, -0.298702, 0.119262, 0.558497},
+	{filter{3, 8, 2, 12}, -0.105439, 0.0153946, 0.135898},
+	{filter{3, 4, 4, 8}, -0.142891, 0.0258736, 0.200632},
+	{filter{4, 0, 3, 5}, -0.826319, -0.590612, -0.368214},
+	{filter{1, 2, 2, 9}, -0.557409, -0.233035, 0.0534525},
+	{filter{2, 7, 3, 4}, -0.0646826, 0.00620476, 0.0784847},
+	{filter{2, 6, 2, 16}, -0.192387, -0.029699, 0.215855},
+	{filter{2, 1, 3, 2}, -0.0397818, -0.00568076, 0.0292026},
+	{filter{5, 10, 1, 15}, -0.53823, -0.369934, -0.190235},
+	{filter{3, 6, 2, 10}, -0.124877, 0.0296483, 0.139239},
+	{filter{2, 1, 1, 14}, -0.101475, 0.0225617, 0.231971},
+	{filter{3, 5, 6, 4}, -0.0799915, -0.00729616, 0.063262},
+	{filter{1, 9, 2, 12}, -0.272556, 0.019424, 0.302559},
+	{filter{3, 4, 2, 14}, -0.164292, -0.0321188, 0.0846339},
+}
+
+const maxFilterWidth = 16
+
+var grayCode = [4]uint32{0, 1, 3, 2}
+
+// Calculate fingerprints 11025 Hz mono samples. Under about three seconds
+// of audio gives nothing.
+func Calculate(samples []int16) []uint32 {
+	image := chromaImage(samples)
+	if len(image) < maxFilterWidth {
+		return nil
+	}
+
+	integral := integralImage(image)
+	fp := make([]uint32, 0, len(image)-maxFilterWidth+1)
+	for offset := 0; offset+maxFilterWidth <= len(image); offset++ {
+		var bits uint32
+		for _, c := range classifiers {
+			bits = bits<<2 | grayCode[c.classify(integral, offset)]
+		}
+		fp = append(fp, bits)
+	}
+	return fp
+}
+
+// chromaImage is one smoothed, normalized chroma vector per frame
+func chromaImage(samples []int16) [][numBands]float64 {
+	window := make([]float64, frameSize)
+	for i := range window {
+		// Hamming, with the samples scaled to [-1, 1]
+		window[i] = (0.54 - 0.46*math.Cos(2*math.Pi*float64(i)/float64(frameSize-1))) / math.MaxInt16
+	}
+
+	minIndex := max(1, freqToIndex(minFreq))
+	maxIndex := min(frameSize/2, freqToIndex(maxFreq))
+	notes := make([]int, frameSize/2)
+	for i := minIndex; i < maxIndex; i++ {
+		freq := float64(i) * SampleRate / frameSize
+		octave := math.Log2(freq / (440.0 / 16))
+		notes[i] = int(numBands * (octave - math.Floor(octave)))
+	}
+
+	var chromas [][numBands]float64
+	buf := make([]complex128, frameSize)
+	for start := 0; start+frameSize <= len(samples); start += frameStep {
+		for i := range buf {
+			buf[i] = complex(float64(samples[start+i])*window[i], 0)
+		}
+		fft(buf)
+
+		var chroma [numBands]float64
+		for i := minIndex; i < maxIndex; i++ {
+			energy := real(buf[i])*real(buf[i]) + imag(buf[i])*imag(buf[i])
+			chroma[notes[i]] += energy
+		}
+		chromas = append(chromas, chroma)
+	}
+
+	var image [][numBands]float64
+	for i := 0; i+len(chromaFilter) <= len(chromas); i++ {
+		var row [numBands]float64
+		for j, coef := range chromaFilter {
+			for b := range row {
+				row[b] += coef * chromas[i+j][b]
+			}
+		}
+		normalize(&row)
+		image = append(image, row)
+	}
+	return image
+}
+
+func freqToIndex(freq float64) int {
+	return int(math.Round(frameSize * freq / SampleRate))
+}
+
+func normalize(row *[numBands]float64) {
+	var sum float64
+	for _, v := range row {
+		sum += v * v
+	}
+	norm := math.Sqrt(sum)
+	for i := range row {
+		if norm < normMinimum {
+			row[i] = 0
+		} else {
+			row[i] /= norm
+		}
+	}
+}
+
+// fft is an in-place radix-2 Cooley-Tukey, len(a) a power of two
+func fft(a []complex128) {
+	n := len(a)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j |= bit
+		if i < j {
+			a[i], a[j] = a[j], a[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := range size / 2 {
+				u, v := a[start+k], a[start+k+size/2]*w
+				a[start+k], a[start+k+size/2] = u+v, u-v
+				w *= step
+			}
+		}
+	}
+}
+
+// integralImage[r][c] is the sum of image[0..r)[0..c), so any rectangle
+// sums in four lookups
+func integralImage(image [][numBands]float64) [][numBands + 1]float64 {
+	ii := make([][numBands + 1]float64, len(image)+1)
+	for r, row := range image {
+		for c, v := range row {
+			ii[r+1][c+1] = v + ii[r][c+1] + ii[r+1][c] - ii[r][c]
+		}
+	}
+	return ii
+}
+
+// area sums frames [x1, x2) and bands [y1, y2)
+func area(ii [][numBands + 1]float64, x1, y1, x2, y2 int) float64 {
+	return ii[x2][y2] - ii[x1][y2] - ii[x2][y1] + ii[x1][y1]
+}
+
+func subtractLog(a, b float64) float64 {
+	return math.Log1p(a) - math.Log1p(b)
+}
+
+func (f filter) apply(ii [][numBands + 1]float64, x int) float64 {
+	y, w, h := f.y, f.width, f.height
+	switch f.kind {
+	case 0:
+		return subtractLog(area(ii, x, y, x+w, y+h), 0)
+	case 1: // upper half against lower half
+		a := area(ii, x, y+h/2, x+w, y+h)
+		b := area(ii, x, y, x+w, y+h/2)
+		return subtractLog(a, b)
+	case 2: // later half against earlier half
+		a := area(ii, x+w/2, y, x+w, y+h)
+		b := area(ii, x, y, x+w/2, y+h)
+		return subtractLog(a, b)
+	case 3: // checkerboard
+		a := area(ii, x, y+h/2, x+w/2, y+h) + area(ii, x+w/2, y, x+w, y+h/2)
+		b := area(ii, x, y, x+w/2, y+h/2) + area(ii, x+w/2, y+h/2, x+w, y+h)
+		return subtractLog(a, b)
+	case 4: // middle third of the bands against the outer ones
+		h3 := h / 3
+		a := area(ii, x, y+h3, x+w, y+2*h3)
+		b := area(ii, x, y, x+w, y+h3) + area(ii, x, y+2*h3, x+w, y+h)
+		return subtractLog(a, b)
+	case 5: // middle third of the frames against the outer ones
+		w3 := w / 3
+		a := area(ii, x+w3, y, x+2*w3, y+h)
+		b := area(ii, x, y, x+w3, y+h) + area(ii, x+2*w3, y, x+w, y+h)
+		return subtractLog(a, b)
+	}
+	return 0
+}
+
+func (c classifier) classify(ii [][numBands + 1]float64, x int) int {
+	v := c.apply(ii, x)
+	switch {
+	case v < c.t0:
+		return 0
+	case v < c.t1:
+		return 1
+	case v < c.t2:
+		return 2
+	}
+	return 3
+}
+
+// Encode compresses a fingerprint the way fpcalc prints it: a header, the
+// positions of the bits that changed since the previous sub-fingerprint in
+// 3 bits each (5 more for big ones), in URL-safe base64
+func Encode(fp []uint32) string {
+	var deltas []int
+	var prev uint32
+	for i, x := range fp {
+		if i > 0 {
+			x ^= prev
+		}
+		prev = fp[i]
+
+		last := 0
+		for bit := 1; x != 0; bit++ {
+			if x&1 != 0 {
+				deltas = append(deltas, bit-last)
+				last = bit
+			}
+			x >>= 1
+		}
+		deltas = append(deltas, 0)
+	}
+
+	out := []byte{algorithm, byte(len(fp) >> 16), byte(len(fp) >> 8), byte(len(fp))}
+	normal, exceptional := &bitWriter{}, &bitWriter{}
+	for _, d := range deltas {
+		normal.write(uint32(min(d, 7)), 3)
+		if d >= 7 {
+			exceptional.write(uint32(d-7), 5)
+		}
+	}
+	out = append(out, normal.buf...)
+	out = append(out, exceptional.buf...)
+	return base64.RawURLEncoding.EncodeToString(out)
+}
+
+// bitWriter packs values least significant bit first, like Chromaprint's
+// PackInt3Array and PackInt5Array
+type bitWriter struct {
+	buf  []byte
+	used int // bits used in the last byte
+}
+
+func (w *bitWriter) write(v uint32, bits int) {
+	for range bits {
+		if w.used == 0 {
+			w.buf = append(w.buf, 0)
+		}
+		w.buf[len(w.buf)-1] |= byte(v&1) << w.used
+		v >>= 1
+		w.used = (w.used + 1) % 8
+	}
+}
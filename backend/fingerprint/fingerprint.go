@@ -0,0 +1,121 @@
+// Package fingerprint identifies songs by their audio. Fingerprints are
+// Chromaprint's: made by fpcalc when it's installed, else from the PCM
+// ffmpeg decodes, and they can be looked up on AcoustID to name the
+// downloads that came without metadata.
+package fingerprint
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// MaxLength is how much audio is fingerprinted, fpcalc's default. AcoustID
+// only needs the start of a song.
+const MaxLength = 120
+
+var ErrTooShort = errors.New("not enough audio to fingerprint")
+
+// Fingerprint is the compressed fingerprint of a file and its duration
+type Fingerprint struct {
+	Value    string `json:"fingerprint"`
+	Duration int    `json:"duration"` // seconds
+}
+
+// Hash is what exact duplicates share
+func (fp *Fingerprint) Hash() string {
+	sum := sha256.Sum256([]byte(fp.Value))
+	return hex.EncodeToString(sum[:])
+}
+
+// Compute fingerprints the audio file at path
+func Compute(ctx context.Context, path string) (*Fingerprint, error) {
+	if _, err := exec.LookPath("fpcalc"); err == nil {
+		return fpcalc(ctx, path)
+	}
+	return decodeAndCalculate(ctx, path)
+}
+
+func fpcalc(ctx context.Context, path string) (*Fingerprint, error) {
+	output, err := exec.CommandContext(ctx, "fpcalc", "-json", "-length", strconv.Itoa(MaxLength), path).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, fmt.Errorf("fpcalc %s: %s: %w", path, strings.TrimSpace(string(exitErr.Stderr)), err)
+		}
+		return nil, err
+	}
+
+	var res struct {
+		Duration    float64 `json:"duration"`
+		Fingerprint string  `json:"fingerprint"`
+	}
+	if err := json.Unmarshal(output, &res); err != nil {
+		return nil, fmt.Errorf("fpcalc %s: %w", path, err)
+	}
+	if res.Fingerprint == "" {
+		return nil, ErrTooShort
+	}
+	return &Fingerprint{Value: res.Fingerprint, Duration: int(res.Duration)}, nil
+}
+
+// decodeAndCalculate has ffmpeg decode the start of the file to 11025 Hz
+// mono and fingerprints it here
+func decodeAndCalculate(ctx context.Context, path string) (*Fingerprint, error) {
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-v", "error", "-nostdin", "-i", path,
+		"-t", strconv.Itoa(MaxLength), "-ac", "1", "-ar", strconv.Itoa(SampleRate), "-f", "s16le", "-")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg %s: %s: %w", path, strings.TrimSpace(stderr.String()), err)
+	}
+
+	samples := make([]int16, len(output)/2)
+	if err := binary.Read(bytes.NewReader(output[:len(samples)*2]), binary.LittleEndian, samples); err != nil {
+		return nil, err
+	}
+	fp := Calculate(samples)
+	if len(fp) == 0 {
+		return nil, ErrTooShort
+	}
+
+	// fpcalc reports the whole file's duration, not the part fingerprinted
+	duration := float64(len(samples)) / SampleRate
+	if full, err := probeDuration(ctx, path); err == nil {
+		duration = full
+	}
+	return &Fingerprint{Value: Encode(fp), Duration: int(duration)}, nil
+}
+
+func probeDuration(ctx context.Context, path string) (float64, error) {
+	output, err := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1", path).Output()
+	if err != nil {
+		return 0, err
+	}
+	d, err := strconv.ParseFloat(strings.TrimSpace(string(output)), 64)
+	if err != nil || math.IsNaN(d) {
+		return 0, fmt.Errorf("no duration for %s", path)
+	}
+	return d, nil
+}
+
+// Available tells if fpcalc or ffmpeg is installed, one of them is needed
+func Available() bool {
+	for _, name := range []string{"fpcalc", "ffmpeg"} {
+		if _, err := exec.LookPath(name); err == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+package httpd
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"cryogon/rizumu-backend/store"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func (s *Server) getArtists() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		artists, err := s.Store.GetArtists(r.Context())
+		if err != nil {
+			log.Printf("Failed to fetch artists. err: %v", err)
+			http.Error(w, "Failed to fetch artists", 500)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, artists)
+	}
+}
+
+func (s *Server) getArtist() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		artistID, err := strconv.ParseInt(chi.URLParam(r, "artistID"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid artist ID", 400)
+			return
+		}
+
+		artist, err := s.Store.GetArtist(r.Context(), artistID)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Artist not found", 404)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to fetch artist. err: %v", err)
+			http.Error(w, "Failed to fetch artist", 500)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, artist)
+	}
+}
+
+func (s *Server) getSongsByArtist() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		artistID, err := strconv.ParseInt(chi.URLParam(r, "artistID"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid artist ID", 400)
+			return
+		}
+
+		songs, err := s.Store.GetSongsByArtist(r.Context(), artistID)
+		if err != nil {
+			log.Printf("Failed to fetch songs. err: %v", err)
+			http.Error(w, "Failed to fetch songs", 500)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, toAPISongs(songs))
+	}
+}
+
+func (s *Server) getAlbumsByArtist() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		artistID, err := strconv.ParseInt(chi.URLParam(r, "artistID"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid artist ID", 400)
+			return
+		}
+
+		albums, err := s.Store.GetAlbumsByArtist(r.Context(), artistID)
+		if err != nil {
+			log.Printf("Failed to fetch albums. err: %v", err)
+			http.Error(w, "Failed to fetch albums", 500)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, albums)
+	}
+}
+
+func (s *Server) getAlbums() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		albums, err := s.Store.GetAlbums(r.Context())
+		if err != nil {
+			log.Printf("Failed to fetch albums. err: %v", err)
+			http.Error(w, "Failed to fetch albums", 500)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, albums)
+	}
+}
+
+func (s *Server) getAlbum() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		albumID, err := strconv.ParseInt(chi.URLParam(r, "albumID"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid album ID", 400)
+			return
+		}
+
+		album, err := s.Store.GetAlbum(r.Context(), albumID)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Album not found", 404)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to fetch album. err: %v", err)
+			http.Error(w, "Failed to fetch album", 500)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, album)
+	}
+}
+
+func (s *Server) getSongsByAlbum() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		albumID, err := strconv.ParseInt(chi.URLParam(r, "albumID"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid album ID", 400)
+			return
+		}
+
+		songs, err := s.Store.GetSongsByAlbum(r.Context(), albumID)
+		if err != nil {
+			log.Printf("Failed to fetch songs. err: %v", err)
+			http.Error(w, "Failed to fetch songs", 500)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, toAPISongs(songs))
+	}
+}
+
+func (s *Server) getSongArtists() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		songID, err := strconv.ParseInt(chi.URLParam(r, "songID"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid song ID", 400)
+			return
+		}
+
+		credits, err := s.Store.GetSongArtists(r.Context(), songID)
+		if err != nil {
+			log.Printf("Failed to fetch song artists. err: %v", err)
+			http.Error(w, "Failed to fetch song artists", 500)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, credits)
+	}
+}
+
+func toAPISongs(songs []*store.Song) []ApiSong {
+	apiSongs := make([]ApiSong, 0, len(songs))
+	for _, song := range songs {
+		apiSongs = append(apiSongs, toAPISong(song))
+	}
+	return apiSongs
+}
@@ -79,8 +79,10 @@ func (s *Server) handleSpotifyCallback() http.HandlerFunc {
 			http.Error(w, "Failed to save to DB", 500)
 			return
 		}
+		// drop the cached token so the new login is picked up
+		s.Tokens.Invalidate(userID, "spotify")
 
-		log.Printf("Successfully authenticated! Token: %s", token.AccessToken)
+		log.Printf("Successfully authenticated Spotify user %s", spotifyUser.ID)
 		w.Write([]byte("Success! You are authenticated. You can close this window."))
 	}
 }
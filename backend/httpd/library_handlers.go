@@ -0,0 +1,215 @@
+package httpd
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cryogon/rizumu-backend/library"
+)
+
+// handleLibraryScan : POST /library/scan, rescans the library folders in the
+// background. Progress is on GET /library/scan.
+func (s *Server) handleLibraryScan() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(s.Library.Dirs()) == 0 {
+			http.Error(w, "No library folders configured, set LIBRARY_DIRS", 400)
+			return
+		}
+
+		if !s.Library.Trigger() {
+			respondWithJSON(w, http.StatusConflict, s.Library.Status())
+			return
+		}
+
+		respondWithJSON(w, http.StatusAccepted, s.Library.Status())
+	}
+}
+
+func (s *Server) handleLibraryScanStatus() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		respondWithJSON(w, http.StatusOK, s.Library.Status())
+	}
+}
+
+// handleOsuImport : POST /library/osu {"path": "..."}, imports the beatmaps
+// and collections of an osu! install (or its Songs folder). The files stay
+// where they are.
+func (s *Server) handleOsuImport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Path string `json:"path"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Path == "" {
+			http.Error(w, "path is required", http.StatusBadRequest)
+			return
+		}
+
+		res, err := library.ImportOsu(r.Context(), s.Store, req.Path)
+		if err != nil {
+			log.Printf("ERROR: osu! import of %s: %v", req.Path, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, res)
+	}
+}
+
+// handleReorganize : POST /library/reorganize, moves the downloaded files to
+// where the library root and naming template want them, in the background.
+// Progress is on GET /library/reorganize.
+func (s *Server) handleReorganize() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.Reorganizer.Trigger() {
+			respondWithJSON(w, http.StatusConflict, s.Reorganizer.Status())
+			return
+		}
+		respondWithJSON(w, http.StatusAccepted, s.Reorganizer.Status())
+	}
+}
+
+func (s *Server) handleReorganizeStatus() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		respondWithJSON(w, http.StatusOK, s.Reorganizer.Status())
+	}
+}
+
+// handleReprobe : POST /library/reprobe?all=true, probes the song files
+// missing their size, bitrate, format or duration (all of them with
+// all=true) in the background. Progress is on GET /library/reprobe.
+func (s *Server) handleReprobe() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		all := r.URL.Query().Get("all") == "true"
+		if !s.Reprober.Trigger(all) {
+			respondWithJSON(w, http.StatusConflict, s.Reprober.Status())
+			return
+		}
+		respondWithJSON(w, http.StatusAccepted, s.Reprober.Status())
+	}
+}
+
+func (s *Server) handleReprobeStatus() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		respondWithJSON(w, http.StatusOK, s.Reprober.Status())
+	}
+}
+
+// handleCacheUsage : GET /library/cache, the space the downloaded songs
+// take against CACHE_BUDGET_GB
+func (s *Server) handleCacheUsage() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		usage, err := s.Downloader.CacheUsage(r.Context())
+		if err != nil {
+			log.Printf("Failed to compute cache usage. err: %v", err)
+			http.Error(w, "Failed to compute cache usage", 500)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, usage)
+	}
+}
+
+// handleFsck : POST /library/fsck?repair=true, checks the library against
+// the disk in the background: missing, empty and undecodable files, files
+// no song points at, stuck statuses. repair=true fixes them too. The
+// findings are on GET /library/fsck.
+func (s *Server) handleFsck() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		repair := r.URL.Query().Get("repair") == "true"
+		if !s.Fsck.Trigger(repair) {
+			respondWithJSON(w, http.StatusConflict, s.Fsck.Status())
+			return
+		}
+		respondWithJSON(w, http.StatusAccepted, s.Fsck.Status())
+	}
+}
+
+func (s *Server) handleFsckStatus() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		respondWithJSON(w, http.StatusOK, s.Fsck.Status())
+	}
+}
+
+// handleFindDuplicates : GET /library/duplicates?tolerance=3, the songs
+// that look like one track across providers: same normalized title and
+// artist, durations within tolerance seconds. Each group suggests the song
+// to keep.
+func (s *Server) handleFindDuplicates() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tolerance := library.DefaultDurationTolerance
+		if v := r.URL.Query().Get("tolerance"); v != "" {
+			secs, err := strconv.ParseFloat(v, 64)
+			if err != nil || secs < 0 {
+				http.Error(w, "Invalid tolerance", 400)
+				return
+			}
+			tolerance = time.Duration(secs * float64(time.Second))
+		}
+
+		groups, err := s.Deduper.Find(r.Context(), tolerance)
+		if err != nil {
+			log.Printf("Failed to find duplicates. err: %v", err)
+			http.Error(w, "Failed to find duplicates", 500)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, groups)
+	}
+}
+
+// handleMergeDuplicates : POST /library/duplicates/merge {"keep": 1,
+// "merge": [2, 3]}, folds the songs of merge into keep: playlists, plays
+// and tags move over, their downloaded files are deleted
+func (s *Server) handleMergeDuplicates() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Keep  int64   `json:"keep"`
+			Merge []int64 `json:"merge"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Keep == 0 || len(req.Merge) == 0 {
+			http.Error(w, "keep and merge are required", http.StatusBadRequest)
+			return
+		}
+
+		res, err := s.Deduper.Merge(r.Context(), req.Keep, req.Merge)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			http.Error(w, "Song not found", 404)
+		case errors.Is(err, library.ErrMergeLocal), errors.Is(err, library.ErrMergeActive):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case err != nil:
+			log.Printf("Failed to merge songs. err: %v", err)
+			http.Error(w, "Failed to merge songs", 500)
+		default:
+			respondWithJSON(w, http.StatusOK, res)
+		}
+	}
+}
+
+// handleFingerprint : POST /library/fingerprint?all=true, fingerprints the
+// song files without a fingerprint (all of them with all=true) in the
+// background, and looks up the songs still waiting for metadata when
+// ACOUSTID_API_KEY is set. Progress is on GET /library/fingerprint.
+func (s *Server) handleFingerprint() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.Downloader.Fingerprinting() {
+			http.Error(w, "Neither fpcalc nor ffmpeg is installed", http.StatusServiceUnavailable)
+			return
+		}
+
+		all := r.URL.Query().Get("all") == "true"
+		if !s.Fingerprinter.Trigger(all) {
+			respondWithJSON(w, http.StatusConflict, s.Fingerprinter.Status())
+			return
+		}
+		respondWithJSON(w, http.StatusAccepted, s.Fingerprinter.Status())
+	}
+}
+
+func (s *Server) handleFingerprintStatus() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		respondWithJSON(w, http.StatusOK, s.Fingerprinter.Status())
+	}
+}
@@ -1,9 +1,14 @@
 package httpd
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
 )
 
 func (s *Server) getPlaylists() http.HandlerFunc {
@@ -22,3 +27,36 @@ func (s *Server) getPlaylists() http.HandlerFunc {
 		}
 	}
 }
+
+// pinPlaylist : POST /playlists/{playlistID}/pin keeps a playlist downloaded
+// for offline use: its songs are queued now, the ones a sync adds later
+// after each sync, and the cache budget never evicts them.
+// POST /playlists/{playlistID}/unpin lets them go.
+func (s *Server) pinPlaylist(pinned bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		playlistID, err := strconv.ParseInt(chi.URLParam(r, "playlistID"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid playlist ID", 400)
+			return
+		}
+
+		err = s.Store.SetPlaylistPinned(r.Context(), playlistID, pinned)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Playlist not found", 404)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to pin playlist. err: %v", err)
+			http.Error(w, "Failed to pin playlist", 500)
+			return
+		}
+
+		queued := 0
+		if pinned {
+			if queued, err = s.Downloader.DownloadPinned(r.Context(), playlistID); err != nil {
+				log.Printf("Failed to queue pinned playlist. err: %v", err)
+			}
+		}
+		respondWithJSON(w, http.StatusOK, map[string]any{"pinned": pinned, "queued": queued})
+	}
+}
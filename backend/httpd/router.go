@@ -7,25 +7,51 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 
+	"cryogon/rizumu-backend/covers"
 	"cryogon/rizumu-backend/downloader"
+	"cryogon/rizumu-backend/library"
 	"cryogon/rizumu-backend/player"
 	"cryogon/rizumu-backend/spotify"
 	"cryogon/rizumu-backend/store"
+	"cryogon/rizumu-backend/tokens"
 )
 
 type Server struct {
 	Downloader *downloader.Service
-	Spotify    *spotify.Client
-	Store      *store.Store
-	player     *player.Player
+	Covers     *covers.Cache
+	Library    *library.Scanner
+	// Reorganizer moves the downloaded files when the layout changes
+	Reorganizer *library.Reorganizer
+	// Reprober fills the file info of songs downloaded without it
+	Reprober *library.Reprober
+	// Fsck finds (and fixes) songs and files that disagree
+	Fsck *library.Fsck
+	// Deduper finds and merges the songs that exist more than once
+	Deduper *library.Deduper
+	// Fingerprinter fingerprints the songs downloaded before it was there
+	Fingerprinter *library.Fingerprinter
+	Spotify       *spotify.Client
+	Syncer        *spotify.Syncer
+	Tokens        *tokens.Manager
+	Store         *store.Store
+	player        *player.Player
 }
 
-func NewRouter(dlSvc *downloader.Service, spotifyClient *spotify.Client, db *store.Store, player *player.Player) http.Handler {
+func NewRouter(dlSvc *downloader.Service, scanner *library.Scanner, spotifyClient *spotify.Client, syncer *spotify.Syncer, tokenMgr *tokens.Manager, db *store.Store, player *player.Player) http.Handler {
 	srv := &Server{
-		Downloader: dlSvc,
-		Spotify:    spotifyClient,
-		Store:      db,
-		player:     player,
+		Downloader:    dlSvc,
+		Covers:        dlSvc.Covers(),
+		Library:       scanner,
+		Reorganizer:   library.NewReorganizer(db, dlSvc.Layout()),
+		Reprober:      library.NewReprober(db),
+		Fsck:          library.NewFsck(db, dlSvc.Layout()),
+		Deduper:       library.NewDeduper(db, dlSvc.Layout()),
+		Fingerprinter: library.NewFingerprinter(db, dlSvc),
+		Spotify:       spotifyClient,
+		Syncer:        syncer,
+		Tokens:        tokenMgr,
+		Store:         db,
+		player:        player,
 	}
 
 	r := chi.NewRouter()
@@ -34,21 +60,59 @@ func NewRouter(dlSvc *downloader.Service, spotifyClient *spotify.Client, db *sto
 
 	// Task Routes (from task_handlers.go)
 	r.Post("/download", srv.handleCreateDownload())
+	r.Get("/tasks", srv.handleListTasks())
+	r.Get("/tasks/events", srv.handleTaskEvents())
 	r.Get("/tasks/{taskID}", srv.handleGetTaskStatus())
+	r.Post("/tasks/{taskID}/cancel", srv.handleCancelTask())
+	r.Post("/tasks/{taskID}/retry", srv.handleRetryTask())
+	r.Post("/tasks/pause", srv.handlePauseQueue())
+	r.Post("/tasks/resume", srv.handleResumeQueue())
 	r.Get("/stream/{songID}", srv.handleStreamSong())
 
 	// Auth Routes (from auth_handlers.go)
 	r.Get("/auth/spotify/login", srv.handleSpotifyLogin())
 	r.Get("/auth/spotify/callback", srv.handleSpotifyCallback())
 	r.Post("/me/sync", srv.handleSyncSpotify())
+	r.Get("/me/sync/status", srv.handleSyncStatus())
+
+	// Local library (from library_handlers.go)
+	r.Post("/library/scan", srv.handleLibraryScan())
+	r.Get("/library/scan", srv.handleLibraryScanStatus())
+	r.Post("/library/osu", srv.handleOsuImport())
+	r.Post("/library/reorganize", srv.handleReorganize())
+	r.Get("/library/reorganize", srv.handleReorganizeStatus())
+	r.Post("/library/reprobe", srv.handleReprobe())
+	r.Get("/library/reprobe", srv.handleReprobeStatus())
+	r.Get("/library/cache", srv.handleCacheUsage())
+	r.Post("/library/fsck", srv.handleFsck())
+	r.Get("/library/fsck", srv.handleFsckStatus())
+	r.Get("/library/duplicates", srv.handleFindDuplicates())
+	r.Post("/library/duplicates/merge", srv.handleMergeDuplicates())
+	r.Post("/library/fingerprint", srv.handleFingerprint())
+	r.Get("/library/fingerprint", srv.handleFingerprintStatus())
 
 	// Playlists
 	r.Get("/playlists", srv.getPlaylists())
+	r.Post("/playlists/{playlistID}/pin", srv.pinPlaylist(true))
+	r.Post("/playlists/{playlistID}/unpin", srv.pinPlaylist(false))
 
 	// Songs
 	r.Get("/songs", srv.getSongs())
 	r.Get("/songs/playlist/{playlistID}", srv.getSongsByPlaylist())
 	r.Delete("/songs/{songID}", srv.deleteSong())
+	r.Get("/songs/{songID}/artists", srv.getSongArtists())
+	r.Get("/songs/{songID}/beatmaps", srv.getSongBeatmaps())
+	r.Get("/songs/{songID}/cover", srv.getSongCover())
+	r.Get("/songs/{songID}/tags", srv.getSongTags())
+
+	// Artists & Albums (from artist_handlers.go)
+	r.Get("/artists", srv.getArtists())
+	r.Get("/artists/{artistID}", srv.getArtist())
+	r.Get("/artists/{artistID}/songs", srv.getSongsByArtist())
+	r.Get("/artists/{artistID}/albums", srv.getAlbumsByArtist())
+	r.Get("/albums", srv.getAlbums())
+	r.Get("/albums/{albumID}", srv.getAlbum())
+	r.Get("/albums/{albumID}/songs", srv.getSongsByAlbum())
 
 	// Song Playback
 	r.Route("/play/{songID}", func(r chi.Router) {
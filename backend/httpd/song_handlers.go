@@ -3,14 +3,18 @@ package httpd
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
+	"cryogon/rizumu-backend/covers"
+	"cryogon/rizumu-backend/player"
 	"cryogon/rizumu-backend/store"
 
 	"github.com/go-chi/chi/v5"
@@ -22,6 +26,7 @@ type ApiSong struct {
 	Artist   string `json:"artist"`
 	Album    string `json:"album"`
 	ImageURL string `json:"image_url"`
+	CoverURL string `json:"cover_url,omitempty"` // cached artwork, see getSongCover
 	Lyrics   string `json:"lyrics,omitempty"`
 	Duration string `json:"duration"` // duration
 
@@ -30,6 +35,8 @@ type ApiSong struct {
 	Energy  float64 `json:"energy"`
 	Valence float64 `json:"valence"`
 
+	PreviewMs *int64 `json:"preview_ms,omitempty"` // preview_ms
+
 	// Usage Stats
 	PlayCount    int64      `json:"play_count"`     // play_count
 	LastPlayedAt *time.Time `json:"last_played_at"` // last_played_at
@@ -51,7 +58,8 @@ type ApiSong struct {
 	CreatedAt time.Time `json:"created_at"`
 
 	// State
-	Status string `json:"status"`
+	Status            string `json:"status"`
+	UnavailableReason string `json:"unavailable_reason,omitempty"`
 }
 
 func (s *Server) getSongs() http.HandlerFunc {
@@ -146,13 +154,15 @@ func (s *Server) deleteSong() http.HandlerFunc {
 			return
 		}
 
-		if song.FilePath != "" {
+		// files imported from elsewhere (library folders, osu!) are left alone
+		layout := s.Downloader.Layout()
+		if song.FilePath != "" && layout.Contains(song.FilePath) {
 			if err := os.Remove(song.FilePath); err != nil && !os.IsNotExist(err) {
 				log.Printf("WARN: Failed to delete audio file %s: %v", song.FilePath, err)
 			}
 		}
 
-		if song.ImageURL != "" && !strings.HasPrefix(song.ImageURL, "http") {
+		if song.ImageURL != "" && !strings.HasPrefix(song.ImageURL, "http") && layout.Contains(song.ImageURL) {
 			if err := os.Remove(song.ImageURL); err != nil && !os.IsNotExist(err) {
 				log.Printf("WARN: Failed to delete cover image %s: %v", song.ImageURL, err)
 			}
@@ -169,6 +179,110 @@ func (s *Server) deleteSong() http.HandlerFunc {
 	}
 }
 
+// getSongBeatmaps : the osu! difficulties of a song, empty for other sources
+func (s *Server) getSongBeatmaps() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		songID, err := strconv.ParseInt(chi.URLParam(r, "songID"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid song ID", 400)
+			return
+		}
+
+		beatmaps, err := s.Store.GetOsuBeatmaps(r.Context(), songID)
+		if err != nil {
+			log.Printf("Failed to fetch beatmaps. err: %v", err)
+			http.Error(w, "Failed to fetch beatmaps", 500)
+			return
+		}
+		if beatmaps == nil {
+			beatmaps = []store.OsuBeatmap{}
+		}
+		respondWithJSON(w, http.StatusOK, beatmaps)
+	}
+}
+
+// getSongCover : GET /songs/{songID}/cover?size=300. size rounds up to a
+// thumbnail size (64, 300, 640), without it the original is served.
+func (s *Server) getSongCover() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		songID, err := strconv.ParseInt(chi.URLParam(r, "songID"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid song ID", 400)
+			return
+		}
+		size := 0
+		if v := r.URL.Query().Get("size"); v != "" {
+			if size, err = strconv.Atoi(v); err != nil || size < 0 {
+				http.Error(w, "Invalid size", 400)
+				return
+			}
+		}
+
+		song, err := s.Store.GetSong(r.Context(), songID)
+		if err != nil {
+			http.Error(w, "Song not found", 404)
+			return
+		}
+
+		cover, err := s.Covers.Cover(r.Context(), song)
+		if errors.Is(err, covers.ErrNoCover) {
+			http.Error(w, "Song has no cover", 404)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to fetch cover. err: %v", err)
+			http.Error(w, "Failed to fetch cover", 502)
+			return
+		}
+
+		path, mime, err := s.Covers.File(cover, size)
+		if err != nil {
+			log.Printf("Failed to make thumbnail. err: %v", err)
+			http.Error(w, "Failed to make thumbnail", 500)
+			return
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			http.Error(w, "Failed to read cover", 500)
+			return
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			http.Error(w, "Failed to read cover", 500)
+			return
+		}
+
+		// the file name is the hash and the size, it never changes content
+		name := filepath.Base(path)
+		w.Header().Set("Content-Type", mime)
+		w.Header().Set("ETag", `"`+strings.TrimSuffix(name, filepath.Ext(name))+`"`)
+		w.Header().Set("Cache-Control", "public, max-age=604800")
+		http.ServeContent(w, r, name, info.ModTime(), f)
+	}
+}
+
+func (s *Server) getSongTags() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		songID, err := strconv.ParseInt(chi.URLParam(r, "songID"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid song ID", 400)
+			return
+		}
+
+		tags, err := s.Store.GetSongTags(r.Context(), songID)
+		if err != nil {
+			log.Printf("Failed to fetch tags. err: %v", err)
+			http.Error(w, "Failed to fetch tags", 500)
+			return
+		}
+		if tags == nil {
+			tags = []store.Tag{}
+		}
+		respondWithJSON(w, http.StatusOK, tags)
+	}
+}
+
 func (s *Server) playSong() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		songID, err := strconv.ParseInt(chi.URLParam(r, "songID"), 10, 64)
@@ -185,6 +299,8 @@ func (s *Server) playSong() http.HandlerFunc {
 
 		// clear current playing song first
 		s.player.Close()
+		// ?mode=preview plays a short part of each song
+		s.player.SetMode(player.PlayMode(r.URL.Query().Get("mode")))
 
 		if playlistIDStr != "" {
 			playlistID, err := strconv.ParseInt(playlistIDStr, 10, 64)
@@ -264,28 +380,35 @@ func formatDuration(ms int64) string {
 func toAPISong(s *store.Song) ApiSong {
 	// Custom value formatting logic
 	durationStr := formatDuration(s.DurationMs)
+	coverURL := ""
+	if s.ImageURL != "" {
+		coverURL = fmt.Sprintf("/songs/%d/cover", s.ID)
+	}
 
 	return ApiSong{
-		ID:           s.ID,
-		Title:        s.Title,
-		Artist:       s.Artist,
-		Album:        s.Album,
-		ImageURL:     s.ImageURL,
-		Lyrics:       s.Lyrics,
-		Duration:     durationStr,
-		BPM:          s.BPM,
-		Energy:       s.Energy,
-		Valence:      s.Valence,
-		PlayCount:    s.PlayCount,
-		LastPlayedAt: s.LastPlayedAt,
-		IsFavorite:   s.IsFavorite,
-		Provider:     s.Provider,
-		ProviderID:   s.ProviderID,
-		FilePath:     s.FilePath,
-		FileSize:     s.FileSize,
-		Bitrate:      s.Bitrate,
-		Format:       s.Format,
-		CreatedAt:    s.CreatedAt,
-		Status:       s.Status,
+		ID:                s.ID,
+		Title:             s.Title,
+		Artist:            s.Artist,
+		Album:             s.Album,
+		ImageURL:          s.ImageURL,
+		CoverURL:          coverURL,
+		Lyrics:            s.Lyrics,
+		Duration:          durationStr,
+		BPM:               s.BPM,
+		Energy:            s.Energy,
+		Valence:           s.Valence,
+		PreviewMs:         s.PreviewMs,
+		PlayCount:         s.PlayCount,
+		LastPlayedAt:      s.LastPlayedAt,
+		IsFavorite:        s.IsFavorite,
+		Provider:          s.Provider,
+		ProviderID:        s.ProviderID,
+		FilePath:          s.FilePath,
+		FileSize:          s.FileSize,
+		Bitrate:           s.Bitrate,
+		Format:            s.Format,
+		CreatedAt:         s.CreatedAt,
+		Status:            s.Status,
+		UnavailableReason: s.UnavailableReason,
 	}
 }
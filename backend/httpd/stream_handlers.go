@@ -5,9 +5,9 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 
 	"cryogon/rizumu-backend/downloader"
-	"cryogon/rizumu-backend/utils"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -41,6 +41,13 @@ func (s *Server) handleStreamSong() http.HandlerFunc {
 
 		if fileReady {
 			log.Printf("Streaming Song: %s", song.Title)
+			// players fetch the rest of the file with more Range requests,
+			// only the first one is a play
+			if rng := r.Header.Get("Range"); r.Method == http.MethodGet && (rng == "" || strings.HasPrefix(rng, "bytes=0-")) {
+				if err := s.Store.MarkSongPlayed(r.Context(), songID); err != nil {
+					log.Printf("WARN: Failed to record play of song %d: %v", songID, err)
+				}
+			}
 			http.ServeFile(w, r, song.FilePath)
 			return
 		}
@@ -51,13 +58,14 @@ func (s *Server) handleStreamSong() http.HandlerFunc {
 			return
 		}
 
+		// never downloaded, or evicted by the cache quota ('Remote')
 		log.Printf("Song %d not found on disk. Trigerring new download.", songID)
 		payload := downloader.DownloadPayload{
 			Mode: "download",
 			URL:  "",
 		}
 
-		sourceURL, err := utils.GetSourceURL(song.Provider, song.ProviderID)
+		sourceURL, err := s.Downloader.SourceURL(song.Provider, song.ProviderID)
 		if err != nil {
 			http.Error(w, err.Error(), 400)
 			return
@@ -2,13 +2,16 @@ package httpd
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"cryogon/rizumu-backend/downloader"
+	"cryogon/rizumu-backend/spotify"
 	"cryogon/rizumu-backend/store"
 
 	"github.com/go-chi/chi/v5"
@@ -22,14 +25,22 @@ func (s *Server) handleCreateDownload() http.HandlerFunc {
 			http.Error(w, "bad request", http.StatusBadRequest)
 			return
 		}
+		if req.Quality != "" {
+			quality, err := downloader.ParseQuality(string(req.Quality))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			req.Quality = quality
+		}
 
 		// download each song the playlist as separate task
 		if strings.Contains(req.URL, "/playlist/") || strings.Contains(req.URL, "/album/") {
-			s.processPlaylistDownload(w, r, req.URL)
+			s.processPlaylistDownload(w, r, req)
 			return
 		}
 
-		source, err := s.Downloader.GetSource(req)
+		source, providerID, err := s.Downloader.Identify(req.URL)
 		if err != nil {
 			log.Printf("ERROR: bad source found")
 			http.Error(w, err.Error(), 400)
@@ -37,10 +48,10 @@ func (s *Server) handleCreateDownload() http.HandlerFunc {
 		}
 
 		newSong := &store.Song{
-			Title:      "Unknown (Pending)",
+			Title:      store.PendingTitle,
 			Artist:     "Unknown",
-			Provider:   source.String(),
-			ProviderID: req.URL,
+			Provider:   source.Name(),
+			ProviderID: providerID,
 			Status:     "Pending",
 		}
 
@@ -51,36 +62,169 @@ func (s *Server) handleCreateDownload() http.HandlerFunc {
 		}
 
 		task, err := s.Downloader.CreateDownload(req, dbID)
+		if errors.Is(err, downloader.ErrQualityConflict) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
 		if err != nil {
 			http.Error(w, "internal server error", http.StatusInternalServerError)
 			return
 		}
 
-		respondWithJSON(w, http.StatusAccepted, task) // 202 Accepted is perfect
+		respondWithJSON(w, http.StatusAccepted, task.Snapshot()) // 202 Accepted is perfect
 	}
 }
 
 func (s *Server) handleGetTaskStatus() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id, _ := strconv.ParseInt(chi.URLParam(r, "taskID"), 10, 64)
-		song, err := s.Store.GetSong(r.Context(), id)
+		task, err := s.Store.GetDownloadTask(r.Context(), id)
 		if err != nil {
 			http.Error(w, "Not found", 404)
 			return
 		}
-		w.Header().Set("Content-Type", "application/json")
-		respondWithJSON(w, 200, song)
+		respondWithJSON(w, 200, task)
 	}
 }
 
-func (s *Server) processPlaylistDownload(w http.ResponseWriter, r *http.Request, url string) {
-	conn, _ := s.Store.GetSpotifyConnection(r.Context(), 1)
-	if conn == nil {
+// handleListTasks : GET /tasks?status=&song_id=&source=&limit=&offset=
+func (s *Server) handleListTasks() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		filter := store.TaskFilter{
+			Status: q.Get("status"),
+			Source: q.Get("source"),
+		}
+		filter.SongID, _ = strconv.ParseInt(q.Get("song_id"), 10, 64)
+		filter.Limit, _ = strconv.ParseInt(q.Get("limit"), 10, 64)
+		filter.Offset, _ = strconv.ParseInt(q.Get("offset"), 10, 64)
+
+		tasks, err := s.Store.ListDownloadTasks(r.Context(), filter)
+		if err != nil {
+			log.Printf("ERROR: listing tasks: %v", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		respondWithJSON(w, 200, tasks)
+	}
+}
+
+func (s *Server) handleCancelTask() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.ParseInt(chi.URLParam(r, "taskID"), 10, 64)
+		if err := s.Downloader.CancelTask(r.Context(), id); err != nil {
+			respondWithTaskError(w, err)
+			return
+		}
+		respondWithJSON(w, http.StatusAccepted, map[string]any{"id": id, "cancelled": true})
+	}
+}
+
+func (s *Server) handleRetryTask() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.ParseInt(chi.URLParam(r, "taskID"), 10, 64)
+		task, err := s.Downloader.RetryTask(r.Context(), id)
+		if err != nil {
+			respondWithTaskError(w, err)
+			return
+		}
+		respondWithJSON(w, http.StatusAccepted, task.Snapshot())
+	}
+}
+
+// handlePauseQueue : stops new downloads from starting, running ones finish
+func (s *Server) handlePauseQueue() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.Downloader.PauseQueue()
+		respondWithJSON(w, 200, map[string]bool{"paused": true})
+	}
+}
+
+func (s *Server) handleResumeQueue() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.Downloader.ResumeQueue()
+		respondWithJSON(w, 200, map[string]bool{"paused": false})
+	}
+}
+
+// handleTaskEvents : GET /tasks/events, server-sent events of every task
+// change. The queued and running tasks are sent first as "progress".
+func (s *Server) handleTaskEvents() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming not supported", http.StatusInternalServerError)
+			return
+		}
+
+		events, unsubscribe := s.Downloader.Subscribe()
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+
+		send := func(ev downloader.TaskEvent) bool {
+			data, err := json.Marshal(ev)
+			if err != nil {
+				log.Printf("ERROR: Failed to encode task event: %v", err)
+				return true
+			}
+			_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+			return err == nil
+		}
+
+		for _, ev := range s.Downloader.ActiveTasks() {
+			if !send(ev) {
+				return
+			}
+		}
+		flusher.Flush()
+
+		// keeps proxies from closing an idle stream
+		keepAlive := time.NewTicker(30 * time.Second)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+					return
+				}
+			case ev := <-events:
+				if !send(ev) {
+					return
+				}
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func respondWithTaskError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, downloader.ErrTaskNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, downloader.ErrTaskFinished), errors.Is(err, downloader.ErrTaskNotRetryable):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		log.Printf("ERROR: task operation failed: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (s *Server) processPlaylistDownload(w http.ResponseWriter, r *http.Request, req downloader.DownloadPayload) {
+	ts, err := s.Tokens.TokenSource(r.Context(), 1, "spotify")
+	if err != nil {
+		log.Printf("ERROR: no spotify token: %v", err)
 		http.Error(w, "Login required", http.StatusUnauthorized)
 		return
 	}
 
-	songs, err := s.Spotify.FetchTracksFromURL(r.Context(), conn.ToOAuthToken(), url)
+	songs, err := s.Spotify.FetchTracksFromURL(r.Context(), ts, req.URL)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
@@ -89,7 +233,15 @@ func (s *Server) processPlaylistDownload(w http.ResponseWriter, r *http.Request,
 	count := 0
 	for _, song := range songs {
 		dbID, _ := s.Store.SaveSong(r.Context(), song)
-		payload := downloader.DownloadPayload{Mode: "download", URL: "https://open.spotify.com/track/" + song.ProviderID}
+		if err := spotify.LinkCredits(r.Context(), s.Store, dbID, song.RawMetadata); err != nil {
+			log.Printf("WARN: Failed to link artists of '%s': %v", song.Title, err)
+		}
+		payload := downloader.DownloadPayload{
+			Mode:     "download",
+			URL:      "https://open.spotify.com/track/" + song.ProviderID,
+			Priority: downloader.PriorityBulk,
+			Quality:  req.Quality,
+		}
 		if _, err := s.Downloader.CreateDownload(payload, dbID); err == nil {
 			count++
 		}
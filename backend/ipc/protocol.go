@@ -6,20 +6,30 @@ type CommandType string
 
 // What client can send
 const (
-	CmdPlay      CommandType = "play"
-	CmdPause     CommandType = "pause"
-	CmdStop      CommandType = "stop"
-	CmdDownload  CommandType = "download"
-	CmdNext      CommandType = "next"
-	CmdPrev      CommandType = "prev"
-	CmdSongs     CommandType = "songs" // returns song
-	CmdPlaylists CommandType = "playlists"
+	CmdPlay       CommandType = "play"
+	CmdPause      CommandType = "pause"
+	CmdStop       CommandType = "stop"
+	CmdDownload   CommandType = "download"
+	CmdNext       CommandType = "next"
+	CmdPrev       CommandType = "prev"
+	CmdSongs      CommandType = "songs" // returns song
+	CmdPlaylists  CommandType = "playlists"
+	CmdSync       CommandType = "sync"        // starts a spotify sync
+	CmdSyncStatus CommandType = "sync_status" // returns spotify.SyncStatus
+
+	CmdDownloads       CommandType = "downloads"   // returns the queued and running tasks, "task_event" pushes the changes
+	CmdCancelTask      CommandType = "cancel_task" // task_id
+	CmdRetryTask       CommandType = "retry_task"  // task_id
+	CmdPauseDownloads  CommandType = "pause_downloads"
+	CmdResumeDownloads CommandType = "resume_downloads"
 )
 
 type Command struct {
 	Type       CommandType `json:"type"`
 	SongID     int64       `json:"song_id"`
 	PlaylistID int64       `json:"playlist_id"`
+	TaskID     int64       `json:"task_id"`
+	Mode       string      `json:"mode,omitempty"` // play: "preview" plays a short part of each song
 }
 
 type PlayerState struct {
@@ -7,25 +7,48 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"sync"
 	"time"
 
+	"cryogon/rizumu-backend/downloader"
 	"cryogon/rizumu-backend/player"
+	"cryogon/rizumu-backend/spotify"
 	"cryogon/rizumu-backend/store"
 )
 
 type IPCHandler struct {
-	clients  []net.Conn
-	player   *player.Player
-	commands chan Command
-	store    store.Store
+	clients   []net.Conn
+	clientsMu sync.Mutex
+	player    *player.Player
+	syncer    *spotify.Syncer
+	downloads *downloader.Service
+	commands  chan Command
+	store     *store.Store
 }
 
-func NewIPCHandler(player *player.Player, store store.Store) *IPCHandler {
-	return &IPCHandler{
-		clients:  make([]net.Conn, 0),
-		commands: make(chan Command, 10),
-		player:   player,
-		store:    store,
+func NewIPCHandler(player *player.Player, syncer *spotify.Syncer, downloads *downloader.Service, store *store.Store) *IPCHandler {
+	h := &IPCHandler{
+		clients:   make([]net.Conn, 0),
+		commands:  make(chan Command, 10),
+		player:    player,
+		syncer:    syncer,
+		downloads: downloads,
+		store:     store,
+	}
+
+	// push sync progress to every client as it happens
+	syncer.OnUpdate(func(status spotify.SyncStatus) {
+		h.broadcast(status, "sync_status")
+	})
+	go h.forwardTaskEvents()
+	return h
+}
+
+// forwardTaskEvents pushes download progress to every client
+func (h *IPCHandler) forwardTaskEvents() {
+	events, _ := h.downloads.Subscribe()
+	for ev := range events {
+		h.broadcast(ev, "task_event")
 	}
 }
 
@@ -60,7 +83,10 @@ func (h *IPCHandler) handleClient(conn net.Conn) {
 		h.removeClient(conn)
 	}()
 
+	h.clientsMu.Lock()
 	h.clients = append(h.clients, conn)
+	h.clientsMu.Unlock()
+
 	scanner := bufio.NewScanner(conn)
 	for scanner.Scan() {
 		var cmd Command
@@ -73,6 +99,9 @@ func (h *IPCHandler) handleClient(conn net.Conn) {
 }
 
 func (h *IPCHandler) removeClient(conn net.Conn) {
+	h.clientsMu.Lock()
+	defer h.clientsMu.Unlock()
+
 	for i, c := range h.clients {
 		if c == conn {
 			h.clients = append(h.clients[:i], h.clients[i+1:]...)
@@ -87,6 +116,7 @@ func (h *IPCHandler) handleCommands(cmd Command, conn net.Conn) {
 	switch cmd.Type {
 	case CmdPlay:
 		h.player.Close()
+		h.player.SetMode(player.PlayMode(cmd.Mode))
 		if cmd.PlaylistID > 0 {
 			var filteredSong []store.Song
 			songs, err := h.store.GetSongsByPlaylist(context.Background(), cmd.PlaylistID)
@@ -156,6 +186,42 @@ func (h *IPCHandler) handleCommands(cmd Command, conn net.Conn) {
 		if err != nil {
 			return
 		}
+	case CmdSync:
+		h.syncer.Trigger()
+	case CmdSyncStatus:
+		data, err := NewMessage(h.syncer.Status(), "sync_status")
+		if err != nil {
+			fmt.Printf("[IPC] Failed to parse sync status. %v", err)
+			return
+		}
+		data = append(data, '\n')
+		_, err = conn.Write(data)
+		if err != nil {
+			return
+		}
+	case CmdCancelTask:
+		if err := h.downloads.CancelTask(context.Background(), cmd.TaskID); err != nil {
+			fmt.Printf("[IPC] Failed to cancel task %d. %v", cmd.TaskID, err)
+		}
+	case CmdRetryTask:
+		if _, err := h.downloads.RetryTask(context.Background(), cmd.TaskID); err != nil {
+			fmt.Printf("[IPC] Failed to retry task %d. %v", cmd.TaskID, err)
+		}
+	case CmdDownloads:
+		data, err := NewMessage(h.downloads.ActiveTasks(), "downloads")
+		if err != nil {
+			fmt.Printf("[IPC] Failed to parse downloads. %v", err)
+			return
+		}
+		data = append(data, '\n')
+		_, err = conn.Write(data)
+		if err != nil {
+			return
+		}
+	case CmdPauseDownloads:
+		h.downloads.PauseQueue()
+	case CmdResumeDownloads:
+		h.downloads.ResumeQueue()
 	}
 }
 
@@ -178,19 +244,27 @@ func (h *IPCHandler) broadcastPlayerState() {
 			Duration: int(song.DurationMs / 1000),
 		}
 
-		data, err := NewMessage(msg, "player_state")
+		h.broadcast(msg, "player_state")
+	}
+}
+
+// broadcast sends a message to every connected client
+func (h *IPCHandler) broadcast(payload any, msgType string) {
+	data, err := NewMessage(payload, msgType)
+	if err != nil {
+		fmt.Printf("[IPC] Failed to parse %s. err: %v", msgType, err)
+		return
+	}
+	data = append(data, '\n')
+
+	h.clientsMu.Lock()
+	defer h.clientsMu.Unlock()
+
+	for _, client := range h.clients {
+		_, err := client.Write(data)
 		if err != nil {
-			fmt.Printf("[IPC] Failed to parse player's state. err: %v", err)
+			fmt.Printf("[IPC] Failed to broadcast %s. err: %v", msgType, err)
 			continue
 		}
-
-		data = append(data, '\n')
-		for _, client := range h.clients {
-			_, err := client.Write(data)
-			if err != nil {
-				fmt.Printf("[IPC] Failed to broadcast player's state. err: %v", err)
-				continue
-			}
-		}
 	}
 }
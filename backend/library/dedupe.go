@@ -0,0 +1,367 @@
+package library
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"cryogon/rizumu-backend/downloader"
+	"cryogon/rizumu-backend/store"
+)
+
+// DefaultDurationTolerance is how far apart two songs' durations can be and
+// still be the same recording: the silence trimmed by each service
+const DefaultDurationTolerance = 3 * time.Second
+
+var (
+	ErrMergeLocal  = errors.New("library files can't be merged away, the next scan brings them back")
+	ErrMergeActive = errors.New("song is being downloaded")
+)
+
+// Match of a DuplicateGroup
+const (
+	MatchFingerprint = "fingerprint" // the same audio
+	MatchMetadata    = "metadata"    // same normalized title and artist, close durations
+)
+
+// DuplicateGroup is songs that look like one track. Keep is the suggested
+// canonical song: a library file, else one with a file, else the best
+// metadata.
+type DuplicateGroup struct {
+	Match string        `json:"match"`
+	Keep  int64         `json:"keep"`
+	Songs []*store.Song `json:"songs"`
+}
+
+// MergeResult is what a merge did
+type MergeResult struct {
+	Keep         int64    `json:"keep"`
+	Merged       []int64  `json:"merged"`
+	AdoptedFile  string   `json:"adopted_file,omitempty"`
+	DeletedFiles []string `json:"deleted_files"`
+}
+
+// Deduper finds songs that exist more than once, the same track synced from
+// Spotify, downloaded from YouTube and imported from osu!, and merges them.
+// UNIQUE(provider, provider_id) only catches them within a provider.
+type Deduper struct {
+	db     *store.Store
+	layout downloader.Layout
+}
+
+func NewDeduper(db *store.Store, layout downloader.Layout) *Deduper {
+	return &Deduper{db: db, layout: layout}
+}
+
+// Find groups the songs with the same fingerprint, then the others with the
+// same normalized title and primary artist whose durations are within
+// tolerance. A song without a duration joins the biggest group of its
+// title.
+func (d *Deduper) Find(ctx context.Context, tolerance time.Duration) ([]DuplicateGroup, error) {
+	songs, err := d.db.GetDedupeCandidates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	hashes, err := d.db.GetFingerprintHashes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := []DuplicateGroup{}
+	grouped := make(map[int64]bool)
+	for _, songs := range groupBy(songs, func(s *store.Song) string { return hashes[s.ID] }) {
+		if group, ok := newGroup(MatchFingerprint, songs); ok {
+			groups = append(groups, group)
+			for _, song := range group.Songs {
+				grouped[song.ID] = true
+			}
+		}
+	}
+
+	byMetadata := groupBy(songs, func(s *store.Song) string {
+		if grouped[s.ID] {
+			return ""
+		}
+		return dedupeKey(s)
+	})
+	for _, songs := range byMetadata {
+		for _, cluster := range clusterByDuration(songs, tolerance.Milliseconds()) {
+			if group, ok := newGroup(MatchMetadata, cluster); ok {
+				groups = append(groups, group)
+			}
+		}
+	}
+	return groups, nil
+}
+
+// groupBy splits songs by key, in the order the keys first appear. Songs
+// with an empty key and keys of one song are left out.
+func groupBy(songs []*store.Song, keyOf func(*store.Song) string) [][]*store.Song {
+	byKey := make(map[string][]*store.Song)
+	var keys []string
+	for _, song := range songs {
+		key := keyOf(song)
+		if key == "" {
+			continue
+		}
+		if _, ok := byKey[key]; !ok {
+			keys = append(keys, key)
+		}
+		byKey[key] = append(byKey[key], song)
+	}
+
+	var groups [][]*store.Song
+	for _, key := range keys {
+		if len(byKey[key]) > 1 {
+			groups = append(groups, byKey[key])
+		}
+	}
+	return groups
+}
+
+// newGroup orders songs best first. Only one library file stays in a group:
+// two of them are two files the user has, not a duplicate to merge.
+func newGroup(match string, songs []*store.Song) (DuplicateGroup, bool) {
+	songs = slices.Clone(songs)
+	slices.SortFunc(songs, func(a, b *store.Song) int {
+		if r := canonicalRank(a) - canonicalRank(b); r != 0 {
+			return r
+		}
+		if a.PlayCount != b.PlayCount {
+			return cmp.Compare(b.PlayCount, a.PlayCount)
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	kept := songs[:0]
+	local := false
+	for _, song := range songs {
+		if song.Provider == store.ProviderLocal {
+			if local {
+				continue
+			}
+			local = true
+		}
+		kept = append(kept, song)
+	}
+	if len(kept) < 2 {
+		return DuplicateGroup{}, false
+	}
+	return DuplicateGroup{Match: match, Keep: kept[0].ID, Songs: kept}, true
+}
+
+// canonicalRank is lower for the song a group should keep. Ties go to the
+// most played, then the oldest (newGroup).
+func canonicalRank(song *store.Song) int {
+	rank := 0
+	if song.Title == store.PendingTitle {
+		rank += 8
+	}
+	if song.Provider != store.ProviderLocal {
+		rank += 4
+	}
+	if song.Status != "Downloaded" || song.FilePath == "" {
+		rank += 2
+	}
+	if song.Provider != "spotify" {
+		// Spotify has the cleanest metadata
+		rank++
+	}
+	return rank
+}
+
+// clusterByDuration splits songs into runs of durations within tolerance of
+// the run's shortest. Songs without a duration go to the biggest run.
+func clusterByDuration(songs []*store.Song, tolerance int64) [][]*store.Song {
+	songs = slices.Clone(songs)
+	slices.SortStableFunc(songs, func(a, b *store.Song) int {
+		return cmp.Compare(a.DurationMs, b.DurationMs)
+	})
+
+	var unknown []*store.Song
+	var clusters [][]*store.Song
+	for _, song := range songs {
+		switch {
+		case song.DurationMs <= 0:
+			unknown = append(unknown, song)
+		case len(clusters) > 0 && song.DurationMs-clusters[len(clusters)-1][0].DurationMs <= tolerance:
+			clusters[len(clusters)-1] = append(clusters[len(clusters)-1], song)
+		default:
+			clusters = append(clusters, []*store.Song{song})
+		}
+	}
+
+	if len(unknown) > 0 {
+		biggest := -1
+		for i, c := range clusters {
+			if biggest < 0 || len(c) > len(clusters[biggest]) {
+				biggest = i
+			}
+		}
+		if biggest < 0 {
+			clusters = append(clusters, unknown)
+		} else {
+			clusters[biggest] = append(clusters[biggest], unknown...)
+		}
+	}
+	return clusters
+}
+
+var (
+	// " - Remastered 2011", " - Official Video"
+	noiseSuffixRegex = regexp.MustCompile(`(?i)\s+-\s+[^-]*\b(?:remaster(?:ed)?|official|lyrics?|audio|video|explicit)\b[^-]*$`)
+	bracketRegex     = regexp.MustCompile(`[(\[【「]([^)\]】」]*)[)\]】」]`)
+	// words that make a bracket noise rather than a version ("(Live)" stays)
+	noiseRegex     = regexp.MustCompile(`(?i)\b(?:feat|ft|featuring|official|video|audio|lyrics?|mv|pv|hd|hq|4k|remaster(?:ed)?|explicit|clean|visualizer)\b`)
+	featTailRegex  = regexp.MustCompile(`(?i)\s+(?:feat\.?|ft\.?|featuring)\s+.*$`)
+	artistSepRegex = regexp.MustCompile(`(?i)\s*(?:,|&|\sx\s|×)\s*`)
+)
+
+// dedupeKey is the normalized primary artist and title of song, empty when
+// there's nothing to compare
+func dedupeKey(song *store.Song) string {
+	if song.Title == store.PendingTitle {
+		return ""
+	}
+	artist := normalizeArtist(song.Artist)
+	title := normalizeTitle(song.Title, artist)
+	if title == "" {
+		return ""
+	}
+	return artist + "\x00" + title
+}
+
+// normalizeArtist keeps the first credited artist, without the decorations
+// of YouTube channels
+func normalizeArtist(artist string) string {
+	if credits := downloader.SplitArtists(artist); len(credits) > 0 {
+		artist = credits[0].Name
+	}
+	artist = artistSepRegex.Split(artist, 2)[0]
+	artist = fold(artist)
+	for _, suffix := range []string{" topic", " official", "vevo"} {
+		artist = strings.TrimSpace(strings.TrimSuffix(artist, suffix))
+	}
+	return artist
+}
+
+// normalizeTitle drops what tells uploads apart rather than recordings: an
+// "Artist - " prefix, featured artists, "(Official Video)", "- Remastered".
+// Versions ("(Live)", "(Acoustic Ver.)") stay.
+func normalizeTitle(title, artist string) string {
+	if i := strings.Index(title, " - "); i > 0 && artist != "" && strings.Contains(fold(title[:i]), artist) {
+		title = title[i+3:]
+	}
+	title = noiseSuffixRegex.ReplaceAllString(title, "")
+	title = bracketRegex.ReplaceAllStringFunc(title, func(m string) string {
+		if noiseRegex.MatchString(m) {
+			return " "
+		}
+		return " " + bracketRegex.FindStringSubmatch(m)[1] + " "
+	})
+	title = featTailRegex.ReplaceAllString(title, "")
+	return fold(title)
+}
+
+// fold lowercases s and keeps only its letters and digits, one space apart
+func fold(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return ' '
+	}, s)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// Merge folds dupIDs into keepID (see store.MergeSongs). If keepID has no
+// file, it takes one of theirs; their other files are deleted when they're
+// downloads, inside the layout.
+func (d *Deduper) Merge(ctx context.Context, keepID int64, dupIDs []int64) (*MergeResult, error) {
+	keep, err := d.db.GetSong(ctx, keepID)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.checkActive(ctx, keepID); err != nil {
+		return nil, err
+	}
+
+	var dups []*store.Song
+	for _, id := range dupIDs {
+		if id == keepID || slices.ContainsFunc(dups, func(s *store.Song) bool { return s.ID == id }) {
+			continue
+		}
+		dup, err := d.db.GetSong(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if dup.Provider == store.ProviderLocal {
+			return nil, fmt.Errorf("song %d: %w", id, ErrMergeLocal)
+		}
+		if err := d.checkActive(ctx, id); err != nil {
+			return nil, err
+		}
+		dups = append(dups, dup)
+	}
+
+	res := &MergeResult{Keep: keepID, Merged: []int64{}, DeletedFiles: []string{}}
+	var adoptFrom int64
+	if !hasFile(keep) && keep.Provider != store.ProviderLocal {
+		for _, dup := range dups {
+			if hasFile(dup) {
+				adoptFrom, res.AdoptedFile = dup.ID, dup.FilePath
+				break
+			}
+		}
+	}
+
+	var remove []string
+	for _, dup := range dups {
+		res.Merged = append(res.Merged, dup.ID)
+		if dup.FilePath == "" || dup.ID == adoptFrom || dup.FilePath == keep.FilePath || !d.layout.Contains(dup.FilePath) {
+			continue
+		}
+		remove = append(remove, dup.FilePath)
+	}
+
+	if err := d.db.MergeSongs(ctx, keepID, res.Merged, adoptFrom); err != nil {
+		return nil, err
+	}
+	log.Printf("[Library] Merged songs %v into %d", res.Merged, keepID)
+
+	for _, path := range remove {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("[Library] WARN: Failed to delete %s: %v", path, err)
+			continue
+		}
+		res.DeletedFiles = append(res.DeletedFiles, path)
+	}
+	return res, nil
+}
+
+func (d *Deduper) checkActive(ctx context.Context, songID int64) error {
+	task, err := d.db.GetActiveTaskForSong(ctx, songID)
+	if err != nil {
+		return err
+	}
+	if task != nil {
+		return fmt.Errorf("song %d: %w", songID, ErrMergeActive)
+	}
+	return nil
+}
+
+func hasFile(song *store.Song) bool {
+	if song.Status != "Downloaded" || song.FilePath == "" {
+		return false
+	}
+	_, err := os.Stat(song.FilePath)
+	return err == nil
+}
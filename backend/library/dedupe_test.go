@@ -0,0 +1,141 @@
+package library
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"cryogon/rizumu-backend/downloader"
+	"cryogon/rizumu-backend/store"
+)
+
+func TestMergeSurvivesResync(t *testing.T) {
+	ctx := context.Background()
+	db := newTestStore(t)
+	deduper := NewDeduper(db, downloader.Layout{Root: t.TempDir()})
+
+	spotify := &store.Song{Title: "Under Pressure", Artist: "Queen", Provider: "spotify", ProviderID: "sp1"}
+	youtube := &store.Song{Title: "Queen - Under Pressure (Official Video)", Artist: "Queen Official", Provider: "youtube", ProviderID: "yt1"}
+	keepID, err := db.SaveSong(ctx, spotify)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dupID, err := db.SaveSong(ctx, youtube)
+	if err != nil {
+		t.Fatal(err)
+	}
+	playlistID, err := db.SavePlaylist(ctx, &store.Playlist{UserID: 1, Name: "Mix", SourceType: "youtube", ExternalID: "mix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddSongToPlaylist(ctx, playlistID, dupID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := deduper.Merge(ctx, keepID, []int64{dupID}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the next sync saves the YouTube song again and adds it to its playlist
+	id, err := db.SaveSong(ctx, &store.Song{Title: youtube.Title, Artist: youtube.Artist, Provider: "youtube", ProviderID: "yt1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != keepID {
+		t.Fatalf("saving the merged song again gave song %d, want %d", id, keepID)
+	}
+	if err := db.AddSongToPlaylist(ctx, playlistID, id); err != nil {
+		t.Fatal(err)
+	}
+
+	songs, err := db.GetDedupeCandidates(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(songs) != 1 || songs[0].Title != "Under Pressure" {
+		t.Errorf("songs after the sync: %d, the first %q", len(songs), songs[0].Title)
+	}
+	inPlaylist, err := db.GetSongsByPlaylist(ctx, playlistID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inPlaylist) != 1 || inPlaylist[0].ID != keepID {
+		t.Errorf("playlist holds %d songs, want only %d", len(inPlaylist), keepID)
+	}
+
+	// merging the kept song away in turn moves the alias along
+	otherID, err := db.SaveSong(ctx, &store.Song{Title: "Under Pressure", Artist: "Queen", Provider: "youtube-music", ProviderID: "ytm1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := deduper.Merge(ctx, otherID, []int64{keepID}); err != nil {
+		t.Fatal(err)
+	}
+	for _, song := range []*store.Song{spotify, youtube} {
+		id, err := db.SaveSong(ctx, &store.Song{Title: "x", Artist: "x", Provider: song.Provider, ProviderID: song.ProviderID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != otherID {
+			t.Errorf("%s/%s saved as song %d, want %d", song.Provider, song.ProviderID, id, otherID)
+		}
+	}
+
+	// deleting the song forgets its aliases
+	if err := db.DeleteSong(ctx, otherID); err != nil {
+		t.Fatal(err)
+	}
+	if id, err := db.SaveSong(ctx, &store.Song{Title: "x", Artist: "x", Provider: "spotify", ProviderID: "sp1"}); err != nil || id == otherID {
+		t.Errorf("saved as song %d (%v) after deleting it", id, err)
+	}
+}
+
+func TestMergeSurvivesOsuImport(t *testing.T) {
+	ctx := context.Background()
+	db := newTestStore(t)
+	deduper := NewDeduper(db, downloader.Layout{Root: t.TempDir()})
+
+	songsDir := filepath.Join(t.TempDir(), "Songs")
+	setDir := filepath.Join(songsDir, "100 Queen - Under Pressure")
+	writeTestFile(t, filepath.Join(setDir, "audio.mp3"), "audio")
+	writeOsuDiff(t, setDir, "Under Pressure", "Queen", osuTestDiff{"Hard.osu", "audio.mp3", "Hard", 100, 1001})
+
+	if _, err := ImportOsu(ctx, db, songsDir); err != nil {
+		t.Fatal(err)
+	}
+	songs, err := db.GetDedupeCandidates(ctx)
+	if err != nil || len(songs) != 1 {
+		t.Fatalf("%d songs imported (%v)", len(songs), err)
+	}
+	osuID := songs[0].ID
+
+	keepID, err := db.SaveSong(ctx, &store.Song{Title: "Under Pressure", Artist: "Queen & David Bowie", Provider: "spotify", ProviderID: "sp1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := deduper.Merge(ctx, keepID, []int64{osuID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.AdoptedFile != filepath.Join(setDir, "audio.mp3") {
+		t.Errorf("adopted %q", res.AdoptedFile)
+	}
+
+	if _, err := ImportOsu(ctx, db, songsDir); err != nil {
+		t.Fatal(err)
+	}
+	songs, err = db.GetDedupeCandidates(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(songs) != 1 || songs[0].ID != keepID {
+		t.Fatalf("%d songs after importing again, want only %d", len(songs), keepID)
+	}
+	if songs[0].Provider != "spotify" || songs[0].Artist != "Queen & David Bowie" {
+		t.Errorf("the import overwrote the kept song: %s %q", songs[0].Provider, songs[0].Artist)
+	}
+	beatmaps, err := db.GetOsuBeatmaps(ctx, keepID)
+	if err != nil || len(beatmaps) != 1 {
+		t.Errorf("%d beatmaps on the kept song (%v)", len(beatmaps), err)
+	}
+}
@@ -0,0 +1,108 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+
+	"cryogon/rizumu-backend/downloader"
+	"cryogon/rizumu-backend/store"
+)
+
+var (
+	ErrFingerprintRunning = errors.New("the library is already being fingerprinted")
+	ErrNoFingerprinter    = errors.New("neither fpcalc nor ffmpeg found")
+)
+
+// FingerprintStatus is a snapshot of the last (or current) fingerprint job
+type FingerprintStatus struct {
+	JobStatus
+	All           bool `json:"all"`           // every file again, not only new ones
+	Total         int  `json:"total"`         // songs looked at
+	Fingerprinted int  `json:"fingerprinted"` // songs with a fingerprint now
+	Identified    int  `json:"identified"`    // pending songs named by a lookup
+	Missing       int  `json:"missing"`       // file not on disk anymore
+}
+
+// Fingerprinter fingerprints the songs downloaded or imported before
+// fingerprints were, and looks up the ones still waiting for metadata.
+// New downloads are fingerprinted by the downloader.
+type Fingerprinter struct {
+	db *store.Store
+	dl *downloader.Service
+
+	job
+	status FingerprintStatus
+}
+
+func NewFingerprinter(db *store.Store, dl *downloader.Service) *Fingerprinter {
+	f := &Fingerprinter{db: db, dl: dl}
+	f.job = job{
+		errRunning: ErrFingerprintRunning,
+		start:      "Fingerprinting the library files",
+		work:       f.fingerprint,
+		base:       func() *JobStatus { return &f.status.JobStatus },
+		summary: func() string {
+			return fmt.Sprintf("Fingerprinting finished: %d songs, %d fingerprinted, %d identified, %d missing",
+				f.status.Total, f.status.Fingerprinted, f.status.Identified, f.status.Missing)
+		},
+	}
+	return f
+}
+
+// Status returns a copy of the current state
+func (f *Fingerprinter) Status() FingerprintStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	st := f.status
+	st.Errors = slices.Clone(f.status.Errors)
+	return st
+}
+
+// Trigger starts the job in the background. Returns false if it's running.
+// all fingerprints every file again, otherwise only those without one.
+func (f *Fingerprinter) Trigger(all bool) bool {
+	return f.trigger(func() { f.status = FingerprintStatus{All: all} })
+}
+
+// Run fingerprints and waits for it
+func (f *Fingerprinter) Run(ctx context.Context, all bool) error {
+	return f.runNow(ctx, func() { f.status = FingerprintStatus{All: all} })
+}
+
+func (f *Fingerprinter) fingerprint(ctx context.Context) error {
+	if !f.dl.Fingerprinting() {
+		return ErrNoFingerprinter
+	}
+	all := f.Status().All
+	songs, err := f.db.GetSongsToFingerprint(ctx, all)
+	if err != nil {
+		return fmt.Errorf("failed to load songs: %w", err)
+	}
+	f.count(func() { f.status.Total = len(songs) })
+
+	for _, song := range songs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := os.Stat(song.FilePath); errors.Is(err, os.ErrNotExist) {
+			f.count(func() { f.status.Missing++ })
+			continue
+		}
+
+		match, err := f.dl.Fingerprint(ctx, song, all)
+		if err != nil {
+			f.addError(fmt.Errorf("song %d (%s): %w", song.ID, song.FilePath, err))
+			continue
+		}
+		f.count(func() {
+			f.status.Fingerprinted++
+			if match != nil {
+				f.status.Identified++
+			}
+		})
+	}
+	return nil
+}
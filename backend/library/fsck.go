@@ -0,0 +1,292 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"cryogon/rizumu-backend/downloader"
+	"cryogon/rizumu-backend/store"
+)
+
+var ErrFsckRunning = errors.New("the library is already being checked")
+
+// Kinds of FsckFinding
+const (
+	FindingMissing     = "missing"     // the song's file is gone
+	FindingOrphan      = "orphan"      // a file no song points at
+	FindingEmpty       = "empty"       // a zero-byte song file
+	FindingUndecodable = "undecodable" // ffprobe finds no audio in it
+	FindingStuck       = "stuck"       // a status a crash left behind
+)
+
+// orphanMinAge spares the files a running download is still writing
+const orphanMinAge = time.Hour
+
+// FsckFinding is one problem the check found
+type FsckFinding struct {
+	Kind   string `json:"kind"`
+	SongID int64  `json:"song_id,omitempty"`
+	Path   string `json:"path,omitempty"`
+	Detail string `json:"detail,omitempty"`
+	Fixed  bool   `json:"fixed"`
+}
+
+// FsckStatus is a snapshot of the last (or current) check
+type FsckStatus struct {
+	JobStatus
+	Repair   bool          `json:"repair"`  // fix what's found, not only report it
+	Checked  int           `json:"checked"` // songs with a file looked at
+	Findings []FsckFinding `json:"findings"`
+	Fixed    int           `json:"fixed"`
+}
+
+// Fsck checks the library against the disk: songs whose file is gone or
+// isn't audio, files no song points at (leftovers of crashed downloads)
+// and statuses that don't hold. With repair, broken downloads go back to
+// 'Remote' to be downloaded again, library files to 'Missing', stuck
+// songs to 'Pending', and orphans are deleted.
+type Fsck struct {
+	db     *store.Store
+	layout downloader.Layout
+
+	job
+	status FsckStatus
+}
+
+func NewFsck(db *store.Store, layout downloader.Layout) *Fsck {
+	f := &Fsck{db: db, layout: layout}
+	f.job = job{
+		errRunning: ErrFsckRunning,
+		start:      "Checking the library",
+		work:       f.check,
+		base:       func() *JobStatus { return &f.status.JobStatus },
+		summary: func() string {
+			return fmt.Sprintf("Check finished: %d songs, %d findings, %d fixed",
+				f.status.Checked, len(f.status.Findings), f.status.Fixed)
+		},
+	}
+	return f
+}
+
+// Status returns a copy of the current state
+func (f *Fsck) Status() FsckStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	st := f.status
+	st.Findings = slices.Clone(f.status.Findings)
+	st.Errors = slices.Clone(f.status.Errors)
+	return st
+}
+
+// Trigger starts the check in the background. Returns false if it's running.
+func (f *Fsck) Trigger(repair bool) bool {
+	return f.trigger(func() { f.status = FsckStatus{Repair: repair, Findings: []FsckFinding{}} })
+}
+
+// Run checks and waits for it
+func (f *Fsck) Run(ctx context.Context, repair bool) error {
+	return f.runNow(ctx, func() { f.status = FsckStatus{Repair: repair, Findings: []FsckFinding{}} })
+}
+
+func (f *Fsck) check(ctx context.Context) error {
+	if err := f.checkFiles(ctx); err != nil {
+		return err
+	}
+	if err := f.checkStuck(ctx); err != nil {
+		return err
+	}
+	return f.checkOrphans(ctx)
+}
+
+// checkFiles looks at the file of every song that should have one
+func (f *Fsck) checkFiles(ctx context.Context) error {
+	songs, err := f.db.GetSongsWithFiles(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load songs: %w", err)
+	}
+	_, probeErr := exec.LookPath("ffprobe")
+	if probeErr != nil {
+		f.addError(fmt.Errorf("ffprobe not found, undecodable files aren't checked: %w", probeErr))
+	}
+
+	for _, song := range songs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		f.count(func() { f.status.Checked++ })
+
+		finding := FsckFinding{SongID: song.ID, Path: song.FilePath}
+		info, err := os.Stat(song.FilePath)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			finding.Kind = FindingMissing
+		case err != nil:
+			f.addError(fmt.Errorf("song %d: %w", song.ID, err))
+			continue
+		case info.Size() == 0:
+			finding.Kind = FindingEmpty
+		case probeErr == nil:
+			meta, err := downloader.ProbeFile(song.FilePath)
+			if err != nil {
+				finding.Kind, finding.Detail = FindingUndecodable, err.Error()
+			} else if !meta.HasAudio {
+				finding.Kind, finding.Detail = FindingUndecodable, "no audio stream"
+			}
+		}
+		if finding.Kind == "" {
+			continue
+		}
+
+		if f.repairing() {
+			finding.Fixed = f.forgetFile(ctx, song, finding.Kind != FindingMissing)
+		}
+		f.addFinding(finding)
+	}
+	return nil
+}
+
+// forgetFile resets a song whose file is broken: library files are marked
+// 'Missing', the others go back to 'Remote' to be downloaded again. Only
+// files inside the layout are deleted, the rest aren't ours.
+func (f *Fsck) forgetFile(ctx context.Context, song *store.Song, remove bool) bool {
+	var err error
+	switch {
+	case song.Provider == store.ProviderLocal:
+		err = f.db.MarkSongsMissing(ctx, []int64{song.ID})
+	case remove && f.layout.Contains(song.FilePath):
+		if err = os.Remove(song.FilePath); err == nil || errors.Is(err, os.ErrNotExist) {
+			err = f.db.EvictSong(ctx, song.ID)
+		}
+	default:
+		err = f.db.EvictSong(ctx, song.ID)
+	}
+	if err != nil {
+		f.addError(fmt.Errorf("song %d: %w", song.ID, err))
+		return false
+	}
+	return true
+}
+
+func (f *Fsck) checkStuck(ctx context.Context) error {
+	songs, err := f.db.GetStuckSongs(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load stuck songs: %w", err)
+	}
+
+	for _, song := range songs {
+		finding := FsckFinding{Kind: FindingStuck, SongID: song.ID, Detail: fmt.Sprintf("%s without a download or file", song.Status)}
+		if f.repairing() {
+			if err := f.db.UpdateSongStatus(ctx, song.ID, "Pending"); err != nil {
+				f.addError(fmt.Errorf("song %d: %w", song.ID, err))
+			} else {
+				finding.Fixed = true
+			}
+		}
+		f.addFinding(finding)
+	}
+	return nil
+}
+
+// checkOrphans walks the folders of the layout (and the temp folder, for
+// spotdl's metadata files) for files no song points at. The folders of
+// older versions are only walked while songs still point into them. The
+// work files of unfinished downloads are spared however old: a task
+// waiting for a retry resumes its partial file.
+func (f *Fsck) checkOrphans(ctx context.Context) error {
+	refs, err := f.db.GetFileReferences(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load file references: %w", err)
+	}
+	known := make(map[string]bool, len(refs))
+	for _, ref := range refs {
+		if abs, err := filepath.Abs(ref); err == nil {
+			known[abs] = true
+		}
+	}
+	tasks, err := f.db.GetUnfinishedDownloadTasks(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load unfinished downloads: %w", err)
+	}
+	working := make(map[string]bool, len(tasks))
+	for _, task := range tasks {
+		if abs, err := filepath.Abs(f.layout.WorkPath(task.SongID)); err == nil {
+			working[abs] = true
+		}
+	}
+
+	var orphans []string
+	for _, dir := range f.layout.FileDirs(refs) {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			if !d.Type().IsRegular() || !oldEnough(d) {
+				return ctx.Err()
+			}
+			if abs, err := filepath.Abs(path); err == nil && !known[abs] && !working[workPath(abs)] {
+				orphans = append(orphans, abs)
+			}
+			return ctx.Err()
+		})
+		if err != nil {
+			return fmt.Errorf("failed to walk %s: %w", dir, err)
+		}
+	}
+	spotdlMeta, _ := filepath.Glob(filepath.Join(os.TempDir(), "meta_*.spotdl"))
+	for _, path := range spotdlMeta {
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) >= orphanMinAge {
+			orphans = append(orphans, path)
+		}
+	}
+
+	for _, path := range orphans {
+		finding := FsckFinding{Kind: FindingOrphan, Path: path}
+		if f.repairing() {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				f.addError(err)
+			} else {
+				finding.Fixed = true
+			}
+		}
+		f.addFinding(finding)
+	}
+	return nil
+}
+
+// workPath is the WorkPath a file of a download would come from, the path
+// up to the first dot of its name: 12.webm.part and 12.opus both are 12
+func workPath(path string) string {
+	name, _, _ := strings.Cut(filepath.Base(path), ".")
+	return filepath.Join(filepath.Dir(path), name)
+}
+
+func oldEnough(d fs.DirEntry) bool {
+	info, err := d.Info()
+	return err == nil && time.Since(info.ModTime()) >= orphanMinAge
+}
+
+func (f *Fsck) repairing() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.status.Repair
+}
+
+func (f *Fsck) addFinding(finding FsckFinding) {
+	f.count(func() {
+		f.status.Findings = append(f.status.Findings, finding)
+		if finding.Fixed {
+			f.status.Fixed++
+		}
+	})
+}
@@ -0,0 +1,132 @@
+package library
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"cryogon/rizumu-backend/downloader"
+	"cryogon/rizumu-backend/store"
+)
+
+// writeOldFile writes a file old enough to be an orphan
+func writeOldFile(t *testing.T, path string) {
+	t.Helper()
+	writeTestFile(t, path, "data")
+	old := time.Now().Add(-2 * orphanMinAge)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// saveDownloadedSong saves a song downloaded to path
+func saveDownloadedSong(t *testing.T, db *store.Store, providerID, path string) int64 {
+	t.Helper()
+	ctx := context.Background()
+	id, err := db.SaveSong(ctx, &store.Song{Title: providerID, Artist: "Artist", Provider: "youtube", ProviderID: providerID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateSongFile(ctx, id, store.SongFile{Path: path, Size: 4}); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func orphanPaths(st FsckStatus) map[string]bool {
+	paths := make(map[string]bool)
+	for _, finding := range st.Findings {
+		if finding.Kind == FindingOrphan {
+			paths[finding.Path] = finding.Fixed
+		}
+	}
+	return paths
+}
+
+func TestFsckOrphans(t *testing.T) {
+	// no ffprobe, whatever is installed
+	t.Setenv("PATH", t.TempDir())
+	db := newTestStore(t)
+	root := t.TempDir()
+	layout := downloader.Layout{Root: root}
+
+	// started from a home with a music folder of its own
+	home := t.TempDir()
+	t.Chdir(home)
+	personal := filepath.Join(home, "songs", "personal.mp3")
+	personalCover := filepath.Join(home, "covers", "me.jpg")
+	writeOldFile(t, personal)
+	writeOldFile(t, personalCover)
+
+	kept := filepath.Join(root, "songs", "Artist", "kept.mp3")
+	writeOldFile(t, kept)
+	saveDownloadedSong(t, db, "kept", kept)
+	leftover := filepath.Join(root, "songs", "12.webm.part")
+	writeOldFile(t, leftover)
+	writing := filepath.Join(root, "songs", "13.webm.part")
+	writeTestFile(t, writing, "still downloading")
+	// a download waiting for a retry since long ago
+	retryID, err := db.SaveSong(context.Background(), &store.Song{Title: "retry", Artist: "Artist", Provider: "youtube", ProviderID: "retry"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := &store.DownloadTask{SongID: retryID, Source: "youtube", SourceURL: "https://www.youtube.com/watch?v=retry", Priority: "bulk", Status: "Pending"}
+	if _, err := db.CreateDownloadTask(context.Background(), task); err != nil {
+		t.Fatal(err)
+	}
+	retrying := layout.WorkPath(retryID) + ".webm.part"
+	writeOldFile(t, retrying)
+
+	fsck := NewFsck(db, layout)
+	if err := fsck.Run(context.Background(), true); err != nil {
+		t.Fatal(err)
+	}
+	orphans := orphanPaths(fsck.Status())
+	if len(orphans) != 1 || !orphans[leftover] {
+		t.Errorf("orphans = %v, want only %s, fixed", orphans, leftover)
+	}
+	for _, path := range []string{personal, personalCover, kept, writing, retrying} {
+		if !exists(path) {
+			t.Errorf("%s was deleted", path)
+		}
+	}
+	if exists(leftover) {
+		t.Errorf("%s wasn't deleted", leftover)
+	}
+}
+
+func TestFsckLegacyDirs(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	db := newTestStore(t)
+	layout := downloader.Layout{Root: t.TempDir()}
+
+	// a download of an old version, before the library root
+	dir := t.TempDir()
+	t.Chdir(dir)
+	writeOldFile(t, filepath.Join(dir, "songs", "old.mp3"))
+	saveDownloadedSong(t, db, "old", filepath.Join("songs", "old.mp3"))
+	leftover := filepath.Join(dir, "songs", "7.part")
+	writeOldFile(t, leftover)
+	// no song points into covers/, it's not walked
+	cover := filepath.Join(dir, "covers", "7.jpg")
+	writeOldFile(t, cover)
+
+	fsck := NewFsck(db, layout)
+	if err := fsck.Run(context.Background(), true); err != nil {
+		t.Fatal(err)
+	}
+	orphans := orphanPaths(fsck.Status())
+	if len(orphans) != 1 || !orphans[leftover] {
+		t.Errorf("orphans = %v, want only %s, fixed", orphans, leftover)
+	}
+	if !exists(filepath.Join(dir, "songs", "old.mp3")) || !exists(cover) {
+		t.Error("a file in use or outside the layout was deleted")
+	}
+}
@@ -0,0 +1,95 @@
+package library
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// JobStatus is what the status of every library job has, each job's status
+// embeds it and adds its counters
+type JobStatus struct {
+	Running    bool       `json:"running"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Errors     []string   `json:"errors,omitempty"`
+}
+
+// job runs a library job one at a time, in the background or not. The job
+// keeps its status next to it, guarded by mu; base reaches the JobStatus
+// the status embeds.
+type job struct {
+	errRunning error  // Run while the job runs
+	start      string // logged when the job starts
+	work       func(ctx context.Context) error
+	base       func() *JobStatus
+	// summary sums up the counters, logged with the errors when the job ends
+	summary func() string
+
+	mu sync.Mutex
+}
+
+// trigger starts the job in the background. reset sets the status the job
+// starts with. Returns false if it's running.
+func (j *job) trigger(reset func()) bool {
+	if !j.begin(reset) {
+		return false
+	}
+	go j.run(context.Background())
+	return true
+}
+
+// runNow runs the job and waits for it
+func (j *job) runNow(ctx context.Context, reset func()) error {
+	if !j.begin(reset) {
+		return j.errRunning
+	}
+	return j.run(ctx)
+}
+
+func (j *job) begin(reset func()) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.base().Running {
+		return false
+	}
+
+	reset()
+	now := time.Now()
+	st := j.base()
+	st.Running, st.StartedAt = true, &now
+	return true
+}
+
+func (j *job) run(ctx context.Context) error {
+	log.Printf("[Library] %s...", j.start)
+	err := j.work(ctx)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	st := j.base()
+	st.Running = false
+	st.FinishedAt = &now
+	if err != nil {
+		st.Errors = append(st.Errors, err.Error())
+	}
+	log.Printf("[Library] %s, %d errors", j.summary(), len(st.Errors))
+	return err
+}
+
+func (j *job) addError(err error) {
+	log.Printf("[Library] WARN: %v", err)
+	j.count(func() {
+		st := j.base()
+		st.Errors = append(st.Errors, err.Error())
+	})
+}
+
+// count updates the status while the job runs
+func (j *job) count(fn func()) {
+	j.mu.Lock()
+	fn()
+	j.mu.Unlock()
+}
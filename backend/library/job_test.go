@@ -0,0 +1,77 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type testJobStatus struct {
+	JobStatus
+	Done int `json:"done"`
+}
+
+func TestJob(t *testing.T) {
+	errRunning := errors.New("running")
+	started, release := make(chan struct{}), make(chan struct{})
+	wait := started
+
+	var status testJobStatus
+	var j *job
+	j = &job{
+		errRunning: errRunning,
+		start:      "Testing",
+		work: func(ctx context.Context) error {
+			if started != nil {
+				close(started)
+				started = nil
+			}
+			<-release
+			j.count(func() { status.Done++ })
+			j.addError(errors.New("one item failed"))
+			return errors.New("the job failed")
+		},
+		base:    func() *JobStatus { return &status.JobStatus },
+		summary: func() string { return fmt.Sprintf("Test finished: %d done", status.Done) },
+	}
+	snapshot := func() testJobStatus {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		return status
+	}
+	reset := func() { status = testJobStatus{} }
+
+	if !j.trigger(reset) {
+		t.Fatal("the job didn't start")
+	}
+	<-wait
+	if st := snapshot(); !st.Running || st.StartedAt == nil || st.FinishedAt != nil {
+		t.Errorf("status while running: %+v", st.JobStatus)
+	}
+	if j.trigger(reset) {
+		t.Error("triggered twice")
+	}
+	if err := j.runNow(context.Background(), reset); err != errRunning {
+		t.Errorf("runNow while running = %v", err)
+	}
+	close(release)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for snapshot().Running && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	st := snapshot()
+	if st.Running || st.FinishedAt == nil || st.Done != 1 || len(st.Errors) != 2 {
+		t.Errorf("status: %+v", st)
+	}
+
+	// a new run starts from the reset status
+	if err := j.runNow(context.Background(), func() { status = testJobStatus{Done: 10} }); err == nil {
+		t.Error("the job's error was lost")
+	}
+	if st := snapshot(); st.Done != 11 || len(st.Errors) != 2 {
+		t.Errorf("status after running again: %+v", st)
+	}
+}
@@ -0,0 +1,312 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"cryogon/rizumu-backend/downloader"
+	"cryogon/rizumu-backend/store"
+)
+
+// osuProvider is the provider of the osu! download source, an imported
+// beatmapset is the same song as a downloaded one
+const osuProvider = "osu!"
+
+var errNoBeatmaps = errors.New("no beatmap in folder")
+
+// OsuImportResult sums up an import of an osu! install
+type OsuImportResult struct {
+	Beatmapsets int      `json:"beatmapsets"`
+	Songs       int      `json:"songs"` // one per distinct audio file
+	Collections int      `json:"collections"`
+	Errors      []string `json:"errors,omitempty"`
+}
+
+func (r *OsuImportResult) addError(err error) {
+	log.Printf("[Library] WARN: %v", err)
+	r.Errors = append(r.Errors, err.Error())
+}
+
+// ImportOsu imports an osu! (stable) install, or only its Songs folder.
+// Every beatmapset becomes one song per audio file, the difficulties sharing
+// it are the same song. Files are not copied: songs play the audio and show
+// the background from the Songs folder. The collection.db next to Songs,
+// if any, becomes playlists.
+func ImportOsu(ctx context.Context, db *store.Store, dir string) (*OsuImportResult, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	songsDir := dir
+	if fi, err := os.Stat(filepath.Join(dir, "Songs")); err == nil && fi.IsDir() {
+		songsDir = filepath.Join(dir, "Songs")
+	}
+
+	entries, err := os.ReadDir(songsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("[Library] Importing osu! beatmaps from %s...", songsDir)
+	res := &OsuImportResult{}
+	byHash := make(map[string]int64) // .osu MD5 -> song, for the collections
+
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		if !e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+
+		n, err := importBeatmapset(ctx, db, filepath.Join(songsDir, e.Name()), byHash)
+		if err != nil {
+			if !errors.Is(err, errNoBeatmaps) {
+				res.addError(fmt.Errorf("%s: %w", e.Name(), err))
+			}
+			continue
+		}
+		res.Beatmapsets++
+		res.Songs += n
+	}
+
+	collectionPath := filepath.Join(filepath.Dir(songsDir), "collection.db")
+	if _, err := os.Stat(collectionPath); err == nil {
+		n, err := importOsuCollections(ctx, db, collectionPath, byHash)
+		if err != nil {
+			res.addError(fmt.Errorf("collection.db: %w", err))
+		}
+		res.Collections = n
+	}
+
+	log.Printf("[Library] osu! import finished: %d beatmapsets, %d songs, %d collections, %d errors",
+		res.Beatmapsets, res.Songs, res.Collections, len(res.Errors))
+	return res, nil
+}
+
+// importBeatmapset saves the songs of a beatmapset folder, and records the
+// checksum of each difficulty in byHash. Returns the number of songs.
+func importBeatmapset(ctx context.Context, db *store.Store, setDir string, byHash map[string]int64) (int, error) {
+	entries, err := os.ReadDir(setDir)
+	if err != nil {
+		return 0, err
+	}
+
+	// osu! comes from Windows, the .osu files don't always match the case
+	files := make(map[string]string) // lower case -> name on disk
+	for _, e := range entries {
+		if !e.IsDir() {
+			files[strings.ToLower(e.Name())] = e.Name()
+		}
+	}
+
+	// all the names first: "Artist - Title [Hard].osu" sorts before "audio.mp3"
+	var diffs []downloader.OsuDifficulty
+	for _, e := range entries {
+		if e.IsDir() || !strings.EqualFold(filepath.Ext(e.Name()), ".osu") {
+			continue
+		}
+
+		diff, err := readOsuDifficulty(filepath.Join(setDir, e.Name()))
+		if err != nil {
+			log.Printf("[Library] WARN: %v", err)
+			continue
+		}
+		if _, ok := files[strings.ToLower(diff.AudioFilename)]; !ok || diff.AudioFilename == "" {
+			log.Printf("[Library] WARN: %s: audio %q not found", filepath.Join(setDir, e.Name()), diff.AudioFilename)
+			continue
+		}
+		diffs = append(diffs, diff)
+	}
+	if len(diffs) == 0 {
+		return 0, errNoBeatmaps
+	}
+
+	// the audio most difficulties use is the one of the beatmapset, the
+	// others (rare, a cut or sped up version) are kept as local songs
+	groups := downloader.GroupOsuDifficulties(diffs)
+	setID := beatmapsetID(filepath.Base(setDir), diffs)
+
+	for i, group := range groups {
+		if err := ctx.Err(); err != nil {
+			return i, err
+		}
+
+		providerID := setID
+		if i > 0 {
+			providerID = ""
+		}
+		set := downloader.NewOsuSetInfo(group)
+		id, err := saveOsuSong(ctx, db, setDir, set, files, providerID)
+		if err != nil {
+			return i, fmt.Errorf("%s: %w", set.Main.AudioFilename, err)
+		}
+		for _, b := range set.Beatmaps {
+			byHash[b.Checksum] = id
+		}
+	}
+	return len(groups), nil
+}
+
+func readOsuDifficulty(path string) (downloader.OsuDifficulty, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return downloader.OsuDifficulty{}, err
+	}
+	defer f.Close()
+	return downloader.ParseOsuDifficulty(f)
+}
+
+// saveOsuSong saves the song of a group of difficulties with their
+// beatmaps and tags. Without a beatmapset ID (unsubmitted maps) it can't be
+// downloaded again, so it's a local song.
+func saveOsuSong(ctx context.Context, db *store.Store, setDir string, set downloader.OsuSetInfo, files map[string]string, setID string) (int64, error) {
+	audio := files[strings.ToLower(set.Main.AudioFilename)]
+	path := filepath.Join(setDir, audio)
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+
+	song := &store.Song{
+		Title:     set.Main.Title,
+		Artist:    set.Main.Artist,
+		BPM:       set.Main.BPM,
+		PreviewMs: set.PreviewMs,
+		FilePath:  path,
+		FileSize:  info.Size(),
+	}
+	if song.Title == "" {
+		song.Title = strings.TrimSuffix(audio, filepath.Ext(audio))
+	}
+	if song.Artist == "" {
+		song.Artist = "Unknown"
+	}
+	if bg, ok := files[strings.ToLower(set.Background)]; ok && bg != "" {
+		song.ImageURL = filepath.Join(setDir, bg)
+	}
+
+	var id int64
+	merged := false
+	if setID == "" {
+		id, err = db.SaveLocalSong(ctx, song, info.ModTime().UnixNano())
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		song.Provider = osuProvider
+		song.ProviderID = setID
+		id, err = db.SaveSong(ctx, song)
+		if err != nil {
+			return 0, err
+		}
+
+		// a beatmapset downloaded before keeps its own copy
+		existing, err := db.GetSong(ctx, id)
+		if err != nil {
+			return 0, err
+		}
+		// merged into a song of another provider, which keeps its metadata
+		merged = existing.Provider != osuProvider
+		if _, statErr := os.Stat(existing.FilePath); existing.Status != "Downloaded" || existing.FilePath == "" || statErr != nil {
+			song.ID = id
+			if merged {
+				err = db.UpdateSongFile(ctx, id, store.SongFile{Path: path, Size: info.Size()})
+			} else {
+				err = db.UpdateSongFullMetadata(ctx, song)
+			}
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	if !merged {
+		if err := db.SetSongCredits(ctx, id, downloader.SplitArtists(song.Artist), nil); err != nil {
+			log.Printf("[Library] WARN: Failed to save artists of %s: %v", path, err)
+		}
+	}
+	if err := db.SetOsuBeatmaps(ctx, id, set.Beatmaps); err != nil {
+		log.Printf("[Library] WARN: Failed to save beatmaps of %s: %v", path, err)
+	}
+	if err := db.AddSongTags(ctx, id, set.Tags); err != nil {
+		log.Printf("[Library] WARN: Failed to save tags of %s: %v", path, err)
+	}
+	return id, nil
+}
+
+// beatmapsetID is the BeatmapSetID of the difficulties, or for old maps
+// that don't have one the ID osu! puts at the start of the folder name,
+// "123456 Artist - Title". Empty for unsubmitted maps.
+func beatmapsetID(folder string, diffs []downloader.OsuDifficulty) string {
+	for _, d := range diffs {
+		if d.BeatmapSetID > 0 {
+			return strconv.Itoa(d.BeatmapSetID)
+		}
+		if d.BeatmapSetID < 0 {
+			return ""
+		}
+	}
+	prefix, _, _ := strings.Cut(folder, " ")
+	if id, err := strconv.Atoi(prefix); err == nil && id > 0 {
+		return prefix
+	}
+	return ""
+}
+
+// importOsuCollections mirrors each collection as an 'osu' playlist.
+// Beatmaps that were not imported (deleted from Songs) are left out.
+func importOsuCollections(ctx context.Context, db *store.Store, path string, byHash map[string]int64) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	collections, err := ParseOsuCollections(f)
+	if err != nil {
+		return 0, err
+	}
+
+	imported := 0
+	for _, c := range collections {
+		var songIDs []int64
+		seen := make(map[int64]bool)
+		missing := 0
+		for _, hash := range c.Hashes {
+			id, ok := byHash[hash]
+			if !ok {
+				missing++
+				continue
+			}
+			if !seen[id] {
+				seen[id] = true
+				songIDs = append(songIDs, id)
+			}
+		}
+
+		playlistID, err := db.SavePlaylist(ctx, &store.Playlist{
+			UserID:     1,
+			Name:       c.Name,
+			SourceType: "osu",
+			ExternalID: "collection:" + c.Name,
+		})
+		if err != nil {
+			return imported, fmt.Errorf("collection %q: %w", c.Name, err)
+		}
+		if err := db.SetPlaylistSongs(ctx, playlistID, songIDs); err != nil {
+			return imported, fmt.Errorf("collection %q: %w", c.Name, err)
+		}
+		if missing > 0 {
+			log.Printf("[Library] Collection %q: %d beatmaps not in Songs", c.Name, missing)
+		}
+		imported++
+	}
+	return imported, nil
+}
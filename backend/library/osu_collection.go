@@ -0,0 +1,97 @@
+package library
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// OsuCollection is a collection of osu! stable, beatmaps by their MD5
+type OsuCollection struct {
+	Name   string
+	Hashes []string // MD5 of the .osu files, hex
+}
+
+// ParseOsuCollections reads osu!'s collection.db:
+//
+//	int32 version, int32 count, then per collection:
+//	string name, int32 count, count x string md5
+//
+// Integers are little endian, strings are 0x00 (empty) or 0x0b followed
+// by a ULEB128 length and UTF-8 bytes.
+func ParseOsuCollections(r io.Reader) ([]OsuCollection, error) {
+	br := bufio.NewReader(r)
+
+	var version, count int32
+	if err := binary.Read(br, binary.LittleEndian, &version); err != nil {
+		return nil, fmt.Errorf("failed to read version: %w", err)
+	}
+	if err := binary.Read(br, binary.LittleEndian, &count); err != nil {
+		return nil, fmt.Errorf("failed to read collection count: %w", err)
+	}
+	if count < 0 {
+		return nil, fmt.Errorf("invalid collection count %d", count)
+	}
+
+	var collections []OsuCollection
+	for i := range count {
+		name, err := readOsuString(br)
+		if err != nil {
+			return nil, fmt.Errorf("collection %d: failed to read name: %w", i, err)
+		}
+
+		var n int32
+		if err := binary.Read(br, binary.LittleEndian, &n); err != nil {
+			return nil, fmt.Errorf("collection %q: failed to read beatmap count: %w", name, err)
+		}
+		if n < 0 {
+			return nil, fmt.Errorf("collection %q: invalid beatmap count %d", name, n)
+		}
+
+		c := OsuCollection{Name: name}
+		for range n {
+			hash, err := readOsuString(br)
+			if err != nil {
+				return nil, fmt.Errorf("collection %q: failed to read beatmap: %w", name, err)
+			}
+			if hash != "" {
+				c.Hashes = append(c.Hashes, hash)
+			}
+		}
+		collections = append(collections, c)
+	}
+	return collections, nil
+}
+
+// maxOsuString guards against a corrupted length allocating gigabytes
+const maxOsuString = 1 << 20
+
+func readOsuString(r *bufio.Reader) (string, error) {
+	marker, err := r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	switch marker {
+	case 0x00:
+		return "", nil
+	case 0x0b:
+	default:
+		return "", fmt.Errorf("invalid string marker 0x%02x", marker)
+	}
+
+	length, err := binary.ReadUvarint(r) // ULEB128
+	if err != nil {
+		return "", err
+	}
+	if length > maxOsuString {
+		return "", errors.New("string too long")
+	}
+
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
@@ -0,0 +1,98 @@
+package library
+
+import (
+	"bytes"
+	"encoding/binary"
+	"slices"
+	"testing"
+)
+
+// osuString encodes s the way osu! does, empty strings as a lone 0x00
+func osuString(s string) []byte {
+	if s == "" {
+		return []byte{0x00}
+	}
+	b := binary.AppendUvarint([]byte{0x0b}, uint64(len(s)))
+	return append(b, s...)
+}
+
+// encodeOsuCollections writes a collection.db
+func encodeOsuCollections(collections []OsuCollection) []byte {
+	b := binary.LittleEndian.AppendUint32(nil, 20150203)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(collections)))
+	for _, c := range collections {
+		b = append(b, osuString(c.Name)...)
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(c.Hashes)))
+		for _, hash := range c.Hashes {
+			b = append(b, osuString(hash)...)
+		}
+	}
+	return b
+}
+
+var testCollections = []OsuCollection{
+	{Name: "Favourites", Hashes: []string{"0cc175b9c0f1b6a831c399e269772661", "92eb5ffee6ae2fec3ad71c777531578f"}},
+	{Name: "", Hashes: nil},
+	// a name longer than 127 bytes takes two ULEB128 bytes
+	{Name: string(bytes.Repeat([]byte("ö"), 100)), Hashes: []string{"4a8a08f09d37b73795649038408b5f33"}},
+}
+
+func TestParseOsuCollections(t *testing.T) {
+	got, err := ParseOsuCollections(bytes.NewReader(encodeOsuCollections(testCollections)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(testCollections) {
+		t.Fatalf("%d collections, want %d", len(got), len(testCollections))
+	}
+	for i, want := range testCollections {
+		if got[i].Name != want.Name || !slices.Equal(got[i].Hashes, want.Hashes) {
+			t.Errorf("collection %d = %q %v, want %q %v", i, got[i].Name, got[i].Hashes, want.Name, want.Hashes)
+		}
+	}
+}
+
+func TestParseOsuCollectionsSkipsEmptyHashes(t *testing.T) {
+	data := encodeOsuCollections([]OsuCollection{{Name: "Mixed", Hashes: []string{"", "0cc175b9c0f1b6a831c399e269772661", ""}}})
+	got, err := ParseOsuCollections(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || !slices.Equal(got[0].Hashes, []string{"0cc175b9c0f1b6a831c399e269772661"}) {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestParseOsuCollectionsTruncated(t *testing.T) {
+	data := encodeOsuCollections(testCollections)
+	for n := range len(data) {
+		if _, err := ParseOsuCollections(bytes.NewReader(data[:n])); err == nil {
+			t.Errorf("no error with the file cut at %d of %d bytes", n, len(data))
+		}
+	}
+}
+
+func TestParseOsuCollectionsCorrupted(t *testing.T) {
+	header := func(count int32) []byte {
+		b := binary.LittleEndian.AppendUint32(nil, 20150203)
+		return binary.LittleEndian.AppendUint32(b, uint32(count))
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"negative collection count", header(-1)},
+		{"bad string marker", append(header(1), 0x0c, 1, 'a')},
+		{"negative beatmap count", append(append(header(1), osuString("c")...), 0xff, 0xff, 0xff, 0xff)},
+		{"huge string length", append(header(1), 0x0b, 0xff, 0xff, 0xff, 0xff, 0x0f)},
+		{"overlong ULEB128", append(header(1), append([]byte{0x0b}, bytes.Repeat([]byte{0x80}, 11)...)...)},
+		{"more collections than data", append(header(1000), append(osuString("c"), 0, 0, 0, 0)...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseOsuCollections(bytes.NewReader(tt.data)); err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+}
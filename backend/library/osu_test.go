@@ -0,0 +1,235 @@
+package library
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"cryogon/rizumu-backend/store"
+)
+
+func newTestStore(t *testing.T) *store.Store {
+	t.Helper()
+	db, err := store.NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type osuTestDiff struct {
+	file, audio, version string
+	setID, beatmapID     int
+}
+
+// writeOsuDiff writes a .osu file and returns its MD5, how collection.db
+// refers to it
+func writeOsuDiff(t *testing.T, setDir, title, artist string, d osuTestDiff) string {
+	t.Helper()
+	content := fmt.Sprintf(`osu file format v14
+
+[General]
+AudioFilename: %s
+PreviewTime: 12345
+Mode: 0
+
+[Metadata]
+Title:%s
+Artist:%s
+Creator:mapper
+Version:%s
+Source:Touhou
+Tags:stream jump
+BeatmapID:%d
+BeatmapSetID:%d
+
+[Difficulty]
+HPDrainRate:5
+CircleSize:4
+OverallDifficulty:7
+ApproachRate:9
+
+[Events]
+0,0,"BG.JPG",0,0
+
+[TimingPoints]
+100,300,4,2,0,60,1,0
+`, d.audio, title, artist, d.version, d.beatmapID, d.setID)
+	writeTestFile(t, filepath.Join(setDir, d.file), content)
+	sum := md5.Sum([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestImportOsu(t *testing.T) {
+	ctx := context.Background()
+	db := newTestStore(t)
+	osuDir := t.TempDir()
+	songsDir := filepath.Join(osuDir, "Songs")
+	hashes := make(map[string]string) // .osu file -> MD5
+
+	set := func(folder, title, artist string, audio []string, diffs ...osuTestDiff) {
+		dir := filepath.Join(songsDir, folder)
+		for _, name := range audio {
+			writeTestFile(t, filepath.Join(dir, name), "audio of "+folder+"/"+name)
+		}
+		writeTestFile(t, filepath.Join(dir, "bg.jpg"), "jpeg")
+		for _, d := range diffs {
+			hashes[folder+"/"+d.file] = writeOsuDiff(t, dir, title, artist, d)
+		}
+	}
+
+	// three difficulties, one audio file: one song
+	set("100 Artist A - Song A", "Song A", "Artist A", []string{"audio.mp3"},
+		osuTestDiff{"Easy.osu", "audio.mp3", "Easy", 100, 1001},
+		osuTestDiff{"Normal.osu", "audio.mp3", "Normal", 100, 1002},
+		osuTestDiff{"Hard.osu", "audio.mp3", "Hard", 100, 1003},
+	)
+	// a cut of the song for one difficulty: a second, local, song
+	set("200 Artist B - Song B", "Song B", "Artist B", []string{"song.ogg", "song_cut.ogg"},
+		osuTestDiff{"Normal.osu", "song.ogg", "Normal", 200, 2001},
+		osuTestDiff{"Insane.osu", "song.ogg", "Insane", 200, 2002},
+		osuTestDiff{"Cut.osu", "song_cut.ogg", "Marathon Cut", 200, 2003},
+	)
+	// never submitted: can't be downloaded again, a local song
+	set("beatmap-637 Artist D - Song D", "Song D", "Artist D", []string{"audio.mp3"},
+		osuTestDiff{"Test.osu", "audio.mp3", "Test", -1, 0},
+	)
+	// made on Windows: the names in the .osu don't match the case on disk
+	set("300 Artist C - Song C", "Song C", "Artist C", []string{"audio.mp3"},
+		osuTestDiff{"Hard.OSU", "Audio.MP3", "Hard", 0, 3001},
+		// its audio is gone, the difficulty is skipped
+		osuTestDiff{"Gone.osu", "missing.mp3", "Gone", 0, 3002},
+	)
+	// neither are beatmapsets
+	writeTestFile(t, filepath.Join(songsDir, "not a set", "readme.txt"), "hi")
+	writeTestFile(t, filepath.Join(songsDir, ".hidden", "x.osu"), "osu file format v14")
+
+	collections := []OsuCollection{
+		{Name: "Favourites", Hashes: []string{
+			hashes["100 Artist A - Song A/Easy.osu"],
+			hashes["100 Artist A - Song A/Hard.osu"], // the same song again
+			hashes["300 Artist C - Song C/Hard.OSU"],
+			"ffffffffffffffffffffffffffffffff", // deleted since
+		}},
+		{Name: "Cuts", Hashes: []string{hashes["200 Artist B - Song B/Cut.osu"]}},
+	}
+	writeTestFile(t, filepath.Join(osuDir, "collection.db"), string(encodeOsuCollections(collections)))
+
+	res, err := ImportOsu(ctx, db, osuDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Beatmapsets != 4 || res.Songs != 5 || res.Collections != 2 || len(res.Errors) != 0 {
+		t.Fatalf("result = %+v, want 4 beatmapsets, 5 songs, 2 collections", res)
+	}
+
+	songs, err := db.GetDedupeCandidates(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(songs) != 5 {
+		t.Fatalf("%d songs in the database, want 5", len(songs))
+	}
+	byTitle := make(map[string]*store.Song)
+	for _, song := range songs {
+		if song.Status != "Downloaded" {
+			t.Errorf("%s is %s", song.Title, song.Status)
+		}
+		if song.Provider == store.ProviderLocal {
+			byTitle[song.Title+" (local)"] = song
+		} else {
+			byTitle[song.Title] = song
+		}
+	}
+
+	wantSongs := []struct {
+		key, provider, providerID, file string
+		beatmaps                        int
+	}{
+		{"Song A", "osu!", "100", "100 Artist A - Song A/audio.mp3", 3},
+		{"Song B", "osu!", "200", "200 Artist B - Song B/song.ogg", 2},
+		{"Song B (local)", store.ProviderLocal, "", "200 Artist B - Song B/song_cut.ogg", 1},
+		{"Song D (local)", store.ProviderLocal, "", "beatmap-637 Artist D - Song D/audio.mp3", 1},
+		{"Song C", "osu!", "300", "300 Artist C - Song C/audio.mp3", 1},
+	}
+	for _, want := range wantSongs {
+		song, ok := byTitle[want.key]
+		if !ok {
+			t.Errorf("no song %s", want.key)
+			continue
+		}
+		if song.Provider != want.provider || (want.providerID != "" && song.ProviderID != want.providerID) {
+			t.Errorf("%s from %s/%s, want %s/%s", want.key, song.Provider, song.ProviderID, want.provider, want.providerID)
+		}
+		if file := filepath.Join(songsDir, want.file); song.FilePath != file {
+			t.Errorf("%s plays %s, want %s", want.key, song.FilePath, file)
+		}
+		if bg := filepath.Join(filepath.Dir(song.FilePath), "bg.jpg"); song.ImageURL != bg {
+			t.Errorf("%s background %q, want %q", want.key, song.ImageURL, bg)
+		}
+		beatmaps, err := db.GetOsuBeatmaps(ctx, song.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(beatmaps) != want.beatmaps {
+			t.Errorf("%s has %d beatmaps, want %d", want.key, len(beatmaps), want.beatmaps)
+		}
+	}
+
+	playlists, err := db.GetPlaylists()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantPlaylists := map[string][]int64{
+		"Favourites": {byTitle["Song A"].ID, byTitle["Song C"].ID},
+		"Cuts":       {byTitle["Song B (local)"].ID},
+	}
+	found := 0
+	for _, p := range playlists {
+		if p.SourceType != "osu" {
+			continue
+		}
+		found++
+		songs, err := db.GetSongsByPlaylist(ctx, p.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []int64
+		for _, song := range songs {
+			ids = append(ids, song.ID)
+		}
+		if want, ok := wantPlaylists[p.Name]; !ok || !slices.Equal(ids, want) {
+			t.Errorf("playlist %q has %v, want %v", p.Name, ids, want)
+		}
+	}
+	if found != len(wantPlaylists) {
+		t.Errorf("%d osu playlists, want %d", found, len(wantPlaylists))
+	}
+
+	// importing again updates, it doesn't add
+	res, err = ImportOsu(ctx, db, songsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Songs != 5 {
+		t.Errorf("second import: %d songs", res.Songs)
+	}
+	if songs, err = db.GetDedupeCandidates(ctx); err != nil || len(songs) != 5 {
+		t.Errorf("%d songs after importing twice (%v), want 5", len(songs), err)
+	}
+}
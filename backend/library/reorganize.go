@@ -0,0 +1,206 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"cryogon/rizumu-backend/downloader"
+	"cryogon/rizumu-backend/store"
+)
+
+var ErrReorganizeRunning = errors.New("the library is already being reorganized")
+
+// ReorganizeStatus is a snapshot of the last (or current) reorganize job
+type ReorganizeStatus struct {
+	JobStatus
+	Total   int `json:"total"`   // downloaded songs looked at
+	Moved   int `json:"moved"`   // songs with a file moved
+	Skipped int `json:"skipped"` // already in place, or not managed by the layout
+}
+
+// Reorganizer moves the downloaded files to where the layout wants them:
+// after changing the library root or the naming template, and for the
+// ./songs and ./covers files of older versions.
+type Reorganizer struct {
+	db     *store.Store
+	layout downloader.Layout
+
+	job
+	status ReorganizeStatus
+}
+
+func NewReorganizer(db *store.Store, layout downloader.Layout) *Reorganizer {
+	r := &Reorganizer{db: db, layout: layout}
+	r.job = job{
+		errRunning: ErrReorganizeRunning,
+		start:      "Reorganizing into " + layout.SongsDir(),
+		work:       r.reorganize,
+		base:       func() *JobStatus { return &r.status.JobStatus },
+		summary: func() string {
+			return fmt.Sprintf("Reorganize finished: %d songs, %d moved, %d skipped",
+				r.status.Total, r.status.Moved, r.status.Skipped)
+		},
+	}
+	return r
+}
+
+// Status returns a copy of the current state
+func (r *Reorganizer) Status() ReorganizeStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	st := r.status
+	st.Errors = slices.Clone(r.status.Errors)
+	return st
+}
+
+// Trigger starts the job in the background. Returns false if it's running.
+func (r *Reorganizer) Trigger() bool {
+	return r.trigger(r.reset)
+}
+
+// Run reorganizes and waits for it
+func (r *Reorganizer) Run(ctx context.Context) error {
+	return r.runNow(ctx, r.reset)
+}
+
+func (r *Reorganizer) reset() {
+	r.status = ReorganizeStatus{}
+}
+
+func (r *Reorganizer) reorganize(ctx context.Context) error {
+	songs, err := r.db.GetDownloadedSongs(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load songs: %w", err)
+	}
+	r.count(func() { r.status.Total = len(songs) })
+
+	for _, song := range songs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		moved, err := r.relocate(ctx, song)
+		switch {
+		case err != nil:
+			r.addError(fmt.Errorf("song %d (%s): %w", song.ID, song.FilePath, err))
+		case moved:
+			r.count(func() { r.status.Moved++ })
+		default:
+			r.count(func() { r.status.Skipped++ })
+		}
+	}
+	return nil
+}
+
+// fileMove is one file of a song going somewhere else
+type fileMove struct {
+	from, to string
+}
+
+// relocate moves the audio and the cover of a song if they're not in place
+func (r *Reorganizer) relocate(ctx context.Context, song *store.Song) (bool, error) {
+	var moves []fileMove
+
+	filePath := song.FilePath
+	if r.managed(filePath) {
+		dest := r.layout.SongPath(song, downloader.TrackNumber(filePath), filepath.Ext(filePath))
+		if filePath = downloader.FreePath(dest, song.FilePath, destinations(moves)...); !downloader.SamePath(filePath, song.FilePath) {
+			moves = append(moves, fileMove{song.FilePath, filePath})
+		}
+	}
+
+	imageURL := song.ImageURL
+	if !strings.HasPrefix(imageURL, "http") && r.managed(imageURL) {
+		dest := r.layout.CoverPath(song.ID, filepath.Ext(imageURL))
+		if imageURL = downloader.FreePath(dest, song.ImageURL, destinations(moves)...); !downloader.SamePath(imageURL, song.ImageURL) {
+			moves = append(moves, fileMove{song.ImageURL, imageURL})
+		}
+	}
+
+	if len(moves) == 0 {
+		return false, nil
+	}
+
+	move := func() error { return moveAll(moves) }
+	undo := func() error { return moveAll(reversed(moves)) }
+	if err := r.db.RelocateSong(ctx, song.ID, filePath, imageURL, move, undo); err != nil {
+		return false, err
+	}
+
+	for _, m := range moves {
+		log.Printf("[Library] Moved %s -> %s", m.from, m.to)
+		removeEmptyDirs(filepath.Dir(m.from), r.layout.SongsDir(), r.layout.CoversDir())
+	}
+	return true, nil
+}
+
+// managed tells if the file exists and is the layout's to move
+func (r *Reorganizer) managed(path string) bool {
+	if path == "" || !r.layout.Contains(path) {
+		return false
+	}
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// moveAll moves the files in order. If one fails, those already moved are
+// put back.
+func moveAll(moves []fileMove) error {
+	for i, m := range moves {
+		if err := downloader.MoveFile(m.from, m.to); err != nil {
+			for _, done := range slices.Backward(moves[:i]) {
+				if undoErr := downloader.MoveFile(done.to, done.from); undoErr != nil {
+					log.Printf("[Library] CRITICAL: Failed to move %s back: %v", done.to, undoErr)
+				}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// destinations are the paths moves go to
+func destinations(moves []fileMove) []string {
+	paths := make([]string, 0, len(moves))
+	for _, m := range moves {
+		paths = append(paths, m.to)
+	}
+	return paths
+}
+
+func reversed(moves []fileMove) []fileMove {
+	back := make([]fileMove, 0, len(moves))
+	for _, m := range slices.Backward(moves) {
+		back = append(back, fileMove{from: m.to, to: m.from})
+	}
+	return back
+}
+
+// removeEmptyDirs removes dir and its parents while they're empty, as long
+// as they're inside one of roots
+func removeEmptyDirs(dir string, roots ...string) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return
+	}
+	var absRoots []string
+	for _, root := range roots {
+		if abs, err := filepath.Abs(root); err == nil {
+			absRoots = append(absRoots, abs)
+		}
+	}
+
+	for inDirs(dir, absRoots) {
+		// fails on a folder that isn't empty, which is where we stop
+		if os.Remove(dir) != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
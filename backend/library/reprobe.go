@@ -0,0 +1,108 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+
+	"cryogon/rizumu-backend/downloader"
+	"cryogon/rizumu-backend/store"
+)
+
+var ErrReprobeRunning = errors.New("the library is already being probed")
+
+// ReprobeStatus is a snapshot of the last (or current) reprobe job
+type ReprobeStatus struct {
+	JobStatus
+	All     bool `json:"all"`     // every file, not only those missing info
+	Total   int  `json:"total"`   // songs with a file looked at
+	Probed  int  `json:"probed"`  // rows updated
+	Skipped int  `json:"skipped"` // already complete
+	Missing int  `json:"missing"` // file not on disk anymore
+}
+
+// Reprober fills the size, bitrate, format and duration of the songs
+// downloaded before every file was probed
+type Reprober struct {
+	db *store.Store
+
+	job
+	status ReprobeStatus
+}
+
+func NewReprober(db *store.Store) *Reprober {
+	p := &Reprober{db: db}
+	p.job = job{
+		errRunning: ErrReprobeRunning,
+		start:      "Probing the library files",
+		work:       p.reprobe,
+		base:       func() *JobStatus { return &p.status.JobStatus },
+		summary: func() string {
+			return fmt.Sprintf("Reprobe finished: %d songs, %d probed, %d skipped, %d missing",
+				p.status.Total, p.status.Probed, p.status.Skipped, p.status.Missing)
+		},
+	}
+	return p
+}
+
+// Status returns a copy of the current state
+func (p *Reprober) Status() ReprobeStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	st := p.status
+	st.Errors = slices.Clone(p.status.Errors)
+	return st
+}
+
+// Trigger starts the job in the background. Returns false if it's running.
+// all probes every file again, otherwise only those missing info.
+func (p *Reprober) Trigger(all bool) bool {
+	return p.trigger(func() { p.status = ReprobeStatus{All: all} })
+}
+
+// Run probes and waits for it
+func (p *Reprober) Run(ctx context.Context, all bool) error {
+	return p.runNow(ctx, func() { p.status = ReprobeStatus{All: all} })
+}
+
+func (p *Reprober) reprobe(ctx context.Context) error {
+	songs, err := p.db.GetSongsWithFiles(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load songs: %w", err)
+	}
+	all := p.Status().All
+	p.count(func() { p.status.Total = len(songs) })
+
+	for _, song := range songs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !all && probed(song) {
+			p.count(func() { p.status.Skipped++ })
+			continue
+		}
+		if _, err := os.Stat(song.FilePath); errors.Is(err, os.ErrNotExist) {
+			p.count(func() { p.status.Missing++ })
+			continue
+		}
+
+		file, err := downloader.ProbeSongFile(song.FilePath)
+		if err != nil {
+			p.addError(fmt.Errorf("song %d (%s): %w", song.ID, song.FilePath, err))
+			continue
+		}
+		if err := p.db.UpdateSongFile(ctx, song.ID, file); err != nil {
+			p.addError(fmt.Errorf("song %d: %w", song.ID, err))
+			continue
+		}
+		p.count(func() { p.status.Probed++ })
+	}
+	return nil
+}
+
+// probed tells if the row already has what a probe gives
+func probed(song *store.Song) bool {
+	return song.FileSize > 0 && song.Bitrate > 0 && song.Format != "" && song.DurationMs > 0
+}
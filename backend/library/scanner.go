@@ -0,0 +1,267 @@
+// Package library imports the audio files already on disk as 'local' songs
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"cryogon/rizumu-backend/downloader"
+	"cryogon/rizumu-backend/store"
+)
+
+// audioExts are the files worth probing, anything else in the folders is skipped
+var audioExts = map[string]bool{
+	".mp3":  true,
+	".flac": true,
+	".ogg":  true,
+	".opus": true,
+	".m4a":  true,
+	".aac":  true,
+	".wav":  true,
+	".wma":  true,
+}
+
+var ErrScanRunning = errors.New("a library scan is already running")
+
+// ScanStatus is a snapshot of the last (or current) scan
+type ScanStatus struct {
+	JobStatus
+	Dirs     []string `json:"dirs"`
+	Scanned  int      `json:"scanned"`  // audio files found
+	Imported int      `json:"imported"` // new or changed files, probed again
+	Missing  int      `json:"missing"`  // files gone since the last scan
+}
+
+// Scanner walks the library folders and keeps the 'local' songs in sync
+// with them. Unchanged files (same size and mtime) are not probed again.
+type Scanner struct {
+	db   *store.Store
+	dirs []string
+
+	job
+	status ScanStatus
+
+	// a scan and the watcher don't update the songs at the same time
+	applyMu sync.Mutex
+}
+
+// NewScanner creates a scanner of dirs. Relative paths are resolved now, so
+// the songs keep working if the working directory changes.
+func NewScanner(db *store.Store, dirs []string) *Scanner {
+	var abs []string
+	for _, dir := range dirs {
+		if strings.TrimSpace(dir) == "" {
+			continue
+		}
+		path, err := filepath.Abs(dir)
+		if err != nil {
+			log.Printf("[Library] WARN: Ignoring library dir %q: %v", dir, err)
+			continue
+		}
+		abs = append(abs, path)
+	}
+
+	s := &Scanner{db: db, dirs: abs, status: ScanStatus{Dirs: abs}}
+	s.job = job{
+		errRunning: ErrScanRunning,
+		start:      fmt.Sprintf("Scanning %d folders", len(abs)),
+		work:       s.scan,
+		base:       func() *JobStatus { return &s.status.JobStatus },
+		summary: func() string {
+			return fmt.Sprintf("Scan finished: %d files, %d imported, %d missing",
+				s.status.Scanned, s.status.Imported, s.status.Missing)
+		},
+	}
+	return s
+}
+
+// Dirs are the library folders, absolute
+func (s *Scanner) Dirs() []string {
+	return slices.Clone(s.dirs)
+}
+
+// Status returns a copy of the current state
+func (s *Scanner) Status() ScanStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.status
+	st.Dirs = slices.Clone(s.status.Dirs)
+	st.Errors = slices.Clone(s.status.Errors)
+	return st
+}
+
+// Trigger starts a scan in the background. Returns false if one is running.
+func (s *Scanner) Trigger() bool {
+	return s.trigger(s.reset)
+}
+
+// Scan runs a scan and waits for it
+func (s *Scanner) Scan(ctx context.Context) error {
+	return s.runNow(ctx, s.reset)
+}
+
+func (s *Scanner) reset() {
+	s.status = ScanStatus{Dirs: s.dirs}
+}
+
+func (s *Scanner) scan(ctx context.Context) error {
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+
+	known, err := s.db.GetLocalFiles(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load local files: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	var walked []string // dirs we could read, only their files can be missing
+
+	for _, dir := range s.dirs {
+		if _, err := os.Stat(dir); err != nil {
+			// an unmounted drive must not mark its whole content missing
+			s.addError(fmt.Errorf("skipping %s: %w", dir, err))
+			continue
+		}
+
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				s.addError(err)
+				if d != nil && d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			if d.IsDir() {
+				if path != dir && strings.HasPrefix(d.Name(), ".") {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() || !IsAudioFile(path) {
+				return nil
+			}
+			if isHidden(path, dir) {
+				return nil
+			}
+
+			seen[path] = true
+			s.count(func() { s.status.Scanned++ })
+
+			info, err := d.Info()
+			if err != nil {
+				s.addError(err)
+				return nil
+			}
+			if f := known[path]; f != nil && f.Status != "Missing" && unchanged(f, info) {
+				return nil
+			}
+
+			if _, err := s.importFile(ctx, path, info); err != nil {
+				s.addError(fmt.Errorf("%s: %w", path, err))
+				return nil
+			}
+			s.count(func() { s.status.Imported++ })
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		walked = append(walked, dir)
+	}
+
+	var missing []int64
+	for path, f := range known {
+		if seen[path] || f.Status == "Missing" || !inDirs(path, walked) {
+			continue
+		}
+		missing = append(missing, f.SongID)
+	}
+	if err := s.db.MarkSongsMissing(ctx, missing); err != nil {
+		return fmt.Errorf("failed to mark missing files: %w", err)
+	}
+	s.count(func() { s.status.Missing = len(missing) })
+	return nil
+}
+
+// importFile probes the file and saves it as a local song with its credits
+func (s *Scanner) importFile(ctx context.Context, path string, info fs.FileInfo) (int64, error) {
+	meta, err := downloader.ProbeFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	song := &store.Song{
+		Title:      meta.Title,
+		Artist:     meta.Artist,
+		Album:      meta.Album,
+		DurationMs: meta.DurationMs,
+		FilePath:   path,
+		FileSize:   meta.Size,
+		Bitrate:    meta.Bitrate,
+		Format:     meta.Format,
+	}
+	if song.Artist == "" {
+		song.Artist = "Unknown"
+	}
+
+	id, err := s.db.SaveLocalSong(ctx, song, info.ModTime().UnixNano())
+	if err != nil {
+		return 0, err
+	}
+
+	credits := downloader.SplitArtists(meta.Artist)
+	var album *store.Album
+	if meta.Album != "" {
+		album = &store.Album{Title: meta.Album, ArtistName: song.Artist}
+		if len(credits) > 0 {
+			album.ArtistName = credits[0].Name
+		}
+	}
+	if err := s.db.SetSongCredits(ctx, id, credits, album); err != nil {
+		log.Printf("[Library] WARN: Failed to save artists of %s: %v", path, err)
+	}
+	return id, nil
+}
+
+// IsAudioFile tells by the extension if path is worth probing
+func IsAudioFile(path string) bool {
+	return audioExts[strings.ToLower(filepath.Ext(path))]
+}
+
+func unchanged(f *store.LocalFile, info fs.FileInfo) bool {
+	return f.Size == info.Size() && f.MTime == info.ModTime().UnixNano()
+}
+
+func inDirs(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		if strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isHidden tells if path, or a folder between it and root, is a dot file
+func isHidden(path, root string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	for part := range strings.SplitSeq(rel, string(filepath.Separator)) {
+		if strings.HasPrefix(part, ".") && part != "." && part != ".." {
+			return true
+		}
+	}
+	return false
+}
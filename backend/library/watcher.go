@@ -0,0 +1,233 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"cryogon/rizumu-backend/store"
+)
+
+var ErrWatchUnsupported = errors.New("watching the library is not supported on this platform")
+
+const (
+	// DefaultDebounce : a path is handled once it had no event for this long
+	DefaultDebounce = 2 * time.Second
+	// maxDebounceWait flushes anyway during a long copy, so the first
+	// files show up before the last one is written
+	maxDebounceWait = 30 * time.Second
+)
+
+// Watcher keeps the library in sync with the folders while the backend
+// runs. Changes are collected per path and applied after a quiet period,
+// so copying an album probes each file once, not on every write.
+type Watcher struct {
+	scanner  *Scanner
+	debounce time.Duration
+
+	mu         sync.Mutex
+	pending    map[string]struct{} // paths that changed, file or folder
+	timer      *time.Timer
+	firstEvent time.Time // of the pending batch
+
+	stop func() error // set by start, platform specific
+}
+
+func NewWatcher(scanner *Scanner, debounce time.Duration) *Watcher {
+	if debounce <= 0 {
+		debounce = DefaultDebounce
+	}
+	return &Watcher{
+		scanner:  scanner,
+		debounce: debounce,
+		pending:  make(map[string]struct{}),
+	}
+}
+
+// Start watches the scanner's folders in the background
+func (w *Watcher) Start() error {
+	stop, err := w.start(w.scanner.Dirs())
+	if err != nil {
+		return err
+	}
+	w.stop = stop
+	log.Printf("[Library] Watching %d folders", len(w.scanner.Dirs()))
+	return nil
+}
+
+func (w *Watcher) Close() error {
+	w.mu.Lock()
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.mu.Unlock()
+
+	if w.stop == nil {
+		return nil
+	}
+	return w.stop()
+}
+
+// changed queues path (a file or a folder, that may not exist anymore)
+func (w *Watcher) changed(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now()
+	if len(w.pending) == 0 {
+		w.firstEvent = now
+	}
+	w.pending[path] = struct{}{}
+
+	delay := w.debounce
+	if waited := now.Sub(w.firstEvent); waited+delay > maxDebounceWait {
+		delay = max(maxDebounceWait-waited, 0)
+	}
+	if w.timer == nil {
+		w.timer = time.AfterFunc(delay, w.flush)
+	} else {
+		w.timer.Reset(delay)
+	}
+}
+
+func (w *Watcher) flush() {
+	w.mu.Lock()
+	paths := make([]string, 0, len(w.pending))
+	for path := range w.pending {
+		paths = append(paths, path)
+	}
+	w.pending = make(map[string]struct{})
+	w.mu.Unlock()
+
+	if len(paths) == 0 {
+		return
+	}
+	if err := w.apply(context.Background(), paths); err != nil {
+		log.Printf("[Library] WARN: Failed to apply %d changes: %v", len(paths), err)
+	}
+}
+
+type addedFile struct {
+	path string
+	info fs.FileInfo
+}
+
+// apply brings the songs in line with what is on disk at paths. A file
+// that disappeared while one with the same size and mtime appeared was
+// moved: the song follows it instead of being imported again.
+func (w *Watcher) apply(ctx context.Context, paths []string) error {
+	s := w.scanner
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+
+	known, err := s.db.GetLocalFiles(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load local files: %w", err)
+	}
+
+	var added []addedFile
+	gone := make(map[int64]*store.LocalFile)
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			// a file, or a whole folder, was deleted or moved away
+			for p, f := range known {
+				if f.Status != "Missing" && (p == path || strings.HasPrefix(p, path+string(filepath.Separator))) {
+					gone[f.SongID] = f
+				}
+			}
+			continue
+		}
+		if err != nil {
+			log.Printf("[Library] WARN: %v", err)
+			continue
+		}
+
+		if !info.IsDir() {
+			if w.wanted(path) && needsImport(known[path], info) {
+				added = append(added, addedFile{path, info})
+			}
+			continue
+		}
+
+		// a folder was created or moved in, take what's inside
+		_ = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || !d.Type().IsRegular() || !w.wanted(p) {
+				return nil
+			}
+			if fi, err := d.Info(); err == nil && needsImport(known[p], fi) {
+				added = append(added, addedFile{p, fi})
+			}
+			return nil
+		})
+	}
+
+	renamed, imported := 0, 0
+	for _, a := range added {
+		if f := matchMoved(gone, a.info); f != nil {
+			if err := s.db.RenameLocalSong(ctx, f.SongID, a.path, a.info.ModTime().UnixNano()); err == nil {
+				log.Printf("[Library] Moved %s -> %s", f.Path, a.path)
+				delete(gone, f.SongID)
+				renamed++
+				continue
+			}
+			// the new path already has its own song, keep that one
+		}
+
+		if _, err := s.importFile(ctx, a.path, a.info); err != nil {
+			log.Printf("[Library] WARN: Failed to import %s: %v", a.path, err)
+			continue
+		}
+		imported++
+	}
+
+	var missing []int64
+	for id, f := range gone {
+		log.Printf("[Library] Missing %s", f.Path)
+		missing = append(missing, id)
+	}
+	if err := s.db.MarkSongsMissing(ctx, missing); err != nil {
+		return fmt.Errorf("failed to mark missing files: %w", err)
+	}
+
+	if imported+renamed+len(missing) > 0 {
+		log.Printf("[Library] Applied changes: %d imported, %d moved, %d missing", imported, renamed, len(missing))
+	}
+	return nil
+}
+
+// wanted tells if path is an audio file inside a library folder, not hidden
+func (w *Watcher) wanted(path string) bool {
+	if !IsAudioFile(path) {
+		return false
+	}
+	for _, dir := range w.scanner.dirs {
+		if inDirs(path, []string{dir}) {
+			return !isHidden(path, dir)
+		}
+	}
+	return false
+}
+
+func needsImport(f *store.LocalFile, info fs.FileInfo) bool {
+	return f == nil || f.Status == "Missing" || !unchanged(f, info)
+}
+
+// matchMoved finds the gone file that info was moved from. A move keeps
+// the size and the mtime (to the nanosecond), a copy or a new file doesn't.
+func matchMoved(gone map[int64]*store.LocalFile, info fs.FileInfo) *store.LocalFile {
+	for _, f := range gone {
+		if unchanged(f, info) {
+			return f
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+//go:build linux
+
+package library
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// file writes are picked up once closed, folders as soon as they appear so
+// their content gets watched too
+const watchMask = syscall.IN_CLOSE_WRITE | syscall.IN_CREATE | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF
+
+// inotify watches every folder of the library, inotify isn't recursive
+type inotify struct {
+	file *os.File
+
+	mu    sync.Mutex
+	fd    int
+	paths map[int]string // by watch descriptor
+}
+
+func (w *Watcher) start(dirs []string) (func() error, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	in := &inotify{
+		// non blocking, so Close wakes up a Read in progress
+		file:  os.NewFile(uintptr(fd), "inotify"),
+		fd:    fd,
+		paths: make(map[int]string),
+	}
+
+	for _, dir := range dirs {
+		in.addTree(dir)
+	}
+
+	go in.read(w)
+	return in.file.Close, nil
+}
+
+// addTree watches dir and the folders under it, hidden ones aside
+func (in *inotify) addTree(dir string) {
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		if path != dir && strings.HasPrefix(d.Name(), ".") {
+			return fs.SkipDir
+		}
+
+		wd, err := syscall.InotifyAddWatch(in.fd, path, watchMask)
+		if err != nil {
+			// usually fs.inotify.max_user_watches, the next scan still sees it
+			log.Printf("[Library] WARN: Can't watch %s: %v", path, err)
+			return nil
+		}
+		in.mu.Lock()
+		in.paths[wd] = path
+		in.mu.Unlock()
+		return nil
+	})
+}
+
+func (in *inotify) read(w *Watcher) {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := in.file.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				log.Printf("[Library] WARN: Watcher stopped: %v", err)
+			}
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(ev.Len)]
+			offset += syscall.SizeofInotifyEvent + int(ev.Len)
+
+			in.handle(w, ev, strings.TrimRight(string(nameBytes), "\x00"))
+		}
+	}
+}
+
+func (in *inotify) handle(w *Watcher, ev *syscall.InotifyEvent, name string) {
+	if ev.Mask&syscall.IN_Q_OVERFLOW != 0 {
+		// events were dropped, only a full scan can tell what changed
+		log.Println("[Library] WARN: Watcher overflowed, rescanning")
+		w.scanner.Trigger()
+		return
+	}
+
+	in.mu.Lock()
+	dir, ok := in.paths[int(ev.Wd)]
+	if ev.Mask&syscall.IN_IGNORED != 0 {
+		// the folder is gone, its watch too
+		delete(in.paths, int(ev.Wd))
+	}
+	in.mu.Unlock()
+	if !ok || name == "" {
+		return
+	}
+
+	path := filepath.Join(dir, name)
+	if ev.Mask&syscall.IN_ISDIR != 0 && ev.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+		if strings.HasPrefix(name, ".") {
+			return
+		}
+		in.addTree(path)
+		w.changed(path)
+		return
+	}
+	if ev.Mask&syscall.IN_CREATE != 0 {
+		// a new file, wait for IN_CLOSE_WRITE
+		return
+	}
+	w.changed(path)
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package library
+
+// start : no inotify here, the library is only synced by scans
+func (w *Watcher) start(dirs []string) (func() error, error) {
+	return nil, ErrWatchUnsupported
+}
@@ -2,16 +2,24 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"cryogon/rizumu-backend/downloader"
+	"cryogon/rizumu-backend/fingerprint"
 	"cryogon/rizumu-backend/httpd"
 	"cryogon/rizumu-backend/ipc"
+	"cryogon/rizumu-backend/library"
 	"cryogon/rizumu-backend/player"
 	"cryogon/rizumu-backend/spotify"
 	"cryogon/rizumu-backend/store"
+	"cryogon/rizumu-backend/tokens"
 
 	"github.com/joho/godotenv"
 )
@@ -30,29 +38,237 @@ func main() {
 		log.Fatal("FATAL: SPOTIFY_ID and SPOTIFY_SECRET must be set")
 	}
 
-	db, err := store.NewSQLiteStore("rizumu.db")
+	keys, err := loadKeyring()
+	if err != nil {
+		log.Fatalf("Failed to load master key: %v", err)
+	}
+
+	db, err := store.NewSQLiteStore("rizumu.db", keys)
 	if err != nil {
 		log.Fatalf("Failed to open database: %v", err)
 	}
 
+	if os.Getenv("RIZUMU_ROTATE_DATA_KEY") == "1" {
+		if err := db.RotateDataKey(context.Background()); err != nil {
+			log.Fatalf("Failed to rotate data key: %v", err)
+		}
+		log.Println("Rotated data key. Remove RIZUMU_ROTATE_DATA_KEY from the env.")
+	}
+
 	if err := db.ResetStuckDownloads(context.Background()); err != nil {
 		log.Printf("WARN: Failed to reset stuck downloads: %v", err)
 	}
 
-	dlSvc := downloader.NewService(db)
+	go spotify.BackfillCredits(context.Background(), db)
+
+	dlSvc := downloader.NewService(db, loadDownloadConfig())
+	if _, err := dlSvc.CanonicalizeProviderIDs(context.Background()); err != nil {
+		log.Printf("WARN: Failed to rewrite URL provider IDs: %v", err)
+	}
+	if err := dlSvc.ResumeTasks(context.Background()); err != nil {
+		log.Printf("WARN: Failed to resume download tasks: %v", err)
+	}
+	// a budget lowered since the last run applies right away, and pinned
+	// playlists get back what they're missing
+	go func() {
+		if _, err := dlSvc.EnforceQuota(context.Background()); err != nil {
+			log.Printf("WARN: Failed to enforce the cache budget: %v", err)
+		}
+		if _, err := dlSvc.DownloadPinned(context.Background(), 0); err != nil {
+			log.Printf("WARN: Failed to queue pinned playlists: %v", err)
+		}
+	}()
+	// e.g. LIBRARY_DIRS=/home/me/Music:/mnt/music, existing files are imported as 'local' songs
+	scanner := library.NewScanner(db, filepath.SplitList(os.Getenv("LIBRARY_DIRS")))
+	if len(scanner.Dirs()) > 0 {
+		scanner.Trigger()
+
+		// new, moved and deleted files are picked up live (Linux only)
+		if err := library.NewWatcher(scanner, library.DefaultDebounce).Start(); err != nil {
+			log.Printf("WARN: Not watching the library folders: %v", err)
+		}
+	}
+
 	spotifyClient := spotify.NewClient(spotifyClientID, spotifyClientSecret)
 
+	tokenMgr := tokens.NewManager(db)
+	tokenMgr.Register("spotify", spotifyClient.OAuthConfig())
+
+	// e.g. SPOTIFY_SYNC_INTERVAL=6h, empty disables scheduled sync
+	var syncInterval time.Duration
+	if v := os.Getenv("SPOTIFY_SYNC_INTERVAL"); v != "" {
+		syncInterval, err = time.ParseDuration(v)
+		if err != nil {
+			log.Printf("WARN: Invalid SPOTIFY_SYNC_INTERVAL %q: %v", v, err)
+		}
+	}
+	syncer := spotify.NewSyncer(spotifyClient, tokenMgr, db, 1, syncInterval)
+	// songs a sync added to pinned playlists are downloaded right after it
+	syncer.OnUpdate(func(status spotify.SyncStatus) {
+		if status.Running || status.FinishedAt == nil {
+			return
+		}
+		go func() {
+			if _, err := dlSvc.DownloadPinned(context.Background(), 0); err != nil {
+				log.Printf("WARN: Failed to queue pinned playlists: %v", err)
+			}
+		}()
+	})
+	syncer.Start()
+
 	musicPlayer := player.NewPlayer(dlSvc, db)
 
-	ipcHandler := ipc.NewIPCHandler(musicPlayer, *db)
+	ipcHandler := ipc.NewIPCHandler(musicPlayer, syncer, dlSvc, db)
 
 	// start ipc server on different thread
 	go ipcHandler.Init()
 
-	router := httpd.NewRouter(dlSvc, spotifyClient, db, musicPlayer)
+	router := httpd.NewRouter(dlSvc, scanner, spotifyClient, syncer, tokenMgr, db, musicPlayer)
 
 	log.Println("Server listening on :8080")
 	if err := http.ListenAndServe(":8080", router); err != nil {
 		log.Fatalf("Server failed to start: %v", err)
 	}
 }
+
+// loadDownloadConfig : DOWNLOAD_WORKERS=4 and DOWNLOAD_SOURCE_LIMITS="youtube=3,osu!=1"
+// override the defaults
+func loadDownloadConfig() downloader.Config {
+	cfg := downloader.DefaultConfig()
+
+	if v := os.Getenv("DOWNLOAD_WORKERS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			log.Printf("WARN: Invalid DOWNLOAD_WORKERS %q: %v", v, err)
+		} else {
+			cfg.Workers = n
+		}
+	}
+
+	// e.g. LIBRARY_ROOT=/mnt/music/rizumu, defaults to $XDG_DATA_HOME/rizumu.
+	// LIBRARY_TEMPLATE="{artist}/{album}/{track} - {title}.{ext}" names the
+	// files under LIBRARY_ROOT/songs, POST /library/reorganize applies a
+	// change to the existing ones.
+	if v := os.Getenv("LIBRARY_ROOT"); v != "" {
+		root, err := filepath.Abs(v)
+		if err != nil {
+			log.Printf("WARN: Invalid LIBRARY_ROOT %q: %v", v, err)
+		} else {
+			cfg.Layout.Root = root
+		}
+	}
+	if v := os.Getenv("LIBRARY_TEMPLATE"); v != "" {
+		cfg.Layout.Template = v
+	}
+
+	// e.g. DOWNLOAD_QUALITY=original keeps YouTube's opus, a download can
+	// still ask for another one
+	if v := os.Getenv("DOWNLOAD_QUALITY"); v != "" {
+		q, err := downloader.ParseQuality(v)
+		if err != nil {
+			log.Printf("WARN: Invalid DOWNLOAD_QUALITY %q: %v", v, err)
+		} else {
+			cfg.Quality = q
+		}
+	}
+
+	// e.g. CACHE_BUDGET_GB=20, the least recently played downloads are
+	// deleted past it (favorites and pinned playlists are kept)
+	if v := os.Getenv("CACHE_BUDGET_GB"); v != "" {
+		gb, err := strconv.ParseFloat(v, 64)
+		if err == nil && gb < 0 {
+			err = errors.New("negative budget")
+		}
+		if err != nil {
+			log.Printf("WARN: Invalid CACHE_BUDGET_GB %q: %v", v, err)
+		} else {
+			cfg.CacheBudget = int64(gb * (1 << 30))
+		}
+	}
+
+	// downloads that come without metadata are named from their fingerprint
+	if v := os.Getenv("ACOUSTID_API_KEY"); v != "" {
+		cfg.Lookup = fingerprint.NewAcoustID(v)
+	}
+
+	// e.g. DOWNLOAD_JOB_TIMEOUT=10m, a hung yt-dlp is killed after that
+	if v := os.Getenv("DOWNLOAD_JOB_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Printf("WARN: Invalid DOWNLOAD_JOB_TIMEOUT %q: %v", v, err)
+		} else {
+			cfg.JobTimeout = d
+		}
+	}
+
+	if v := os.Getenv("DOWNLOAD_MAX_ATTEMPTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			log.Printf("WARN: Invalid DOWNLOAD_MAX_ATTEMPTS %q: %v", v, err)
+		} else {
+			cfg.MaxAttempts = n
+		}
+	}
+
+	// first retry delay, doubled on every attempt
+	if v := os.Getenv("DOWNLOAD_RETRY_DELAY"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Printf("WARN: Invalid DOWNLOAD_RETRY_DELAY %q: %v", v, err)
+		} else {
+			cfg.RetryDelay = d
+		}
+	}
+
+	for _, pair := range strings.Split(os.Getenv("DOWNLOAD_SOURCE_LIMITS"), ",") {
+		source, limit, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			log.Printf("WARN: Invalid limit for %s in DOWNLOAD_SOURCE_LIMITS: %v", source, err)
+			continue
+		}
+		cfg.SourceLimits[source] = n
+	}
+
+	return cfg
+}
+
+// loadKeyring reads the master key that encrypts tokens and cookies at rest.
+// RIZUMU_MASTER_KEY (base64, 32 bytes) wins over the key file at
+// RIZUMU_KEY_FILE (default: rizumu.key, generated on first run).
+// To rotate, set the new key and list the old ones in
+// RIZUMU_PREVIOUS_MASTER_KEYS (comma separated, base64).
+func loadKeyring() (*store.Keyring, error) {
+	var primary []byte
+	var err error
+
+	if v := os.Getenv("RIZUMU_MASTER_KEY"); v != "" {
+		primary, err = store.DecodeKey(v)
+	} else {
+		keyFile := os.Getenv("RIZUMU_KEY_FILE")
+		if keyFile == "" {
+			keyFile = "rizumu.key"
+		}
+		primary, err = store.ReadOrCreateKeyFile(keyFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var previous [][]byte
+	for _, v := range strings.Split(os.Getenv("RIZUMU_PREVIOUS_MASTER_KEYS"), ",") {
+		if strings.TrimSpace(v) == "" {
+			continue
+		}
+		key, err := store.DecodeKey(v)
+		if err != nil {
+			return nil, err
+		}
+		previous = append(previous, key)
+	}
+
+	return store.NewKeyring(primary, previous...)
+}
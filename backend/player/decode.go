@@ -0,0 +1,91 @@
+package player
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/gopxl/beep"
+	"github.com/gopxl/beep/flac"
+	"github.com/gopxl/beep/mp3"
+	"github.com/gopxl/beep/vorbis"
+	"github.com/gopxl/beep/wav"
+)
+
+// decoders by extension. Ogg is usually Vorbis (osu! beatmaps), an Ogg Opus
+// file fails here and goes through ffmpeg like the rest.
+var decoders = map[string]func(f *os.File) (beep.StreamSeekCloser, beep.Format, error){
+	".mp3":  func(f *os.File) (beep.StreamSeekCloser, beep.Format, error) { return mp3.Decode(f) },
+	".flac": func(f *os.File) (beep.StreamSeekCloser, beep.Format, error) { return flac.Decode(f) },
+	".ogg":  func(f *os.File) (beep.StreamSeekCloser, beep.Format, error) { return vorbis.Decode(f) },
+	".oga":  func(f *os.File) (beep.StreamSeekCloser, beep.Format, error) { return vorbis.Decode(f) },
+	".wav":  func(f *os.File) (beep.StreamSeekCloser, beep.Format, error) { return wav.Decode(f) },
+}
+
+// decode opens an audio file for playback. Formats without a decoder in Go
+// (opus, m4a, wma...) are converted to WAV by ffmpeg first.
+func decode(path string) (beep.StreamSeekCloser, beep.Format, error) {
+	if dec, ok := decoders[strings.ToLower(filepath.Ext(path))]; ok {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, beep.Format{}, err
+		}
+		streamer, format, err := dec(f)
+		if err == nil {
+			return streamer, format, nil
+		}
+		f.Close()
+		if _, lookErr := exec.LookPath("ffmpeg"); lookErr != nil {
+			return nil, beep.Format{}, fmt.Errorf("decode %s: %w", filepath.Base(path), err)
+		}
+	}
+	return decodeWithFFmpeg(path)
+}
+
+// tempStreamer removes the converted file once closed
+type tempStreamer struct {
+	beep.StreamSeekCloser
+	path string
+}
+
+func (s *tempStreamer) Close() error {
+	err := s.StreamSeekCloser.Close()
+	os.Remove(s.path)
+	return err
+}
+
+// decodeWithFFmpeg converts the whole file to a temporary WAV, unlike a
+// pipe it can be seeked in
+func decodeWithFFmpeg(path string) (beep.StreamSeekCloser, beep.Format, error) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return nil, beep.Format{}, fmt.Errorf("can't play %s without ffmpeg", filepath.Ext(path))
+	}
+
+	tmp, err := os.CreateTemp("", "rizumu-*.wav")
+	if err != nil {
+		return nil, beep.Format{}, err
+	}
+	tmp.Close()
+
+	output, err := exec.Command("ffmpeg", "-v", "error", "-nostdin", "-y", "-i", path,
+		"-map", "0:a:0", "-c:a", "pcm_s16le", "-f", "wav", tmp.Name()).CombinedOutput()
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, beep.Format{}, fmt.Errorf("ffmpeg %s: %s: %w", filepath.Base(path), strings.TrimSpace(string(output)), err)
+	}
+
+	f, err := os.Open(tmp.Name())
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, beep.Format{}, err
+	}
+	streamer, format, err := wav.Decode(f)
+	if err != nil {
+		// wav.Decode closes f on errors
+		os.Remove(tmp.Name())
+		return nil, beep.Format{}, err
+	}
+	return &tempStreamer{StreamSeekCloser: streamer, path: tmp.Name()}, format, nil
+}
@@ -0,0 +1,91 @@
+package player
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gopxl/beep"
+	"github.com/gopxl/beep/generators"
+	"github.com/gopxl/beep/wav"
+)
+
+// writeTone writes a second of a 440 Hz sine at rate as WAV
+func writeTone(t *testing.T, path string, rate beep.SampleRate) {
+	t.Helper()
+	tone, err := generators.SineTone(rate, 440)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	format := beep.Format{SampleRate: rate, NumChannels: 2, Precision: 2}
+	if err := wav.Encode(f, beep.Take(rate.N(time.Second), tone), format); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tone.WAV")
+	writeTone(t, path, 48000)
+
+	streamer, format, err := decode(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer streamer.Close()
+	if format.SampleRate != 48000 || streamer.Len() != 48000 {
+		t.Errorf("rate %d, %d samples", format.SampleRate, streamer.Len())
+	}
+	if err := streamer.Seek(24000); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDecodeWithoutFFmpeg(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	dir := t.TempDir()
+
+	// a format only ffmpeg decodes
+	opus := filepath.Join(dir, "song.opus")
+	writeTone(t, opus, 48000)
+	if _, _, err := decode(opus); err == nil || !strings.Contains(err.Error(), "ffmpeg") {
+		t.Errorf("decoding opus without ffmpeg: %v", err)
+	}
+
+	// not what its extension says
+	mp3 := filepath.Join(dir, "song.mp3")
+	writeTone(t, mp3, 44100)
+	if _, _, err := decode(mp3); err == nil {
+		t.Error("a WAV named .mp3 decoded as mp3")
+	}
+}
+
+func TestDecodeWithFFmpeg(t *testing.T) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg not installed")
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "song.m4a")
+	writeTone(t, path, 44100)
+
+	streamer, format, err := decode(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format.SampleRate != 44100 || streamer.Len() != 44100 {
+		t.Errorf("rate %d, %d samples", format.SampleRate, streamer.Len())
+	}
+	tmp := streamer.(*tempStreamer).path
+	streamer.Close()
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Errorf("%s left behind", tmp)
+	}
+}
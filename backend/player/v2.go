@@ -12,14 +12,27 @@ import (
 	"cryogon/rizumu-backend/store"
 
 	"github.com/gopxl/beep"
-	"github.com/gopxl/beep/mp3"
 	"github.com/gopxl/beep/speaker"
 )
 
+// PlayMode changes how each song of the queue is played
+type PlayMode string
+
+const (
+	ModeNormal PlayMode = "normal"
+	// ModePreview plays PreviewLength of each song from its preview point,
+	// like osu!'s song select
+	ModePreview PlayMode = "preview"
+)
+
+const PreviewLength = 20 * time.Second
+
 type Player struct {
+	mode       PlayMode
 	playlists  []store.Song
 	ctrl       *beep.Ctrl
 	format     beep.Format
+	rate       beep.SampleRate // of the speaker, set by the first song
 	streamer   beep.StreamSeekCloser
 	songIndex  int
 	store      *store.Store
@@ -29,6 +42,7 @@ type Player struct {
 
 func NewPlayer(downloader *downloader.Service, s *store.Store) *Player {
 	return &Player{
+		mode:       ModeNormal,
 		playlists:  []store.Song{},
 		songIndex:  -1,
 		store:      s,
@@ -37,6 +51,14 @@ func NewPlayer(downloader *downloader.Service, s *store.Store) *Player {
 	}
 }
 
+// SetMode applies to the songs loaded from now on
+func (p *Player) SetMode(mode PlayMode) {
+	if mode != ModePreview {
+		mode = ModeNormal
+	}
+	p.mode = mode
+}
+
 func (p *Player) AddSongs(songs []store.Song) {
 	p.playlists = append(p.playlists, songs...)
 }
@@ -55,36 +77,62 @@ func (p *Player) loadSong(songIndex int) error {
 	}
 
 	song := p.playlists[songIndex]
-	f, err := os.Open(song.FilePath)
+	streamer, format, err := decode(song.FilePath)
 	if err != nil {
 		return err
 	}
 
-	streamer, format, err := mp3.Decode(f)
-	if err != nil {
-		f.Close()
-		return err
-	}
-
 	p.streamer = streamer
 	p.format = format
 	p.songIndex = songIndex
 
 	if p.ctrl == nil {
-		speaker.Init(format.SampleRate, format.SampleRate.N(time.Second/10))
+		p.rate = format.SampleRate
+		speaker.Init(p.rate, p.rate.N(time.Second/10))
+	}
+
+	var playback beep.Streamer = p.streamer
+	if p.mode == ModePreview {
+		if err := p.streamer.Seek(previewStart(song, format, p.streamer.Len())); err != nil {
+			log.Printf("WARN: Failed to seek to the preview of %s: %v", song.Title, err)
+		}
+		playback = beep.Take(format.SampleRate.N(PreviewLength), p.streamer)
+	}
+	if format.SampleRate != p.rate {
+		playback = beep.Resample(4, format.SampleRate, p.rate, playback)
 	}
 
 	p.ctrl = &beep.Ctrl{
-		Streamer: beep.Seq(p.streamer, beep.Callback(func() {
+		Streamer: beep.Seq(playback, beep.Callback(func() {
 			p.onSongEnd()
 		})),
 	}
 
 	fmt.Printf("Loaded Song: %s\n", song.Title)
+	if err := p.store.MarkSongPlayed(context.Background(), song.ID); err != nil {
+		log.Printf("WARN: Failed to record play of %s: %v", song.Title, err)
+	}
 
 	return nil
 }
 
+// previewStart is the sample the preview starts at: the osu! PreviewTime,
+// or 40% into the song (usually past the intro) for other sources
+func previewStart(song store.Song, format beep.Format, length int) int {
+	var start time.Duration
+	if song.PreviewMs != nil {
+		start = time.Duration(*song.PreviewMs) * time.Millisecond
+	} else {
+		start = format.SampleRate.D(length) * 2 / 5
+	}
+
+	n := format.SampleRate.N(start)
+	if n >= length {
+		return 0
+	}
+	return n
+}
+
 func (p *Player) Play() {
 	if p.songIndex == -1 {
 		err := p.loadSong(0)
@@ -241,7 +289,7 @@ func (p *Player) prepareNextSong() {
 
 	song := p.playlists[nextIndex]
 
-	if song.FilePath != "" {
+	if hasFile(song) {
 		return
 	}
 
@@ -262,7 +310,7 @@ func (p *Player) prepareNextSong() {
 	}
 
 	// Check if we still have songs and if the next song already has a file
-	if nextIndex >= len(p.playlists) || p.playlists[nextIndex].FilePath != "" {
+	if nextIndex >= len(p.playlists) || hasFile(p.playlists[nextIndex]) {
 		return
 	}
 	song = p.playlists[nextIndex]
@@ -309,6 +357,16 @@ func (p *Player) prepareNextSong() {
 	}()
 }
 
+// hasFile tells if the song can be played as is. The queue keeps the songs
+// as they were when added, the cache quota may have evicted the file since.
+func hasFile(song store.Song) bool {
+	if song.FilePath == "" {
+		return false
+	}
+	_, err := os.Stat(song.FilePath)
+	return err == nil
+}
+
 func (p *Player) removeSongAndPrepareNext(index int) {
 	if index >= len(p.playlists) {
 		return
@@ -11,6 +11,7 @@ import (
 	"github.com/zmb3/spotify/v2"
 	auth "github.com/zmb3/spotify/v2/auth"
 	"golang.org/x/oauth2"
+	oauthSpotify "golang.org/x/oauth2/spotify" // Alias this to avoid collision
 )
 
 const redirectURL = "http://localhost:8080/auth/spotify/callback"
@@ -20,6 +21,7 @@ var scopes = []string{
 	auth.ScopePlaylistReadCollaborative,
 	auth.ScopeUserReadEmail,
 	auth.ScopeUserReadPrivate,
+	auth.ScopeUserLibraryRead,
 }
 
 type Client struct {
@@ -59,6 +61,17 @@ func (c *Client) ExchangeCode(r *http.Request, state string) (*oauth2.Token, err
 	return token, nil
 }
 
+// OAuthConfig is used by the token manager to refresh stored tokens
+func (c *Client) OAuthConfig() *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     c.ClientID,
+		ClientSecret: c.ClientSecret,
+		Endpoint:     oauthSpotify.Endpoint,
+		RedirectURL:  redirectURL,
+		Scopes:       scopes,
+	}
+}
+
 // NewClientFromToken creates a *real* Spotify client
 // that can make API calls (like getting playlists).
 func (c *Client) NewClientFromToken(token *oauth2.Token) *spotify.Client {
@@ -68,10 +81,17 @@ func (c *Client) NewClientFromToken(token *oauth2.Token) *spotify.Client {
 	return client
 }
 
+// NewClientFromTokenSource is like NewClientFromToken, but refreshes go
+// through ts (see tokens.Manager) so they get persisted
+func (c *Client) NewClientFromTokenSource(ts oauth2.TokenSource) *spotify.Client {
+	httpClient := oauth2.NewClient(context.Background(), ts)
+	return spotify.New(httpClient)
+}
+
 // GetUserPlaylists fetches the authenticated user's playlists
-func (c *Client) GetUserPlaylists(ctx context.Context, token *oauth2.Token) ([]spotify.SimplePlaylist, error) {
+func (c *Client) GetUserPlaylists(ctx context.Context, ts oauth2.TokenSource) ([]spotify.SimplePlaylist, error) {
 	// Create a client using the saved token
-	client := c.NewClientFromToken(token)
+	client := c.NewClientFromTokenSource(ts)
 
 	// Fetch playlists (fetching first 50 for now)
 	// In a real app, you'd handle pagination to get ALL of them.
@@ -84,8 +104,8 @@ func (c *Client) GetUserPlaylists(ctx context.Context, token *oauth2.Token) ([]s
 }
 
 // FetchTracksFromURL is used for the "Explosion" strategy (Manual Playlist Download)
-func (c *Client) FetchTracksFromURL(ctx context.Context, token *oauth2.Token, url string) ([]*store.Song, error) {
-	client := c.NewClientFromToken(token)
+func (c *Client) FetchTracksFromURL(ctx context.Context, ts oauth2.TokenSource, url string) ([]*store.Song, error) {
+	client := c.NewClientFromTokenSource(ts)
 
 	// Parse ID from URL
 	// Format: .../playlist/37i9dQZF1DXcBWIGoYBM5M?si=...
@@ -142,8 +162,8 @@ func (c *Client) FetchTracksFromURL(ctx context.Context, token *oauth2.Token, ur
 }
 
 // GetMetadata fetches a single track
-func (c *Client) GetMetadata(ctx context.Context, token *oauth2.Token, urlStr string) (*store.Song, error) {
-	client := c.NewClientFromToken(token)
+func (c *Client) GetMetadata(ctx context.Context, ts oauth2.TokenSource, urlStr string) (*store.Song, error) {
+	client := c.NewClientFromTokenSource(ts)
 
 	// Parse ID: https://open.spotify.com/track/12345?si=...
 	parts := strings.Split(urlStr, "/")
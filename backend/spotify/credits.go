@@ -0,0 +1,85 @@
+package spotify
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"cryogon/rizumu-backend/store"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+// LinkCredits stores every artist (not just the first one) and the album of a
+// song, read from the raw Spotify track JSON we keep in raw_metadata
+func LinkCredits(ctx context.Context, db *store.Store, songID int64, rawMetadata string) error {
+	if rawMetadata == "" {
+		return nil
+	}
+
+	var track spotify.FullTrack
+	if err := json.Unmarshal([]byte(rawMetadata), &track); err != nil {
+		return err
+	}
+
+	artists, album := creditsFromTrack(&track)
+	return db.SetSongCredits(ctx, songID, artists, album)
+}
+
+// BackfillCredits links artists/albums of songs synced before those tables existed
+func BackfillCredits(ctx context.Context, db *store.Store) {
+	songs, err := db.SongsMissingCredits(ctx, "spotify")
+	if err != nil {
+		log.Printf("WARN: Failed to list songs for credit backfill: %v", err)
+		return
+	}
+
+	linked := 0
+	for _, song := range songs {
+		if err := LinkCredits(ctx, db, song.ID, song.RawMetadata); err != nil {
+			log.Printf("WARN: Failed to link credits of song %d: %v", song.ID, err)
+			continue
+		}
+		linked++
+	}
+
+	if linked > 0 {
+		log.Printf("Backfilled artists/albums for %d songs", linked)
+	}
+}
+
+func creditsFromTrack(track *spotify.FullTrack) ([]store.SongArtist, *store.Album) {
+	artists := make([]store.SongArtist, 0, len(track.Artists))
+	for i, a := range track.Artists {
+		role := "featured"
+		if i == 0 {
+			role = "primary"
+		}
+		artists = append(artists, store.SongArtist{
+			Name:       a.Name,
+			ExternalID: string(a.ID),
+			Role:       role,
+		})
+	}
+
+	if track.Album.Name == "" {
+		return artists, nil
+	}
+
+	album := &store.Album{
+		Title:       track.Album.Name,
+		AlbumType:   track.Album.AlbumType,
+		ReleaseDate: track.Album.ReleaseDate,
+		TotalTracks: int(track.Album.TotalTracks),
+		ExternalID:  string(track.Album.ID),
+	}
+	if len(track.Album.Artists) > 0 {
+		album.ArtistName = track.Album.Artists[0].Name
+	} else if len(track.Artists) > 0 {
+		album.ArtistName = track.Artists[0].Name
+	}
+	if len(track.Album.Images) > 0 {
+		album.ImageURL = track.Album.Images[0].URL
+	}
+	return artists, album
+}
@@ -0,0 +1,208 @@
+package spotify
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"cryogon/rizumu-backend/store"
+	"cryogon/rizumu-backend/tokens"
+)
+
+// SyncStatus is a snapshot of the background sync job
+type SyncStatus struct {
+	Running         bool       `json:"running"`
+	StartedAt       *time.Time `json:"started_at,omitempty"`
+	FinishedAt      *time.Time `json:"finished_at,omitempty"`
+	NextRunAt       *time.Time `json:"next_run_at,omitempty"`
+	CurrentPlaylist string     `json:"current_playlist,omitempty"`
+	PlaylistsDone   int        `json:"playlists_done"`
+	PlaylistsTotal  int        `json:"playlists_total"`
+	LastResult      string     `json:"last_result,omitempty"` // 'success', 'partial', 'failed'
+	Errors          []string   `json:"errors,omitempty"`
+}
+
+// Syncer runs SyncAll in the background, either on a timer or on demand,
+// so a sync doesn't depend on the HTTP request that triggered it
+type Syncer struct {
+	client   *Client
+	tokens   *tokens.Manager
+	db       *store.Store
+	userID   int64
+	interval time.Duration
+
+	mu        sync.Mutex
+	status    SyncStatus
+	listeners []func(SyncStatus)
+}
+
+// NewSyncer creates the job. interval <= 0 disables the scheduled runs,
+// Trigger still works.
+func NewSyncer(client *Client, tokenMgr *tokens.Manager, db *store.Store, userID int64, interval time.Duration) *Syncer {
+	return &Syncer{
+		client:   client,
+		tokens:   tokenMgr,
+		db:       db,
+		userID:   userID,
+		interval: interval,
+	}
+}
+
+// Start kicks off the scheduler loop
+func (s *Syncer) Start() {
+	if s.interval <= 0 {
+		log.Println("[Sync] Scheduled sync disabled")
+		return
+	}
+
+	log.Printf("[Sync] Scheduled sync every %s", s.interval)
+	s.setNextRun()
+
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			s.Trigger()
+			s.setNextRun()
+		}
+	}()
+}
+
+// Trigger starts a sync unless one is already running.
+// Returns false if a sync was already in progress.
+func (s *Syncer) Trigger() bool {
+	s.mu.Lock()
+	if s.status.Running {
+		s.mu.Unlock()
+		return false
+	}
+
+	now := time.Now()
+	s.status.Running = true
+	s.status.StartedAt = &now
+	s.status.FinishedAt = nil
+	s.status.CurrentPlaylist = ""
+	s.status.PlaylistsDone = 0
+	s.status.PlaylistsTotal = 0
+	s.status.Errors = nil
+	s.mu.Unlock()
+
+	s.notify()
+	go s.run()
+	return true
+}
+
+// Status returns a copy of the current state
+func (s *Syncer) Status() SyncStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.snapshot()
+}
+
+// OnUpdate registers fn to be called every time the status changes
+func (s *Syncer) OnUpdate(fn func(SyncStatus)) {
+	s.mu.Lock()
+	s.listeners = append(s.listeners, fn)
+	s.mu.Unlock()
+}
+
+func (s *Syncer) run() {
+	ctx := context.Background()
+	log.Println("[Sync] Starting Spotify Sync...")
+
+	ts, err := s.tokens.TokenSource(ctx, s.userID, "spotify")
+	if err == nil {
+		// Fail early if the refresh token is dead, instead of on every request
+		_, err = ts.Token()
+	}
+	if err != nil {
+		log.Printf("[Sync] Failed to get token: %v", err)
+		s.finish(fmt.Errorf("token: %w", err))
+		return
+	}
+
+	s.finish(s.client.syncAll(ctx, s.client.NewClientFromTokenSource(ts), s.db, s.userID, s))
+}
+
+func (s *Syncer) finish(err error) {
+	s.mu.Lock()
+	now := time.Now()
+	s.status.Running = false
+	s.status.FinishedAt = &now
+	s.status.CurrentPlaylist = ""
+
+	switch {
+	case err != nil:
+		s.status.Errors = append(s.status.Errors, err.Error())
+		s.status.LastResult = "failed"
+	case len(s.status.Errors) > 0:
+		s.status.LastResult = "partial"
+	default:
+		s.status.LastResult = "success"
+	}
+	log.Printf("[Sync] Finished: %s (%d errors)", s.status.LastResult, len(s.status.Errors))
+	s.mu.Unlock()
+
+	s.notify()
+}
+
+func (s *Syncer) setNextRun() {
+	s.mu.Lock()
+	next := time.Now().Add(s.interval)
+	s.status.NextRunAt = &next
+	s.mu.Unlock()
+}
+
+// snapshot must be called with mu held
+func (s *Syncer) snapshot() SyncStatus {
+	st := s.status
+	st.Errors = append([]string(nil), s.status.Errors...)
+	return st
+}
+
+func (s *Syncer) notify() {
+	s.mu.Lock()
+	st := s.snapshot()
+	listeners := append([]func(SyncStatus){}, s.listeners...)
+	s.mu.Unlock()
+
+	for _, fn := range listeners {
+		fn(st)
+	}
+}
+
+// syncReporter implementation
+
+func (s *Syncer) addTotal(n int) {
+	s.mu.Lock()
+	s.status.PlaylistsTotal += n
+	s.mu.Unlock()
+	s.notify()
+}
+
+func (s *Syncer) startPlaylist(name string) {
+	s.mu.Lock()
+	s.status.CurrentPlaylist = name
+	s.mu.Unlock()
+	s.notify()
+}
+
+func (s *Syncer) finishPlaylist(name string, err error) {
+	s.mu.Lock()
+	s.status.PlaylistsDone++
+	if err != nil {
+		s.status.Errors = append(s.status.Errors, fmt.Sprintf("%s: %v", name, err))
+	}
+	s.mu.Unlock()
+	s.notify()
+}
+
+func (s *Syncer) addError(err error) {
+	s.mu.Lock()
+	s.status.Errors = append(s.status.Errors, err.Error())
+	s.mu.Unlock()
+	s.notify()
+}
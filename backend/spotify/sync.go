@@ -3,7 +3,10 @@ package spotify
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"log"
+	"slices"
+	"strings"
 
 	"cryogon/rizumu-backend/store"
 
@@ -11,25 +14,53 @@ import (
 	"golang.org/x/oauth2"
 )
 
+// likedSongsID is the external_id of the playlist holding Spotify's Liked Songs
+const likedSongsID = "liked"
+
+// trackBatchSize is the max number of IDs GetAudioFeatures accepts per call
+const trackBatchSize = 50
+
+// syncReporter is told about the progress of a sync, see Syncer
+type syncReporter interface {
+	addTotal(n int)
+	startPlaylist(name string)
+	finishPlaylist(name string, err error)
+	addError(err error)
+}
+
+type noopReporter struct{}
+
+func (noopReporter) addTotal(int)                 {}
+func (noopReporter) startPlaylist(string)         {}
+func (noopReporter) finishPlaylist(string, error) {}
+func (noopReporter) addError(error)               {}
+
 // SyncAll fetches everything and saves it to the DB
 func (c *Client) SyncAll(ctx context.Context, token *oauth2.Token, db *store.Store, userID int64) error {
-	client := c.NewClientFromToken(token)
+	return c.syncAll(ctx, c.NewClientFromToken(token), db, userID, noopReporter{})
+}
 
+func (c *Client) syncAll(ctx context.Context, client *spotify.Client, db *store.Store, userID int64, report syncReporter) error {
 	// Fetch All User Playlists (Pagination loop)
 	playlists, err := client.CurrentUsersPlaylists(ctx)
 	if err != nil {
 		return err
 	}
 
+	// +1 for Liked Songs, albums are added once we know how many there are
+	report.addTotal(int(playlists.Total) + 1)
+
 	for {
 		for _, p := range playlists.Playlists {
 			log.Printf("Syncing Playlist: %s", p.Name)
+			report.startPlaylist(p.Name)
 
 			dbPlaylist := &store.Playlist{
 				UserID:     userID,
 				Name:       p.Name,
 				SourceType: "spotify",
 				ExternalID: string(p.ID),
+				Kind:       "playlist",
 			}
 			if len(p.Images) > 0 {
 				dbPlaylist.ImageURL = p.Images[0].URL
@@ -38,12 +69,15 @@ func (c *Client) SyncAll(ctx context.Context, token *oauth2.Token, db *store.Sto
 			pID, err := db.SavePlaylist(ctx, dbPlaylist)
 			if err != nil {
 				log.Printf("Error saving playlist %s: %v", p.Name, err)
+				report.finishPlaylist(p.Name, err)
 				continue
 			}
 
-			if err := c.syncPlaylistTracks(ctx, client, db, p.ID, pID); err != nil {
+			err = c.syncPlaylistTracks(ctx, client, db, p.ID, pID)
+			if err != nil {
 				log.Printf("Error syncing tracks for %s: %v", p.Name, err)
 			}
+			report.finishPlaylist(p.Name, err)
 		}
 
 		if err := client.NextPage(ctx, playlists); err != nil {
@@ -53,6 +87,19 @@ func (c *Client) SyncAll(ctx context.Context, token *oauth2.Token, db *store.Sto
 			return err
 		}
 	}
+
+	// Liked Songs and saved albums live outside CurrentUsersPlaylists
+	report.startPlaylist("Liked Songs")
+	err = c.syncLikedSongs(ctx, client, db, userID)
+	if err != nil {
+		log.Printf("Error syncing liked songs: %v", err)
+	}
+	report.finishPlaylist("Liked Songs", err)
+
+	if err := c.syncSavedAlbums(ctx, client, db, userID, report); err != nil {
+		log.Printf("Error syncing saved albums: %v", err)
+		report.addError(fmt.Errorf("saved albums: %w", err))
+	}
 	return nil
 }
 
@@ -64,54 +111,7 @@ func (c *Client) syncPlaylistTracks(ctx context.Context, client *spotify.Client,
 		return err
 	}
 
-	// We need 3 buckets for our batching logic:
-	var idsForAudio []spotify.ID                 // IDs to send to Spotify API
-	songsMap := make(map[spotify.ID]*store.Song) // Map to link IDs back to Song objects
-	var songsToSave []*store.Song                // The final list to save to DB (includes locals)
-
-	// Helper to process the current batch
-	processBatch := func() {
-		if len(songsToSave) == 0 {
-			return
-		}
-
-		// 1. Fetch Audio Features only for valid Spotify IDs
-		if len(idsForAudio) > 0 {
-			features, err := client.GetAudioFeatures(ctx, idsForAudio...)
-			if err != nil {
-				// WARN but don't stop. We just won't have BPM for this batch.
-				log.Printf("WARN: Failed to get audio features for batch of %d. Error: %v", len(idsForAudio), err)
-			} else {
-				// 2. Map features back to the songs
-				for _, f := range features {
-					if f == nil {
-						continue
-					}
-
-					if song, exists := songsMap[f.ID]; exists {
-						song.BPM = float64(f.Tempo)
-						song.Energy = float64(f.Energy)
-						song.Valence = float64(f.Valence)
-					}
-				}
-			}
-		}
-
-		// 3. Save ALL songs to DB (including local ones that we skipped analysis for)
-		for _, song := range songsToSave {
-			sID, err := db.SaveSong(ctx, song)
-			if err != nil {
-				log.Printf("Error saving song '%s': %v", song.Title, err)
-				continue
-			}
-			db.AddSongToPlaylist(ctx, dbPlaylistID, sID)
-		}
-
-		// 4. Clear buckets for next batch
-		idsForAudio = nil
-		songsToSave = nil
-		songsMap = make(map[spotify.ID]*store.Song)
-	}
+	batch := newTrackBatch(ctx, client, db, dbPlaylistID)
 
 	for {
 		for _, item := range tracks.Items {
@@ -122,55 +122,173 @@ func (c *Client) syncPlaylistTracks(ctx context.Context, client *spotify.Client,
 
 			fullTrack := item.Track.Track
 
-			rawJSON, _ := json.Marshal(fullTrack)
-
 			isAnalysisCandidate := true
 			if item.IsLocal || fullTrack.ID == "" || fullTrack.Type != "track" {
 				isAnalysisCandidate = false
 			}
 
-			provider := "spotify"
-			providerID := string(fullTrack.ID)
+			// (Note: We save ALL of them, even podcasts/local files)
+			batch.add(songFromTrack(fullTrack, item.IsLocal), fullTrack.ID, isAnalysisCandidate)
+		}
 
-			if item.IsLocal {
-				provider = "local"
-				providerID = string(fullTrack.URI)
+		// Next page of tracks
+		if err := client.NextPage(ctx, tracks); err != nil {
+			if err == spotify.ErrNoMorePages {
+				break
 			}
+			return err
+		}
+	}
 
-			// 3. Build Song Struct
-			// (Note: We save ALL of them, even podcasts/local files)
-			s := &store.Song{
-				Title:       fullTrack.Name,
-				Artist:      "Unknown Artist",
-				Album:       fullTrack.Album.Name,
-				DurationMs:  int64(fullTrack.Duration),
-				Provider:    provider,
-				ProviderID:  providerID,
-				RawMetadata: string(rawJSON),
+	// Process final partial batch
+	batch.flush()
+	return nil
+}
+
+// syncLikedSongs mirrors the user's Liked Songs into a dedicated playlist
+// and marks every song in it as a favorite. Songs unliked since the last
+// sync leave the playlist and aren't favorites anymore.
+func (c *Client) syncLikedSongs(ctx context.Context, client *spotify.Client, db *store.Store, userID int64) error {
+	log.Println("Syncing Liked Songs")
+
+	tracks, err := client.CurrentUsersTracks(ctx, spotify.Limit(50))
+	if err != nil {
+		return err
+	}
+
+	pID, err := db.SavePlaylist(ctx, &store.Playlist{
+		UserID:      userID,
+		Name:        "Liked Songs",
+		Description: "Your liked songs on Spotify",
+		SourceType:  "spotify",
+		ExternalID:  likedSongsID,
+		Kind:        "liked",
+	})
+	if err != nil {
+		return err
+	}
+
+	batch := newTrackBatch(ctx, client, db, pID)
+	batch.favorite = true
+
+	for {
+		for i := range tracks.Tracks {
+			fullTrack := &tracks.Tracks[i].FullTrack
+			if fullTrack.ID == "" {
+				continue
 			}
+			batch.add(songFromTrack(fullTrack, false), fullTrack.ID, true)
+		}
 
-			if len(fullTrack.Artists) > 0 {
-				s.Artist = fullTrack.Artists[0].Name
+		if err := client.NextPage(ctx, tracks); err != nil {
+			if err == spotify.ErrNoMorePages {
+				break
 			}
-			if len(fullTrack.Album.Images) > 0 {
-				s.ImageURL = fullTrack.Album.Images[0].URL
+			return err
+		}
+	}
+
+	batch.flush()
+	if batch.failed > 0 {
+		// a song missing from batch.saved isn't one that was unliked
+		return fmt.Errorf("%d liked songs couldn't be saved", batch.failed)
+	}
+
+	before, err := db.GetSongsByPlaylist(ctx, pID)
+	if err != nil {
+		return err
+	}
+	var liked []int64
+	for _, id := range batch.saved {
+		// two tracks merged into one song
+		if !slices.Contains(liked, id) {
+			liked = append(liked, id)
+		}
+	}
+	// newest first, as Spotify lists them
+	if err := db.SetPlaylistSongs(ctx, pID, liked); err != nil {
+		return err
+	}
+	unliked := 0
+	for _, song := range before {
+		if slices.Contains(liked, song.ID) {
+			continue
+		}
+		if err := db.SetSongFavorite(ctx, song.ID, false); err != nil {
+			log.Printf("WARN: Failed to unmark '%s' as favorite: %v", song.Title, err)
+			continue
+		}
+		unliked++
+	}
+	if unliked > 0 {
+		log.Printf("%d songs were unliked since the last sync", unliked)
+	}
+	return nil
+}
+
+// syncSavedAlbums imports every saved album as an album-type playlist
+func (c *Client) syncSavedAlbums(ctx context.Context, client *spotify.Client, db *store.Store, userID int64, report syncReporter) error {
+	albums, err := client.CurrentUsersAlbums(ctx, spotify.Limit(50))
+	if err != nil {
+		return err
+	}
+
+	report.addTotal(int(albums.Total))
+
+	for {
+		for _, a := range albums.Albums {
+			log.Printf("Syncing Album: %s", a.Name)
+			report.startPlaylist(a.Name)
+
+			dbPlaylist := &store.Playlist{
+				UserID:      userID,
+				Name:        a.Name,
+				Description: joinArtists(a.Artists),
+				SourceType:  "spotify",
+				ExternalID:  string(a.ID),
+				Kind:        "album",
+			}
+			if len(a.Images) > 0 {
+				dbPlaylist.ImageURL = a.Images[0].URL
 			}
 
-			//  Add to "Save List" (We save everything!)
-			songsToSave = append(songsToSave, s)
+			pID, err := db.SavePlaylist(ctx, dbPlaylist)
+			if err != nil {
+				log.Printf("Error saving album %s: %v", a.Name, err)
+				report.finishPlaylist(a.Name, err)
+				continue
+			}
+
+			err = c.syncAlbumTracks(ctx, client, db, &a.FullAlbum, pID)
+			if err != nil {
+				log.Printf("Error syncing tracks for album %s: %v", a.Name, err)
+			}
+			report.finishPlaylist(a.Name, err)
+		}
 
-			// Add to "Analysis List" (ONLY if it's a valid music track)
-			if isAnalysisCandidate {
-				idsForAudio = append(idsForAudio, fullTrack.ID)
-				songsMap[fullTrack.ID] = s
+		if err := client.NextPage(ctx, albums); err != nil {
+			if err == spotify.ErrNoMorePages {
+				break
 			}
+			return err
+		}
+	}
+	return nil
+}
+
+// syncAlbumTracks walks the (already embedded) track pages of a saved album
+func (c *Client) syncAlbumTracks(ctx context.Context, client *spotify.Client, db *store.Store, album *spotify.FullAlbum, dbPlaylistID int64) error {
+	tracks := &album.Tracks
+	batch := newTrackBatch(ctx, client, db, dbPlaylistID)
 
-			if len(songsToSave) >= 50 {
-				processBatch()
+	for {
+		for _, t := range tracks.Tracks {
+			if t.ID == "" {
+				continue
 			}
+			batch.add(songFromAlbumTrack(t, album.SimpleAlbum), t.ID, true)
 		}
 
-		// Next page of tracks
 		if err := client.NextPage(ctx, tracks); err != nil {
 			if err == spotify.ErrNoMorePages {
 				break
@@ -179,7 +297,146 @@ func (c *Client) syncPlaylistTracks(ctx context.Context, client *spotify.Client,
 		}
 	}
 
-	// Process final partial batch
-	processBatch()
+	batch.flush()
 	return nil
 }
+
+// trackBatch groups songs in batches of 50 so audio features can be fetched
+// in one request before the songs get saved and linked to a playlist
+type trackBatch struct {
+	ctx         context.Context
+	client      *spotify.Client
+	db          *store.Store
+	playlistID  int64
+	favorite    bool                       // mark saved songs as favorites (Liked Songs)
+	saved       []int64                    // the songs saved so far, in order
+	failed      int                        // songs that couldn't be saved
+	idsForAudio []spotify.ID               // IDs to send to Spotify API
+	songsMap    map[spotify.ID]*store.Song // Map to link IDs back to Song objects
+	songsToSave []*store.Song              // The final list to save to DB (includes locals)
+}
+
+func newTrackBatch(ctx context.Context, client *spotify.Client, db *store.Store, playlistID int64) *trackBatch {
+	return &trackBatch{
+		ctx:        ctx,
+		client:     client,
+		db:         db,
+		playlistID: playlistID,
+		songsMap:   make(map[spotify.ID]*store.Song),
+	}
+}
+
+// add queues a song. analyse=false skips audio features (local files, podcasts)
+func (b *trackBatch) add(song *store.Song, id spotify.ID, analyse bool) {
+	//  Add to "Save List" (We save everything!)
+	b.songsToSave = append(b.songsToSave, song)
+
+	// Add to "Analysis List" (ONLY if it's a valid music track)
+	if analyse {
+		b.idsForAudio = append(b.idsForAudio, id)
+		b.songsMap[id] = song
+	}
+
+	if len(b.songsToSave) >= trackBatchSize {
+		b.flush()
+	}
+}
+
+func (b *trackBatch) flush() {
+	if len(b.songsToSave) == 0 {
+		return
+	}
+
+	// 1. Fetch Audio Features only for valid Spotify IDs
+	if len(b.idsForAudio) > 0 {
+		features, err := b.client.GetAudioFeatures(b.ctx, b.idsForAudio...)
+		if err != nil {
+			// WARN but don't stop. We just won't have BPM for this batch.
+			log.Printf("WARN: Failed to get audio features for batch of %d. Error: %v", len(b.idsForAudio), err)
+		} else {
+			// 2. Map features back to the songs
+			for _, f := range features {
+				if f == nil {
+					continue
+				}
+
+				if song, exists := b.songsMap[f.ID]; exists {
+					song.BPM = float64(f.Tempo)
+					song.Energy = float64(f.Energy)
+					song.Valence = float64(f.Valence)
+				}
+			}
+		}
+	}
+
+	// 3. Save ALL songs to DB (including local ones that we skipped analysis for)
+	for _, song := range b.songsToSave {
+		sID, err := b.db.SaveSong(b.ctx, song)
+		if err != nil {
+			log.Printf("Error saving song '%s': %v", song.Title, err)
+			b.failed++
+			continue
+		}
+		b.saved = append(b.saved, sID)
+		b.db.AddSongToPlaylist(b.ctx, b.playlistID, sID)
+
+		if err := LinkCredits(b.ctx, b.db, sID, song.RawMetadata); err != nil {
+			log.Printf("WARN: Failed to link artists of '%s': %v", song.Title, err)
+		}
+
+		if b.favorite {
+			if err := b.db.SetSongFavorite(b.ctx, sID, true); err != nil {
+				log.Printf("WARN: Failed to mark '%s' as favorite: %v", song.Title, err)
+			}
+		}
+	}
+
+	// 4. Clear buckets for next batch
+	b.idsForAudio = nil
+	b.songsToSave = nil
+	b.songsMap = make(map[spotify.ID]*store.Song)
+}
+
+// songFromTrack builds the Song row for a playlist/liked track
+func songFromTrack(fullTrack *spotify.FullTrack, isLocal bool) *store.Song {
+	rawJSON, _ := json.Marshal(fullTrack)
+
+	provider := "spotify"
+	providerID := string(fullTrack.ID)
+
+	if isLocal {
+		provider = "local"
+		providerID = string(fullTrack.URI)
+	}
+
+	s := &store.Song{
+		Title:       fullTrack.Name,
+		Artist:      "Unknown Artist",
+		Album:       fullTrack.Album.Name,
+		DurationMs:  int64(fullTrack.Duration),
+		Provider:    provider,
+		ProviderID:  providerID,
+		RawMetadata: string(rawJSON),
+	}
+
+	if len(fullTrack.Artists) > 0 {
+		s.Artist = fullTrack.Artists[0].Name
+	}
+	if len(fullTrack.Album.Images) > 0 {
+		s.ImageURL = fullTrack.Album.Images[0].URL
+	}
+	return s
+}
+
+// songFromAlbumTrack : album track listings don't carry the album, so we pass it in
+func songFromAlbumTrack(track spotify.SimpleTrack, album spotify.SimpleAlbum) *store.Song {
+	return songFromTrack(&spotify.FullTrack{SimpleTrack: track, Album: album}, false)
+}
+
+func joinArtists(artists []spotify.SimpleArtist) string {
+	names := make([]string, 0, len(artists))
+	for _, a := range artists {
+		names = append(names, a.Name)
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,113 @@
+package spotify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"cryogon/rizumu-backend/store"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+// likedStandIn serves *liked as the user's Liked Songs, in one page
+func likedStandIn(t *testing.T, liked *[]string) *spotify.Client {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/me/tracks", func(w http.ResponseWriter, r *http.Request) {
+		var items []map[string]any
+		for _, id := range *liked {
+			items = append(items, map[string]any{
+				"added_at": "2026-01-01T00:00:00Z",
+				"track": map[string]any{
+					"id": id, "name": "Song " + id, "type": "track", "duration_ms": 200000,
+					"artists": []map[string]any{{"id": "a" + id, "name": "Artist " + id}},
+					"album":   map[string]any{"id": "al" + id, "name": "Album " + id},
+				},
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"items": items, "limit": 50, "offset": 0, "total": len(items)})
+	})
+	mux.HandleFunc("/audio-features", func(w http.ResponseWriter, r *http.Request) {
+		// deprecated for new apps, the sync goes on without
+		http.Error(w, `{"error": {"status": 403, "message": "Forbidden"}}`, http.StatusForbidden)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return spotify.New(srv.Client(), spotify.WithBaseURL(srv.URL+"/"))
+}
+
+func TestSyncLikedSongs(t *testing.T) {
+	ctx := context.Background()
+	db, err := store.NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	liked := []string{"t1", "t2", "t3"}
+	client := likedStandIn(t, &liked)
+	c := &Client{}
+
+	sync := func() (playlist []string, favorites []string) {
+		t.Helper()
+		if err := c.syncLikedSongs(ctx, client, db, 1); err != nil {
+			t.Fatal(err)
+		}
+		playlists, err := db.GetPlaylists()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range playlists {
+			if p.ExternalID != likedSongsID {
+				continue
+			}
+			songs, err := db.GetSongsByPlaylist(ctx, p.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, song := range songs {
+				playlist = append(playlist, song.ProviderID)
+			}
+		}
+		for _, id := range []string{"t1", "t2", "t3", "t4"} {
+			songID, err := db.SaveSong(ctx, &store.Song{Title: "x", Artist: "x", Provider: "spotify", ProviderID: id})
+			if err != nil {
+				t.Fatal(err)
+			}
+			song, err := db.GetSong(ctx, songID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if song.IsFavorite {
+				favorites = append(favorites, id)
+			}
+		}
+		return playlist, favorites
+	}
+
+	tests := []struct {
+		liked []string
+	}{
+		{[]string{"t1", "t2", "t3"}},
+		// t2 unliked, t4 liked
+		{[]string{"t4", "t1", "t3"}},
+		// liked again, nothing is added twice
+		{[]string{"t2", "t4", "t1", "t3"}},
+		{nil},
+	}
+	for i, tt := range tests {
+		liked = tt.liked
+		playlist, favorites := sync()
+		if !slices.Equal(playlist, tt.liked) {
+			t.Errorf("sync %d: playlist %v, want %v", i, playlist, tt.liked)
+		}
+		want := slices.Clone(tt.liked)
+		slices.Sort(want)
+		if !slices.Equal(favorites, want) {
+			t.Errorf("sync %d: favorites %v, want %v", i, favorites, want)
+		}
+	}
+}
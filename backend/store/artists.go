@@ -0,0 +1,279 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+// SetSongCredits replaces the artists credited on a song and links it to its
+// album. album may be nil (e.g. osu! beatmaps have none).
+func (s *Store) SetSongCredits(ctx context.Context, songID int64, artists []SongArtist, album *Album) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM song_artists WHERE song_id = ?", songID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for i, a := range artists {
+		if a.Name == "" {
+			continue
+		}
+		artistID, err := upsertArtist(ctx, tx, a.Name, a.ExternalID)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		role := a.Role
+		if role == "" {
+			role = "primary"
+		}
+
+		_, err = tx.ExecContext(ctx, "INSERT OR IGNORE INTO song_artists (song_id, artist_id, role, position) VALUES (?, ?, ?, ?)",
+			songID, artistID, role, i)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if album != nil && album.Title != "" {
+		albumID, err := upsertAlbum(ctx, tx, album)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE songs SET album_id = ? WHERE id = ?", albumID, songID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func upsertArtist(ctx context.Context, tx *sql.Tx, name, externalID string) (int64, error) {
+	query := `
+	INSERT INTO artists (name, external_id) VALUES (?, ?)
+	ON CONFLICT(name) DO UPDATE SET
+		external_id = COALESCE(NULLIF(excluded.external_id, ''), artists.external_id);
+	`
+	if _, err := tx.ExecContext(ctx, query, name, externalID); err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err := tx.QueryRowContext(ctx, "SELECT id FROM artists WHERE name = ?", name).Scan(&id)
+	return id, err
+}
+
+func upsertAlbum(ctx context.Context, tx *sql.Tx, album *Album) (int64, error) {
+	artistName := album.ArtistName
+	if artistName == "" {
+		artistName = "Unknown Artist"
+	}
+	artistID, err := upsertArtist(ctx, tx, artistName, "")
+	if err != nil {
+		return 0, err
+	}
+
+	query := `
+	INSERT INTO albums (title, artist_id, album_type, release_date, total_tracks, image_url, external_id)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(title, artist_id) DO UPDATE SET
+		album_type = COALESCE(NULLIF(excluded.album_type, ''), albums.album_type),
+		release_date = COALESCE(NULLIF(excluded.release_date, ''), albums.release_date),
+		total_tracks = MAX(excluded.total_tracks, albums.total_tracks),
+		image_url = COALESCE(NULLIF(excluded.image_url, ''), albums.image_url),
+		external_id = COALESCE(NULLIF(excluded.external_id, ''), albums.external_id);
+	`
+	_, err = tx.ExecContext(ctx, query, album.Title, artistID, album.AlbumType, album.ReleaseDate,
+		album.TotalTracks, album.ImageURL, album.ExternalID)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err = tx.QueryRowContext(ctx, "SELECT id FROM albums WHERE title = ? AND artist_id = ?", album.Title, artistID).Scan(&id)
+	return id, err
+}
+
+// SongsMissingCredits returns songs of a provider that have no song_artists
+// rows yet (only id and raw metadata are filled), used for backfilling
+func (s *Store) SongsMissingCredits(ctx context.Context, provider string) ([]*Song, error) {
+	query := `
+	SELECT id, COALESCE(raw_metadata, '') FROM songs s
+	WHERE provider = ? AND NOT EXISTS (SELECT 1 FROM song_artists sa WHERE sa.song_id = s.id)
+	`
+	rows, err := s.db.QueryContext(ctx, query, provider)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var songs []*Song
+	for rows.Next() {
+		var song Song
+		if err := rows.Scan(&song.ID, &song.RawMetadata); err != nil {
+			return nil, err
+		}
+		songs = append(songs, &song)
+	}
+	return songs, rows.Err()
+}
+
+// GetSongArtists lists the credits of a song in order
+func (s *Store) GetSongArtists(ctx context.Context, songID int64) ([]SongArtist, error) {
+	query := `
+	SELECT a.id, a.name, sa.role, sa.position
+	FROM song_artists sa
+	INNER JOIN artists a ON a.id = sa.artist_id
+	WHERE sa.song_id = ?
+	ORDER BY sa.position
+	`
+	rows, err := s.db.QueryContext(ctx, query, songID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credits := make([]SongArtist, 0)
+	for rows.Next() {
+		var c SongArtist
+		if err := rows.Scan(&c.ArtistID, &c.Name, &c.Role, &c.Position); err != nil {
+			return nil, err
+		}
+		credits = append(credits, c)
+	}
+	return credits, rows.Err()
+}
+
+func (s *Store) GetArtists(ctx context.Context) ([]*Artist, error) {
+	query := `
+	SELECT a.id, a.name, COALESCE(a.external_id, ''), COALESCE(a.image_url, ''), a.created_at, COUNT(sa.song_id)
+	FROM artists a
+	LEFT JOIN song_artists sa ON sa.artist_id = a.id
+	GROUP BY a.id
+	HAVING COUNT(sa.song_id) > 0
+	ORDER BY a.name
+	`
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	artists := make([]*Artist, 0)
+	for rows.Next() {
+		var a Artist
+		if err := rows.Scan(&a.ID, &a.Name, &a.ExternalID, &a.ImageURL, &a.CreatedAt, &a.SongCount); err != nil {
+			return nil, err
+		}
+		artists = append(artists, &a)
+	}
+	return artists, rows.Err()
+}
+
+func (s *Store) GetArtist(ctx context.Context, id int64) (*Artist, error) {
+	query := `
+	SELECT a.id, a.name, COALESCE(a.external_id, ''), COALESCE(a.image_url, ''), a.created_at,
+		(SELECT COUNT(*) FROM song_artists sa WHERE sa.artist_id = a.id)
+	FROM artists a WHERE a.id = ?
+	`
+	var a Artist
+	err := s.db.QueryRowContext(ctx, query, id).Scan(&a.ID, &a.Name, &a.ExternalID, &a.ImageURL, &a.CreatedAt, &a.SongCount)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// GetSongsByArtist returns every song the artist is credited on (featured included)
+func (s *Store) GetSongsByArtist(ctx context.Context, artistID int64) ([]*Song, error) {
+	query := `
+	SELECT ` + songColumns + `
+	FROM songs s
+	INNER JOIN song_artists sa ON sa.song_id = s.id
+	WHERE sa.artist_id = ?
+	ORDER BY s.album, s.title
+	`
+	return s.querySongs(ctx, query, artistID)
+}
+
+const albumColumns = `al.id, al.title, al.artist_id, ar.name, COALESCE(al.album_type, ''), COALESCE(al.release_date, ''),
+	al.total_tracks, COALESCE(al.image_url, ''), COALESCE(al.external_id, ''), al.created_at,
+	(SELECT COUNT(*) FROM songs s WHERE s.album_id = al.id)`
+
+func scanAlbum(row rowScanner) (*Album, error) {
+	var a Album
+	err := row.Scan(&a.ID, &a.Title, &a.ArtistID, &a.ArtistName, &a.AlbumType, &a.ReleaseDate,
+		&a.TotalTracks, &a.ImageURL, &a.ExternalID, &a.CreatedAt, &a.SongCount)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (s *Store) queryAlbums(ctx context.Context, query string, args ...any) ([]*Album, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	albums := make([]*Album, 0)
+	for rows.Next() {
+		a, err := scanAlbum(rows)
+		if err != nil {
+			return nil, err
+		}
+		albums = append(albums, a)
+	}
+	return albums, rows.Err()
+}
+
+func (s *Store) GetAlbums(ctx context.Context) ([]*Album, error) {
+	query := `
+	SELECT ` + albumColumns + `
+	FROM albums al
+	INNER JOIN artists ar ON ar.id = al.artist_id
+	ORDER BY al.title
+	`
+	return s.queryAlbums(ctx, query)
+}
+
+// GetAlbumsByArtist returns the albums where artistID is the album artist
+func (s *Store) GetAlbumsByArtist(ctx context.Context, artistID int64) ([]*Album, error) {
+	query := `
+	SELECT ` + albumColumns + `
+	FROM albums al
+	INNER JOIN artists ar ON ar.id = al.artist_id
+	WHERE al.artist_id = ?
+	ORDER BY al.release_date, al.title
+	`
+	return s.queryAlbums(ctx, query, artistID)
+}
+
+func (s *Store) GetAlbum(ctx context.Context, id int64) (*Album, error) {
+	query := `
+	SELECT ` + albumColumns + `
+	FROM albums al
+	INNER JOIN artists ar ON ar.id = al.artist_id
+	WHERE al.id = ?
+	`
+	return scanAlbum(s.db.QueryRowContext(ctx, query, id))
+}
+
+func (s *Store) GetSongsByAlbum(ctx context.Context, albumID int64) ([]*Song, error) {
+	query := `
+	SELECT ` + songColumns + `
+	FROM songs s
+	WHERE s.album_id = ?
+	ORDER BY s.id
+	`
+	return s.querySongs(ctx, query, albumID)
+}
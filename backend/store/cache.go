@@ -0,0 +1,54 @@
+package store
+
+import (
+	"context"
+)
+
+// MarkSongPlayed counts a play and makes the song the most recently used
+// for the cache quota
+func (s *Store) MarkSongPlayed(ctx context.Context, songID int64) error {
+	_, err := s.db.ExecContext(ctx, `
+	UPDATE songs SET play_count = COALESCE(play_count, 0) + 1, last_played_at = CURRENT_TIMESTAMP
+	WHERE id = ?`, songID)
+	return err
+}
+
+// GetCachedSongs lists the downloaded files, least recently used first:
+// by last play, or by download for the songs never played
+func (s *Store) GetCachedSongs(ctx context.Context) ([]CachedSong, error) {
+	rows, err := s.db.QueryContext(ctx, `
+	SELECT s.id, s.file_path, COALESCE(s.file_size, 0),
+		COALESCE(s.is_favorite, 0) OR EXISTS (
+			SELECT 1 FROM playlist_songs ps
+			INNER JOIN playlists p ON p.id = ps.playlist_id
+			WHERE ps.song_id = s.id AND p.pinned
+		)
+	FROM songs s
+	WHERE s.status = 'Downloaded' AND COALESCE(s.file_path, '') != '' AND s.provider != ?
+	ORDER BY COALESCE(s.last_played_at,
+		(SELECT MAX(t.finished_at) FROM download_tasks t WHERE t.song_id = s.id AND t.status = 'Complete'),
+		s.created_at), s.id`, ProviderLocal)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var songs []CachedSong
+	for rows.Next() {
+		var c CachedSong
+		if err := rows.Scan(&c.SongID, &c.Path, &c.Size, &c.Exempt); err != nil {
+			return nil, err
+		}
+		songs = append(songs, c)
+	}
+	return songs, rows.Err()
+}
+
+// EvictSong forgets the file of a downloaded song: it goes back to 'Remote'
+// and is downloaded again when played. The duration stays, it's the song's.
+func (s *Store) EvictSong(ctx context.Context, songID int64) error {
+	_, err := s.db.ExecContext(ctx, `
+	UPDATE songs SET status = 'Remote', file_path = NULL, file_size = 0, bitrate = 0, format = NULL
+	WHERE id = ? AND status = 'Downloaded'`, songID)
+	return err
+}
@@ -0,0 +1,47 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// GetCoverBySource returns the cover cached for a source (an image URL or
+// path), nil if it was never fetched
+func (s *Store) GetCoverBySource(ctx context.Context, source string) (*Cover, error) {
+	var c Cover
+	err := s.db.QueryRowContext(ctx, `
+	SELECT c.hash, c.mime, c.width, c.height, c.size, c.created_at FROM covers c
+	INNER JOIN cover_sources cs ON cs.hash = c.hash
+	WHERE cs.source = ?`, source).Scan(&c.Hash, &c.MIME, &c.Width, &c.Height, &c.Size, &c.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// SaveCover records a cover and points source at it. A cover with the same
+// hash is kept as it is.
+func (s *Store) SaveCover(ctx context.Context, c *Cover, source string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	INSERT OR IGNORE INTO covers (hash, mime, width, height, size)
+	VALUES (?, ?, ?, ?, ?)`, c.Hash, c.MIME, c.Width, c.Height, c.Size)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT OR REPLACE INTO cover_sources (source, hash) VALUES (?, ?)", source, c.Hash)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
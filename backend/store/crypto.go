@@ -0,0 +1,440 @@
+package store
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Secrets in the connections table (tokens, YTM cookies) are encrypted with
+// envelope encryption:
+//   - a random data key (DEK) encrypts the fields with AES-256-GCM
+//   - the DEK is stored in encryption_keys, wrapped by the master key (KEK)
+//
+// Rotating the master key only re-wraps the DEK. Rotating the DEK
+// re-encrypts every row.
+//
+// Each field is bound to its connection and column (the GCM additional
+// data), a value copied to another row or column doesn't decrypt.
+
+// encPrefix marks encrypted values: enc:v2:<dek id>:<base64(nonce|ciphertext)>
+const encPrefix = "enc:v2:"
+
+// encPrefixV1 marks the values of older versions, encrypted without being
+// bound to their field. migrateEncryption encrypts them again.
+const encPrefixV1 = "enc:v1:"
+
+const keySize = 32 // AES-256
+
+// Keyring holds the master keys. The first one wraps new data keys, the
+// others are only used to unwrap data keys during a rotation.
+type Keyring struct {
+	primaryID string
+	keks      map[string][]byte
+}
+
+func NewKeyring(primary []byte, previous ...[]byte) (*Keyring, error) {
+	k := &Keyring{keks: make(map[string][]byte)}
+
+	for i, key := range append([][]byte{primary}, previous...) {
+		if len(key) != keySize {
+			return nil, fmt.Errorf("master key %d must be %d bytes, got %d", i, keySize, len(key))
+		}
+		id := masterKeyID(key)
+		if i == 0 {
+			k.primaryID = id
+		}
+		k.keks[id] = key
+	}
+	return k, nil
+}
+
+// DecodeKey parses a base64 encoded master key
+func DecodeKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("master key is not valid base64: %w", err)
+	}
+	return key, nil
+}
+
+// ReadOrCreateKeyFile loads a base64 master key from path, generating one
+// (readable only by us) if the file doesn't exist yet
+func ReadOrCreateKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return DecodeKey(string(data))
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	encoded := base64.StdEncoding.EncodeToString(key) + "\n"
+	if err := os.WriteFile(path, []byte(encoded), 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write key file: %w", err)
+	}
+
+	log.Printf("[Store] Generated new master key at %s. Back it up, tokens can't be read without it.", path)
+	return key, nil
+}
+
+func masterKeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+func seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func unseal(key, data, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, additionalData)
+}
+
+// fieldAD is the additional data binding a secret to its connection and
+// column
+func fieldAD(connectionID int64, column string) []byte {
+	return []byte(fmt.Sprintf("connections/%d/%s", connectionID, column))
+}
+
+// encryptField encrypts a secret with the active data key, bound to ad
+// (fieldAD). Empty values stay empty so "no refresh token" is still visible.
+func (s *Store) encryptField(plain string, ad []byte) (string, error) {
+	if plain == "" || s.keys == nil {
+		return plain, nil
+	}
+
+	s.keysMu.RLock()
+	id, key := s.activeDataKey, s.dataKeys[s.activeDataKey]
+	s.keysMu.RUnlock()
+
+	sealed, err := seal(key, []byte(plain), ad)
+	if err != nil {
+		return "", err
+	}
+	return encPrefix + id + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptField reverses encryptField, ad must be the same. Values without
+// the prefix are legacy plaintext and returned as is.
+func (s *Store) decryptField(value string, ad []byte) (string, error) {
+	rest, ok := strings.CutPrefix(value, encPrefix)
+	if !ok {
+		if rest, ok = strings.CutPrefix(value, encPrefixV1); !ok {
+			return value, nil
+		}
+		ad = nil
+	}
+
+	id, encoded, ok := strings.Cut(rest, ":")
+	if !ok {
+		return "", errors.New("malformed encrypted value")
+	}
+
+	s.keysMu.RLock()
+	key, found := s.dataKeys[id]
+	s.keysMu.RUnlock()
+	if !found {
+		return "", fmt.Errorf("unknown data key %s", id)
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	plain, err := unseal(key, sealed, ad)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// isCurrent tells if value is already encrypted with the active data key
+func (s *Store) isCurrent(value string) bool {
+	if value == "" {
+		return true
+	}
+
+	s.keysMu.RLock()
+	defer s.keysMu.RUnlock()
+	return strings.HasPrefix(value, encPrefix+s.activeDataKey+":")
+}
+
+// migrateEncryption loads the data keys, re-wraps them if the master key
+// changed, and encrypts any plaintext secrets left from older versions
+func (s *Store) migrateEncryption(ctx context.Context) error {
+	if err := s.loadDataKeys(ctx); err != nil {
+		return err
+	}
+
+	s.keysMu.RLock()
+	hasActive := s.activeDataKey != ""
+	s.keysMu.RUnlock()
+
+	if !hasActive {
+		if err := s.createDataKey(ctx); err != nil {
+			return err
+		}
+	}
+
+	return s.reencryptConnections(ctx)
+}
+
+// RotateDataKey creates a new data key, re-encrypts every secret with it and
+// drops the old keys
+func (s *Store) RotateDataKey(ctx context.Context) error {
+	if s.keys == nil {
+		return errors.New("encryption is not configured")
+	}
+	if err := s.createDataKey(ctx); err != nil {
+		return err
+	}
+	return s.reencryptConnections(ctx)
+}
+
+func (s *Store) loadDataKeys(ctx context.Context) error {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, wrapped_key, kek_id, active FROM encryption_keys")
+	if err != nil {
+		return err
+	}
+
+	type wrapped struct {
+		id, kekID string
+		key       []byte
+	}
+	var rewrap []wrapped
+
+	s.keysMu.Lock()
+	s.dataKeys = make(map[string][]byte)
+	for rows.Next() {
+		var id, wrappedKey, kekID string
+		var active bool
+		if err := rows.Scan(&id, &wrappedKey, &kekID, &active); err != nil {
+			s.keysMu.Unlock()
+			rows.Close()
+			return err
+		}
+
+		kek, ok := s.keys.keks[kekID]
+		if !ok {
+			s.keysMu.Unlock()
+			rows.Close()
+			return fmt.Errorf("data key %s is wrapped with unknown master key %s (pass it in RIZUMU_PREVIOUS_MASTER_KEYS)", id, kekID)
+		}
+
+		raw, err := base64.StdEncoding.DecodeString(wrappedKey)
+		if err == nil {
+			raw, err = unseal(kek, raw, nil)
+		}
+		if err != nil {
+			s.keysMu.Unlock()
+			rows.Close()
+			return fmt.Errorf("failed to unwrap data key %s: %w", id, err)
+		}
+
+		s.dataKeys[id] = raw
+		if active {
+			s.activeDataKey = id
+		}
+		if kekID != s.keys.primaryID {
+			rewrap = append(rewrap, wrapped{id: id, kekID: kekID, key: raw})
+		}
+	}
+	s.keysMu.Unlock()
+
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return err
+	}
+	rows.Close()
+
+	// Master key rotation: wrap the data keys with the new master key
+	for _, w := range rewrap {
+		sealed, err := seal(s.keys.keks[s.keys.primaryID], w.key, nil)
+		if err != nil {
+			return err
+		}
+		_, err = s.db.ExecContext(ctx, "UPDATE encryption_keys SET wrapped_key = ?, kek_id = ? WHERE id = ?",
+			base64.StdEncoding.EncodeToString(sealed), s.keys.primaryID, w.id)
+		if err != nil {
+			return err
+		}
+		log.Printf("[Store] Re-wrapped data key %s (master key %s -> %s)", w.id, w.kekID, s.keys.primaryID)
+	}
+	return nil
+}
+
+func (s *Store) createDataKey(ctx context.Context) error {
+	raw := make([]byte, keySize)
+	if _, err := rand.Read(raw); err != nil {
+		return err
+	}
+	idBytes := make([]byte, 4)
+	if _, err := rand.Read(idBytes); err != nil {
+		return err
+	}
+	id := hex.EncodeToString(idBytes)
+
+	sealed, err := seal(s.keys.keks[s.keys.primaryID], raw, nil)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE encryption_keys SET active = 0"); err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO encryption_keys (id, wrapped_key, kek_id, active) VALUES (?, ?, ?, 1)",
+		id, base64.StdEncoding.EncodeToString(sealed), s.keys.primaryID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.keysMu.Lock()
+	s.dataKeys[id] = raw
+	s.activeDataKey = id
+	s.keysMu.Unlock()
+
+	log.Printf("[Store] Created data key %s", id)
+	return nil
+}
+
+// secretColumns are the encrypted columns of connections, in the order
+// reencryptConnections reads them
+var secretColumns = []string{"access_token", "refresh_token", "metadata"}
+
+// reencryptConnections makes sure every secret is encrypted with the active
+// data key, then removes the keys nothing uses anymore
+func (s *Store) reencryptConnections(ctx context.Context) error {
+	type secretRow struct {
+		id                        int64
+		access, refresh, metadata sql.NullString
+	}
+
+	rows, err := s.db.QueryContext(ctx, "SELECT id, access_token, refresh_token, metadata FROM connections")
+	if err != nil {
+		return err
+	}
+	var pending []secretRow
+	for rows.Next() {
+		var r secretRow
+		if err := rows.Scan(&r.id, &r.access, &r.refresh, &r.metadata); err != nil {
+			rows.Close()
+			return err
+		}
+		if !s.isCurrent(r.access.String) || !s.isCurrent(r.refresh.String) || !s.isCurrent(r.metadata.String) {
+			pending = append(pending, r)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return err
+	}
+	rows.Close()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range pending {
+		fields := []sql.NullString{r.access, r.refresh, r.metadata}
+		for i, f := range fields {
+			if !f.Valid {
+				continue
+			}
+			ad := fieldAD(r.id, secretColumns[i])
+			plain, err := s.decryptField(f.String, ad)
+			if err != nil {
+				tx.Rollback()
+				return fmt.Errorf("connection %d: %s: %w", r.id, secretColumns[i], err)
+			}
+			enc, err := s.encryptField(plain, ad)
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+			fields[i].String = enc
+		}
+
+		_, err := tx.ExecContext(ctx, "UPDATE connections SET access_token = ?, refresh_token = ?, metadata = ? WHERE id = ?",
+			fields[0], fields[1], fields[2], r.id)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	s.keysMu.RLock()
+	active := s.activeDataKey
+	s.keysMu.RUnlock()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM encryption_keys WHERE id != ?", active); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.keysMu.Lock()
+	for id := range s.dataKeys {
+		if id != active {
+			delete(s.dataKeys, id)
+		}
+	}
+	s.keysMu.Unlock()
+
+	if len(pending) > 0 {
+		log.Printf("[Store] Encrypted secrets of %d connections", len(pending))
+	}
+	return nil
+}
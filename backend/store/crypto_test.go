@@ -0,0 +1,261 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, keySize)
+}
+
+func testKeyring(t *testing.T, primary []byte, previous ...[]byte) *Keyring {
+	t.Helper()
+	keys, err := NewKeyring(primary, previous...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func openTestStore(t *testing.T, path string, keys *Keyring) *Store {
+	t.Helper()
+	s, err := NewSQLiteStore(path, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// saveTestConnection saves a Spotify connection with a cookie as metadata
+func saveTestConnection(t *testing.T, s *Store, providerID string) *Connection {
+	t.Helper()
+	ctx := context.Background()
+	token := &oauth2.Token{AccessToken: "access-" + providerID, RefreshToken: "refresh-" + providerID, Expiry: time.Now().Add(time.Hour)}
+	if err := s.SaveConnection(ctx, 1, "spotify", providerID, token); err != nil {
+		t.Fatal(err)
+	}
+	c := getTestConnection(t, s, providerID)
+	if err := s.SetConnectionMetadata(ctx, c.ID, "cookie-"+providerID); err != nil {
+		t.Fatal(err)
+	}
+	return getTestConnection(t, s, providerID)
+}
+
+func getTestConnection(t *testing.T, s *Store, providerID string) *Connection {
+	t.Helper()
+	var id int64
+	if err := s.db.QueryRow("SELECT id FROM connections WHERE provider_id = ?", providerID).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	var c Connection
+	c.ID = id
+	var err error
+	var access, refresh, metadata string
+	err = s.db.QueryRow("SELECT access_token, refresh_token, COALESCE(metadata, '') FROM connections WHERE id = ?", id).
+		Scan(&access, &refresh, &metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.AccessToken, err = s.decryptField(access, fieldAD(id, "access_token")); err != nil {
+		t.Fatal(err)
+	}
+	if c.RefreshToken, err = s.decryptField(refresh, fieldAD(id, "refresh_token")); err != nil {
+		t.Fatal(err)
+	}
+	if c.Metadata, err = s.decryptField(metadata, fieldAD(id, "metadata")); err != nil {
+		t.Fatal(err)
+	}
+	return &c
+}
+
+// rawSecrets are the stored values of the secret columns of a connection
+func rawSecrets(t *testing.T, s *Store, id int64) []string {
+	t.Helper()
+	var access, refresh, metadata string
+	err := s.db.QueryRow("SELECT access_token, refresh_token, COALESCE(metadata, '') FROM connections WHERE id = ?", id).
+		Scan(&access, &refresh, &metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []string{access, refresh, metadata}
+}
+
+func checkSecrets(t *testing.T, c *Connection, providerID string) {
+	t.Helper()
+	if c.AccessToken != "access-"+providerID || c.RefreshToken != "refresh-"+providerID || c.Metadata != "cookie-"+providerID {
+		t.Errorf("connection %s = %q, %q, %q", providerID, c.AccessToken, c.RefreshToken, c.Metadata)
+	}
+}
+
+func TestFieldRoundTrip(t *testing.T) {
+	s := openTestStore(t, filepath.Join(t.TempDir(), "test.db"), testKeyring(t, testKey(1)))
+	ad := fieldAD(1, "access_token")
+
+	enc, err := s.encryptField("secret", ad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(enc, encPrefix+s.activeDataKey+":") || strings.Contains(enc, "secret") {
+		t.Errorf("encrypted value %q", enc)
+	}
+	if plain, err := s.decryptField(enc, ad); err != nil || plain != "secret" {
+		t.Errorf("decryptField = %q, %v", plain, err)
+	}
+
+	// bound to its row and column
+	for _, other := range [][]byte{fieldAD(2, "access_token"), fieldAD(1, "refresh_token")} {
+		if _, err := s.decryptField(enc, other); err == nil {
+			t.Errorf("decrypted with the additional data %s", other)
+		}
+	}
+
+	if enc, err := s.encryptField("", ad); err != nil || enc != "" {
+		t.Errorf("empty value encrypted to %q, %v", enc, err)
+	}
+	if plain, err := s.decryptField("legacy plaintext", ad); err != nil || plain != "legacy plaintext" {
+		t.Errorf("plaintext decrypted to %q, %v", plain, err)
+	}
+}
+
+func TestSwappedSecretsDontDecrypt(t *testing.T) {
+	ctx := context.Background()
+	s := openTestStore(t, filepath.Join(t.TempDir(), "test.db"), testKeyring(t, testKey(1)))
+	a := saveTestConnection(t, s, "a")
+	b := saveTestConnection(t, s, "b")
+
+	// another row's token, then another column's
+	swaps := []struct {
+		query string
+		args  []any
+	}{
+		{"UPDATE connections SET access_token = (SELECT access_token FROM connections WHERE id = ?) WHERE id = ?", []any{b.ID, a.ID}},
+		{"UPDATE connections SET access_token = refresh_token WHERE id = ?", []any{a.ID}},
+	}
+	for _, swap := range swaps {
+		if _, err := s.db.Exec(swap.query, swap.args...); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.GetConnection(ctx, 1, "spotify"); err == nil {
+			t.Errorf("%s: the connection decrypted", swap.query)
+		}
+	}
+}
+
+func TestMigrateEncryption(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	// saved by a version without encryption
+	plain := openTestStore(t, path, nil)
+	c := saveTestConnection(t, plain, "me")
+	if secrets := rawSecrets(t, plain, c.ID); secrets[0] != "access-me" {
+		t.Fatalf("stored %q without keys", secrets[0])
+	}
+	plain.Close()
+
+	s := openTestStore(t, path, testKeyring(t, testKey(1)))
+	for _, value := range rawSecrets(t, s, c.ID) {
+		if !strings.HasPrefix(value, encPrefix+s.activeDataKey+":") {
+			t.Errorf("%q wasn't encrypted", value)
+		}
+	}
+	checkSecrets(t, getTestConnection(t, s, "me"), "me")
+
+	// encrypted by a version that didn't bind values to their field
+	sealed, err := seal(s.dataKeys[s.activeDataKey], []byte("cookie-me"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1 := encPrefixV1 + s.activeDataKey + ":" + base64.StdEncoding.EncodeToString(sealed)
+	if _, err := s.db.Exec("UPDATE connections SET metadata = ? WHERE id = ?", v1, c.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.migrateEncryption(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if metadata := rawSecrets(t, s, c.ID)[2]; !strings.HasPrefix(metadata, encPrefix) {
+		t.Errorf("the v1 value is still %q", metadata)
+	}
+	checkSecrets(t, getTestConnection(t, s, "me"), "me")
+}
+
+func TestMasterKeyRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	oldKey, newKey := testKey(1), testKey(2)
+
+	s := openTestStore(t, path, testKeyring(t, oldKey))
+	c := saveTestConnection(t, s, "me")
+	before := rawSecrets(t, s, c.ID)
+	s.Close()
+
+	s = openTestStore(t, path, testKeyring(t, newKey, oldKey))
+	var kekID string
+	if err := s.db.QueryRow("SELECT kek_id FROM encryption_keys").Scan(&kekID); err != nil {
+		t.Fatal(err)
+	}
+	if kekID != masterKeyID(newKey) {
+		t.Errorf("data key wrapped by %s, want the new master key %s", kekID, masterKeyID(newKey))
+	}
+	// only the data key changes hands, the secrets stay as they are
+	if after := rawSecrets(t, s, c.ID); after[0] != before[0] {
+		t.Error("a master key rotation re-encrypted the secrets")
+	}
+	s.Close()
+
+	// the old master key isn't needed anymore
+	s = openTestStore(t, path, testKeyring(t, newKey))
+	checkSecrets(t, getTestConnection(t, s, "me"), "me")
+}
+
+func TestRotateDataKey(t *testing.T) {
+	ctx := context.Background()
+	s := openTestStore(t, filepath.Join(t.TempDir(), "test.db"), testKeyring(t, testKey(1)))
+	c := saveTestConnection(t, s, "me")
+	oldID := s.activeDataKey
+
+	if err := s.RotateDataKey(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if s.activeDataKey == oldID {
+		t.Fatal("the data key didn't change")
+	}
+	for _, value := range rawSecrets(t, s, c.ID) {
+		if !strings.HasPrefix(value, encPrefix+s.activeDataKey+":") {
+			t.Errorf("%q isn't encrypted with the new data key", value)
+		}
+	}
+	var keys int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM encryption_keys").Scan(&keys); err != nil {
+		t.Fatal(err)
+	}
+	if keys != 1 || len(s.dataKeys) != 1 {
+		t.Errorf("%d data keys stored, %d loaded, want only the new one", keys, len(s.dataKeys))
+	}
+
+	conn, err := s.GetConnection(ctx, 1, "spotify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSecrets(t, conn, "me")
+}
+
+func TestUnknownMasterKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	s := openTestStore(t, path, testKeyring(t, testKey(1)))
+	saveTestConnection(t, s, "me")
+	s.Close()
+
+	_, err := NewSQLiteStore(path, testKeyring(t, testKey(2)))
+	if err == nil || !strings.Contains(err.Error(), "unknown master key "+masterKeyID(testKey(1))) {
+		t.Errorf("opening with another master key: %v", err)
+	}
+}
@@ -1,17 +1,26 @@
 package store
 
 import (
+	"context"
 	"database/sql"
+	"sync"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
 type Store struct {
 	db *sql.DB
+
+	// Encryption of connection secrets, see crypto.go
+	keys          *Keyring
+	keysMu        sync.RWMutex
+	dataKeys      map[string][]byte
+	activeDataKey string
 }
 
-// NewSQLiteStore opens the database file
-func NewSQLiteStore(dbPath string) (*Store, error) {
+// NewSQLiteStore opens the database file. keys encrypts the secrets in the
+// connections table; nil stores them in plaintext.
+func NewSQLiteStore(dbPath string, keys *Keyring) (*Store, error) {
 	db, err := sql.Open("sqlite3", dbPath)
 	if err != nil {
 		return nil, err
@@ -21,13 +30,19 @@ func NewSQLiteStore(dbPath string) (*Store, error) {
 		return nil, err
 	}
 
-	s := &Store{db: db}
+	s := &Store{db: db, keys: keys}
 
 	// Run migrations immediately on startup
 	if err := s.migrate(); err != nil {
 		return nil, err
 	}
 
+	if keys != nil {
+		if err := s.migrateEncryption(context.Background()); err != nil {
+			return nil, err
+		}
+	}
+
 	return s, nil
 }
 
@@ -0,0 +1,80 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// SaveFingerprint records (or replaces) the fingerprint of a song
+func (s *Store) SaveFingerprint(ctx context.Context, fp *SongFingerprint) error {
+	_, err := s.db.ExecContext(ctx, `
+	INSERT OR REPLACE INTO song_fingerprints (song_id, fingerprint, hash, duration)
+	VALUES (?, ?, ?, ?)`, fp.SongID, fp.Fingerprint, fp.Hash, fp.Duration)
+	return err
+}
+
+// GetFingerprint returns the fingerprint of a song, nil if it has none yet
+func (s *Store) GetFingerprint(ctx context.Context, songID int64) (*SongFingerprint, error) {
+	fp := SongFingerprint{SongID: songID}
+	err := s.db.QueryRowContext(ctx, `
+	SELECT fingerprint, hash, COALESCE(duration, 0) FROM song_fingerprints WHERE song_id = ?`,
+		songID).Scan(&fp.Fingerprint, &fp.Hash, &fp.Duration)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &fp, nil
+}
+
+// GetFingerprintHashes maps the songs whose fingerprint another song shares
+// to that fingerprint's hash
+func (s *Store) GetFingerprintHashes(ctx context.Context) (map[int64]string, error) {
+	rows, err := s.db.QueryContext(ctx, `
+	SELECT song_id, hash FROM song_fingerprints
+	WHERE hash IN (SELECT hash FROM song_fingerprints GROUP BY hash HAVING COUNT(*) > 1)`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hashes := make(map[int64]string)
+	for rows.Next() {
+		var songID int64
+		var hash string
+		if err := rows.Scan(&songID, &hash); err != nil {
+			return nil, err
+		}
+		hashes[songID] = hash
+	}
+	return hashes, rows.Err()
+}
+
+// GetSongsToFingerprint lists the songs with a file and no fingerprint, and
+// the ones still waiting for their metadata (to look them up again). all
+// lists every song with a file.
+func (s *Store) GetSongsToFingerprint(ctx context.Context, all bool) ([]*Song, error) {
+	query := `
+	SELECT ` + songColumns + ` FROM songs s
+	LEFT JOIN song_fingerprints f ON f.song_id = s.id
+	WHERE s.status = 'Downloaded' AND COALESCE(s.file_path, '') != ''
+		AND (? OR f.song_id IS NULL OR s.title = ?)
+	ORDER BY s.id`
+	return s.querySongs(ctx, query, all, PendingTitle)
+}
+
+// IdentifySong gives a song still titled PendingTitle the metadata a
+// fingerprint lookup found. Returns false if something named it meanwhile.
+func (s *Store) IdentifySong(ctx context.Context, songID int64, title, artist, album string, durationMs int64) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `
+	UPDATE songs SET title = ?, artist = ?, album = ?,
+		duration_ms = CASE WHEN COALESCE(duration_ms, 0) = 0 THEN ? ELSE duration_ms END
+	WHERE id = ? AND title = ?`, title, artist, album, durationMs, songID, PendingTitle)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,177 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// ProviderLocal is the provider of songs imported from the library folders.
+// Their provider_id is the absolute path of the file.
+const ProviderLocal = "local"
+
+// GetLocalFiles returns the imported files by path, Missing ones included
+func (s *Store) GetLocalFiles(ctx context.Context) (map[string]*LocalFile, error) {
+	rows, err := s.db.QueryContext(ctx, `
+	SELECT id, provider_id, COALESCE(file_size, 0), COALESCE(file_mtime, 0), status
+	FROM songs WHERE provider = ?`, ProviderLocal)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := make(map[string]*LocalFile)
+	for rows.Next() {
+		var f LocalFile
+		if err := rows.Scan(&f.SongID, &f.Path, &f.Size, &f.MTime, &f.Status); err != nil {
+			return nil, err
+		}
+		files[f.Path] = &f
+	}
+	return files, rows.Err()
+}
+
+// SaveLocalSong inserts or refreshes the song of a local file (song.FilePath)
+// from its tags. A song that was Missing is Downloaded again.
+func (s *Store) SaveLocalSong(ctx context.Context, song *Song, mtime int64) (int64, error) {
+	query := `
+	INSERT INTO songs (title, artist, album, image_url, duration_ms, bpm, energy, valence, preview_ms, provider, provider_id, file_path, file_size, bitrate, format, file_mtime, status)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 'Downloaded')
+	ON CONFLICT(provider, provider_id) DO UPDATE SET
+		title = excluded.title,
+		artist = excluded.artist,
+		album = excluded.album,
+		duration_ms = excluded.duration_ms,
+		file_path = excluded.file_path,
+		file_size = excluded.file_size,
+		bitrate = excluded.bitrate,
+		format = excluded.format,
+		file_mtime = excluded.file_mtime,
+		preview_ms = excluded.preview_ms,
+		status = 'Downloaded',
+		unavailable_reason = NULL;
+	`
+	_, err := s.db.ExecContext(ctx, query,
+		song.Title, song.Artist, song.Album, song.ImageURL, song.DurationMs,
+		song.BPM, song.Energy, song.Valence, song.PreviewMs,
+		ProviderLocal, song.FilePath, song.FilePath, song.FileSize, song.Bitrate, song.Format, mtime,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err = s.db.QueryRowContext(ctx, "SELECT id FROM songs WHERE provider = ? AND provider_id = ?",
+		ProviderLocal, song.FilePath).Scan(&id)
+	return id, err
+}
+
+// MarkSongsMissing flags local songs whose file is gone. They keep their
+// play history and playlist entries, in case the file comes back.
+func (s *Store) MarkSongsMissing(ctx context.Context, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	args := []any{ProviderLocal}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+
+	_, err := s.db.ExecContext(ctx,
+		"UPDATE songs SET status = 'Missing' WHERE provider = ? AND id IN ("+placeholders+")", args...)
+	return err
+}
+
+// RenameLocalSong points a local song at the path its file was moved to.
+// The song keeps its ID, so play history and playlists follow the file.
+func (s *Store) RenameLocalSong(ctx context.Context, songID int64, path string, mtime int64) error {
+	_, err := s.db.ExecContext(ctx, `
+	UPDATE songs SET provider_id = ?, file_path = ?, file_mtime = ?, status = 'Downloaded'
+	WHERE id = ? AND provider = ?`, path, path, mtime, songID, ProviderLocal)
+	return err
+}
+
+// GetDownloadedSongs lists the songs with a file that came from a download
+// (not a library folder)
+func (s *Store) GetDownloadedSongs(ctx context.Context) ([]*Song, error) {
+	query := `
+	SELECT ` + songColumns + ` FROM songs s
+	WHERE s.status = 'Downloaded' AND COALESCE(s.file_path, '') != '' AND s.provider != ?
+	ORDER BY s.id`
+	return s.querySongs(ctx, query, ProviderLocal)
+}
+
+// GetSongsWithFiles lists every song with a file, downloaded or from a
+// library folder
+func (s *Store) GetSongsWithFiles(ctx context.Context) ([]*Song, error) {
+	query := `
+	SELECT ` + songColumns + ` FROM songs s
+	WHERE s.status = 'Downloaded' AND COALESCE(s.file_path, '') != ''
+	ORDER BY s.id`
+	return s.querySongs(ctx, query)
+}
+
+// RelocateSong points a song at its moved audio and cover. move runs inside
+// the transaction, so the row only changes if the files moved. If the
+// commit fails, undo puts the files back.
+func (s *Store) RelocateSong(ctx context.Context, songID int64, filePath, imageURL string, move, undo func() error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE songs SET file_path = ?, image_url = ? WHERE id = ?", filePath, imageURL, songID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := move(); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		if undoErr := undo(); undoErr != nil {
+			return errors.Join(err, undoErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// GetFileReferences lists every file a song points at, audio or local
+// cover, whatever its status
+func (s *Store) GetFileReferences(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `
+	SELECT file_path FROM songs WHERE COALESCE(file_path, '') != ''
+	UNION
+	SELECT image_url FROM songs WHERE COALESCE(image_url, '') != '' AND image_url NOT LIKE 'http%'`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, rows.Err()
+}
+
+// GetStuckSongs lists the songs a crash left in a status that doesn't hold:
+// 'Downloading' with no unfinished task, or 'Downloaded' without a file
+func (s *Store) GetStuckSongs(ctx context.Context) ([]*Song, error) {
+	query := `
+	SELECT ` + songColumns + ` FROM songs s
+	WHERE (s.status = 'Downloading' AND NOT EXISTS (
+		SELECT 1 FROM download_tasks t WHERE t.song_id = s.id AND t.status IN ('Pending', 'Downloading')
+	))
+	OR (s.status = 'Downloaded' AND COALESCE(s.file_path, '') = '')
+	ORDER BY s.id`
+	return s.querySongs(ctx, query)
+}
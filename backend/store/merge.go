@@ -0,0 +1,180 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// PendingTitle is the title of a song added by URL until its download
+// brings the real metadata
+const PendingTitle = "Unknown (Pending)"
+
+// GetDedupeCandidates lists the songs duplicates are looked for in, all of
+// them. The ones still waiting for their metadata only match by
+// fingerprint.
+func (s *Store) GetDedupeCandidates(ctx context.Context) ([]*Song, error) {
+	query := `SELECT ` + songColumns + ` FROM songs s ORDER BY s.id`
+	return s.querySongs(ctx, query)
+}
+
+// MergeSongs folds the songs dupIDs into keepID, in one transaction: their
+// playlist entries, plays, tags and beatmaps move to keepID, their play
+// counts add up and the rows are deleted. A playlist holding both keeps
+// only keepID's entry. Their (provider, provider_id) become aliases of
+// keepID, SaveSong returns keepID when a sync saves them again. With adoptFrom (one of dupIDs), keepID takes that
+// song's file; its own is gone or was never downloaded.
+func (s *Store) MergeSongs(ctx context.Context, keepID int64, dupIDs []int64, adoptFrom int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	var exists int
+	if err := tx.QueryRowContext(ctx, "SELECT 1 FROM songs WHERE id = ?", keepID).Scan(&exists); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if adoptFrom != 0 {
+		_, err := tx.ExecContext(ctx, `
+		UPDATE songs SET (file_path, file_size, bitrate, format, status) =
+			(SELECT file_path, file_size, bitrate, format, status FROM songs WHERE id = ?)
+		WHERE id = ?`, adoptFrom, keepID)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	for _, dupID := range dupIDs {
+		if dupID == keepID {
+			continue
+		}
+		if err := tx.QueryRowContext(ctx, "SELECT 1 FROM songs WHERE id = ?", dupID).Scan(&exists); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := mergeSong(ctx, tx, keepID, dupID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func mergeSong(ctx context.Context, tx *sql.Tx, keepID, dupID int64) error {
+	steps := []struct {
+		query string
+		args  []any
+	}{
+		{`UPDATE playlist_songs SET song_id = ?
+		WHERE song_id = ? AND playlist_id NOT IN (SELECT playlist_id FROM playlist_songs WHERE song_id = ?)`,
+			[]any{keepID, dupID, keepID}},
+		{"DELETE FROM playlist_songs WHERE song_id = ?", []any{dupID}},
+
+		{"UPDATE play_history SET song_id = ? WHERE song_id = ?", []any{keepID, dupID}},
+
+		{"INSERT OR IGNORE INTO song_tags (song_id, tag_id) SELECT ?, tag_id FROM song_tags WHERE song_id = ?",
+			[]any{keepID, dupID}},
+		{"DELETE FROM song_tags WHERE song_id = ?", []any{dupID}},
+
+		{"UPDATE OR IGNORE osu_beatmaps SET song_id = ? WHERE song_id = ?", []any{keepID, dupID}},
+		{"DELETE FROM osu_beatmaps WHERE song_id = ?", []any{dupID}},
+
+		// credits only when keepID has none, they'd disagree otherwise
+		{`INSERT OR IGNORE INTO song_artists (song_id, artist_id, role, position)
+		SELECT ?, artist_id, role, position FROM song_artists
+		WHERE song_id = ? AND NOT EXISTS (SELECT 1 FROM song_artists WHERE song_id = ?)`,
+			[]any{keepID, dupID, keepID}},
+		{"DELETE FROM song_artists WHERE song_id = ?", []any{dupID}},
+
+		{`UPDATE songs SET
+			play_count = COALESCE(play_count, 0) + (SELECT COALESCE(play_count, 0) FROM songs WHERE id = ?),
+			last_played_at = (SELECT MAX(last_played_at) FROM songs WHERE id IN (?, ?)),
+			is_favorite = (SELECT MAX(COALESCE(is_favorite, 0)) FROM songs WHERE id IN (?, ?)),
+			image_url = COALESCE(NULLIF(image_url, ''), (SELECT image_url FROM songs WHERE id = ?))
+		WHERE id = ?`,
+			[]any{dupID, keepID, dupID, keepID, dupID, dupID, keepID}},
+
+		// the same recording, keepID may not have been fingerprinted yet
+		{"UPDATE OR IGNORE song_fingerprints SET song_id = ? WHERE song_id = ?", []any{keepID, dupID}},
+		{"DELETE FROM song_fingerprints WHERE song_id = ?", []any{dupID}},
+
+		// what was merged into dupID before, and dupID itself
+		{"UPDATE song_aliases SET song_id = ? WHERE song_id = ?", []any{keepID, dupID}},
+		{`INSERT OR REPLACE INTO song_aliases (provider, provider_id, song_id)
+		SELECT provider, provider_id, ? FROM songs WHERE id = ?`, []any{keepID, dupID}},
+
+		{"DELETE FROM download_tasks WHERE song_id = ?", []any{dupID}},
+		{"DELETE FROM songs WHERE id = ?", []any{dupID}},
+	}
+
+	for _, step := range steps {
+		if _, err := tx.ExecContext(ctx, step.query, step.args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// songAlias is the song (provider, providerID) was merged into, 0 if none
+func (s *Store) songAlias(ctx context.Context, provider, providerID string) (int64, error) {
+	var id int64
+	err := s.db.QueryRowContext(ctx, "SELECT song_id FROM song_aliases WHERE provider = ? AND provider_id = ?",
+		provider, providerID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return id, err
+}
+
+// GetURLProviderSongs lists the songs added by URL before provider_id was
+// the ID on the provider, they kept the whole URL
+func (s *Store) GetURLProviderSongs(ctx context.Context) ([]*Song, error) {
+	query := `SELECT ` + songColumns + ` FROM songs s
+	WHERE s.provider_id LIKE 'http://%' OR s.provider_id LIKE 'https://%' ORDER BY s.id`
+	return s.querySongs(ctx, query)
+}
+
+// SetSongProviderID changes the provider ID of a song. When another song
+// has that ID already (the same URL added again), the song is merged into
+// it, which takes its file if it has none. Returns the song that remains.
+func (s *Store) SetSongProviderID(ctx context.Context, songID int64, providerID string) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	var keepID int64
+	err = tx.QueryRowContext(ctx, `
+	SELECT other.id FROM songs other JOIN songs s ON other.provider = s.provider
+	WHERE s.id = ? AND other.provider_id = ? AND other.id != s.id`, songID, providerID).Scan(&keepID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		if _, err := tx.ExecContext(ctx, "UPDATE songs SET provider_id = ? WHERE id = ?", providerID, songID); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		return songID, tx.Commit()
+	case err != nil:
+		tx.Rollback()
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	UPDATE songs SET (file_path, file_size, bitrate, format, status) =
+		(SELECT file_path, file_size, bitrate, format, status FROM songs WHERE id = ?)
+	WHERE id = ? AND COALESCE(status, '') != 'Downloaded'
+		AND (SELECT status FROM songs WHERE id = ?) = 'Downloaded'`, songID, keepID, songID)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if err := mergeSong(ctx, tx, keepID, songID); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	return keepID, tx.Commit()
+}
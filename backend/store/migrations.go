@@ -1,6 +1,10 @@
 package store
 
-import "log"
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
 
 func (s *Store) migrate() error {
 	query := `
@@ -32,6 +36,15 @@ func (s *Store) migrate() error {
         UNIQUE(provider, provider_id) -- Prevent duplicate links
 	  );
 
+    -- Data keys for the secrets in connections, wrapped by the master key
+    CREATE TABLE IF NOT EXISTS encryption_keys (
+        id TEXT PRIMARY KEY,
+        wrapped_key TEXT NOT NULL,
+        kek_id TEXT NOT NULL,       -- which master key wrapped it
+        active BOOLEAN DEFAULT 0,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+    );
+
     CREATE TABLE IF NOT EXISTS playlists (
         id INTEGER PRIMARY KEY AUTOINCREMENT,
         user_id INTEGER NOT NULL,
@@ -44,6 +57,12 @@ func (s *Store) migrate() error {
         
         -- If synced, this is the Spotify/YTM ID. If custom, this is NULL.
         external_id TEXT, 
+
+        -- 'playlist', 'album' or 'liked' (Spotify Liked Songs)
+        kind TEXT DEFAULT 'playlist',
+
+        -- kept downloaded for offline use, the cache quota leaves its songs alone
+        pinned BOOLEAN DEFAULT 0,
         
         created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
         UNIQUE(user_id, source_type, external_id)
@@ -56,6 +75,7 @@ func (s *Store) migrate() error {
         title TEXT NOT NULL,
         artist TEXT NOT NULL,
         album TEXT,
+        album_id INTEGER,          -- albums.id, album is kept as the display text
 				image_url TEXT,
 	      lyrics TEXT,
         duration_ms INTEGER,
@@ -65,6 +85,7 @@ func (s *Store) migrate() error {
         key TEXT,                  -- Useful for mixing
         energy REAL,               -- 0.0 to 1.0 (Spotify gives this)
         valence REAL,              -- 0.0 to 1.0 (Mood: Happy/Sad)
+        preview_ms INTEGER,        -- preview playback start, osu! PreviewTime
 
 				-- Usage Stats
 				play_count INTEGER DEFAULT 0,  -- <--- NEW: Increments on play
@@ -80,12 +101,14 @@ func (s *Store) migrate() error {
         file_size INTEGER DEFAULT 0,   -- <--- NEW: Bytes
         bitrate INTEGER DEFAULT 0,     -- <--- NEW: e.g. 320
         format TEXT,                   -- <--- NEW: 'mp3', 'ogg'            -- Path on disk (e.g., "/songs/123.mp3")
+        file_mtime INTEGER,            -- unix nanos, local files are reprobed when it or the size changes
         
         -- Raw Data
         -- We dump the WHOLE JSON from Spotify/YTM here.
         raw_metadata TEXT, 
 
-				status TEXT DEFAULT 'Pending',
+				status TEXT DEFAULT 'Pending', -- 'Remote' once the cache quota evicted the file
+        unavailable_reason TEXT,   -- why status is 'Not Available'
         
         created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
         UNIQUE(provider, provider_id)
@@ -121,6 +144,99 @@ func (s *Store) migrate() error {
         FOREIGN KEY(tag_id) REFERENCES tags(id)
     );
 
+    -- Artists / Albums as real entities (instead of just the text columns)
+    CREATE TABLE IF NOT EXISTS artists (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        name TEXT NOT NULL UNIQUE COLLATE NOCASE,
+        external_id TEXT,          -- e.g. Spotify artist ID
+        image_url TEXT,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+    );
+
+    CREATE TABLE IF NOT EXISTS albums (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        title TEXT NOT NULL COLLATE NOCASE,
+        artist_id INTEGER NOT NULL, -- album artist
+        album_type TEXT,            -- 'album', 'single', 'compilation'
+        release_date TEXT,          -- '1981-12-15', '1981-12' or '1981'
+        total_tracks INTEGER DEFAULT 0,
+        image_url TEXT,
+        external_id TEXT,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        FOREIGN KEY(artist_id) REFERENCES artists(id),
+        UNIQUE(title, artist_id)
+    );
+
+    -- Song <-> Artist Link (all credited artists, not just the first one)
+    CREATE TABLE IF NOT EXISTS song_artists (
+        song_id INTEGER NOT NULL,
+        artist_id INTEGER NOT NULL,
+        role TEXT DEFAULT 'primary', -- 'primary', 'featured'
+        position INTEGER DEFAULT 0,  -- credit order
+        PRIMARY KEY (song_id, artist_id),
+        FOREIGN KEY(song_id) REFERENCES songs(id),
+        FOREIGN KEY(artist_id) REFERENCES artists(id)
+    );
+
+    -- osu! difficulties of a song
+    CREATE TABLE IF NOT EXISTS osu_beatmaps (
+        song_id INTEGER NOT NULL,
+        beatmap_id INTEGER DEFAULT 0,
+        beatmapset_id INTEGER DEFAULT 0,
+        checksum TEXT NOT NULL,       -- MD5 of the .osu file, what collection.db refers to
+        version TEXT NOT NULL,        -- difficulty name
+        creator TEXT,
+        title_unicode TEXT,
+        artist_unicode TEXT,
+        source TEXT,
+        mode INTEGER DEFAULT 0,       -- 0 osu!, 1 taiko, 2 catch, 3 mania
+        hp REAL, cs REAL, od REAL, ar REAL,
+        bpm REAL, min_bpm REAL, max_bpm REAL,
+        PRIMARY KEY (song_id, checksum),
+        FOREIGN KEY(song_id) REFERENCES songs(id)
+    );
+
+    -- Artwork cache, one row per image content (see covers.Cache)
+    CREATE TABLE IF NOT EXISTS covers (
+        hash TEXT PRIMARY KEY,        -- SHA-256 of the original image
+        mime TEXT NOT NULL,           -- sniffed, not what the source claimed
+        width INTEGER DEFAULT 0,      -- 0 when the image can't be decoded (webp)
+        height INTEGER DEFAULT 0,
+        size INTEGER DEFAULT 0,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+    );
+
+    -- Where covers came from: songs.image_url, a remote URL or a local path
+    CREATE TABLE IF NOT EXISTS cover_sources (
+        source TEXT PRIMARY KEY,
+        hash TEXT NOT NULL,
+        FOREIGN KEY(hash) REFERENCES covers(hash)
+    );
+
+    -- Acoustic fingerprints (Chromaprint) of the songs' audio. They describe
+    -- the recording, not the file, so they outlive an eviction.
+    CREATE TABLE IF NOT EXISTS song_fingerprints (
+        song_id INTEGER PRIMARY KEY,
+        fingerprint TEXT NOT NULL,    -- compressed, as fpcalc prints it
+        hash TEXT NOT NULL,           -- SHA-256 of fingerprint, for exact matches
+        duration INTEGER DEFAULT 0,   -- seconds of audio, AcoustID wants it
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        FOREIGN KEY(song_id) REFERENCES songs(id)
+    );
+    CREATE INDEX IF NOT EXISTS idx_song_fingerprints_hash ON song_fingerprints(hash);
+
+    -- Songs merged into another one (library dedupe). A sync or import
+    -- bringing the provider's song back gets the song it was merged into.
+    CREATE TABLE IF NOT EXISTS song_aliases (
+        provider TEXT NOT NULL,
+        provider_id TEXT NOT NULL,
+        song_id INTEGER NOT NULL,     -- the song kept
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY(provider, provider_id),
+        FOREIGN KEY(song_id) REFERENCES songs(id)
+    );
+    CREATE INDEX IF NOT EXISTS idx_song_aliases_song ON song_aliases(song_id);
+
 	  CREATE TABLE IF NOT EXISTS play_history (
         id INTEGER PRIMARY KEY AUTOINCREMENT,
         user_id INTEGER NOT NULL,
@@ -131,6 +247,32 @@ func (s *Store) migrate() error {
         FOREIGN KEY(user_id) REFERENCES users(id),
         FOREIGN KEY(song_id) REFERENCES songs(id)
     );
+
+    -- Download jobs. Survive restarts so unfinished ones can be resumed.
+    CREATE TABLE IF NOT EXISTS download_tasks (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        song_id INTEGER NOT NULL,
+        source TEXT NOT NULL,              -- 'youtube', 'spotify', 'osu!'
+        source_url TEXT NOT NULL,
+        priority TEXT DEFAULT 'interactive', -- 'interactive', 'bulk'
+        quality TEXT,                      -- 'original', 'mp3-320', 'mp3-v0', 'flac'
+        status TEXT DEFAULT 'Pending',     -- same values as songs.status
+        attempts INTEGER DEFAULT 0,
+        progress REAL DEFAULT 0,
+        last_error TEXT,
+        error_kind TEXT,                   -- 'transient', 'permanent'
+        log_tail TEXT,                     -- last lines of the downloader output
+        next_attempt_at DATETIME,          -- set while waiting to retry
+
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        started_at DATETIME,
+        finished_at DATETIME,
+        updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+
+        FOREIGN KEY(song_id) REFERENCES songs(id)
+    );
+    CREATE INDEX IF NOT EXISTS idx_download_tasks_status ON download_tasks(status);
+    CREATE INDEX IF NOT EXISTS idx_download_tasks_song ON download_tasks(song_id);
     `
 
 	_, err := s.db.Exec(query)
@@ -139,5 +281,67 @@ func (s *Store) migrate() error {
 		return err
 	}
 
+	// Columns added after the first release. CREATE TABLE IF NOT EXISTS won't
+	// touch old databases, so we add them one by one.
+	for _, c := range columnMigrations {
+		if err := s.addColumnIfMissing(c.table, c.column, c.definition); err != nil {
+			log.Printf("ERROR: Failed to add column %s.%s: %v", c.table, c.column, err)
+			return err
+		}
+	}
+
 	return nil
 }
+
+var columnMigrations = []struct {
+	table      string
+	column     string
+	definition string
+}{
+	{"playlists", "kind", "TEXT DEFAULT 'playlist'"},
+	{"songs", "album_id", "INTEGER"},
+	{"songs", "unavailable_reason", "TEXT"},
+	{"download_tasks", "error_kind", "TEXT"},
+	{"download_tasks", "next_attempt_at", "DATETIME"},
+	{"songs", "file_mtime", "INTEGER"},
+	{"songs", "preview_ms", "INTEGER"},
+	{"download_tasks", "quality", "TEXT"},
+	{"playlists", "pinned", "BOOLEAN DEFAULT 0"},
+}
+
+// addColumnIfMissing : sqlite has no "ADD COLUMN IF NOT EXISTS", so check table_info first
+func (s *Store) addColumnIfMissing(table, column, definition string) error {
+	exists, err := s.hasColumn(table, column)
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = s.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+func (s *Store) hasColumn(table, column string) (bool, error) {
+	rows, err := s.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    int
+			defaultVal sql.NullString
+			pk         int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
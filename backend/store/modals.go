@@ -33,6 +33,7 @@ type Playlist struct {
 	ImageURL    string    `json:"image_url"`
 	SourceType  string    `json:"source_type"` // 'rizumu', 'spotify', 'osu'
 	ExternalID  string    `json:"external_id"` // The ID on Spotify/osu!
+	Kind        string    `json:"kind"`        // 'playlist', 'album', 'liked'
 	CreatedAt   time.Time `json:"created_at"`
 }
 
@@ -51,6 +52,10 @@ type Song struct {
 	Energy  float64 `json:"energy"`
 	Valence float64 `json:"valence"`
 
+	// PreviewMs is where the preview playback starts (osu! PreviewTime),
+	// nil when the source doesn't tell
+	PreviewMs *int64 `json:"preview_ms,omitempty"`
+
 	// Usage Stats
 	PlayCount    int64      `json:"play_count"`
 	LastPlayedAt *time.Time `json:"last_played_at"` // Pointer allows null (never played)
@@ -72,7 +77,34 @@ type Song struct {
 	CreatedAt time.Time `json:"created_at"`
 
 	// State
-	Status string `json:"status"`
+	Status            string `json:"status"`
+	UnavailableReason string `json:"unavailable_reason,omitempty"` // set when Status is 'Not Available'
+}
+
+// SongFile is the file of a downloaded song as probed on disk
+type SongFile struct {
+	Path       string
+	Size       int64
+	Bitrate    int    // kbps
+	Format     string // 'mp3', 'opus', 'aac', 'flac'
+	DurationMs int64  // 0 keeps the duration the provider gave
+}
+
+// CachedSong is a downloaded file as the cache quota sees it
+type CachedSong struct {
+	SongID int64
+	Path   string
+	Size   int64
+	Exempt bool // a favorite or in a pinned playlist
+}
+
+// LocalFile is what the library scanner remembers of an imported file
+type LocalFile struct {
+	SongID int64
+	Path   string
+	Size   int64
+	MTime  int64 // unix nanos
+	Status string
 }
 
 // PlaylistSong links a Song to a Playlist
@@ -93,8 +125,106 @@ type PlayLog struct {
 	DurationListenedMs int64     `json:"duration_listened_ms"`
 }
 
+// OsuBeatmap is one difficulty of an osu! song. The difficulties sharing
+// an audio file are the same song.
+type OsuBeatmap struct {
+	SongID        int64  `json:"song_id"`
+	BeatmapID     int    `json:"beatmap_id"` // 0 when unknown, -1 for unsubmitted maps
+	BeatmapSetID  int    `json:"beatmapset_id"`
+	Checksum      string `json:"checksum"` // MD5 of the .osu file
+	Version       string `json:"version"`  // difficulty name
+	Creator       string `json:"creator"`
+	TitleUnicode  string `json:"title_unicode,omitempty"`
+	ArtistUnicode string `json:"artist_unicode,omitempty"`
+	Source        string `json:"source,omitempty"`
+
+	Mode              int     `json:"mode"` // 0 osu!, 1 taiko, 2 catch, 3 mania
+	HPDrainRate       float64 `json:"hp"`
+	CircleSize        float64 `json:"cs"`
+	OverallDifficulty float64 `json:"od"`
+	ApproachRate      float64 `json:"ar"`
+	BPM               float64 `json:"bpm"`
+	MinBPM            float64 `json:"min_bpm"`
+	MaxBPM            float64 `json:"max_bpm"`
+}
+
 // Tag allows for genres like "Pop", "High BPM", "Anime"
 type Tag struct {
 	ID   int64  `json:"id"`
 	Name string `json:"name"`
 }
+
+// Artist is anyone credited on a song
+type Artist struct {
+	ID         int64     `json:"id"`
+	Name       string    `json:"name"`
+	ExternalID string    `json:"external_id,omitempty"` // Spotify artist ID
+	ImageURL   string    `json:"image_url,omitempty"`
+	SongCount  int64     `json:"song_count"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Album groups songs released together
+type Album struct {
+	ID          int64     `json:"id"`
+	Title       string    `json:"title"`
+	ArtistID    int64     `json:"artist_id"`
+	ArtistName  string    `json:"artist"` // Album artist
+	AlbumType   string    `json:"album_type,omitempty"`
+	ReleaseDate string    `json:"release_date,omitempty"`
+	TotalTracks int       `json:"total_tracks"`
+	ImageURL    string    `json:"image_url,omitempty"`
+	ExternalID  string    `json:"external_id,omitempty"`
+	SongCount   int64     `json:"song_count"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// SongArtist is one credit of a song, in order
+type SongArtist struct {
+	ArtistID   int64  `json:"artist_id"`
+	Name       string `json:"name"`
+	ExternalID string `json:"-"`
+	Role       string `json:"role"` // 'primary', 'featured'
+	Position   int    `json:"position"`
+}
+
+// DownloadTask is a persisted download job of one song
+type DownloadTask struct {
+	ID         int64      `json:"id"`
+	SongID     int64      `json:"song_id"`
+	Source     string     `json:"source"` // 'youtube', 'spotify', 'osu!'
+	SourceURL  string     `json:"source_url"`
+	Priority   string     `json:"priority"`          // 'interactive', 'bulk'
+	Quality    string     `json:"quality,omitempty"` // 'original', 'mp3-320', 'mp3-v0', 'flac'
+	Status     string     `json:"status"`
+	Attempts   int        `json:"attempts"`
+	Progress   float64    `json:"progress"`
+	LastError  string     `json:"last_error,omitempty"`
+	ErrorKind  string     `json:"error_kind,omitempty"` // 'transient', 'permanent'
+	LogTail    string     `json:"log_tail,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"` // waiting for a retry
+}
+
+// Cover is an image of the artwork cache. Sources with the same content
+// share it.
+type Cover struct {
+	Hash      string    `json:"hash"` // SHA-256 of the original
+	MIME      string    `json:"mime"`
+	Width     int       `json:"width"`
+	Height    int       `json:"height"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SongFingerprint is the Chromaprint fingerprint of a song's audio
+type SongFingerprint struct {
+	SongID      int64  `json:"song_id"`
+	Fingerprint string `json:"fingerprint"`
+	Hash        string `json:"hash"`
+	Duration    int    `json:"duration"` // seconds
+}
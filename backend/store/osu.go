@@ -0,0 +1,59 @@
+package store
+
+import (
+	"context"
+)
+
+// SetOsuBeatmaps replaces the difficulties of an osu! song
+func (s *Store) SetOsuBeatmaps(ctx context.Context, songID int64, beatmaps []OsuBeatmap) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM osu_beatmaps WHERE song_id = ?", songID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	query := `
+	INSERT OR REPLACE INTO osu_beatmaps (song_id, beatmap_id, beatmapset_id, checksum, version, creator,
+		title_unicode, artist_unicode, source, mode, hp, cs, od, ar, bpm, min_bpm, max_bpm)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	for _, b := range beatmaps {
+		_, err := tx.ExecContext(ctx, query, songID, b.BeatmapID, b.BeatmapSetID, b.Checksum, b.Version, b.Creator,
+			b.TitleUnicode, b.ArtistUnicode, b.Source, b.Mode,
+			b.HPDrainRate, b.CircleSize, b.OverallDifficulty, b.ApproachRate, b.BPM, b.MinBPM, b.MaxBPM)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetOsuBeatmaps lists the difficulties of a song, easiest (by OD) first
+func (s *Store) GetOsuBeatmaps(ctx context.Context, songID int64) ([]OsuBeatmap, error) {
+	rows, err := s.db.QueryContext(ctx, `
+	SELECT song_id, beatmap_id, beatmapset_id, checksum, version, COALESCE(creator, ''),
+		COALESCE(title_unicode, ''), COALESCE(artist_unicode, ''), COALESCE(source, ''), mode,
+		hp, cs, od, ar, bpm, min_bpm, max_bpm
+	FROM osu_beatmaps WHERE song_id = ?
+	ORDER BY mode, od, version`, songID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var beatmaps []OsuBeatmap
+	for rows.Next() {
+		var b OsuBeatmap
+		if err := rows.Scan(&b.SongID, &b.BeatmapID, &b.BeatmapSetID, &b.Checksum, &b.Version, &b.Creator,
+			&b.TitleUnicode, &b.ArtistUnicode, &b.Source, &b.Mode,
+			&b.HPDrainRate, &b.CircleSize, &b.OverallDifficulty, &b.ApproachRate, &b.BPM, &b.MinBPM, &b.MaxBPM); err != nil {
+			return nil, err
+		}
+		beatmaps = append(beatmaps, b)
+	}
+	return beatmaps, rows.Err()
+}
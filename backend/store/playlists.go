@@ -2,6 +2,7 @@ package store
 
 import (
 	"context"
+	"database/sql"
 	"log"
 	"time"
 )
@@ -16,20 +17,31 @@ type PlaylistV2 struct {
 	ImageURL    string    `json:"image_url"`
 	SourceType  string    `json:"source_type"`
 	ExternalID  string    `json:"external_id"`
+	Kind        string    `json:"kind"`
 	CreatedAt   time.Time `json:"created_at"`
 	SongCount   int64     `json:"song_count"`
+
+	// Pinned playlists are kept downloaded for offline use, ready once
+	// DownloadedCount reaches SongCount
+	Pinned          bool  `json:"pinned"`
+	DownloadedCount int64 `json:"downloaded_count"`
 }
 
 func (s *Store) SavePlaylist(ctx context.Context, p *Playlist) (int64, error) {
 	query := `
-	INSERT INTO playlists (user_id, name, description, image_url, source_type, external_id)
-	VALUES (?, ?, ?, ?, ?, ?)
+	INSERT INTO playlists (user_id, name, description, image_url, source_type, external_id, kind)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
 	ON CONFLICT(user_id, source_type, external_id) DO UPDATE SET
 		name = excluded.name,
 		description = excluded.description,
-		image_url = excluded.image_url;
+		image_url = excluded.image_url,
+		kind = excluded.kind;
 	`
-	_, err := s.db.ExecContext(ctx, query, p.UserID, p.Name, p.Description, p.ImageURL, p.SourceType, p.ExternalID)
+	if p.Kind == "" {
+		p.Kind = "playlist"
+	}
+
+	_, err := s.db.ExecContext(ctx, query, p.UserID, p.Name, p.Description, p.ImageURL, p.SourceType, p.ExternalID, p.Kind)
 	if err != nil {
 		return 0, err
 	}
@@ -40,10 +52,13 @@ func (s *Store) SavePlaylist(ctx context.Context, p *Playlist) (int64, error) {
 	return id, err
 }
 
-// AddSongToPlaylist links them in the join table
+// AddSongToPlaylist links them in the join table, once: a sync adds the
+// songs already there again, and a merged song comes back as the one kept
 func (s *Store) AddSongToPlaylist(ctx context.Context, playlistID, songID int64) error {
-	query := `INSERT OR IGNORE INTO playlist_songs (playlist_id, song_id) VALUES (?, ?)`
-	_, err := s.db.ExecContext(ctx, query, playlistID, songID)
+	query := `
+	INSERT INTO playlist_songs (playlist_id, song_id)
+	SELECT ?, ? WHERE NOT EXISTS (SELECT 1 FROM playlist_songs WHERE playlist_id = ? AND song_id = ?)`
+	_, err := s.db.ExecContext(ctx, query, playlistID, songID, playlistID, songID)
 	return err
 }
 
@@ -59,8 +74,32 @@ func (s *Store) RemoveSongFromAllPlaylists(ctx context.Context, songID int64) er
 	return err
 }
 
+// SetPlaylistSongs replaces the content of a playlist mirrored from elsewhere
+// (an osu! collection), songIDs in order
+func (s *Store) SetPlaylistSongs(ctx context.Context, playlistID int64, songIDs []int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM playlist_songs WHERE playlist_id = ?", playlistID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for i, songID := range songIDs {
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO playlist_songs (playlist_id, song_id, sort_order) VALUES (?, ?, ?)",
+			playlistID, songID, i); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
 func (s *Store) GetPlaylists() ([]*PlaylistV2, error) {
-	query := "SELECT * from playlists"
+	query := "SELECT id, user_id, name, description, image_url, source_type, external_id, kind, created_at, COALESCE(pinned, 0) from playlists"
 	playlists, err := s.db.Query(query)
 	if err != nil {
 		return nil, err
@@ -78,14 +117,18 @@ func (s *Store) GetPlaylists() ([]*PlaylistV2, error) {
 
 	for playlists.Next() {
 		ps := &Playlist{} // Initialize the pointer
-		var sc int64
+		var sc, dc int64
+		var pinned bool
 
-		if err := playlists.Scan(&ps.ID, &ps.UserID, &ps.Name, &ps.Description, &ps.ImageURL, &ps.SourceType, &ps.ExternalID, &ps.CreatedAt); err != nil {
+		if err := playlists.Scan(&ps.ID, &ps.UserID, &ps.Name, &ps.Description, &ps.ImageURL, &ps.SourceType, &ps.ExternalID, &ps.Kind, &ps.CreatedAt, &pinned); err != nil {
 			return nil, err
 		}
 
-		songCount := s.db.QueryRow("SELECT count(id) from playlist_songs where playlist_id = ?", ps.ID)
-		if err := songCount.Scan(&sc); err != nil {
+		songCount := s.db.QueryRow(`
+		SELECT count(ps.id), COALESCE(SUM(s.status = 'Downloaded'), 0) from playlist_songs ps
+		INNER JOIN songs s ON s.id = ps.song_id
+		where ps.playlist_id = ?`, ps.ID)
+		if err := songCount.Scan(&sc, &dc); err != nil {
 			return nil, err
 		}
 
@@ -97,9 +140,42 @@ func (s *Store) GetPlaylists() ([]*PlaylistV2, error) {
 			ImageURL:    ps.ImageURL,
 			SourceType:  ps.SourceType,
 			ExternalID:  ps.ExternalID,
+			Kind:        ps.Kind,
 			CreatedAt:   ps.CreatedAt,
 			SongCount:   sc,
+
+			Pinned:          pinned,
+			DownloadedCount: dc,
 		})
 	}
 	return formatedPlaylist, nil
 }
+
+// SetPlaylistPinned marks a playlist to keep downloaded, sql.ErrNoRows if
+// there is no such playlist
+func (s *Store) SetPlaylistPinned(ctx context.Context, playlistID int64, pinned bool) error {
+	result, err := s.db.ExecContext(ctx, "UPDATE playlists SET pinned = ? WHERE id = ?", pinned, playlistID)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetPinnedSongsToDownload lists the songs of pinned playlists without a
+// file yet, those of playlistID only when it's > 0. Songs that can't be
+// downloaded ('Not Available') are left out.
+func (s *Store) GetPinnedSongsToDownload(ctx context.Context, playlistID int64) ([]*Song, error) {
+	query := `
+	SELECT ` + songColumns + ` FROM songs s
+	WHERE s.status NOT IN ('Downloaded', 'Not Available') AND s.provider != ?
+	AND s.id IN (
+		SELECT ps.song_id FROM playlist_songs ps
+		INNER JOIN playlists p ON p.id = ps.playlist_id
+		WHERE p.pinned AND (? <= 0 OR p.id = ?)
+	)
+	ORDER BY s.id`
+	return s.querySongs(ctx, query, ProviderLocal, playlistID, playlistID)
+}
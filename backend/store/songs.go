@@ -11,7 +11,63 @@ type SongConfig struct {
 	Limit  int64
 }
 
+// songColumns is what every song listing selects, in scanSong order
+const songColumns = `s.id, s.title, s.artist, s.album, s.image_url, s.provider, s.provider_id, s.file_path, s.status, s.bpm, s.energy, s.valence, s.duration_ms,
+	COALESCE(s.unavailable_reason, ''), s.preview_ms, COALESCE(s.file_size, 0), COALESCE(s.bitrate, 0), COALESCE(s.format, ''),
+	COALESCE(s.play_count, 0), s.last_played_at, COALESCE(s.is_favorite, 0)`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSong(row rowScanner) (*Song, error) {
+	var song Song
+	var filePath sql.NullString
+	var previewMs sql.NullInt64
+	var lastPlayed sql.NullTime
+	err := row.Scan(&song.ID, &song.Title, &song.Artist, &song.Album, &song.ImageURL,
+		&song.Provider, &song.ProviderID, &filePath, &song.Status, &song.BPM, &song.Energy, &song.Valence, &song.DurationMs,
+		&song.UnavailableReason, &previewMs, &song.FileSize, &song.Bitrate, &song.Format,
+		&song.PlayCount, &lastPlayed, &song.IsFavorite)
+	if err != nil {
+		return nil, err
+	}
+	song.FilePath = filePath.String
+	if previewMs.Valid {
+		song.PreviewMs = &previewMs.Int64
+	}
+	if lastPlayed.Valid {
+		song.LastPlayedAt = &lastPlayed.Time
+	}
+	return &song, nil
+}
+
+func (s *Store) querySongs(ctx context.Context, query string, args ...any) ([]*Song, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var songs []*Song
+	for rows.Next() {
+		song, err := scanSong(rows)
+		if err != nil {
+			return nil, err
+		}
+		songs = append(songs, song)
+	}
+	return songs, rows.Err()
+}
+
+// SaveSong inserts the song, or refreshes its audio features and returns
+// the existing one. A song merged into another (MergeSongs) returns that
+// one, untouched.
 func (s *Store) SaveSong(ctx context.Context, song *Song) (int64, error) {
+	if id, err := s.songAlias(ctx, song.Provider, song.ProviderID); err != nil || id != 0 {
+		return id, err
+	}
+
 	query := `
 	INSERT INTO songs (title, artist, album, image_url, duration_ms, bpm, energy, valence, provider, provider_id, raw_metadata, status)
 	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
@@ -43,76 +99,27 @@ func (s *Store) SaveSong(ctx context.Context, song *Song) (int64, error) {
 }
 
 func (s *Store) GetSong(ctx context.Context, id int64) (*Song, error) {
-	query := `SELECT id, title, artist, album, image_url, provider, provider_id, file_path, status, bpm, energy, valence, duration_ms 
-	          FROM songs WHERE id = ?`
-	row := s.db.QueryRowContext(ctx, query, id)
-
-	var song Song
-	var filePath sql.NullString
-	err := row.Scan(&song.ID, &song.Title, &song.Artist, &song.Album, &song.ImageURL,
-		&song.Provider, &song.ProviderID, &filePath, &song.Status, &song.BPM, &song.Energy, &song.Valence, &song.DurationMs)
-	if err != nil {
-		return nil, err
-	}
-	song.FilePath = filePath.String
-	return &song, nil
+	query := `SELECT ` + songColumns + ` FROM songs s WHERE s.id = ?`
+	return scanSong(s.db.QueryRowContext(ctx, query, id))
 }
 
 func (s *Store) GetSongs(ctx context.Context, config SongConfig) ([]*Song, error) {
 	query := `
-	SELECT id, title, artist, album, image_url, provider, provider_id, file_path, status, bpm, energy, valence, duration_ms FROM songs
-	where id > ?
+	SELECT ` + songColumns + ` FROM songs s
+	where s.id > ?
   LIMIT ?
 	`
-	rows, err := s.db.QueryContext(ctx, query, config.Offset, config.Limit)
-	if err != nil {
-		return nil, err
-	}
-
-	var songs []*Song
-
-	for rows.Next() {
-		var song Song
-		var filePath sql.NullString
-		err := rows.Scan(&song.ID, &song.Title, &song.Artist, &song.Album, &song.ImageURL,
-			&song.Provider, &song.ProviderID, &filePath, &song.Status, &song.BPM, &song.Energy, &song.Valence, &song.DurationMs)
-		if err != nil {
-			return nil, err
-		}
-		song.FilePath = filePath.String
-		songs = append(songs, &song)
-	}
-
-	return songs, nil
+	return s.querySongs(ctx, query, config.Offset, config.Limit)
 }
 
 func (s *Store) GetSongsByPlaylist(ctx context.Context, playlistID int64) ([]*Song, error) {
 	query := `
-   SELECT s.id, s.title, s.artist, s.album, s.image_url, s.provider, s.provider_id, s.file_path, s.status, s.bpm, s.energy, s.valence, s.duration_ms
+   SELECT ` + songColumns + `
    FROM songs s
    INNER JOIN playlist_songs ps ON s.id = ps.song_id
    WHERE ps.playlist_id = ?
 	`
-	rows, err := s.db.QueryContext(ctx, query, playlistID)
-	if err != nil {
-		return nil, err
-	}
-
-	var songs []*Song
-
-	for rows.Next() {
-		var song Song
-		var filePath sql.NullString
-		err := rows.Scan(&song.ID, &song.Title, &song.Artist, &song.Album, &song.ImageURL,
-			&song.Provider, &song.ProviderID, &filePath, &song.Status, &song.BPM, &song.Energy, &song.Valence, &song.DurationMs)
-		if err != nil {
-			return nil, err
-		}
-		song.FilePath = filePath.String
-		songs = append(songs, &song)
-	}
-
-	return songs, nil
+	return s.querySongs(ctx, query, playlistID)
 }
 
 func (s *Store) DeleteSong(ctx context.Context, id int64) error {
@@ -136,6 +143,31 @@ func (s *Store) DeleteSong(ctx context.Context, id int64) error {
 		return err
 	}
 
+	if _, err := tx.ExecContext(ctx, "DELETE FROM song_artists WHERE song_id = ?", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM osu_beatmaps WHERE song_id = ?", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM download_tasks WHERE song_id = ?", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM song_fingerprints WHERE song_id = ?", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM song_aliases WHERE song_id = ?", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	if _, err := tx.ExecContext(ctx, "DELETE FROM songs WHERE id = ?", id); err != nil {
 		tx.Rollback()
 		return err
@@ -149,23 +181,38 @@ func (s *Store) UpdateSongStatus(ctx context.Context, songID int64, status strin
 	return err
 }
 
-func (s *Store) UpdateSongPath(ctx context.Context, songID int64, path string, fileSize int64) error {
-	_, err := s.db.ExecContext(ctx, "UPDATE songs SET file_path = ?, file_size = ?, status = 'Downloaded' WHERE id = ?", path, fileSize, songID)
+// MarkSongUnavailable sets the song to 'Not Available' and keeps why
+func (s *Store) MarkSongUnavailable(ctx context.Context, songID int64, reason string) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE songs SET status = 'Not Available', unavailable_reason = ? WHERE id = ?", reason, songID)
 	return err
 }
 
-func (s *Store) UpdateSongProgress(ctx context.Context, id int64, progress float64) error {
-	// Optional: Add a 'progress' column if you want persistence, or just mark Downloading
-	_, err := s.db.ExecContext(ctx, "UPDATE songs SET status = 'Downloading' WHERE id = ?", id)
+// SetSongFavorite toggles the "Like" flag, used for Spotify Liked Songs
+func (s *Store) SetSongFavorite(ctx context.Context, songID int64, favorite bool) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE songs SET is_favorite = ? WHERE id = ?", favorite, songID)
+	return err
+}
+
+// UpdateSongFile marks the song Downloaded with its file. All the file
+// attributes change in the one statement, a reader never sees the new path
+// with the old size or format.
+func (s *Store) UpdateSongFile(ctx context.Context, songID int64, file SongFile) error {
+	query := `
+	UPDATE songs
+	SET file_path = ?, file_size = ?, bitrate = ?, format = ?,
+		duration_ms = CASE WHEN ? > 0 THEN ? ELSE duration_ms END,
+		status = 'Downloaded', unavailable_reason = NULL
+	WHERE id = ?`
+	_, err := s.db.ExecContext(ctx, query, file.Path, file.Size, file.Bitrate, file.Format, file.DurationMs, file.DurationMs, songID)
 	return err
 }
 
 func (s *Store) UpdateSongFullMetadata(ctx context.Context, song *Song) error {
 	query := `
 	UPDATE songs 
-	SET file_path = ?, image_url = ?, title = ?, artist = ?, bpm = ?, duration_ms = ?, status = 'Downloaded'
+	SET file_path = ?, image_url = ?, title = ?, artist = ?, bpm = ?, duration_ms = ?, preview_ms = ?, status = 'Downloaded'
 	WHERE id = ?`
-	_, err := s.db.ExecContext(ctx, query, song.FilePath, song.ImageURL, song.Title, song.Artist, song.BPM, song.ID, song.DurationMs)
+	_, err := s.db.ExecContext(ctx, query, song.FilePath, song.ImageURL, song.Title, song.Artist, song.BPM, song.DurationMs, song.PreviewMs, song.ID)
 	return err
 }
 
@@ -180,5 +227,5 @@ func (s *Store) ResetStuckDownloads(ctx context.Context) error {
 	if rows > 0 {
 		log.Printf("Reset %d rows", rows)
 	}
-	return nil
+	return s.resetStuckTasks(ctx)
 }
@@ -0,0 +1,57 @@
+package store
+
+import (
+	"context"
+	"strings"
+)
+
+// AddSongTags links the tags (created if needed, lower case) to a song.
+// Tags already on the song are kept.
+func (s *Store) AddSongTags(ctx context.Context, songID int64, names []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		if _, err := tx.ExecContext(ctx, "INSERT OR IGNORE INTO tags (name) VALUES (?)", name); err != nil {
+			tx.Rollback()
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `
+		INSERT OR IGNORE INTO song_tags (song_id, tag_id)
+		SELECT ?, id FROM tags WHERE name = ?`, songID, name)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *Store) GetSongTags(ctx context.Context, songID int64) ([]Tag, error) {
+	rows, err := s.db.QueryContext(ctx, `
+	SELECT t.id, t.name FROM tags t
+	INNER JOIN song_tags st ON st.tag_id = t.id
+	WHERE st.song_id = ?
+	ORDER BY t.name`, songID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []Tag
+	for rows.Next() {
+		var t Tag
+		if err := rows.Scan(&t.ID, &t.Name); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
@@ -0,0 +1,206 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"time"
+)
+
+// TaskFilter narrows ListDownloadTasks. Zero values are ignored.
+type TaskFilter struct {
+	Status string
+	SongID int64
+	Source string
+	Limit  int64
+	Offset int64
+}
+
+const taskColumns = `id, song_id, source, source_url, priority, COALESCE(quality, ''), status, attempts, progress,
+	COALESCE(last_error, ''), COALESCE(error_kind, ''), COALESCE(log_tail, ''), created_at, started_at, finished_at, updated_at,
+	next_attempt_at`
+
+func scanTask(row rowScanner) (*DownloadTask, error) {
+	var t DownloadTask
+	var startedAt, finishedAt, nextAttemptAt sql.NullTime
+	err := row.Scan(&t.ID, &t.SongID, &t.Source, &t.SourceURL, &t.Priority, &t.Quality, &t.Status, &t.Attempts, &t.Progress,
+		&t.LastError, &t.ErrorKind, &t.LogTail, &t.CreatedAt, &startedAt, &finishedAt, &t.UpdatedAt, &nextAttemptAt)
+	if err != nil {
+		return nil, err
+	}
+	if startedAt.Valid {
+		t.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		t.FinishedAt = &finishedAt.Time
+	}
+	if nextAttemptAt.Valid {
+		t.NextAttemptAt = &nextAttemptAt.Time
+	}
+	return &t, nil
+}
+
+func (s *Store) queryTasks(ctx context.Context, query string, args ...any) ([]*DownloadTask, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tasks := make([]*DownloadTask, 0)
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, t)
+	}
+	return tasks, rows.Err()
+}
+
+// CreateDownloadTask inserts a Pending task and returns its ID
+func (s *Store) CreateDownloadTask(ctx context.Context, task *DownloadTask) (int64, error) {
+	query := `
+	INSERT INTO download_tasks (song_id, source, source_url, priority, quality, status)
+	VALUES (?, ?, ?, ?, ?, 'Pending')`
+	res, err := s.db.ExecContext(ctx, query, task.SongID, task.Source, task.SourceURL, task.Priority, task.Quality)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (s *Store) GetDownloadTask(ctx context.Context, id int64) (*DownloadTask, error) {
+	query := `SELECT ` + taskColumns + ` FROM download_tasks WHERE id = ?`
+	return scanTask(s.db.QueryRowContext(ctx, query, id))
+}
+
+// GetActiveTaskForSong returns the unfinished task of a song, or nil if the
+// song isn't queued
+func (s *Store) GetActiveTaskForSong(ctx context.Context, songID int64) (*DownloadTask, error) {
+	query := `
+	SELECT ` + taskColumns + ` FROM download_tasks
+	WHERE song_id = ? AND status IN ('Pending', 'Downloading')
+	ORDER BY id DESC LIMIT 1`
+	task, err := scanTask(s.db.QueryRowContext(ctx, query, songID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return task, err
+}
+
+// ListDownloadTasks returns tasks newest first
+func (s *Store) ListDownloadTasks(ctx context.Context, filter TaskFilter) ([]*DownloadTask, error) {
+	var where []string
+	var args []any
+	if filter.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, filter.Status)
+	}
+	if filter.SongID > 0 {
+		where = append(where, "song_id = ?")
+		args = append(args, filter.SongID)
+	}
+	if filter.Source != "" {
+		where = append(where, "source = ?")
+		args = append(args, filter.Source)
+	}
+
+	query := `SELECT ` + taskColumns + ` FROM download_tasks`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	query += " ORDER BY id DESC LIMIT ? OFFSET ?"
+	args = append(args, limit, filter.Offset)
+
+	return s.queryTasks(ctx, query, args...)
+}
+
+// GetUnfinishedDownloadTasks returns the Pending/Downloading tasks, oldest
+// first, so they can be queued again after a restart
+func (s *Store) GetUnfinishedDownloadTasks(ctx context.Context) ([]*DownloadTask, error) {
+	query := `
+	SELECT ` + taskColumns + ` FROM download_tasks
+	WHERE status IN ('Pending', 'Downloading')
+	ORDER BY id`
+	return s.queryTasks(ctx, query)
+}
+
+// StartDownloadTask marks a task as running and counts the attempt
+func (s *Store) StartDownloadTask(ctx context.Context, id int64) error {
+	query := `
+	UPDATE download_tasks
+	SET status = 'Downloading', attempts = attempts + 1, progress = 0, next_attempt_at = NULL,
+		started_at = CURRENT_TIMESTAMP, finished_at = NULL, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?`
+	_, err := s.db.ExecContext(ctx, query, id)
+	return err
+}
+
+func (s *Store) UpdateDownloadTaskProgress(ctx context.Context, id int64, progress float64) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE download_tasks SET progress = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", progress, id)
+	return err
+}
+
+// FinishDownloadTask stores the outcome of a task. errorKind and lastError
+// are empty on success.
+func (s *Store) FinishDownloadTask(ctx context.Context, id int64, status, errorKind, lastError, logTail string) error {
+	query := `
+	UPDATE download_tasks
+	SET status = ?, error_kind = NULLIF(?, ''), last_error = NULLIF(?, ''), log_tail = ?,
+		progress = CASE WHEN ? = 'Complete' THEN 100 ELSE progress END,
+		next_attempt_at = NULL, finished_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?`
+	_, err := s.db.ExecContext(ctx, query, status, errorKind, lastError, logTail, status, id)
+	return err
+}
+
+// ScheduleDownloadRetry puts a failed task back to Pending until nextAttempt
+func (s *Store) ScheduleDownloadRetry(ctx context.Context, id int64, errorKind, lastError, logTail string, nextAttempt time.Time) error {
+	query := `
+	UPDATE download_tasks
+	SET status = 'Pending', error_kind = ?, last_error = ?, log_tail = ?,
+		next_attempt_at = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?`
+	_, err := s.db.ExecContext(ctx, query, errorKind, lastError, logTail, nextAttempt.UTC(), id)
+	return err
+}
+
+// SetDownloadTaskQuality changes the quality a task downloads in
+func (s *Store) SetDownloadTaskQuality(ctx context.Context, id int64, quality string) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE download_tasks SET quality = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", quality, id)
+	return err
+}
+
+// RequeueDownloadTask puts a finished task back to Pending for a retry, with
+// all its attempts ahead of it again
+func (s *Store) RequeueDownloadTask(ctx context.Context, id int64) error {
+	query := `
+	UPDATE download_tasks
+	SET status = 'Pending', priority = 'interactive', attempts = 0, progress = 0, last_error = NULL, error_kind = NULL,
+		next_attempt_at = NULL, finished_at = NULL, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?`
+	_, err := s.db.ExecContext(ctx, query, id)
+	return err
+}
+
+// resetStuckTasks puts tasks that were running when we died back to Pending
+func (s *Store) resetStuckTasks(ctx context.Context) error {
+	result, err := s.db.ExecContext(ctx, "UPDATE download_tasks SET status = 'Pending', updated_at = CURRENT_TIMESTAMP WHERE status = 'Downloading'")
+	if err != nil {
+		return err
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows > 0 {
+		log.Printf("[Store] Reset %d interrupted download tasks", rows)
+	}
+	return nil
+}
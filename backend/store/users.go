@@ -7,41 +7,71 @@ import (
 	"golang.org/x/oauth2"
 )
 
-// SaveSpotifyConnection links a Spotify account to a User
-func (s *Store) SaveSpotifyConnection(ctx context.Context, userID int64, spotifyID string, token *oauth2.Token) error {
-	// Upsert: Insert or Update if it exists
+// SaveConnection links an external account (Spotify, osu!, YTM) to a User
+func (s *Store) SaveConnection(ctx context.Context, userID int64, provider, providerID string, token *oauth2.Token) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	// Upsert: Insert or Update if it exists. The tokens are encrypted for
+	// the row, so they're written once its id is known.
 	query := `
-	INSERT INTO connections (user_id, provider, provider_id, access_token, refresh_token, expiry)
-	VALUES (?, 'spotify', ?, ?, ?, ?)
+	INSERT INTO connections (user_id, provider, provider_id, expiry)
+	VALUES (?, ?, ?, ?)
 	ON CONFLICT(provider, provider_id) DO UPDATE SET
-		access_token = excluded.access_token,
-		refresh_token = excluded.refresh_token,
-		expiry = excluded.expiry;
+		expiry = excluded.expiry
+	RETURNING id;
 	`
+	var id int64
+	if err := tx.QueryRowContext(ctx, query, userID, provider, providerID, token.Expiry).Scan(&id); err != nil {
+		tx.Rollback()
+		return err
+	}
 
-	_, err := s.db.ExecContext(ctx, query,
-		userID,
-		spotifyID,
-		token.AccessToken,
-		token.RefreshToken,
-		token.Expiry,
-	)
+	accessToken, err := s.encryptField(token.AccessToken, fieldAD(id, "access_token"))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	refreshToken, err := s.encryptField(token.RefreshToken, fieldAD(id, "refresh_token"))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE connections SET access_token = ?, refresh_token = ? WHERE id = ?",
+		accessToken, refreshToken, id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
 
+// SetConnectionMetadata stores the extra config of a connection (YTM cookies)
+func (s *Store) SetConnectionMetadata(ctx context.Context, connectionID int64, metadata string) error {
+	enc, err := s.encryptField(metadata, fieldAD(connectionID, "metadata"))
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, "UPDATE connections SET metadata = ? WHERE id = ?", enc, connectionID)
 	return err
 }
 
-// GetSpotifyConnection retrieves the token for a specific user
-func (s *Store) GetSpotifyConnection(ctx context.Context, userID int64) (*Connection, error) {
+// GetConnection retrieves the token of a provider for a specific user
+func (s *Store) GetConnection(ctx context.Context, userID int64, provider string) (*Connection, error) {
 	query := `
-    SELECT id, user_id, provider, provider_id, access_token, refresh_token, expiry 
+    SELECT id, user_id, provider, provider_id, access_token, refresh_token, expiry, metadata
     FROM connections 
-    WHERE user_id = ? AND provider = 'spotify'
+    WHERE user_id = ? AND provider = ?
     LIMIT 1`
 
-	row := s.db.QueryRowContext(ctx, query, userID)
+	row := s.db.QueryRowContext(ctx, query, userID, provider)
 
 	var c Connection
-	err := row.Scan(&c.ID, &c.UserID, &c.Provider, &c.ProviderID, &c.AccessToken, &c.RefreshToken, &c.Expiry)
+	var accessToken, refreshToken, metadata sql.NullString
+	err := row.Scan(&c.ID, &c.UserID, &c.Provider, &c.ProviderID, &accessToken, &refreshToken, &c.Expiry, &metadata)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -49,9 +79,29 @@ func (s *Store) GetSpotifyConnection(ctx context.Context, userID int64) (*Connec
 		return nil, err
 	}
 
+	if c.AccessToken, err = s.decryptField(accessToken.String, fieldAD(c.ID, "access_token")); err != nil {
+		return nil, err
+	}
+	if c.RefreshToken, err = s.decryptField(refreshToken.String, fieldAD(c.ID, "refresh_token")); err != nil {
+		return nil, err
+	}
+	if c.Metadata, err = s.decryptField(metadata.String, fieldAD(c.ID, "metadata")); err != nil {
+		return nil, err
+	}
+
 	return &c, nil
 }
 
+// SaveSpotifyConnection links a Spotify account to a User
+func (s *Store) SaveSpotifyConnection(ctx context.Context, userID int64, spotifyID string, token *oauth2.Token) error {
+	return s.SaveConnection(ctx, userID, "spotify", spotifyID, token)
+}
+
+// GetSpotifyConnection retrieves the token for a specific user
+func (s *Store) GetSpotifyConnection(ctx context.Context, userID int64) (*Connection, error) {
+	return s.GetConnection(ctx, userID, "spotify")
+}
+
 // CreateAdminUser Helper to create the initial "Admin" user if your DB is empty
 func (s *Store) CreateAdminUser(ctx context.Context) (int64, error) {
 	// Check if user exists
@@ -0,0 +1,66 @@
+package tags
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// writeFFmpeg copies the streams of path into a new file with the tags, for
+// the files the native writers can't handle
+func writeFFmpeg(path string, t *Tags) error {
+	var coverPath string
+	if len(t.Cover) > 0 {
+		cover, err := os.CreateTemp("", "cover-*")
+		if err != nil {
+			return err
+		}
+		coverPath = cover.Name()
+		defer os.Remove(coverPath)
+		_, err = cover.Write(t.Cover)
+		if closeErr := cover.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	err := runFFmpeg(path, t, coverPath)
+	if err != nil && coverPath != "" {
+		// not every container takes a picture stream (Ogg), the text still helps
+		err = runFFmpeg(path, t, "")
+	}
+	return err
+}
+
+func runFFmpeg(path string, t *Tags, coverPath string) error {
+	return replaceFile(path, func(out *os.File) error {
+		args := []string{"-v", "error", "-y", "-i", path}
+		if coverPath != "" {
+			args = append(args, "-i", coverPath, "-map", "0:a", "-map", "1:v", "-disposition:v:0", "attached_pic")
+		} else {
+			args = append(args, "-map", "0")
+		}
+		args = append(args, "-c", "copy", "-map_metadata", "0")
+		for key, value := range map[string]string{"title": t.Title, "artist": t.Artist, "album": t.Album} {
+			if value != "" {
+				args = append(args, "-metadata", key+"="+value)
+			}
+		}
+		if t.Track > 0 {
+			args = append(args, "-metadata", "track="+strconv.Itoa(t.Track))
+		}
+		// ffmpeg picks the muxer from the extension, the temp file has it
+		args = append(args, out.Name())
+
+		output, err := exec.Command("ffmpeg", args...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("ffmpeg %s: %s: %w", filepath.Base(path), strings.TrimSpace(string(output)), err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,379 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand/v2"
+	"os"
+	"testing"
+)
+
+// The fixtures are built byte by byte: tiny files with the containers of the
+// real thing and random bytes for audio. Each comes with an audioOf that
+// pulls the audio back out of the file, without the code under test, to
+// check tagging left it alone.
+
+type fixture struct {
+	name    string
+	ext     string
+	data    func() []byte
+	audioOf func(t *testing.T, b []byte) []byte
+}
+
+var fixtures = []fixture{
+	{"mp3", ".mp3", mp3Fixture, mp3Audio},
+	{"flac", ".flac", flacFixture, flacAudio},
+	{"vorbis", ".ogg", vorbisFixture, oggAudio},
+	{"opus", ".opus", opusFixture, oggAudio},
+	{"m4a", ".m4a", func() []byte { return m4aFixture(true) }, m4aAudio},
+	{"m4a moov last", ".m4a", func() []byte { return m4aFixture(false) }, m4aAudio},
+}
+
+func noise(seed uint64, n int) []byte {
+	r := rand.New(rand.NewPCG(seed, seed))
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(r.Uint32())
+	}
+	return b
+}
+
+func jpegCover(n int) []byte {
+	return append([]byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00"), noise(100, n)...)
+}
+
+func pngCover() []byte {
+	return append([]byte("\x89PNG\r\n\x1a\n"), noise(101, 300)...)
+}
+
+func writeFixture(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// mp3: MPEG-1 layer III frames, 128 kb/s at 44.1 kHz, without a tag
+
+func mp3Fixture() []byte {
+	var b []byte
+	for i := range 5 {
+		frame := noise(uint64(i), 417)
+		copy(frame, []byte{0xff, 0xfb, 0x90, 0x64})
+		b = append(b, frame...)
+	}
+	return b
+}
+
+func mp3Audio(t *testing.T, b []byte) []byte {
+	t.Helper()
+	if !bytes.HasPrefix(b, []byte("ID3")) {
+		return b
+	}
+	if len(b) < 10 {
+		t.Fatal("ID3 header cut short")
+	}
+	// syncsafe: 7 bits a byte
+	size := 10 + int(b[6])<<21 | int(b[7])<<14 | int(b[8])<<7 | int(b[9])
+	if b[5]&0x10 != 0 {
+		size += 10 // footer
+	}
+	return b[size:]
+}
+
+// flac: STREAMINFO, comments from the encoder, padding, then frames
+
+func flacFixture() []byte {
+	block := func(kind byte, data []byte) []byte {
+		return append([]byte{kind, byte(len(data) >> 16), byte(len(data) >> 8), byte(len(data))}, data...)
+	}
+	comment := &vorbisComment{vendor: "reference libFLAC 1.4.3"}
+	comment.set("TITLE", "Old title")
+	comment.set("ENCODER", "flac")
+
+	b := []byte("fLaC")
+	b = append(b, block(flacStreamInfo, noise(10, 34))...)
+	b = append(b, block(flacVorbisComment, comment.bytes())...)
+	b = append(b, block(0x80|1, make([]byte, 512))...) // last, padding
+	return append(b, append([]byte{0xff, 0xf8}, noise(11, 3000)...)...)
+}
+
+func flacAudio(t *testing.T, b []byte) []byte {
+	t.Helper()
+	b = b[4:]
+	for {
+		if len(b) < 4 {
+			t.Fatal("FLAC metadata cut short")
+		}
+		size := int(b[1])<<16 | int(b[2])<<8 | int(b[3])
+		last := b[0]&0x80 != 0
+		b = b[4+size:]
+		if last {
+			return b
+		}
+	}
+}
+
+// Ogg: the headers on their own pages, then audio pages of a few packets
+// each, granules counting up from there
+
+const oggTestSerial = 0x1234abcd
+
+// crc32Ogg is the page checksum computed bit by bit, unlike oggCRC
+func crc32Ogg(b []byte) uint32 {
+	var crc uint32
+	for _, c := range b {
+		crc ^= uint32(c) << 24
+		for range 8 {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func oggTestPage(headerType byte, granule uint64, seq uint32, packets ...[]byte) []byte {
+	var segments, data []byte
+	for _, packet := range packets {
+		for n := len(packet); ; n -= 255 {
+			if n < 255 {
+				segments = append(segments, byte(n))
+				break
+			}
+			segments = append(segments, 255)
+		}
+		data = append(data, packet...)
+	}
+
+	b := []byte("OggS\x00")
+	b = append(b, headerType)
+	b = binary.LittleEndian.AppendUint64(b, granule)
+	b = binary.LittleEndian.AppendUint32(b, oggTestSerial)
+	b = binary.LittleEndian.AppendUint32(b, seq)
+	b = binary.LittleEndian.AppendUint32(b, 0)
+	b = append(b, byte(len(segments)))
+	b = append(b, segments...)
+	b = append(b, data...)
+	binary.LittleEndian.PutUint32(b[22:], crc32Ogg(b))
+	return b
+}
+
+// oggTestAudio is the audio pages of a stream whose headers took seq pages
+func oggTestAudio(seq uint32) []byte {
+	var b []byte
+	for i := range 4 {
+		var packets [][]byte
+		for j := range 3 {
+			// some over 255 bytes, laced over several segments
+			packets = append(packets, noise(uint64(20+i*3+j), 100+(i*3+j)*70))
+		}
+		headerType := byte(0)
+		if i == 3 {
+			headerType = 0x04 // last page
+		}
+		b = append(b, oggTestPage(headerType, uint64(960*3*(i+1)), seq+uint32(i), packets...)...)
+	}
+	return b
+}
+
+func opusFixture() []byte {
+	head := []byte("OpusHead\x01\x02")
+	head = binary.LittleEndian.AppendUint16(head, 312)
+	head = binary.LittleEndian.AppendUint32(head, 48000)
+	head = append(head, 0, 0, 0)
+	comment := append([]byte("OpusTags"), (&vorbisComment{vendor: "libopus 1.4"}).bytes()...)
+
+	b := oggTestPage(oggFirstPage, 0, 0, head)
+	b = append(b, oggTestPage(0, 0, 1, comment)...)
+	return append(b, oggTestAudio(2)...)
+}
+
+func vorbisFixture() []byte {
+	id := append([]byte("\x01vorbis"), noise(30, 23)...)
+	comment := append([]byte("\x03vorbis"), (&vorbisComment{vendor: "Xiph.Org libVorbis I 20200704"}).bytes()...)
+	comment = append(comment, 1)
+	setup := append([]byte("\x05vorbis"), noise(31, 600)...)
+
+	b := oggTestPage(oggFirstPage, 0, 0, id)
+	// the comments and the setup share a page, like libvorbis does it
+	b = append(b, oggTestPage(0, 0, 1, comment, setup)...)
+	return append(b, oggTestAudio(2)...)
+}
+
+type oggTestPageInfo struct {
+	headerType byte
+	granule    uint64
+	segments   []byte
+	data       []byte
+}
+
+// oggPages splits an Ogg file into pages, checking their checksum and
+// that they are numbered from 0 without a gap
+func oggPages(t *testing.T, b []byte) []oggTestPageInfo {
+	t.Helper()
+	var pages []oggTestPageInfo
+	for seq := uint32(0); len(b) > 0; seq++ {
+		if len(b) < 27 || !bytes.HasPrefix(b, []byte("OggS")) {
+			t.Fatalf("page %d: not an Ogg page", seq)
+		}
+		n := int(b[26])
+		segments := b[27 : 27+n]
+		size := 27 + n
+		for _, lv := range segments {
+			size += int(lv)
+		}
+		raw := bytes.Clone(b[:size])
+		b = b[size:]
+
+		if got := binary.LittleEndian.Uint32(raw[18:]); got != seq {
+			t.Fatalf("page %d numbered %d", seq, got)
+		}
+		if got := binary.LittleEndian.Uint32(raw[14:]); got != oggTestSerial {
+			t.Fatalf("page %d of stream %x", seq, got)
+		}
+		crc := binary.LittleEndian.Uint32(raw[22:])
+		binary.LittleEndian.PutUint32(raw[22:], 0)
+		if want := crc32Ogg(raw); crc != want {
+			t.Fatalf("page %d: checksum %08x, want %08x", seq, crc, want)
+		}
+		pages = append(pages, oggTestPageInfo{
+			headerType: raw[5],
+			granule:    binary.LittleEndian.Uint64(raw[6:]),
+			segments:   raw[27 : 27+n],
+			data:       raw[27+n:],
+		})
+	}
+	return pages
+}
+
+// oggAudio is the audio pages, without their number and checksum
+func oggAudio(t *testing.T, b []byte) []byte {
+	t.Helper()
+	var audio []byte
+	for _, page := range oggPages(t, b) {
+		if page.granule == 0 || page.granule == oggNoGranule {
+			continue
+		}
+		audio = append(audio, page.headerType)
+		audio = binary.LittleEndian.AppendUint64(audio, page.granule)
+		audio = append(audio, page.segments...)
+		audio = append(audio, page.data...)
+	}
+	return audio
+}
+
+// m4a: ftyp, moov and mdat with four chunks of two tracks, the first with
+// 32 bit offsets (stco), the second with 64 bit ones (co64)
+
+const m4aChunkSize = 300
+
+func atom(kind string, body ...[]byte) []byte {
+	content := bytes.Join(body, nil)
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(content)))
+	b = append(b, kind...)
+	return append(b, content...)
+}
+
+func m4aFixture(moovFirst bool) []byte {
+	ftyp := atom("ftyp", []byte("M4A \x00\x00\x00\x00M4A isom"))
+	var chunks []byte
+	for i := range 4 {
+		chunks = append(chunks, noise(uint64(40+i), m4aChunkSize)...)
+	}
+	mdat := atom("mdat", chunks)
+
+	moov := func(mdatOffset int) []byte {
+		stco := binary.BigEndian.AppendUint32(make([]byte, 4), 2)
+		co64 := binary.BigEndian.AppendUint32(make([]byte, 4), 2)
+		for i := range 4 {
+			offset := mdatOffset + 8 + i*m4aChunkSize
+			if i%2 == 0 {
+				stco = binary.BigEndian.AppendUint32(stco, uint32(offset))
+			} else {
+				co64 = binary.BigEndian.AppendUint64(co64, uint64(offset))
+			}
+		}
+		trak := func(offsets string, table []byte) []byte {
+			return atom("trak",
+				atom("tkhd", make([]byte, 84)),
+				atom("mdia",
+					atom("mdhd", make([]byte, 24)),
+					atom("hdlr", []byte("\x00\x00\x00\x00\x00\x00\x00\x00soun\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00")),
+					atom("minf",
+						atom("smhd", make([]byte, 8)),
+						atom("stbl", atom("stsd", noise(50, 60)), atom(offsets, table)))))
+		}
+		return atom("moov", atom("mvhd", make([]byte, 100)), trak("stco", stco), trak("co64", co64))
+	}
+
+	if moovFirst {
+		size := len(moov(0))
+		return bytes.Join([][]byte{ftyp, moov(len(ftyp) + size), mdat}, nil)
+	}
+	// ffmpeg without +faststart, with its encoder in the metadata
+	b := append(ftyp, mdat...)
+	m := moov(len(ftyp))
+	tool := atom("\xa9too", atom("data", []byte("\x00\x00\x00\x01\x00\x00\x00\x00Lavf60.16.100")))
+	meta := atom("meta", make([]byte, 4), atom("hdlr", make([]byte, 8), []byte("mdirappl"), make([]byte, 9)), atom("ilst", tool))
+	m = atom("moov", m[8:], atom("udta", meta))
+	return append(b, m...)
+}
+
+// m4aChildren are the atoms directly in body
+func m4aChildren(t *testing.T, body []byte) map[string][]byte {
+	t.Helper()
+	children := make(map[string][]byte)
+	for len(body) > 0 {
+		if len(body) < 8 {
+			t.Fatal("mp4 atom cut short")
+		}
+		size := int(binary.BigEndian.Uint32(body))
+		if size < 8 || size > len(body) {
+			t.Fatalf("bad mp4 atom size %d", size)
+		}
+		children[string(body[4:8])] = body[8:size]
+		body = body[size:]
+	}
+	return children
+}
+
+// m4aAudio is the chunks the offset tables point at, track by track
+func m4aAudio(t *testing.T, b []byte) []byte {
+	t.Helper()
+	moov, ok := m4aChildren(t, b)["moov"]
+	if !ok {
+		t.Fatal("no moov")
+	}
+
+	var audio []byte
+	chunk := func(offset uint64) {
+		if offset+m4aChunkSize > uint64(len(b)) {
+			t.Fatalf("chunk offset %d past the end", offset)
+		}
+		audio = append(audio, b[offset:offset+m4aChunkSize]...)
+	}
+	// both traks are named trak, walk them in order
+	for body := moov; len(body) > 0; {
+		size := int(binary.BigEndian.Uint32(body))
+		kind, trak := string(body[4:8]), body[8:size]
+		body = body[size:]
+		if kind != "trak" {
+			continue
+		}
+		stbl := m4aChildren(t, m4aChildren(t, m4aChildren(t, m4aChildren(t, trak)["mdia"])["minf"])["stbl"])
+		if table, ok := stbl["stco"]; ok {
+			for i := range int(binary.BigEndian.Uint32(table[4:])) {
+				chunk(uint64(binary.BigEndian.Uint32(table[8+i*4:])))
+			}
+		}
+		if table, ok := stbl["co64"]; ok {
+			for i := range int(binary.BigEndian.Uint32(table[4:])) {
+				chunk(binary.BigEndian.Uint64(table[8+i*8:]))
+			}
+		}
+	}
+	return audio
+}
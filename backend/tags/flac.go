@@ -0,0 +1,161 @@
+package tags
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// FLAC metadata block types
+const (
+	flacStreamInfo    = 0
+	flacVorbisComment = 4
+	flacPicture       = 6
+)
+
+const flacMaxBlock = 1<<24 - 1
+
+var flacMagic = []byte("fLaC")
+
+// flacFormat is Vorbis comments and picture blocks in FLAC files
+type flacFormat struct{}
+
+type flacBlock struct {
+	kind byte
+	data []byte
+}
+
+// readFlacBlocks reads the metadata blocks, r is left at the first audio frame
+func readFlacBlocks(r io.Reader) ([]flacBlock, error) {
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(r, magic); err != nil || !bytes.Equal(magic, flacMagic) {
+		return nil, errors.New("not a FLAC file")
+	}
+
+	var blocks []flacBlock
+	for {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, fmt.Errorf("bad FLAC metadata: %w", err)
+		}
+		size := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+		block := flacBlock{kind: header[0] & 0x7f, data: make([]byte, size)}
+		if _, err := io.ReadFull(r, block.data); err != nil {
+			return nil, fmt.Errorf("bad FLAC metadata: %w", err)
+		}
+		blocks = append(blocks, block)
+		if header[0]&0x80 != 0 {
+			break
+		}
+	}
+	if len(blocks) == 0 || blocks[0].kind != flacStreamInfo {
+		return nil, errors.New("FLAC file without STREAMINFO")
+	}
+	return blocks, nil
+}
+
+func (flacFormat) Read(path string) (*Tags, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	blocks, err := readFlacBlocks(bufio.NewReader(f))
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Tags{}
+	var cover *picture
+	for _, block := range blocks {
+		switch block.kind {
+		case flacVorbisComment:
+			c, err := parseVorbisComment(block.data)
+			if err != nil {
+				return nil, err
+			}
+			t = c.tags()
+		case flacPicture:
+			p, err := decodePicture(block.data)
+			if err == nil && (cover == nil || p.kind == pictureFrontCover) {
+				cover = p
+			}
+		}
+	}
+	if cover != nil {
+		t.Cover, t.CoverMIME = cover.data, cover.mime
+	}
+	return t, nil
+}
+
+func (flacFormat) Write(path string, t *Tags) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	blocks, err := readFlacBlocks(r)
+	if err != nil {
+		return err
+	}
+
+	var comment *vorbisComment
+	kept := make([]flacBlock, 0, len(blocks)+2)
+	for _, block := range blocks {
+		switch {
+		case block.kind == flacVorbisComment:
+			// there should be one, the first wins if not
+			if comment == nil {
+				if comment, err = parseVorbisComment(block.data); err != nil {
+					return err
+				}
+			}
+			continue
+		case block.kind == flacPicture && len(t.Cover) > 0:
+			// replaced by the new cover
+			if p, err := decodePicture(block.data); err == nil && p.kind == pictureFrontCover {
+				continue
+			}
+		}
+		kept = append(kept, block)
+	}
+	if comment == nil {
+		comment = &vorbisComment{vendor: vendor}
+	}
+	comment.apply(t)
+
+	// right after STREAMINFO, readers look for the comments early
+	added := []flacBlock{{kind: flacVorbisComment, data: comment.bytes()}}
+	if len(t.Cover) > 0 {
+		added = append(added, flacBlock{kind: flacPicture, data: encodePicture(t.coverMIME(), t.Cover)})
+	}
+	blocks = append(kept[:1], append(added, kept[1:]...)...)
+
+	return replaceFile(path, func(out *os.File) error {
+		w := bufio.NewWriter(out)
+		w.Write(flacMagic)
+		for i, block := range blocks {
+			if len(block.data) > flacMaxBlock {
+				return fmt.Errorf("FLAC metadata block of %d bytes is too big", len(block.data))
+			}
+			kind := block.kind
+			if i == len(blocks)-1 {
+				kind |= 0x80
+			}
+			size := len(block.data)
+			w.Write([]byte{kind, byte(size >> 16), byte(size >> 8), byte(size)})
+			w.Write(block.data)
+		}
+		// the audio frames as they are
+		if _, err := io.Copy(w, r); err != nil {
+			return err
+		}
+		return w.Flush()
+	})
+}
@@ -0,0 +1,73 @@
+package tags
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/bogem/id3v2"
+)
+
+// id3Format is ID3v2 in mp3 files
+type id3Format struct{}
+
+func (id3Format) Read(path string) (*Tags, error) {
+	tag, err := id3v2.Open(path, id3v2.Options{Parse: true})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tag.Close() }()
+
+	t := &Tags{
+		Title:  tag.Title(),
+		Artist: tag.Artist(),
+		Album:  tag.Album(),
+	}
+	// "3" or "3/12"
+	track, _, _ := strings.Cut(tag.GetTextFrame(tag.CommonID("Track number/Position in set")).Text, "/")
+	t.Track, _ = strconv.Atoi(strings.TrimSpace(track))
+
+	for _, frame := range tag.GetFrames(tag.CommonID("Attached picture")) {
+		pic, ok := frame.(id3v2.PictureFrame)
+		if !ok {
+			continue
+		}
+		if t.Cover == nil || pic.PictureType == id3v2.PTFrontCover {
+			t.Cover, t.CoverMIME = pic.Picture, pic.MimeType
+		}
+	}
+	return t, nil
+}
+
+func (id3Format) Write(path string, t *Tags) error {
+	tag, err := id3v2.Open(path, id3v2.Options{Parse: true})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tag.Close() }()
+	tag.SetDefaultEncoding(id3v2.EncodingUTF8)
+
+	if t.Title != "" {
+		tag.SetTitle(t.Title)
+	}
+	if t.Artist != "" {
+		tag.SetArtist(t.Artist)
+	}
+	if t.Album != "" {
+		tag.SetAlbum(t.Album)
+	}
+	if t.Track > 0 {
+		tag.AddTextFrame(tag.CommonID("Track number/Position in set"), tag.DefaultEncoding(), strconv.Itoa(t.Track))
+	}
+	if len(t.Cover) > 0 {
+		// one cover, not one more each time the song is tagged
+		tag.DeleteFrames(tag.CommonID("Attached picture"))
+		tag.AddAttachedPicture(id3v2.PictureFrame{
+			Encoding:    id3v2.EncodingISO,
+			MimeType:    t.coverMIME(),
+			PictureType: id3v2.PTFrontCover,
+			Description: "Cover",
+			Picture:     t.Cover,
+		})
+	}
+	return tag.Save()
+}
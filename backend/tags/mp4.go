@@ -0,0 +1,387 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// mp4Format is iTunes style metadata (moov/udta/meta/ilst) in m4a files.
+// Writing rewrites the moov atom; when it sits before the audio, the chunk
+// offsets are moved by the size difference.
+type mp4Format struct{}
+
+// mp4Containers are the atoms walked into, down to the chunk offsets and
+// the metadata. Everything else is kept as is.
+var mp4Containers = map[string]bool{
+	"moov": true, "trak": true, "mdia": true, "minf": true, "stbl": true,
+	"udta": true, "meta": true, "ilst": true,
+}
+
+// ilst item types
+const (
+	mp4Title  = "\xa9nam"
+	mp4Artist = "\xa9ART"
+	mp4Album  = "\xa9alb"
+	mp4Track  = "trkn"
+	mp4Cover  = "covr"
+)
+
+// data atom types
+const (
+	mp4Implicit = 0
+	mp4UTF8     = 1
+	mp4JPEG     = 13
+	mp4PNG      = 14
+)
+
+type mp4Atom struct {
+	kind     string
+	prefix   []byte // in front of the children: meta's version and flags
+	data     []byte // content of a leaf
+	children []*mp4Atom
+	leaf     bool
+}
+
+func parseMP4Atoms(b []byte, parent string) ([]*mp4Atom, error) {
+	var atoms []*mp4Atom
+	for len(b) > 0 {
+		if len(b) < 8 {
+			return nil, errors.New("mp4 atom is cut short")
+		}
+		size := uint64(binary.BigEndian.Uint32(b))
+		kind := string(b[4:8])
+		header := uint64(8)
+		switch size {
+		case 0: // up to the end
+			size = uint64(len(b))
+		case 1: // 64 bit size
+			if len(b) < 16 {
+				return nil, errors.New("mp4 atom is cut short")
+			}
+			size, header = binary.BigEndian.Uint64(b[8:]), 16
+		}
+		if size < header || size > uint64(len(b)) {
+			return nil, fmt.Errorf("bad size for mp4 atom %q", kind)
+		}
+		body := b[header:size]
+		b = b[size:]
+
+		a := &mp4Atom{kind: kind}
+		var err error
+		switch {
+		case kind == "meta":
+			// an ISO full box with version and flags, QuickTime's has none
+			n := 4
+			if len(body) >= 8 && string(body[4:8]) == "hdlr" {
+				n = 0
+			}
+			if len(body) < n {
+				return nil, errors.New("mp4 meta atom is cut short")
+			}
+			a.prefix = body[:n]
+			a.children, err = parseMP4Atoms(body[n:], kind)
+		case mp4Containers[kind] || parent == "ilst":
+			a.children, err = parseMP4Atoms(body, kind)
+		default:
+			a.leaf, a.data = true, body
+		}
+		if err != nil {
+			return nil, err
+		}
+		atoms = append(atoms, a)
+	}
+	return atoms, nil
+}
+
+func (a *mp4Atom) bytes() []byte {
+	body := a.data
+	if !a.leaf {
+		body = bytes.Clone(a.prefix)
+		for _, child := range a.children {
+			body = append(body, child.bytes()...)
+		}
+	}
+
+	var b []byte
+	if size := 8 + len(body); size <= math.MaxUint32 {
+		b = binary.BigEndian.AppendUint32(b, uint32(size))
+		b = append(b, a.kind...)
+	} else {
+		b = binary.BigEndian.AppendUint32(b, 1)
+		b = append(b, a.kind...)
+		b = binary.BigEndian.AppendUint64(b, uint64(size+8))
+	}
+	return append(b, body...)
+}
+
+func (a *mp4Atom) child(kind string) *mp4Atom {
+	for _, c := range a.children {
+		if c.kind == kind {
+			return c
+		}
+	}
+	return nil
+}
+
+// find follows a path of kinds below a, nil if one is missing
+func (a *mp4Atom) find(kinds ...string) *mp4Atom {
+	for _, kind := range kinds {
+		if a = a.child(kind); a == nil {
+			return nil
+		}
+	}
+	return a
+}
+
+// ensure returns the child of kind, added with newAtom if missing
+func (a *mp4Atom) ensure(kind string, newAtom func() *mp4Atom) *mp4Atom {
+	if c := a.child(kind); c != nil {
+		return c
+	}
+	c := newAtom()
+	a.children = append(a.children, c)
+	return c
+}
+
+// itemData is the payload of the data atom of an ilst item, and its type
+func (a *mp4Atom) itemData() ([]byte, uint32) {
+	data := a.child("data")
+	if data == nil || len(data.data) < 8 {
+		return nil, 0
+	}
+	// version (always 0) and the type, then a locale
+	return data.data[8:], binary.BigEndian.Uint32(data.data) & 0xffffff
+}
+
+func (a *mp4Atom) setItem(kind string, dataType uint32, payload []byte) {
+	data := binary.BigEndian.AppendUint32(nil, dataType)
+	data = binary.BigEndian.AppendUint32(data, 0)
+	data = append(data, payload...)
+	item := &mp4Atom{kind: kind, children: []*mp4Atom{{kind: "data", leaf: true, data: data}}}
+
+	for i, c := range a.children {
+		if c.kind == kind {
+			a.children[i] = item
+			return
+		}
+	}
+	a.children = append(a.children, item)
+}
+
+// mp4File is where moov is in the file and what's in it
+type mp4File struct {
+	offset, size int64
+	moov         *mp4Atom
+}
+
+func readMP4(f *os.File) (*mp4File, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	var found *mp4File
+	header := make([]byte, 16)
+	for offset := int64(0); offset < info.Size(); {
+		if _, err := f.ReadAt(header[:8], offset); err != nil {
+			return nil, fmt.Errorf("bad mp4 file: %w", err)
+		}
+		size := int64(binary.BigEndian.Uint32(header))
+		kind := string(header[4:8])
+		switch size {
+		case 0:
+			size = info.Size() - offset
+		case 1:
+			if _, err := f.ReadAt(header[8:], offset+8); err != nil {
+				return nil, fmt.Errorf("bad mp4 file: %w", err)
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:]))
+		}
+		if size < 8 || offset+size > info.Size() {
+			return nil, fmt.Errorf("bad size for mp4 atom %q", kind)
+		}
+
+		switch kind {
+		case "moof":
+			// fragments point at their data relative to themselves
+			return nil, errors.New("fragmented mp4 files are not supported")
+		case "moov":
+			b := make([]byte, size)
+			if _, err := f.ReadAt(b, offset); err != nil {
+				return nil, err
+			}
+			atoms, err := parseMP4Atoms(b, "")
+			if err != nil {
+				return nil, err
+			}
+			found = &mp4File{offset: offset, size: size, moov: atoms[0]}
+		}
+		offset += size
+	}
+	if found == nil {
+		return nil, errors.New("mp4 file without moov atom")
+	}
+	return found, nil
+}
+
+func (mp4Format) Read(path string) (*Tags, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	file, err := readMP4(f)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Tags{}
+	ilst := file.moov.find("udta", "meta", "ilst")
+	if ilst == nil {
+		return t, nil
+	}
+	for _, item := range ilst.children {
+		payload, dataType := item.itemData()
+		switch item.kind {
+		case mp4Title:
+			t.Title = string(payload)
+		case mp4Artist:
+			t.Artist = string(payload)
+		case mp4Album:
+			t.Album = string(payload)
+		case mp4Track:
+			// reserved, track, total
+			if len(payload) >= 4 {
+				t.Track = int(binary.BigEndian.Uint16(payload[2:]))
+			}
+		case mp4Cover:
+			t.Cover = payload
+			if dataType == mp4PNG {
+				t.CoverMIME = "image/png"
+			} else {
+				t.CoverMIME = "image/jpeg"
+			}
+		}
+	}
+	return t, nil
+}
+
+func (mp4Format) Write(path string, t *Tags) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	file, err := readMP4(f)
+	if err != nil {
+		return err
+	}
+
+	udta := file.moov.ensure("udta", func() *mp4Atom { return &mp4Atom{kind: "udta"} })
+	meta := udta.ensure("meta", func() *mp4Atom {
+		// iTunes metadata handler
+		hdlr := &mp4Atom{kind: "hdlr", leaf: true, data: append(make([]byte, 8), "mdirappl\x00\x00\x00\x00\x00\x00\x00\x00\x00"...)}
+		return &mp4Atom{kind: "meta", prefix: make([]byte, 4), children: []*mp4Atom{hdlr}}
+	})
+	ilst := meta.ensure("ilst", func() *mp4Atom { return &mp4Atom{kind: "ilst"} })
+
+	if t.Title != "" {
+		ilst.setItem(mp4Title, mp4UTF8, []byte(t.Title))
+	}
+	if t.Artist != "" {
+		ilst.setItem(mp4Artist, mp4UTF8, []byte(t.Artist))
+	}
+	if t.Album != "" {
+		ilst.setItem(mp4Album, mp4UTF8, []byte(t.Album))
+	}
+	if t.Track > 0 && t.Track <= math.MaxUint16 {
+		ilst.setItem(mp4Track, mp4Implicit, []byte{0, 0, byte(t.Track >> 8), byte(t.Track), 0, 0, 0, 0})
+	}
+	if len(t.Cover) > 0 {
+		dataType := uint32(mp4JPEG)
+		if t.coverMIME() == "image/png" {
+			dataType = mp4PNG
+		}
+		ilst.setItem(mp4Cover, dataType, t.Cover)
+	}
+
+	// the audio after moov moves by as much as moov grew
+	shift := int64(len(file.moov.bytes())) - file.size
+	if shift != 0 {
+		if err := shiftChunkOffsets(file.moov, file.offset, shift); err != nil {
+			return err
+		}
+	}
+	moov := file.moov.bytes()
+
+	return replaceFile(path, func(out *os.File) error {
+		if _, err := io.Copy(out, io.NewSectionReader(f, 0, file.offset)); err != nil {
+			return err
+		}
+		if _, err := out.Write(moov); err != nil {
+			return err
+		}
+		_, err := io.Copy(out, io.NewSectionReader(f, file.offset+file.size, math.MaxInt64-file.offset-file.size))
+		return err
+	})
+}
+
+// shiftChunkOffsets moves the chunk offsets (stco, co64) pointing past the
+// moov atom at moovOffset by shift
+func shiftChunkOffsets(moov *mp4Atom, moovOffset, shift int64) error {
+	for _, trak := range moov.children {
+		if trak.kind != "trak" {
+			continue
+		}
+		stbl := trak.find("mdia", "minf", "stbl")
+		if stbl == nil {
+			continue
+		}
+		for _, table := range stbl.children {
+			var width int
+			switch table.kind {
+			case "stco":
+				width = 4
+			case "co64":
+				width = 8
+			default:
+				continue
+			}
+			if len(table.data) < 8 {
+				return errors.New("mp4 chunk offset table is cut short")
+			}
+			count := int(binary.BigEndian.Uint32(table.data[4:]))
+			if len(table.data) < 8+count*width {
+				return errors.New("mp4 chunk offset table is cut short")
+			}
+
+			for i := range count {
+				entry := table.data[8+i*width:]
+				if width == 4 {
+					offset := int64(binary.BigEndian.Uint32(entry))
+					if offset <= moovOffset {
+						continue
+					}
+					offset += shift
+					if offset < 0 || offset > math.MaxUint32 {
+						return errors.New("mp4 chunk offset out of range")
+					}
+					binary.BigEndian.PutUint32(entry, uint32(offset))
+				} else {
+					offset := int64(binary.BigEndian.Uint64(entry))
+					if offset > moovOffset {
+						binary.BigEndian.PutUint64(entry, uint64(offset+shift))
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+)
+
+// chunkOffsets are the stco and co64 entries of the file, in track order
+func chunkOffsets(t *testing.T, b []byte) []uint64 {
+	t.Helper()
+	var offsets []uint64
+	for body := m4aChildren(t, b)["moov"]; len(body) > 0; {
+		size := int(binary.BigEndian.Uint32(body))
+		kind, trak := string(body[4:8]), body[8:size]
+		body = body[size:]
+		if kind != "trak" {
+			continue
+		}
+		stbl := m4aChildren(t, m4aChildren(t, m4aChildren(t, m4aChildren(t, trak)["mdia"])["minf"])["stbl"])
+		if table, ok := stbl["stco"]; ok {
+			for i := range int(binary.BigEndian.Uint32(table[4:])) {
+				offsets = append(offsets, uint64(binary.BigEndian.Uint32(table[8+i*4:])))
+			}
+		}
+		if table, ok := stbl["co64"]; ok {
+			for i := range int(binary.BigEndian.Uint32(table[4:])) {
+				offsets = append(offsets, binary.BigEndian.Uint64(table[8+i*8:]))
+			}
+		}
+	}
+	return offsets
+}
+
+func TestMP4ChunkOffsets(t *testing.T) {
+	tests := []struct {
+		name      string
+		moovFirst bool
+	}{
+		{"moov before mdat", true},
+		{"moov after mdat", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "song.m4a")
+			original := m4aFixture(tt.moovFirst)
+			writeFixture(t, path, original)
+			moovSize := len(m4aChildren(t, original)["moov"])
+
+			if err := (mp4Format{}).Write(path, &Tags{Title: "Title", Cover: jpegCover(4096)}); err != nil {
+				t.Fatal(err)
+			}
+			tagged := readFile(t, path)
+			shift := uint64(len(m4aChildren(t, tagged)["moov"]) - moovSize)
+
+			before, after := chunkOffsets(t, original), chunkOffsets(t, tagged)
+			if len(after) != len(before) {
+				t.Fatalf("%d chunk offsets, want %d", len(after), len(before))
+			}
+			for i := range before {
+				want := before[i]
+				if tt.moovFirst {
+					want += shift
+				}
+				if after[i] != want {
+					t.Errorf("chunk %d at %d, want %d", i, after[i], want)
+				}
+			}
+
+			ftyp := m4aChildren(t, original)["ftyp"]
+			if !bytes.Equal(m4aChildren(t, tagged)["ftyp"], ftyp) {
+				t.Error("ftyp changed")
+			}
+			if !bytes.Equal(m4aChildren(t, tagged)["mdat"], m4aChildren(t, original)["mdat"]) {
+				t.Error("mdat changed")
+			}
+		})
+	}
+}
+
+func TestMP4KeepsOtherItems(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "song.m4a")
+	writeFixture(t, path, m4aFixture(false))
+
+	if err := (mp4Format{}).Write(path, &Tags{Title: "Title", Artist: "Artist"}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(readFile(t, path), []byte("\xa9too")) || !bytes.Contains(readFile(t, path), []byte("Lavf60.16.100")) {
+		t.Error("the encoder item is gone")
+	}
+	got, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "Title" || got.Artist != "Artist" {
+		t.Errorf("got %q by %q", got.Title, got.Artist)
+	}
+}
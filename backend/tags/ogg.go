@@ -0,0 +1,299 @@
+package tags
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// oggFormat is Vorbis comments in Ogg Vorbis and Ogg Opus files. The
+// comments are the second packet of the stream: changing them repaginates
+// the headers and renumbers the pages after them.
+type oggFormat struct{}
+
+const (
+	oggContinued = 0x01 // the page starts with the rest of a packet
+	oggFirstPage = 0x02
+
+	// granule of a page where no packet ends
+	oggNoGranule = ^uint64(0)
+)
+
+var oggMagic = []byte("OggS")
+
+type oggPage struct {
+	headerType byte
+	granule    uint64
+	serial     uint32
+	seq        uint32
+	segments   []byte // lacing values
+	data       []byte
+}
+
+func readOggPage(r io.Reader) (*oggPage, error) {
+	header := make([]byte, 27)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[:4], oggMagic) || header[4] != 0 {
+		return nil, errors.New("not an Ogg page")
+	}
+
+	p := &oggPage{
+		headerType: header[5],
+		granule:    binary.LittleEndian.Uint64(header[6:]),
+		serial:     binary.LittleEndian.Uint32(header[14:]),
+		seq:        binary.LittleEndian.Uint32(header[18:]),
+		segments:   make([]byte, header[26]),
+	}
+	if _, err := io.ReadFull(r, p.segments); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	size := 0
+	for _, lv := range p.segments {
+		size += int(lv)
+	}
+	p.data = make([]byte, size)
+	if _, err := io.ReadFull(r, p.data); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return p, nil
+}
+
+func (p *oggPage) bytes() []byte {
+	b := make([]byte, 27, 27+len(p.segments)+len(p.data))
+	copy(b, oggMagic)
+	b[5] = p.headerType
+	binary.LittleEndian.PutUint64(b[6:], p.granule)
+	binary.LittleEndian.PutUint32(b[14:], p.serial)
+	binary.LittleEndian.PutUint32(b[18:], p.seq)
+	b[26] = byte(len(p.segments))
+	b = append(b, p.segments...)
+	b = append(b, p.data...)
+	binary.LittleEndian.PutUint32(b[22:], oggCRC(b))
+	return b
+}
+
+var oggCRCTable = func() (table [256]uint32) {
+	for i := range table {
+		r := uint32(i) << 24
+		for range 8 {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		table[i] = r
+	}
+	return table
+}()
+
+// oggCRC is the page checksum, computed with the checksum field zeroed
+func oggCRC(b []byte) uint32 {
+	var crc uint32
+	for _, c := range b {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^c]
+	}
+	return crc
+}
+
+// oggCodec tells the header packets apart
+type oggCodec struct {
+	headers       int    // header packets before the audio
+	commentPrefix []byte // in front of the comments in the second packet
+	framingBit    bool   // Vorbis ends the comment packet with a 1
+}
+
+var (
+	opusCodec   = oggCodec{headers: 2, commentPrefix: []byte("OpusTags")}
+	vorbisCodec = oggCodec{headers: 3, commentPrefix: []byte("\x03vorbis"), framingBit: true}
+)
+
+// oggHeaders are the first pages of a stream, the ones holding the headers
+type oggHeaders struct {
+	codec   oggCodec
+	first   *oggPage // the identification header, alone on its page
+	packets [][]byte // every header packet, the first included
+	pages   int
+}
+
+func readOggHeaders(r io.Reader) (*oggHeaders, error) {
+	first, err := readOggPage(r)
+	if err != nil {
+		return nil, fmt.Errorf("bad Ogg file: %w", err)
+	}
+	if first.headerType&oggFirstPage == 0 {
+		return nil, errors.New("Ogg file doesn't start a stream")
+	}
+
+	h := &oggHeaders{first: first}
+	var packet []byte
+	for page := first; ; {
+		if page.serial != first.serial {
+			return nil, errors.New("multiplexed Ogg streams are not supported")
+		}
+		h.pages++
+
+		offset := 0
+		for _, lv := range page.segments {
+			packet = append(packet, page.data[offset:offset+int(lv)]...)
+			offset += int(lv)
+			if lv < 255 {
+				h.packets = append(h.packets, packet)
+				packet = nil
+			}
+		}
+
+		if h.codec.headers == 0 && len(h.packets) > 0 {
+			switch id := h.packets[0]; {
+			case bytes.HasPrefix(id, []byte("OpusHead")):
+				h.codec = opusCodec
+			case bytes.HasPrefix(id, []byte("\x01vorbis")):
+				h.codec = vorbisCodec
+			default:
+				return nil, errors.New("unsupported Ogg codec")
+			}
+		}
+		if h.codec.headers > 0 && len(h.packets) >= h.codec.headers {
+			// audio starts on a fresh page, otherwise we'd have to
+			// repaginate it too
+			if len(h.packets) > h.codec.headers || packet != nil {
+				return nil, errors.New("Ogg audio shares a page with the headers")
+			}
+			return h, nil
+		}
+
+		if page, err = readOggPage(r); err != nil {
+			return nil, fmt.Errorf("bad Ogg headers: %w", err)
+		}
+	}
+}
+
+func (h *oggHeaders) comment() (*vorbisComment, error) {
+	packet := h.packets[1]
+	if !bytes.HasPrefix(packet, h.codec.commentPrefix) {
+		return nil, errors.New("Ogg comment header missing")
+	}
+	return parseVorbisComment(packet[len(h.codec.commentPrefix):])
+}
+
+func (oggFormat) Read(path string) (*Tags, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h, err := readOggHeaders(bufio.NewReader(f))
+	if err != nil {
+		return nil, err
+	}
+	c, err := h.comment()
+	if err != nil {
+		return nil, err
+	}
+
+	t := c.tags()
+	if cover := c.oggCover(); cover != nil {
+		t.Cover, t.CoverMIME = cover.data, cover.mime
+	}
+	return t, nil
+}
+
+func (oggFormat) Write(path string, t *Tags) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	h, err := readOggHeaders(r)
+	if err != nil {
+		return err
+	}
+	c, err := h.comment()
+	if err != nil {
+		return err
+	}
+	c.apply(t)
+	if len(t.Cover) > 0 {
+		c.setOggCover(t.coverMIME(), t.Cover)
+	}
+
+	packet := append(bytes.Clone(h.codec.commentPrefix), c.bytes()...)
+	if h.codec.framingBit {
+		packet = append(packet, 1)
+	}
+	packets := append([][]byte{packet}, h.packets[2:]...)
+	pages := oggPaginate(packets, h.first.serial, h.first.seq+1)
+	shift := uint32(len(pages) + 1 - h.pages)
+
+	return replaceFile(path, func(out *os.File) error {
+		w := bufio.NewWriter(out)
+		w.Write(h.first.bytes())
+		for _, page := range pages {
+			w.Write(page.bytes())
+		}
+
+		if shift == 0 {
+			if _, err := io.Copy(w, r); err != nil {
+				return err
+			}
+			return w.Flush()
+		}
+		// the audio pages keep their content, only their number changes
+		for {
+			page, err := readOggPage(r)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("bad Ogg page: %w", err)
+			}
+			if page.serial == h.first.serial {
+				page.seq += shift
+			}
+			w.Write(page.bytes())
+		}
+		return w.Flush()
+	})
+}
+
+// oggPaginate lays packets out on pages, starting a fresh one
+func oggPaginate(packets [][]byte, serial, seq uint32) []*oggPage {
+	var pages []*oggPage
+	page := &oggPage{serial: serial, seq: seq, granule: oggNoGranule}
+	flush := func() {
+		pages = append(pages, page)
+		next := &oggPage{serial: serial, seq: page.seq + 1, granule: oggNoGranule}
+		if last := page.segments[len(page.segments)-1]; last == 255 {
+			next.headerType = oggContinued
+		}
+		page = next
+	}
+
+	for _, packet := range packets {
+		for {
+			if len(page.segments) == 255 {
+				flush()
+			}
+			n := min(len(packet), 255)
+			page.segments = append(page.segments, byte(n))
+			page.data = append(page.data, packet[:n]...)
+			packet = packet[n:]
+			if n < 255 {
+				// header pages are all at granule 0
+				page.granule = 0
+				break
+			}
+		}
+	}
+	pages = append(pages, page)
+	return pages
+}
@@ -0,0 +1,78 @@
+package tags
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// headerPages are the pages before the audio
+func headerPages(pages []oggTestPageInfo) []oggTestPageInfo {
+	for i, page := range pages {
+		if page.granule != 0 && page.granule != oggNoGranule {
+			return pages[:i]
+		}
+	}
+	return pages
+}
+
+func TestOggCommentPages(t *testing.T) {
+	for _, fx := range fixtures {
+		if fx.name != "opus" && fx.name != "vorbis" {
+			continue
+		}
+		t.Run(fx.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "song"+fx.ext)
+			writeFixture(t, path, fx.data())
+			before := len(oggPages(t, readFile(t, path)))
+
+			if err := (oggFormat{}).Write(path, &Tags{Title: "Big", Cover: jpegCover(200 << 10)}); err != nil {
+				t.Fatal(err)
+			}
+			pages := oggPages(t, readFile(t, path))
+			headers := headerPages(pages)
+			// 200 KiB in base64 needs 5 pages of 255 full segments at least
+			if len(headers) < 6 {
+				t.Fatalf("%d header pages, the comments should span several", len(headers))
+			}
+			if headers[0].headerType != oggFirstPage || len(headers[0].segments) != 1 {
+				t.Error("the identification header isn't alone on the first page")
+			}
+			for i, page := range headers[1:] {
+				prev := headers[i]
+				continued := prev.segments[len(prev.segments)-1] == 255
+				if (page.headerType&oggContinued != 0) != continued {
+					t.Errorf("header page %d: continued flag %v, previous page ends with a full segment: %v",
+						i+1, page.headerType&oggContinued != 0, continued)
+				}
+				// only pages where a packet ends have a granule
+				if ends := page.segments[len(page.segments)-1] < 255; ends != (page.granule == 0) {
+					t.Errorf("header page %d: granule %x with a packet ending: %v", i+1, page.granule, ends)
+				}
+			}
+			grown := len(pages)
+
+			// and back down: the audio pages are renumbered backwards
+			if err := (oggFormat{}).Write(path, &Tags{Cover: pngCover()}); err != nil {
+				t.Fatal(err)
+			}
+			pages = oggPages(t, readFile(t, path))
+			if len(pages) >= grown || len(pages) != before {
+				t.Errorf("%d pages after shrinking the comments, was %d before and %d grown", len(pages), before, grown)
+			}
+			if tags, err := Read(path); err != nil || tags.Title != "Big" {
+				t.Errorf("read after shrinking: %v, %v", tags, err)
+			}
+		})
+	}
+}
+
+func TestOggBrokenFileUntouched(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "song.ogg")
+	writeFixture(t, path, []byte("OggS not really"))
+	if err := (oggFormat{}).Write(path, &Tags{Title: "x"}); err == nil {
+		t.Error("wrote tags into a broken file")
+	}
+	if got := readFile(t, path); string(got) != "OggS not really" {
+		t.Error("broken file was changed")
+	}
+}
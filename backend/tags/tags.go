@@ -0,0 +1,112 @@
+// Package tags reads and writes the title, artist, album and cover embedded
+// in audio files: ID3v2 in mp3, Vorbis comments in FLAC and Ogg (Vorbis,
+// Opus), atoms in m4a. Anything else goes through ffmpeg.
+package tags
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Tags is what a file says about the song. Cover is the front cover.
+type Tags struct {
+	Title  string
+	Artist string
+	Album  string
+	Track  int // track number, 0 when unknown
+	Cover  []byte
+	// CoverMIME is image/jpeg or image/png, guessed from Cover when empty
+	CoverMIME string
+}
+
+// Format reads and writes the tags of one kind of file
+type Format interface {
+	Read(path string) (*Tags, error)
+	// Write sets the non-empty fields of t and keeps the others
+	Write(path string, t *Tags) error
+}
+
+var ErrUnsupported = errors.New("unsupported file type")
+
+// formats by lower case extension
+var formats = map[string]Format{
+	".mp3":  id3Format{},
+	".flac": flacFormat{},
+	".ogg":  oggFormat{},
+	".oga":  oggFormat{},
+	".opus": oggFormat{},
+	".m4a":  mp4Format{},
+	".m4b":  mp4Format{},
+	".mp4":  mp4Format{},
+}
+
+// FormatOf returns the format of the file by its extension
+func FormatOf(path string) (Format, bool) {
+	f, ok := formats[strings.ToLower(filepath.Ext(path))]
+	return f, ok
+}
+
+// Read returns the tags of the file at path
+func Read(path string) (*Tags, error) {
+	f, ok := FormatOf(path)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupported, filepath.Ext(path))
+	}
+	return f.Read(path)
+}
+
+// Write sets the non-empty fields of t in the file at path. If the file
+// can't be tagged in place (an odd container, a damaged file), ffmpeg
+// copies the streams into a tagged file instead.
+func Write(path string, t *Tags) error {
+	f, ok := FormatOf(path)
+	if !ok {
+		return writeFFmpeg(path, t)
+	}
+	err := f.Write(path, t)
+	if err == nil {
+		return nil
+	}
+	if ffErr := writeFFmpeg(path, t); ffErr != nil {
+		return errors.Join(err, ffErr)
+	}
+	return nil
+}
+
+// coverMIME is the type of the cover, sniffed if not set
+func (t *Tags) coverMIME() string {
+	if t.CoverMIME != "" {
+		return t.CoverMIME
+	}
+	return http.DetectContentType(t.Cover)
+}
+
+// replaceFile writes the new content of path next to it with write, then
+// swaps it in, so a failure never leaves a half written song
+func replaceFile(path string, write func(out *os.File) error) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tagging-*"+filepath.Ext(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
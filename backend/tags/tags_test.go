@@ -0,0 +1,94 @@
+package tags
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readFile(t *testing.T, path string) []byte {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func checkTags(t *testing.T, got, want *Tags) {
+	t.Helper()
+	if got.Title != want.Title || got.Artist != want.Artist || got.Album != want.Album || got.Track != want.Track {
+		t.Errorf("got %q / %q / %q / %d, want %q / %q / %q / %d",
+			got.Title, got.Artist, got.Album, got.Track, want.Title, want.Artist, want.Album, want.Track)
+	}
+	if !bytes.Equal(got.Cover, want.Cover) {
+		t.Errorf("cover of %d bytes, want %d", len(got.Cover), len(want.Cover))
+	}
+	if got.CoverMIME != want.CoverMIME {
+		t.Errorf("cover type %q, want %q", got.CoverMIME, want.CoverMIME)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, fx := range fixtures {
+		t.Run(fx.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "song"+fx.ext)
+			writeFixture(t, path, fx.data())
+			audio := fx.audioOf(t, readFile(t, path))
+
+			// the format itself, Write would hide its failures behind ffmpeg
+			format, ok := FormatOf(path)
+			if !ok {
+				t.Fatalf("no format for %s", fx.ext)
+			}
+
+			// big enough to take several Ogg pages
+			want := &Tags{
+				Title:     "Bohemian Rhapsody",
+				Artist:    "Queen",
+				Album:     "A Night at the Opera",
+				Track:     11,
+				Cover:     jpegCover(100 << 10),
+				CoverMIME: "image/jpeg",
+			}
+			if err := format.Write(path, want); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			got, err := Read(path)
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			checkTags(t, got, want)
+			if !bytes.Equal(fx.audioOf(t, readFile(t, path)), audio) {
+				t.Error("audio changed by the first write")
+			}
+
+			// a smaller cover and only the album: the rest is kept
+			update := &Tags{Album: "Greatest Hits", Cover: pngCover()}
+			if err := format.Write(path, update); err != nil {
+				t.Fatalf("second write: %v", err)
+			}
+			if got, err = Read(path); err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			want.Album, want.Cover, want.CoverMIME = update.Album, update.Cover, "image/png"
+			checkTags(t, got, want)
+			if !bytes.Equal(fx.audioOf(t, readFile(t, path)), audio) {
+				t.Error("audio changed by the second write")
+			}
+		})
+	}
+}
+
+func TestReadUntagged(t *testing.T) {
+	for _, fx := range fixtures {
+		t.Run(fx.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "song"+fx.ext)
+			writeFixture(t, path, fx.data())
+			if _, err := Read(path); err != nil {
+				t.Errorf("read: %v", err)
+			}
+		})
+	}
+}
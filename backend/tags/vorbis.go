@@ -0,0 +1,226 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"image"
+	_ "image/jpeg" // cover sizes for the picture block
+	_ "image/png"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// vendor goes in the comments we create, the ones we edit keep theirs
+const vendor = "rizumu"
+
+// pictureFrontCover is the FLAC / ID3 picture type of a front cover
+const pictureFrontCover = 3
+
+var errShortComment = errors.New("vorbis comment is cut short")
+
+// vorbisComment is the KEY=value list FLAC, Vorbis and Opus share
+type vorbisComment struct {
+	vendor string
+	fields []string
+}
+
+// parseVorbisComment reads a comment block, without the packet prefix
+// Ogg puts in front of it
+func parseVorbisComment(data []byte) (*vorbisComment, error) {
+	r := bytes.NewReader(data)
+	readString := func() (string, error) {
+		var n uint32
+		if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+			return "", errShortComment
+		}
+		if int64(n) > int64(r.Len()) {
+			return "", errShortComment
+		}
+		b := make([]byte, n)
+		_, _ = r.Read(b)
+		return string(b), nil
+	}
+
+	c := &vorbisComment{}
+	var err error
+	if c.vendor, err = readString(); err != nil {
+		return nil, err
+	}
+	var count uint32
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return nil, errShortComment
+	}
+	for range count {
+		field, err := readString()
+		if err != nil {
+			return nil, err
+		}
+		c.fields = append(c.fields, field)
+	}
+	return c, nil
+}
+
+func (c *vorbisComment) bytes() []byte {
+	var b bytes.Buffer
+	writeString := func(s string) {
+		_ = binary.Write(&b, binary.LittleEndian, uint32(len(s)))
+		b.WriteString(s)
+	}
+	writeString(c.vendor)
+	_ = binary.Write(&b, binary.LittleEndian, uint32(len(c.fields)))
+	for _, field := range c.fields {
+		writeString(field)
+	}
+	return b.Bytes()
+}
+
+// get returns the first value of key, keys are case-insensitive
+func (c *vorbisComment) get(key string) string {
+	for _, field := range c.fields {
+		k, v, ok := strings.Cut(field, "=")
+		if ok && strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return ""
+}
+
+// set replaces every value of key with value
+func (c *vorbisComment) set(key, value string) {
+	c.remove(key)
+	c.fields = append(c.fields, key+"="+value)
+}
+
+func (c *vorbisComment) remove(key string) {
+	kept := c.fields[:0]
+	for _, field := range c.fields {
+		k, _, _ := strings.Cut(field, "=")
+		if !strings.EqualFold(k, key) {
+			kept = append(kept, field)
+		}
+	}
+	c.fields = kept
+}
+
+func (c *vorbisComment) tags() *Tags {
+	t := &Tags{
+		Title:  c.get("TITLE"),
+		Artist: c.get("ARTIST"),
+		Album:  c.get("ALBUM"),
+	}
+	// "3" or "3/12"
+	track, _, _ := strings.Cut(c.get("TRACKNUMBER"), "/")
+	t.Track, _ = strconv.Atoi(strings.TrimSpace(track))
+	return t
+}
+
+// apply sets the text fields of t. Covers are stored differently by FLAC
+// and Ogg, the callers handle them.
+func (c *vorbisComment) apply(t *Tags) {
+	if t.Title != "" {
+		c.set("TITLE", t.Title)
+	}
+	if t.Artist != "" {
+		c.set("ARTIST", t.Artist)
+	}
+	if t.Album != "" {
+		c.set("ALBUM", t.Album)
+	}
+	if t.Track > 0 {
+		c.set("TRACKNUMBER", strconv.Itoa(t.Track))
+	}
+}
+
+// picture is a FLAC picture block, also base64'd in Ogg comments as
+// METADATA_BLOCK_PICTURE
+type picture struct {
+	kind uint32
+	mime string
+	data []byte
+}
+
+func encodePicture(mime string, data []byte) []byte {
+	var width, height, depth uint32
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		width, height, depth = uint32(cfg.Width), uint32(cfg.Height), 24
+	}
+
+	be := binary.BigEndian
+	b := be.AppendUint32(nil, pictureFrontCover)
+	b = be.AppendUint32(b, uint32(len(mime)))
+	b = append(b, mime...)
+	b = be.AppendUint32(b, 0) // no description
+	b = be.AppendUint32(b, width)
+	b = be.AppendUint32(b, height)
+	b = be.AppendUint32(b, depth)
+	b = be.AppendUint32(b, 0) // colors, only for indexed images
+	b = be.AppendUint32(b, uint32(len(data)))
+	return append(b, data...)
+}
+
+func decodePicture(block []byte) (*picture, error) {
+	r := bytes.NewReader(block)
+	readBytes := func() ([]byte, error) {
+		var n uint32
+		if err := binary.Read(r, binary.BigEndian, &n); err != nil || int64(n) > int64(r.Len()) {
+			return nil, errors.New("picture block is cut short")
+		}
+		b := make([]byte, n)
+		_, _ = r.Read(b)
+		return b, nil
+	}
+
+	p := &picture{}
+	if err := binary.Read(r, binary.BigEndian, &p.kind); err != nil {
+		return nil, errors.New("picture block is cut short")
+	}
+	mime, err := readBytes()
+	if err != nil {
+		return nil, err
+	}
+	p.mime = string(mime)
+	if _, err := readBytes(); err != nil { // description
+		return nil, err
+	}
+	// width, height, depth, colors
+	if _, err := r.Seek(16, io.SeekCurrent); err != nil {
+		return nil, err
+	}
+	if p.data, err = readBytes(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// pictureField is the comment holding a picture in Ogg files
+const pictureField = "METADATA_BLOCK_PICTURE"
+
+// oggCover returns the front cover (or the first picture) of the comments
+func (c *vorbisComment) oggCover() *picture {
+	var found *picture
+	for _, field := range c.fields {
+		k, v, _ := strings.Cut(field, "=")
+		if !strings.EqualFold(k, pictureField) {
+			continue
+		}
+		raw, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			continue
+		}
+		p, err := decodePicture(raw)
+		if err != nil {
+			continue
+		}
+		if found == nil || p.kind == pictureFrontCover {
+			found = p
+		}
+	}
+	return found
+}
+
+func (c *vorbisComment) setOggCover(mime string, data []byte) {
+	c.set(pictureField, base64.StdEncoding.EncodeToString(encodePicture(mime, data)))
+}
@@ -0,0 +1,143 @@
+// Package tokens : hands out always-valid OAuth tokens for the provider
+// connections stored in the DB
+package tokens
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"cryogon/rizumu-backend/store"
+
+	"golang.org/x/oauth2"
+)
+
+// ErrNotConnected is returned when the user never logged in with the provider
+var ErrNotConnected = errors.New("no connection found for provider")
+
+type key struct {
+	userID   int64
+	provider string
+}
+
+// Manager caches one TokenSource per (user, provider). Every caller shares
+// that source, so a token is only refreshed once even if many goroutines
+// (sync, downloads, handlers) need it at the same time.
+type Manager struct {
+	store *store.Store
+
+	mu      sync.Mutex
+	configs map[string]*oauth2.Config
+	sources map[key]*persistingSource
+}
+
+func NewManager(db *store.Store) *Manager {
+	return &Manager{
+		store:   db,
+		configs: make(map[string]*oauth2.Config),
+		sources: make(map[key]*persistingSource),
+	}
+}
+
+// Register sets the OAuth config used to refresh tokens of a provider
+func (m *Manager) Register(provider string, config *oauth2.Config) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.configs[provider] = config
+}
+
+// TokenSource returns a source that refreshes the stored token when it
+// expires and saves the refreshed token back to the connections table
+func (m *Manager) TokenSource(ctx context.Context, userID int64, provider string) (oauth2.TokenSource, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k := key{userID: userID, provider: provider}
+	if src, ok := m.sources[k]; ok {
+		return src, nil
+	}
+
+	config, ok := m.configs[provider]
+	if !ok {
+		return nil, fmt.Errorf("no oauth config registered for %s", provider)
+	}
+
+	conn, err := m.store.GetConnection(ctx, userID, provider)
+	if err != nil {
+		return nil, err
+	}
+	if conn == nil {
+		return nil, ErrNotConnected
+	}
+
+	src := &persistingSource{
+		store:      m.store,
+		config:     config,
+		userID:     userID,
+		provider:   provider,
+		providerID: conn.ProviderID,
+		current:    conn.ToOAuthToken(),
+	}
+	m.sources[k] = src
+	return src, nil
+}
+
+// Token is a shortcut for TokenSource(...).Token()
+func (m *Manager) Token(ctx context.Context, userID int64, provider string) (*oauth2.Token, error) {
+	src, err := m.TokenSource(ctx, userID, provider)
+	if err != nil {
+		return nil, err
+	}
+	return src.Token()
+}
+
+// Invalidate drops the cached source, e.g. after the user logged in again
+func (m *Manager) Invalidate(userID int64, provider string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sources, key{userID: userID, provider: provider})
+}
+
+type persistingSource struct {
+	store      *store.Store
+	config     *oauth2.Config
+	userID     int64
+	provider   string
+	providerID string
+
+	mu      sync.Mutex
+	current *oauth2.Token
+}
+
+func (p *persistingSource) Token() (*oauth2.Token, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.current.Valid() {
+		return p.current, nil
+	}
+
+	// Refresh with a background context: the source outlives the request
+	// that first asked for it
+	newToken, err := p.config.TokenSource(context.Background(), p.current).Token()
+	if err != nil {
+		return nil, err
+	}
+
+	// Handle the edge case where some providers don't send back a
+	// new refresh token if the old one is still valid.
+	if newToken.RefreshToken == "" {
+		newToken.RefreshToken = p.current.RefreshToken
+	}
+
+	log.Printf("[Tokens] %s token refreshed for user %d. Saving to DB...", p.provider, p.userID)
+	if err := p.store.SaveConnection(context.Background(), p.userID, p.provider, p.providerID, newToken); err != nil {
+		log.Printf("CRITICAL: Failed to save refreshed token: %v", err)
+		// We continue anyway, because we have the valid token in memory now
+	}
+
+	p.current = newToken
+	return newToken, nil
+}
@@ -47,6 +47,13 @@ func fetchPlaylists(ipc *IPCClient) tea.Cmd {
 	}
 }
 
+func fetchDownloads(ipc *IPCClient) tea.Cmd {
+	return func() tea.Msg {
+		err := ipc.Send(CmdDownloads, 0, 0)
+		return err
+	}
+}
+
 func fetchSongs(ipc *IPCClient, itemID int64) tea.Cmd {
 	return func() tea.Msg {
 		err := ipc.Send(CmdSongs, itemID, 0)
@@ -100,6 +107,8 @@ type model struct {
 
 	songProgress *ProgressBar
 	playerState  PlayerState
+
+	downloads map[int64]TaskEvent // by song ID, while queued or running
 }
 
 func InitialModel(ipcClient *IPCClient, progressBar *ProgressBar) model {
@@ -119,12 +128,14 @@ func InitialModel(ipcClient *IPCClient, progressBar *ProgressBar) model {
 		ipc:          ipcClient,
 		songModel:    table.New(table.WithColumns(songColumns), table.WithFocused(true)),
 		songProgress: progressBar,
+		downloads:    make(map[int64]TaskEvent),
 	}
 }
 
 func (m model) Init() tea.Cmd {
 	var cmds []tea.Cmd
 	cmds = append(cmds, fetchPlaylists(m.ipc))
+	cmds = append(cmds, fetchDownloads(m.ipc))
 	cmds = append(cmds, listenToIPC(m.ipc))
 	return tea.Batch(cmds...)
 }
@@ -146,21 +157,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			var songs []Song
 			if err := json.Unmarshal(msg.Data, &songs); err == nil {
 				m.songs = songs
-				var songRows []table.Row
-				for _, song := range m.songs {
-					status := ""
-					if song.ID == m.playerState.SongID {
-						status = "▶ "
-					}
-					songRows = append(songRows, table.Row{
-						status,
-						fmt.Sprintf("%d", song.ID),
-						song.Title,
-						song.Artist,
-						fmt.Sprintf("%d", song.DurationMs),
-					})
-				}
-				m.songModel.SetRows(songRows)
+				m.songModel.SetRows(m.songRows())
 				m.activeSection = sectionSongs
 				m.songModel.Focus()
 			}
@@ -174,21 +171,27 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				}
 
 				// Update table rows to reflect playing state
-				var songRows []table.Row
-				for _, song := range m.songs {
-					status := ""
-					if song.ID == m.playerState.SongID {
-						status = "▶ "
-					}
-					songRows = append(songRows, table.Row{
-						status,
-						fmt.Sprintf("%d", song.ID),
-						song.Title,
-						song.Artist,
-						fmt.Sprintf("%d", song.DurationMs),
-					})
+				m.songModel.SetRows(m.songRows())
+			}
+		case "downloads":
+			var events []TaskEvent
+			if err := json.Unmarshal(msg.Data, &events); err == nil {
+				for _, ev := range events {
+					m.downloads[ev.SongID] = ev
 				}
-				m.songModel.SetRows(songRows)
+				m.songModel.SetRows(m.songRows())
+			}
+		case "task_event":
+			var ev TaskEvent
+			if err := json.Unmarshal(msg.Data, &ev); err == nil {
+				if ev.Type == "finished" {
+					delete(m.downloads, ev.SongID)
+					// the song row changed, reload it
+					m.updateSongStatus(ev)
+				} else {
+					m.downloads[ev.SongID] = ev
+				}
+				m.songModel.SetRows(m.songRows())
 			}
 		}
 
@@ -280,6 +283,44 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, tea.Batch(cmds...)
 }
 
+func (m model) songRows() []table.Row {
+	var songRows []table.Row
+	for _, song := range m.songs {
+		status := ""
+		if song.ID == m.playerState.SongID {
+			status = "▶ "
+		} else if dl, ok := m.downloads[song.ID]; ok {
+			status = downloadGlyph(dl.Progress) + " "
+		} else if song.Status == "Not Available" {
+			status = "✗ "
+		}
+		songRows = append(songRows, table.Row{
+			status,
+			fmt.Sprintf("%d", song.ID),
+			song.Title,
+			song.Artist,
+			fmt.Sprintf("%d", song.DurationMs),
+		})
+	}
+	return songRows
+}
+
+// updateSongStatus mirrors the end of a download on the loaded songs
+func (m *model) updateSongStatus(ev TaskEvent) {
+	for i := range m.songs {
+		if m.songs[i].ID != ev.SongID {
+			continue
+		}
+		switch ev.Status {
+		case "Complete":
+			m.songs[i].Status = "Downloaded"
+		case "Not Available":
+			m.songs[i].Status = ev.Status
+			m.songs[i].UnavailableReason = ev.Error
+		}
+	}
+}
+
 func (m model) View() string {
 	// CRITICAL: Prevent crash on startup/resize when dimensions are invalid
 	if m.width < 20 || m.height < 10 {
@@ -379,6 +420,9 @@ func (m model) View() string {
 		// Width available = leftColumnWidth - 2 (cursor) - 1 (space) = leftColumnWidth - 3
 		// But let's be safe and use leftColumnWidth - 4
 		displayName := truncate(item.Name, leftColumnWidth-4)
+		if mark := item.OfflineMark(); mark != "" {
+			displayName = mark + " " + truncate(item.Name, leftColumnWidth-6)
+		}
 
 		itemView += fmt.Sprintf("%s %s\n", cursor, style.Render(displayName))
 	}
@@ -396,6 +440,15 @@ func (m model) View() string {
 		Render(m.songModel.View())
 
 	songTitle := fmt.Sprintf(" %s - %s", m.playerState.Artist, m.playerState.SongName)
+	// Explain why the highlighted song can't be played
+	if m.activeSection == sectionSongs && m.songModel.Cursor() < len(m.songs) {
+		song := m.songs[m.songModel.Cursor()]
+		if dl, ok := m.downloads[song.ID]; ok {
+			songTitle = fmt.Sprintf(" ⬇ %s: %s", song.Title, downloadSummary(dl))
+		} else if song.Status == "Not Available" && song.UnavailableReason != "" {
+			songTitle = fmt.Sprintf(" ✗ %s: %s", song.Title, song.UnavailableReason)
+		}
+	}
 	songProgress := fmt.Sprintf("%d / %d \n", m.playerState.Progress, m.playerState.Duration)
 
 	contentWidth := trueWidth - 2
@@ -1,5 +1,7 @@
 package main
 
+import "fmt"
+
 type ProgressBar struct {
 	str string
 
@@ -38,3 +40,31 @@ func (p *ProgressBar) Update(width, currentProgress, totalDuration int) {
 		}
 	}
 }
+
+var downloadBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// downloadGlyph draws a download's progress in one cell
+func downloadGlyph(percent float64) string {
+	i := int(percent / 100 * float64(len(downloadBlocks)-1))
+	i = max(0, min(i, len(downloadBlocks)-1))
+	return string(downloadBlocks[i])
+}
+
+// downloadSummary : "42% 1.2 MiB/s 5s left", or the status while queued
+func downloadSummary(ev TaskEvent) string {
+	if ev.Type == "queued" || ev.Type == "retrying" {
+		if ev.Error != "" {
+			return fmt.Sprintf("%s (%s)", ev.Type, ev.Error)
+		}
+		return ev.Type
+	}
+
+	summary := fmt.Sprintf("%.0f%%", ev.Progress)
+	if ev.Speed > 0 {
+		summary += fmt.Sprintf(" %.1f MiB/s", ev.Speed/(1<<20))
+	}
+	if ev.ETA > 0 {
+		summary += fmt.Sprintf(" %ds left", ev.ETA)
+	}
+	return summary
+}
@@ -16,6 +16,7 @@ const (
 	CmdPrev      CommandType = "prev"
 	CmdSongs     CommandType = "songs" // returns song
 	CmdPlaylists CommandType = "playlists"
+	CmdDownloads CommandType = "downloads" // returns []TaskEvent
 )
 
 type Message struct {
@@ -47,6 +48,24 @@ type Playlist struct {
 	SourceType  string    `json:"source_type"` // 'rizumu', 'spotify', 'osu'
 	ExternalID  string    `json:"external_id"` // The ID on Spotify/osu!
 	CreatedAt   time.Time `json:"created_at"`
+	SongCount   int64     `json:"song_count"`
+
+	// Pinned playlists are kept downloaded, ready offline once every song is
+	Pinned          bool  `json:"pinned"`
+	DownloadedCount int64 `json:"downloaded_count"`
+}
+
+// OfflineMark is ✓ for a pinned playlist fully downloaded, ↓ while it's
+// still downloading, empty for the others
+func (p Playlist) OfflineMark() string {
+	switch {
+	case !p.Pinned:
+		return ""
+	case p.DownloadedCount >= p.SongCount:
+		return "✓"
+	default:
+		return "↓"
+	}
 }
 
 // Implement list.Item interface for Playlist so we can use it in bubbles/list
@@ -89,5 +108,18 @@ type Song struct {
 	CreatedAt time.Time `json:"created_at"`
 
 	// State
-	Status string `json:"status"`
+	Status            string `json:"status"`
+	UnavailableReason string `json:"unavailable_reason,omitempty"`
+}
+
+// TaskEvent is a download update, pushed as "task_event"
+type TaskEvent struct {
+	Type     string  `json:"type"` // queued | started | progress | retrying | finished
+	TaskID   int64   `json:"task_id"`
+	SongID   int64   `json:"song_id"`
+	Status   string  `json:"status"`
+	Progress float64 `json:"progress"`
+	Speed    float64 `json:"speed,omitempty"` // bytes per second
+	ETA      int64   `json:"eta,omitempty"`   // seconds
+	Error    string  `json:"error,omitempty"`
 }